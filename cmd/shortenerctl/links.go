@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+var (
+	errUnknownFormat = errors.New("unknown format")
+	errCSVColumns    = errors.New("invalid csv columns")
+)
+
+// csvHeader lists columns of exported CSV files, one for every field of link.
+// Columns of imported files may be reordered or omitted if file has header.
+// Files without header are read as if they contain its first columns,
+// so files exported by earlier versions with four columns may still be imported.
+var csvHeader = []string{
+	"short_id",
+	"original_url",
+	"user_id",
+	"is_deleted",
+	"created_at",
+	"updated_at",
+	"deleted_at",
+	"creator_ip",
+	"creator_ua_hash",
+	"password_hash",
+	"tags",
+	"max_uses",
+	"remaining_uses",
+	"redirect_code",
+	"passthrough",
+	"workspace_id",
+	"is_disabled",
+}
+
+// linkEncoder writes links in one of supported formats.
+type linkEncoder interface {
+	Encode(l storage.Link) error
+	Flush() error
+}
+
+type jsonlEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *jsonlEncoder) Encode(l storage.Link) error {
+	return e.enc.Encode(l)
+}
+
+func (e *jsonlEncoder) Flush() error {
+	return nil
+}
+
+type csvEncoder struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (e *csvEncoder) Encode(l storage.Link) error {
+	if !e.headerWritten {
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+		e.headerWritten = true
+	}
+	passthrough, err := l.Passthrough.MarshalText()
+	if err != nil {
+		return fmt.Errorf("unable to encode link %s:\n%w", l.ShortPath, err)
+	}
+	return e.w.Write([]string{
+		l.ShortPath,
+		l.OriginalURL,
+		l.UserID.String(),
+		strconv.FormatBool(l.IsDeleted),
+		formatCSVTime(l.CreatedAt),
+		formatCSVTime(l.UpdatedAt),
+		formatCSVTime(l.DeletedAt),
+		l.CreatorIP,
+		l.CreatorUAHash,
+		l.PasswordHash,
+		strings.Join(l.Tags, ","),
+		strconv.Itoa(l.MaxUses),
+		strconv.Itoa(l.RemainingUses),
+		strconv.Itoa(l.RedirectCode),
+		string(passthrough),
+		l.WorkspaceID.String(),
+		strconv.FormatBool(l.IsDisabled),
+	})
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// detectFormat returns explicitly requested format
+// or guesses it by file extension, defaulting to JSONL.
+func detectFormat(format, path string) (string, error) {
+	if format == "" {
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			return formatCSV, nil
+		}
+		return formatJSONL, nil
+	}
+	format = strings.ToLower(format)
+	if format != formatCSV && format != formatJSONL {
+		return "", fmt.Errorf("%w: %s", errUnknownFormat, format)
+	}
+	return format, nil
+}
+
+func newLinkEncoder(format string, w io.Writer) (linkEncoder, error) {
+	switch format {
+	case formatJSONL:
+		return &jsonlEncoder{enc: json.NewEncoder(w)}, nil
+	case formatCSV:
+		return &csvEncoder{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownFormat, format)
+	}
+}
+
+// decodeLinks reads links in provided format, calling fn for every one of them.
+func decodeLinks(format string, r io.Reader, fn func(storage.Link) error) error {
+	switch format {
+	case formatJSONL:
+		dec := json.NewDecoder(r)
+		for line := 1; ; line++ {
+			var l storage.Link
+			if err := dec.Decode(&l); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return fmt.Errorf("unable to decode record #%d:\n%w", line, err)
+			}
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+	case formatCSV:
+		cr := csv.NewReader(r)
+		var columns []string
+		for line := 1; ; line++ {
+			record, err := cr.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return fmt.Errorf("unable to read line #%d:\n%w", line, err)
+			}
+			if line == 1 {
+				// short URLs never match names of columns
+				isHeader := knownCSVColumn(record[0])
+				columns = record
+				if !isHeader && len(record) <= len(csvHeader) {
+					columns = csvHeader[:len(record)]
+				}
+				if err = checkCSVColumns(columns); err != nil {
+					return err
+				}
+				if isHeader {
+					continue
+				}
+			}
+			l, err := parseCSVRecord(columns, record)
+			if err != nil {
+				return fmt.Errorf("unable to parse line #%d:\n%w", line, err)
+			}
+			if err = fn(l); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: %s", errUnknownFormat, format)
+	}
+}
+
+func knownCSVColumn(name string) bool {
+	for _, c := range csvHeader {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCSVColumns ensures that every column is known and appears once
+// and that short URL, original URL and user are present.
+func checkCSVColumns(columns []string) error {
+	seen := make(map[string]bool, len(columns))
+	for _, name := range columns {
+		if !knownCSVColumn(name) {
+			return fmt.Errorf("%w: unknown column %q", errCSVColumns, name)
+		}
+		if seen[name] {
+			return fmt.Errorf("%w: duplicate column %q", errCSVColumns, name)
+		}
+		seen[name] = true
+	}
+	for _, name := range csvHeader[:3] {
+		if !seen[name] {
+			return fmt.Errorf("%w: missing column %q", errCSVColumns, name)
+		}
+	}
+	return nil
+}
+
+func parseCSVRecord(columns, record []string) (storage.Link, error) {
+	var l storage.Link
+	for i, name := range columns {
+		if err := setCSVField(&l, name, record[i]); err != nil {
+			return storage.Link{}, fmt.Errorf("unable to parse %s:\n%w", name, err)
+		}
+	}
+	return l, nil
+}
+
+// setCSVField sets field of link stored in column with provided name.
+// Empty values of optional columns mean zero values.
+func setCSVField(l *storage.Link, name, value string) error {
+	var err error
+	switch name {
+	case "short_id":
+		l.ShortPath = value
+	case "original_url":
+		l.OriginalURL = value
+	case "user_id":
+		l.UserID, err = uuid.Parse(value)
+	case "is_deleted":
+		l.IsDeleted, err = parseCSVBool(value)
+	case "created_at":
+		l.CreatedAt, err = parseCSVTime(value)
+	case "updated_at":
+		l.UpdatedAt, err = parseCSVTime(value)
+	case "deleted_at":
+		l.DeletedAt, err = parseCSVTime(value)
+	case "creator_ip":
+		l.CreatorIP = value
+	case "creator_ua_hash":
+		l.CreatorUAHash = value
+	case "password_hash":
+		l.PasswordHash = value
+	case "tags":
+		if value != "" {
+			l.Tags = strings.Split(value, ",")
+		}
+	case "max_uses":
+		l.MaxUses, err = parseCSVInt(value)
+	case "remaining_uses":
+		l.RemainingUses, err = parseCSVInt(value)
+	case "redirect_code":
+		l.RedirectCode, err = parseCSVInt(value)
+	case "passthrough":
+		err = l.Passthrough.UnmarshalText([]byte(value))
+	case "workspace_id":
+		if value != "" {
+			l.WorkspaceID, err = uuid.Parse(value)
+		}
+	case "is_disabled":
+		l.IsDisabled, err = parseCSVBool(value)
+	}
+	return err
+}
+
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseCSVTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+func parseCSVBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+func parseCSVInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
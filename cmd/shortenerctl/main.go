@@ -0,0 +1,366 @@
+// Command shortenerctl provides administrative operations
+// over URL shortener storage: export, import and inspection of links.
+//
+// Storage is chosen with the same flags, environment variables
+// and JSON config file as the server uses. Command name follows them:
+//
+//	shortenerctl [-d dsn | -f file | -c config] <command> [arguments]
+//
+// Commands:
+//
+//	export [-format jsonl|csv] [-o file]   write all links to file or stdout
+//	import [-format jsonl|csv] [-i file]   read links from file or stdin
+//	lookup <short_id>                      show link with provided short id
+//	list <user_id>                         show all links added by user
+//	delete <short_id>...                   mark links as deleted
+//	restore <short_id>...                  remove deletion mark from links
+//	migrate -to-dsn dsn | -to-file file    copy all links, accounts, templates,
+//	                                       API tokens and workspaces into another storage
+//	verify -to-dsn dsn | -to-file file     compare content with another storage
+//
+// Migration without downtime is performed in three steps.
+// First, server is restarted with mirror storage configured
+// (MIRROR_DATABASE_DSN or MIRROR_FILE_STORAGE_PATH), so every new
+// write is duplicated into it. Then migrate command copies existing data;
+// it may be repeated until verify reports no difference.
+// Finally, server is restarted with mirror storage as the primary one.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/serjyuriev/shortener/internal/pkg/config"
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+var errUsage = errors.New("usage: shortenerctl [config flags] export|import|lookup|list|delete|restore|migrate|verify [arguments]")
+
+var errNoTarget = errors.New("either -to-dsn or -to-file must be provided")
+
+var errMismatch = errors.New("storages content differs")
+
+func main() {
+	log.SetFlags(0)
+
+	cfg := config.GetConfig()
+	s, err := storage.NewStore(cfg.DatabaseDSN, cfg.FileStoragePath)
+	if err != nil {
+		log.Fatalf("unable to open storage: %v", err)
+	}
+
+	if err = run(context.Background(), s, flag.Args(), os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run executes command provided in args against storage.
+func run(ctx context.Context, s storage.Store, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "export":
+		return runExport(ctx, s, args, stdout)
+	case "import":
+		return runImport(ctx, s, args, stdin, stdout)
+	case "lookup":
+		return runLookup(ctx, s, args, stdout)
+	case "list":
+		return runList(ctx, s, args, stdout)
+	case "delete":
+		return runSetDeleted(ctx, s, args, stdout, true)
+	case "restore":
+		return runSetDeleted(ctx, s, args, stdout, false)
+	case "migrate":
+		return runMigrate(ctx, s, args, stdout, true)
+	case "verify":
+		return runMigrate(ctx, s, args, stdout, false)
+	default:
+		return fmt.Errorf("unknown command %q\n%w", cmd, errUsage)
+	}
+}
+
+func runExport(ctx context.Context, s storage.Store, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "", "output format (jsonl/csv)")
+	output := fs.String("o", "", "output file path (stdout if empty)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := detectFormat(*format, *output)
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("unable to create output file:\n%w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	enc, err := newLinkEncoder(f, w)
+	if err != nil {
+		return err
+	}
+	count := 0
+	if err = s.IterateLinks(ctx, func(l storage.Link) error {
+		count++
+		return enc.Encode(l)
+	}); err != nil {
+		return fmt.Errorf("unable to export links:\n%w", err)
+	}
+	if err = enc.Flush(); err != nil {
+		return fmt.Errorf("unable to export links:\n%w", err)
+	}
+
+	if *output != "" {
+		fmt.Fprintf(stdout, "exported %d links\n", count)
+	}
+	return nil
+}
+
+func runImport(ctx context.Context, s storage.Store, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "input format (jsonl/csv)")
+	input := fs.String("i", "", "input file path (stdin if empty)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := detectFormat(*format, *input)
+	if err != nil {
+		return err
+	}
+
+	r := stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("unable to open input file:\n%w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var report storage.MigrationReport
+	if err = decodeLinks(f, r, func(l storage.Link) error {
+		res, err := storage.CopyLink(ctx, s, l, nil)
+		if err != nil {
+			return err
+		}
+		switch res {
+		case storage.CopyInserted:
+			report.Inserted++
+		case storage.CopyUpdated:
+			report.Updated++
+		case storage.CopyUnchanged:
+			report.Unchanged++
+		case storage.CopyConflicted:
+			report.Conflicted = append(report.Conflicted, l.ShortPath)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("unable to import links:\n%w", err)
+	}
+
+	printReport(stdout, report)
+	return nil
+}
+
+// runMigrate copies content into target storage if requested
+// and compares content of both storages.
+func runMigrate(ctx context.Context, s storage.Store, args []string, stdout io.Writer, copyLinks bool) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dsn := fs.String("to-dsn", "", "target database data source name")
+	path := fs.String("to-file", "", "target file storage path")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dsn == "" && *path == "" {
+		return errNoTarget
+	}
+
+	target, err := storage.NewStore(*dsn, *path)
+	if err != nil {
+		return fmt.Errorf("unable to open target storage:\n%w", err)
+	}
+
+	if copyLinks {
+		report, err := storage.Migrate(ctx, s, target)
+		if err != nil {
+			return err
+		}
+		printReport(stdout, report)
+		printEntityReport(stdout, "accounts", report.Accounts)
+		printEntityReport(stdout, "workspaces", report.Workspaces)
+		printEntityReport(stdout, "templates", report.Templates)
+		printEntityReport(stdout, "tokens", report.Tokens)
+	}
+
+	srcSum, err := storage.Summarize(ctx, s)
+	if err != nil {
+		return err
+	}
+	dstSum, err := storage.Summarize(ctx, target)
+	if err != nil {
+		return err
+	}
+	printSummary(stdout, "source", srcSum)
+	printSummary(stdout, "target", dstSum)
+	if srcSum != dstSum {
+		return errMismatch
+	}
+	return nil
+}
+
+func printReport(w io.Writer, report storage.MigrationReport) {
+	fmt.Fprintf(
+		w,
+		"inserted %d, updated %d, unchanged %d, conflicted %d links\n",
+		report.Inserted,
+		report.Updated,
+		report.Unchanged,
+		len(report.Conflicted),
+	)
+	for _, short := range report.Conflicted {
+		fmt.Fprintf(w, "conflict: %s\n", short)
+	}
+}
+
+func printEntityReport(w io.Writer, name string, report storage.EntityReport) {
+	fmt.Fprintf(
+		w,
+		"%s: inserted %d, updated %d, unchanged %d, deleted %d, conflicted %d\n",
+		name,
+		report.Inserted,
+		report.Updated,
+		report.Unchanged,
+		report.Deleted,
+		len(report.Conflicted),
+	)
+	for _, id := range report.Conflicted {
+		fmt.Fprintf(w, "conflict: %s %s\n", name, id)
+	}
+}
+
+func printSummary(w io.Writer, name string, sum storage.Summary) {
+	fmt.Fprintf(
+		w,
+		"%s: %d links, %d accounts, %d workspaces, %d templates, %d tokens, checksum %s\n",
+		name,
+		sum.Count,
+		sum.Accounts,
+		sum.Workspaces,
+		sum.Templates,
+		sum.Tokens,
+		sum.Checksum,
+	)
+}
+
+func runLookup(ctx context.Context, s storage.Store, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: shortenerctl lookup <short_id>")
+	}
+
+	l, err := s.FindLink(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("unable to find link:\n%w", err)
+	}
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(l)
+}
+
+func runList(ctx context.Context, s storage.Store, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: shortenerctl list <user_id>")
+	}
+	uid, err := uuid.Parse(args[0])
+	if err != nil {
+		return fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHORT ID\tORIGINAL URL\tSTATUS")
+	if err = s.IterateLinks(ctx, func(l storage.Link) error {
+		if l.UserID != uid {
+			return nil
+		}
+		status := "active"
+		if l.IsDeleted {
+			status = "deleted"
+		}
+		_, err := fmt.Fprintf(tw, "%s\t%s\t%s\n", l.ShortPath, l.OriginalURL, status)
+		return err
+	}); err != nil {
+		return fmt.Errorf("unable to list links:\n%w", err)
+	}
+	return tw.Flush()
+}
+
+// runSetDeleted marks links as deleted or restores them on behalf of their owners,
+// reporting number of links actually changed.
+func runSetDeleted(ctx context.Context, s storage.Store, args []string, stdout io.Writer, isDeleted bool) error {
+	if len(args) == 0 {
+		return errors.New("at least one short id must be provided")
+	}
+
+	byUser := make(map[uuid.UUID][]string)
+	seen := make(map[string]bool, len(args))
+	for _, short := range args {
+		if seen[short] {
+			continue
+		}
+		seen[short] = true
+		l, err := s.FindLink(ctx, short)
+		if err != nil {
+			return fmt.Errorf("unable to find link %s:\n%w", short, err)
+		}
+		if l.IsDeleted == isDeleted {
+			continue
+		}
+		byUser[l.UserID] = append(byUser[l.UserID], short)
+	}
+
+	changed := 0
+	for uid, urls := range byUser {
+		var err error
+		if isDeleted {
+			err = s.DeleteManyURLs(ctx, uid, urls)
+			changed += len(urls)
+		} else {
+			var restored []string
+			restored, err = s.RestoreManyURLs(ctx, uid, urls, time.Time{})
+			changed += len(restored)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to update links of user %s:\n%w", uid, err)
+		}
+	}
+
+	action := "restored"
+	if isDeleted {
+		action = "deleted"
+	}
+	fmt.Fprintf(stdout, "%s %d links\n", action, changed)
+	return nil
+}
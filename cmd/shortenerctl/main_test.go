@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+func Test_run_exportImport(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+	}{
+		{
+			name:   "jsonl",
+			format: formatJSONL,
+		},
+		{
+			name:   "csv",
+			format: formatCSV,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			uid, uid2 := uuid.New(), uuid.New()
+			src, err := storage.NewFileStore("")
+			require.NoError(t, err)
+			require.NoError(t, src.InsertManyURLs(ctx, uid, map[string]string{
+				"abcdef": "https://github.com/serjyuriev",
+				"lkasdj": "https://yandex.ru",
+			}))
+			require.NoError(t, src.InsertNewURLPair(ctx, uid2, "fedcba", "https://gitlab.com/servady"))
+			require.NoError(t, src.DeleteManyURLs(ctx, uid, []string{"lkasdj"}))
+			require.NoError(t, src.InsertLinks(ctx, []storage.Link{{
+				ShortPath:     "secret",
+				OriginalURL:   "https://github.com/serjyuriev/shortener",
+				CreatorIP:     "127.0.0.1",
+				CreatorUAHash: "ua",
+				PasswordHash:  "$2a$10$hash",
+				Tags:          []string{"code", "go"},
+				MaxUses:       5,
+				RemainingUses: 2,
+				RedirectCode:  308,
+				Passthrough:   storage.PassthroughQuery,
+				UserID:        uid,
+				WorkspaceID:   uuid.New(),
+				IsDisabled:    true,
+			}}))
+
+			var exported bytes.Buffer
+			err = run(ctx, src, []string{"export", "-format", tt.format}, nil, &exported)
+			require.NoError(t, err)
+
+			dst, err := storage.NewFileArrayStore("")
+			require.NoError(t, err)
+			require.NoError(t, dst.InsertNewURLPair(ctx, uid2, "fedcba", "https://gitlab.com/servady"))
+
+			var out bytes.Buffer
+			err = run(ctx, dst, []string{"import", "-format", tt.format}, bytes.NewReader(exported.Bytes()), &out)
+			require.NoError(t, err)
+			assert.Equal(t, "inserted 3, updated 0, unchanged 1, conflicted 0 links\n", out.String())
+
+			var srcLinks, dstLinks []storage.Link
+			require.NoError(t, src.IterateLinks(ctx, func(l storage.Link) error {
+				srcLinks = append(srcLinks, l)
+				return nil
+			}))
+			require.NoError(t, dst.IterateLinks(ctx, func(l storage.Link) error {
+				// existing link keeps its own timestamps
+				if l.ShortPath == "fedcba" {
+					orig, err := src.FindLink(ctx, l.ShortPath)
+					require.NoError(t, err)
+					l.CreatedAt, l.UpdatedAt = orig.CreatedAt, orig.UpdatedAt
+				}
+				dstLinks = append(dstLinks, l)
+				return nil
+			}))
+			assert.Equal(t, srcLinks, dstLinks)
+		})
+	}
+}
+
+func Test_decodeLinks_csv(t *testing.T) {
+	uid := uuid.New()
+	tests := []struct {
+		name    string
+		input   string
+		want    []storage.Link
+		wantErr error
+	}{
+		{
+			name:  "four columns without header",
+			input: "abcdef,https://yandex.ru," + uid.String() + ",true\n",
+			want:  []storage.Link{{ShortPath: "abcdef", OriginalURL: "https://yandex.ru", UserID: uid, IsDeleted: true}},
+		},
+		{
+			name:  "reordered header",
+			input: "user_id,short_id,max_uses,original_url\n" + uid.String() + ",abcdef,3,https://yandex.ru\n",
+			want:  []storage.Link{{ShortPath: "abcdef", OriginalURL: "https://yandex.ru", UserID: uid, MaxUses: 3}},
+		},
+		{
+			name:    "unknown column",
+			input:   "short_id,original_url,user_id,color\nabcdef,https://yandex.ru," + uid.String() + ",red\n",
+			wantErr: errCSVColumns,
+		},
+		{
+			name:    "missing user",
+			input:   "abcdef,https://yandex.ru\n",
+			wantErr: errCSVColumns,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var links []storage.Link
+			err := decodeLinks(formatCSV, strings.NewReader(tt.input), func(l storage.Link) error {
+				links = append(links, l)
+				return nil
+			})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, links)
+		})
+	}
+}
+
+func Test_run_inspect(t *testing.T) {
+	ctx := context.Background()
+	uid := uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523")
+	s, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	require.NoError(t, s.InsertManyURLs(ctx, uid, map[string]string{
+		"abcdef": "https://github.com/serjyuriev",
+		"lkasdj": "https://yandex.ru",
+	}))
+	require.NoError(t, s.InsertNewURLPair(ctx, uuid.New(), "fedcba", "https://gitlab.com/servady"))
+
+	var out bytes.Buffer
+	require.NoError(t, run(ctx, s, []string{"delete", "abcdef"}, nil, &out))
+	assert.Equal(t, "deleted 1 links\n", out.String())
+
+	out.Reset()
+	require.NoError(t, run(ctx, s, []string{"delete", "abcdef", "lkasdj", "lkasdj"}, nil, &out))
+	assert.Equal(t, "deleted 1 links\n", out.String(), "already deleted links are not counted")
+	_, err = s.RestoreManyURLs(ctx, uid, []string{"lkasdj"}, time.Time{})
+	require.NoError(t, err)
+
+	out.Reset()
+	require.NoError(t, run(ctx, s, []string{"list", uid.String()}, nil, &out))
+	assert.Equal(t, strings.Join([]string{
+		"SHORT ID  ORIGINAL URL                   STATUS",
+		"abcdef    https://github.com/serjyuriev  deleted",
+		"lkasdj    https://yandex.ru              active",
+		"",
+	}, "\n"), out.String())
+
+	out.Reset()
+	require.NoError(t, run(ctx, s, []string{"restore", "abcdef"}, nil, &out))
+	assert.Equal(t, "restored 1 links\n", out.String())
+
+	out.Reset()
+	require.NoError(t, run(ctx, s, []string{"restore", "abcdef", "lkasdj"}, nil, &out))
+	assert.Equal(t, "restored 0 links\n", out.String(), "not deleted links are not counted")
+
+	out.Reset()
+	require.NoError(t, run(ctx, s, []string{"lookup", "abcdef"}, nil, &out))
+	var found map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &found))
+	assert.NotEmpty(t, found["created_at"])
+	assert.NotEmpty(t, found["updated_at"])
+	delete(found, "created_at")
+	delete(found, "updated_at")
+	delete(found, "deleted_at")
+	assert.Equal(t, map[string]interface{}{
+		"short_id":     "abcdef",
+		"original_url": "https://github.com/serjyuriev",
+		"user_id":      "6577f191-a012-4f16-afe4-6ed0d542e523",
+		"workspace_id": "00000000-0000-0000-0000-000000000000",
+		"is_deleted":   false,
+		"is_disabled":  false,
+	}, found)
+
+	err = run(ctx, s, []string{"lookup", "qwerty"}, nil, &out)
+	assert.ErrorIs(t, err, storage.ErrNoURLWasFound)
+
+	err = run(ctx, s, []string{"export", "-format", "xml"}, nil, &out)
+	assert.ErrorIs(t, err, errUnknownFormat)
+
+	err = run(ctx, s, nil, nil, &out)
+	assert.ErrorIs(t, err, errUsage)
+}
+
+func Test_run_migrate(t *testing.T) {
+	ctx := context.Background()
+	uid := uuid.New()
+	src, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	require.NoError(t, src.InsertManyURLs(ctx, uid, map[string]string{
+		"abcdef": "https://github.com/serjyuriev",
+		"lkasdj": "https://yandex.ru",
+	}))
+
+	target := t.TempDir() + "/target.json"
+	var out bytes.Buffer
+	err = run(ctx, src, []string{"verify", "-to-file", target}, nil, &out)
+	assert.ErrorIs(t, err, errMismatch)
+
+	out.Reset()
+	err = run(ctx, src, []string{"migrate", "-to-file", target}, nil, &out)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out.String(), "inserted 2, updated 0, unchanged 0, conflicted 0 links\n"))
+
+	require.NoError(t, src.DeleteManyURLs(ctx, uid, []string{"abcdef"}))
+	out.Reset()
+	err = run(ctx, src, []string{"verify", "-to-file", target}, nil, &out)
+	assert.ErrorIs(t, err, errMismatch)
+
+	out.Reset()
+	err = run(ctx, src, []string{"migrate", "-to-file", target}, nil, &out)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out.String(), "inserted 0, updated 1, unchanged 1, conflicted 0 links\n"))
+
+	require.NoError(t, src.InsertAccount(ctx, storage.Account{ID: uid, Login: "serj", PasswordHash: "hash"}))
+	out.Reset()
+	err = run(ctx, src, []string{"verify", "-to-file", target}, nil, &out)
+	assert.ErrorIs(t, err, errMismatch)
+
+	out.Reset()
+	err = run(ctx, src, []string{"migrate", "-to-file", target}, nil, &out)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "accounts: inserted 1, updated 0, unchanged 0, deleted 0, conflicted 0\n")
+	assert.Contains(t, out.String(), "target: 2 links, 1 accounts, 0 workspaces, 0 templates, 0 tokens, checksum ")
+
+	err = run(ctx, src, []string{"migrate"}, nil, &out)
+	assert.ErrorIs(t, err, errNoTarget)
+}
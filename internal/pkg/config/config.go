@@ -9,6 +9,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -19,8 +20,67 @@ type Config struct {
 	BaseURL         string `json:"base_url" env:"BASE_URL" envDefault:"http://localhost:8080"`
 	DatabaseDSN     string `json:"database_dsn,omitempty" env:"DATABASE_DSN"`
 	FileStoragePath string `json:"file_storage_path,omitempty" env:"FILE_STORAGE_PATH"`
-	Protocol        string `json:"protocol" env:"-"`
-	ServerAddress   string `json:"server_address" env:"SERVER_ADDRESS" envDefault:"localhost:8080"`
+	// MirrorDatabaseDSN and MirrorFileStoragePath configure storage
+	// receiving copies of all writes during migration to it.
+	MirrorDatabaseDSN     string `json:"mirror_database_dsn,omitempty" env:"MIRROR_DATABASE_DSN"`
+	MirrorFileStoragePath string `json:"mirror_file_storage_path,omitempty" env:"MIRROR_FILE_STORAGE_PATH"`
+	Protocol              string `json:"protocol" env:"-"`
+	ServerAddress         string `json:"server_address" env:"SERVER_ADDRESS" envDefault:"localhost:8080"`
+	// RestoreGracePeriod is a period during which deleted URLs may be restored.
+	// After it passes, deleted URLs are removed permanently. Zero keeps them forever.
+	// JSON config expects it in nanoseconds.
+	RestoreGracePeriod time.Duration `json:"restore_grace_period" env:"RESTORE_GRACE_PERIOD"`
+	// RedirectCode is HTTP status code of redirects by short URLs
+	// that don't specify their own one (301, 302, 307 or 308).
+	RedirectCode int `json:"redirect_code" env:"REDIRECT_CODE"`
+	// AuthKeys are keys signing user ID cookies as "id:secret" pairs separated by commas.
+	// AuthKeysFile is a file with such pairs, one per line, preceding AuthKeys.
+	// The last key signs new cookies, all of them verify existing ones.
+	AuthKeys     string `json:"auth_keys,omitempty" env:"AUTH_KEYS"`
+	AuthKeysFile string `json:"auth_keys_file,omitempty" env:"AUTH_KEYS_FILE"`
+	// CookieLifetime is a lifetime of user ID cookie, it is renewed
+	// after half of it passes. JSON config expects it in nanoseconds.
+	CookieLifetime time.Duration `json:"cookie_lifetime" env:"COOKIE_LIFETIME"`
+	// LegacyCookiesUntil is RFC 3339 time or date until which user ID cookies
+	// without issue time are accepted and renewed. Empty rejects them.
+	LegacyCookiesUntil string `json:"legacy_cookies_until,omitempty" env:"LEGACY_COOKIES_UNTIL"`
+	// LegacyCookieSecret verifies cookies without key ID issued before auth keys
+	// were configurable, empty rejects them. It must not be one of auth keys.
+	// Such cookies don't identify administrators and registered accounts.
+	LegacyCookieSecret string `json:"legacy_cookie_secret,omitempty" env:"LEGACY_COOKIE_SECRET"`
+	// JWTAlgorithm makes user ID cookies JSON Web Tokens signed with keys
+	// from JWTKeysFile: "id:secret" pairs for HS256, which must differ from auth keys,
+	// or PEM-encoded Ed25519 private keys for EdDSA.
+	// Empty keeps cookies of the previous format. JWTIssuer is put into
+	// and required from tokens, JWTLeeway is a tolerated clock skew.
+	JWTAlgorithm string        `json:"jwt_algorithm,omitempty" env:"JWT_ALGORITHM"`
+	JWTKeysFile  string        `json:"jwt_keys_file,omitempty" env:"JWT_KEYS_FILE"`
+	JWTIssuer    string        `json:"jwt_issuer,omitempty" env:"JWT_ISSUER"`
+	JWTLeeway    time.Duration `json:"jwt_leeway" env:"JWT_LEEWAY"`
+	// TransferLifetime is a period during which token transferring
+	// links to another user may be redeemed.
+	TransferLifetime time.Duration `json:"transfer_lifetime" env:"TRANSFER_LIFETIME"`
+	// AdminToken is a bearer token granting access to admin API,
+	// AdminUserIDs are comma-separated IDs of users having such access.
+	// Admin API is disabled if both are empty.
+	AdminToken   string `json:"admin_token,omitempty" env:"ADMIN_TOKEN"`
+	AdminUserIDs string `json:"admin_user_ids,omitempty" env:"ADMIN_USER_IDS"`
+	// CreateRateLimit and RedirectRateLimit are numbers of requests per second
+	// creating and following links allowed to every user and every client IP,
+	// zero disables limit. Bursts are numbers of requests allowed at once.
+	CreateRateLimit   float64 `json:"create_rate_limit" env:"CREATE_RATE_LIMIT"`
+	CreateRateBurst   int     `json:"create_rate_burst" env:"CREATE_RATE_BURST"`
+	RedirectRateLimit float64 `json:"redirect_rate_limit" env:"REDIRECT_RATE_LIMIT"`
+	RedirectRateBurst int     `json:"redirect_rate_burst" env:"REDIRECT_RATE_BURST"`
+	// TrustedProxies are comma-separated IP addresses and networks of proxies
+	// whose X-Forwarded-For header is used to find out client IP.
+	TrustedProxies string `json:"trusted_proxies,omitempty" env:"TRUSTED_PROXIES"`
+	// MaxLinksPerUser and MaxBatchSize cap numbers of not deleted links of every user
+	// and of links created at once, zero means no limit. QuotaOverrides replace them
+	// for some users as comma-separated "user-id:max-links:max-batch-size" entries.
+	MaxLinksPerUser int    `json:"max_links_per_user" env:"MAX_LINKS_PER_USER"`
+	MaxBatchSize    int    `json:"max_batch_size" env:"MAX_BATCH_SIZE"`
+	QuotaOverrides  string `json:"quota_overrides,omitempty" env:"QUOTA_OVERRIDES"`
 	EnableHTTPS     bool   `json:"enable_https" env:"ENABLE_HTTPS" envDefault:"false"`
 }
 
@@ -29,12 +89,31 @@ func (c *Config) String() string {
 	return fmt.Sprintf(`
 
 	loaded configuration
-		BaseURL:         %s
-		DatabaseDSN:     %s
-		FileStoragePath: %s
-		Protocol:        %s
-		ServerAddress:   %s
-	`, c.BaseURL, c.DatabaseDSN, c.FileStoragePath, c.Protocol, c.ServerAddress)
+		BaseURL:               %s
+		DatabaseDSN:           %s
+		FileStoragePath:       %s
+		MirrorDatabaseDSN:     %s
+		MirrorFileStoragePath: %s
+		Protocol:              %s
+		ServerAddress:         %s
+		RestoreGracePeriod:    %s
+		RedirectCode:          %d
+		AuthKeysFile:          %s
+		CookieLifetime:        %s
+		LegacyCookiesUntil:    %s
+		JWTAlgorithm:          %s
+		JWTKeysFile:           %s
+		JWTIssuer:             %s
+		JWTLeeway:             %s
+		TransferLifetime:      %s
+		AdminUserIDs:          %s
+		CreateRateLimit:       %g/%d
+		RedirectRateLimit:     %g/%d
+		TrustedProxies:        %s
+		MaxLinksPerUser:       %d
+		MaxBatchSize:          %d
+		QuotaOverrides:        %s
+	`, c.BaseURL, c.DatabaseDSN, c.FileStoragePath, c.MirrorDatabaseDSN, c.MirrorFileStoragePath, c.Protocol, c.ServerAddress, c.RestoreGracePeriod, c.RedirectCode, c.AuthKeysFile, c.CookieLifetime, c.LegacyCookiesUntil, c.JWTAlgorithm, c.JWTKeysFile, c.JWTIssuer, c.JWTLeeway, c.TransferLifetime, c.AdminUserIDs, c.CreateRateLimit, c.CreateRateBurst, c.RedirectRateLimit, c.RedirectRateBurst, c.TrustedProxies, c.MaxLinksPerUser, c.MaxBatchSize, c.QuotaOverrides)
 }
 
 var once sync.Once
@@ -51,8 +130,32 @@ func GetConfig() *Config {
 		flag.StringVar(&cfg.BaseURL, "b", "http://localhost:8080", "base URL for shorten links")
 		flag.StringVar(&cfg.DatabaseDSN, "d", "", "data source name")
 		flag.StringVar(&cfg.FileStoragePath, "f", "shorten.json", "shorten URL file path")
+		flag.StringVar(&cfg.MirrorDatabaseDSN, "md", "", "mirror storage data source name")
+		flag.StringVar(&cfg.MirrorFileStoragePath, "mf", "", "mirror storage file path")
 		flag.StringVar(&cfg.Protocol, "p", "http", "protocol to use (http/https)")
 		flag.StringVar(&cfg.ServerAddress, "a", "localhost:8080", "web server address")
+		flag.DurationVar(&cfg.RestoreGracePeriod, "rg", 0, "period during which deleted URLs may be restored before they are removed permanently (0 keeps them forever)")
+		flag.IntVar(&cfg.RedirectCode, "rc", 307, "default redirect status code (301/302/307/308)")
+		flag.StringVar(&cfg.AuthKeys, "ak", "", "keys signing cookies as comma-separated id:secret pairs, the last one is the newest")
+		flag.StringVar(&cfg.AuthKeysFile, "akf", "", "file with keys signing cookies as id:secret pairs, one per line")
+		flag.DurationVar(&cfg.CookieLifetime, "cl", 30*24*time.Hour, "lifetime of user ID cookie")
+		flag.StringVar(&cfg.LegacyCookiesUntil, "lcu", "", "RFC 3339 time or date until which user ID cookies without issue time are accepted")
+		flag.StringVar(&cfg.LegacyCookieSecret, "lcs", "", "secret verifying user ID cookies without key id until legacy cookies cutoff (empty rejects them)")
+		flag.StringVar(&cfg.JWTAlgorithm, "ja", "", "algorithm signing user ID cookies as JWT (HS256/EdDSA), empty disables JWT")
+		flag.StringVar(&cfg.JWTKeysFile, "jkf", "", "file with id:secret pairs signing HS256 JWT or PEM-encoded Ed25519 private keys signing EdDSA JWT, the last one is the newest")
+		flag.StringVar(&cfg.JWTIssuer, "ji", "", "issuer of JWT")
+		flag.DurationVar(&cfg.JWTLeeway, "jl", time.Minute, "clock skew tolerated when verifying JWT")
+		flag.DurationVar(&cfg.TransferLifetime, "tl", 15*time.Minute, "lifetime of token transferring links to another user")
+		flag.StringVar(&cfg.AdminToken, "at", "", "bearer token granting access to admin API")
+		flag.StringVar(&cfg.AdminUserIDs, "au", "", "comma-separated IDs of users having access to admin API")
+		flag.Float64Var(&cfg.CreateRateLimit, "crl", 0, "requests per second creating links allowed to every user and IP (0 disables limit)")
+		flag.IntVar(&cfg.CreateRateBurst, "crb", 20, "requests creating links allowed to every user and IP at once")
+		flag.Float64Var(&cfg.RedirectRateLimit, "rrl", 0, "requests per second following links allowed to every user and IP (0 disables limit)")
+		flag.IntVar(&cfg.RedirectRateBurst, "rrb", 50, "requests following links allowed to every user and IP at once")
+		flag.StringVar(&cfg.TrustedProxies, "tp", "", "comma-separated IP addresses and networks of trusted proxies")
+		flag.IntVar(&cfg.MaxLinksPerUser, "ml", 0, "maximum number of not deleted links of every user (0 means no limit)")
+		flag.IntVar(&cfg.MaxBatchSize, "mb", 0, "maximum number of links created at once (0 means no limit)")
+		flag.StringVar(&cfg.QuotaOverrides, "qo", "", "quotas of some users as comma-separated user-id:max-links:max-batch-size entries")
 		flag.BoolVar(&cfg.EnableHTTPS, "s", false, "enable https")
 		flag.Parse()
 
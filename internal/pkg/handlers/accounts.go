@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/serjyuriev/shortener/internal/pkg/service"
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+// SwitchUser makes subsequent requests of client be made on behalf
+// of user with provided ID by issuing new session cookie.
+// Authentication middleware puts it into context of requests
+// identified by cookie; requests made with API tokens don't have it.
+type SwitchUser func(w http.ResponseWriter, uid string)
+
+var contextKeySwitchUser = ContextKey("switchUser")
+
+type (
+	credentials struct {
+		Login    string `json:"login"`
+		Password string `json:"password"`
+	}
+
+	account struct {
+		CreatedAt time.Time `json:"created_at"`
+		Login     string    `json:"login"`
+	}
+)
+
+// LoginHandler checks credentials of account and issues session cookie of it.
+// URLs shortened by current anonymous user are moved to the account.
+func (h *Handlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	h.authenticate(w, r, http.StatusOK, h.svc.Login)
+}
+
+// LogoutHandler issues session cookie of new anonymous user.
+func (h *Handlers) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	switchUser, ok := r.Context().Value(contextKeySwitchUser).(SwitchUser)
+	if !ok {
+		http.Error(w, "sessions are not available with API tokens", http.StatusForbidden)
+		return
+	}
+	switchUser(w, uuid.New().String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegisterHandler creates account owning URLs shortened by current user
+// and issues session cookie of it.
+func (h *Handlers) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	h.authenticate(w, r, http.StatusCreated, h.svc.Register)
+}
+
+// authenticate performs registration or login with credentials from request
+// and switches current user to the account.
+func (h *Handlers) authenticate(
+	w http.ResponseWriter,
+	r *http.Request,
+	statusCode int,
+	fn func(ctx context.Context, userID, login, password string) (storage.Account, error),
+) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	switchUser, ok := r.Context().Value(contextKeySwitchUser).(SwitchUser)
+	if !ok {
+		http.Error(w, "sessions are not available with API tokens", http.StatusForbidden)
+		return
+	}
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	a, err := fn(ctx, uid, req.Login, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidLogin), errors.Is(err, service.ErrWeakPassword):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, storage.ErrAccountExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, service.ErrInvalidCredentials):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case errors.Is(err, service.ErrQuotaExceeded):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, service.ErrTooManyAttempts):
+			w.Header().Set("Retry-After", strconv.Itoa(int(service.PasswordAttemptWindow.Seconds())))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			log.Printf("unable to authenticate user: %v\n", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	switchUser(w, a.ID.String())
+	writeJSON(w, statusCode, account{CreatedAt: a.CreatedAt, Login: a.Login})
+}
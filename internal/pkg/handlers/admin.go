@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+type (
+	adminURL struct {
+		userURLs
+		UserID string `json:"user_id"`
+	}
+
+	adminURLRequest struct {
+		Disabled *bool `json:"disabled"`
+	}
+
+	adminUser struct {
+		UserID   string `json:"user_id"`
+		Total    int    `json:"total"`
+		Deleted  int    `json:"deleted"`
+		Disabled int    `json:"disabled"`
+	}
+)
+
+// GetAdminURLsHandler streams links of all users along with IDs of their owners
+// the same way GetUserURLsAPIHandler streams links of current user.
+// Query parameter q searches links by original URL.
+func (h *Handlers) GetAdminURLsHandler(w http.ResponseWriter, r *http.Request) {
+	h.streamLinks(w, r, func(opts storage.ListOptions, fn func(storage.Link) error) error {
+		return h.svc.IterateAllURLs(r.Context(), opts, fn)
+	}, func(l storage.Link) interface{} {
+		return h.adminURL(l)
+	})
+}
+
+// GetAdminUsersHandler returns number of links added by every user,
+// users having more links go first.
+func (h *Handlers) GetAdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	counts, err := h.svc.CountURLsByUser(ctx)
+	if err != nil {
+		log.Printf("unable to count user URLs: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(counts) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	res := make([]adminUser, 0, len(counts))
+	for _, c := range counts {
+		res = append(res, adminUser{
+			UserID:   c.UserID.String(),
+			Total:    c.Total,
+			Deleted:  c.Deleted,
+			Disabled: c.Disabled,
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// PatchAdminURLHandler disables or enables link of any user.
+// Disabled links are kept by their owners but can't be followed.
+func (h *Handlers) PatchAdminURLHandler(w http.ResponseWriter, r *http.Request) {
+	var req adminURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Disabled == nil {
+		http.Error(w, "Disabled mark must be provided.", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	l, err := h.svc.SetDisabled(ctx, chi.URLParam(r, "shortPath"), *req.Disabled)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoURLWasFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("unable to disable URL: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.adminURL(l))
+}
+
+func (h *Handlers) adminURL(l storage.Link) adminURL {
+	return adminURL{userURLs: h.userURL(l), UserID: l.UserID.String()}
+}
@@ -2,16 +2,24 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+
 	"github.com/serjyuriev/shortener/internal/pkg/config"
 	"github.com/serjyuriev/shortener/internal/pkg/service"
 	"github.com/serjyuriev/shortener/internal/pkg/shorty"
@@ -19,13 +27,49 @@ import (
 )
 
 type userURLs struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	CreatedAt         time.Time           `json:"created_at"`
+	UpdatedAt         time.Time           `json:"updated_at"`
+	DeletedAt         *time.Time          `json:"deleted_at,omitempty"`
+	ShortURL          string              `json:"short_url"`
+	OriginalURL       string              `json:"original_url"`
+	CreatorIP         string              `json:"creator_ip,omitempty"`
+	CreatorUAHash     string              `json:"creator_ua_hash,omitempty"`
+	Tags              []string            `json:"tags,omitempty"`
+	MaxUses           int                 `json:"max_uses,omitempty"`
+	RedirectCode      int                 `json:"redirect_code,omitempty"`
+	Passthrough       storage.Passthrough `json:"passthrough,omitempty"`
+	RemainingUses     *int                `json:"remaining_uses,omitempty"`
+	IsDeleted         bool                `json:"is_deleted,omitempty"`
+	IsDisabled        bool                `json:"is_disabled,omitempty"`
+	PasswordProtected bool                `json:"password_protected,omitempty"`
+	WorkspaceID       string              `json:"workspace_id,omitempty"`
+}
+
+type (
+	patchURLRequest struct {
+		OriginalURL string `json:"original_url"`
+	}
+
+	urlRevision struct {
+		ReplacedAt  time.Time `json:"replaced_at"`
+		OriginalURL string    `json:"original_url"`
+	}
+)
+
+type userTag struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
 }
 
 type (
 	postShortenRequest struct {
-		URL string `json:"url"`
+		URL          string              `json:"url"`
+		Password     string              `json:"password,omitempty"`
+		Tags         []string            `json:"tags,omitempty"`
+		MaxUses      int                 `json:"max_uses,omitempty"`
+		RedirectCode int                 `json:"redirect_code,omitempty"`
+		Passthrough  storage.Passthrough `json:"passthrough,omitempty"`
+		Template     string              `json:"template,omitempty"`
 	}
 
 	postShortenResponse struct {
@@ -35,8 +79,9 @@ type (
 
 type (
 	postBatchSingleRequest struct {
-		CorrelationID string `json:"correlation_id"`
-		OriginalURL   string `json:"original_url"`
+		CorrelationID string   `json:"correlation_id"`
+		OriginalURL   string   `json:"original_url"`
+		Tags          []string `json:"tags,omitempty"`
 	}
 
 	postBatchSingleResponse struct {
@@ -47,26 +92,82 @@ type (
 
 type ContextKey string
 
+// maxUserURLsLimit is a maximum size of user URLs page.
+const maxUserURLsLimit = 1000
+
+// permanentRedirectMaxAge is a number of seconds clients may cache
+// permanent redirects for. It is limited, as destination of URL may be changed.
+const permanentRedirectMaxAge = 24 * 60 * 60
+
+// redirectCodes lists HTTP status codes allowed for redirects by short URLs.
+var redirectCodes = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+// ErrInvalidRedirectCode is returned when redirect code is not a redirect one.
+var ErrInvalidRedirectCode = errors.New("redirect code must be one of 301, 302, 307, 308")
+
+// ErrProtectedConflict is returned when password or max uses are requested
+// for original URL that is already shortened, as existing link doesn't have them.
+var ErrProtectedConflict = errors.New("original URL is already shortened, password and max uses can't be applied to existing link")
+
 var contextKeyUID = ContextKey("uid")
 
-// Handlers store link to service layer and app's base URL.
+// Handlers store link to service layer, app's base URL and default redirect code.
 type Handlers struct {
-	svc     service.Service
-	baseURL string
+	svc          service.Service
+	transfers    Transfers
+	baseURL      string
+	redirectCode int
+	// trustedProxies are networks of proxies whose X-Forwarded-For header
+	// is used to find out IP of link creator.
+	trustedProxies []*net.IPNet
 }
 
-// MakeHandlers initializes application handler functions and service layer.
-func MakeHandlers() (*Handlers, error) {
-	svc, err := service.NewService()
-	if err != nil {
-		return nil, fmt.Errorf("unable to create new service:\n%w", err)
+// MakeHandlers initializes application handler functions
+// on top of provided service layer according to application configuration.
+func MakeHandlers(svc service.Service) (*Handlers, error) {
+	cfg := config.GetConfig()
+	h := NewHandlers(svc, cfg.BaseURL)
+	if err := h.SetRedirectCode(cfg.RedirectCode); err != nil {
+		return nil, err
 	}
+	return h, nil
+}
 
-	cfg := config.GetConfig()
+// NewHandlers initializes application handler functions
+// on top of provided service layer.
+func NewHandlers(svc service.Service, baseURL string) *Handlers {
 	return &Handlers{
-		baseURL: cfg.BaseURL,
-		svc:     svc,
-	}, nil
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		redirectCode: http.StatusTemporaryRedirect,
+		svc:          svc,
+	}
+}
+
+// SetRedirectCode changes HTTP status code of redirects
+// by short URLs that don't specify their own one.
+func (h *Handlers) SetRedirectCode(code int) error {
+	if !redirectCodes[code] {
+		return fmt.Errorf("unable to use redirect code %d:\n%w", code, ErrInvalidRedirectCode)
+	}
+	h.redirectCode = code
+	return nil
+}
+
+// SetTransfers enables transfers of links between users
+// with tokens issued and redeemed by provided Transfers.
+func (h *Handlers) SetTransfers(t Transfers) {
+	h.transfers = t
+}
+
+// SetTrustedProxies changes networks of proxies whose X-Forwarded-For header
+// is used to find out IP address of client creating links.
+func (h *Handlers) SetTrustedProxies(proxies []*net.IPNet) {
+	h.trustedProxies = proxies
 }
 
 // DeleteURLsHandler removes URLs provided by user from storage.
@@ -92,51 +193,138 @@ func (h *Handlers) DeleteURLsHandler(w http.ResponseWriter, r *http.Request) {
 // GetURLHandler searches service store for provided short URL
 // and, if such URL is found, sends a response,
 // redirecting to the corresponding long URL.
+// For URLs protected with password a form asking for it is sent instead.
+// Query string and sub-path of request are forwarded
+// according to passthrough mode of URL.
 func (h *Handlers) GetURLHandler(w http.ResponseWriter, r *http.Request) {
-	shortPath := strings.TrimPrefix(r.URL.Path, "/")
+	shortPath := shortPathFromRequest(r)
 	if shortPath == "" {
 		http.Error(w, "No short URL is provided.", http.StatusBadRequest)
 		return
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
 	defer cancel()
-	original, err := h.svc.FindOriginalURL(ctx, shortPath)
+	l, err := h.svc.ResolveURL(ctx, shortPath, "", visitFromRequest(r))
 	if err != nil {
-		if errors.Is(err, storage.ErrShortenedDeleted) {
+		if errors.Is(err, storage.ErrShortenedDeleted) || errors.Is(err, storage.ErrLinkExhausted) || errors.Is(err, storage.ErrLinkDisabled) {
 			w.WriteHeader(http.StatusGone)
 			return
 		}
+		if errors.Is(err, service.ErrSubPathNotAllowed) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, service.ErrPasswordRequired) {
+			writePasswordForm(w, http.StatusOK, "")
+			return
+		}
 		log.Printf("unable to find full URL: %v\n", err)
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	w.Header().Add("Location", string(original))
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	h.writeRedirect(w, l)
 }
 
-// GetUserURLsAPIHandler returns all URLs that were added by current user.
+// GetUserURLsAPIHandler streams URLs that were added by current user
+// ordered by creation time and filtered according to query parameters.
+// If limit or cursor query parameter is provided,
+// a single page is returned along with the cursor of the next one.
 func (h *Handlers) GetUserURLsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	h.streamURLs(w, r, func(opts storage.ListOptions, fn func(storage.Link) error) error {
+		return h.svc.IterateUserURLs(r.Context(), uid, opts, fn)
+	})
+}
+
+// streamURLs writes links provided by iterate filtered according to query
+// parameters of request, as a whole or by pages.
+func (h *Handlers) streamURLs(w http.ResponseWriter, r *http.Request, iterate func(storage.ListOptions, func(storage.Link) error) error) {
+	h.streamLinks(w, r, iterate, func(l storage.Link) interface{} {
+		return h.userURL(l)
+	})
+}
+
+// streamLinks works as streamURLs, writing links in representation made by view.
+func (h *Handlers) streamLinks(w http.ResponseWriter, r *http.Request, iterate func(storage.ListOptions, func(storage.Link) error) error, view func(storage.Link) interface{}) {
+	q := r.URL.Query()
+	opts, err := listOptionsFromQuery(q)
+	if err != nil {
+		log.Printf("unable to parse list options: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	paginated := q.Has("limit") || q.Has("cursor")
+
+	stream := &jsonStream{w: w, prefix: "["}
+	if paginated {
+		stream.prefix = `{"urls":[`
+		// one extra link shows whether there is a next page
+		opts.Limit++
+	}
+
+	var last storage.Link
+	hasMore := false
+	err = iterate(opts, func(l storage.Link) error {
+		if paginated && stream.count == opts.Limit-1 {
+			hasMore = true
+			return nil
+		}
+		last = l
+		return stream.write(view(l))
+	})
+	if err != nil {
+		if stream.count > 0 {
+			log.Printf("unable to stream user URLs: %v\n", err)
+			return
+		}
+		log.Printf("unable to find user URLs: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if !paginated {
+		if stream.count == 0 {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusNoContent)
+			w.Write([]byte(storage.ErrNoURLWasFound.Error()))
+			return
+		}
+		stream.close("]")
+		return
+	}
+
+	next := ""
+	if hasMore {
+		next = last.Cursor().String()
+	}
+	suffix, err := json.Marshal(next)
+	if err != nil {
+		log.Printf("unable to marshal next cursor: %v\n", err)
+		return
+	}
+	stream.close(`],"next_cursor":` + string(suffix) + "}")
+}
+
+// GetURLHistoryHandler returns previous original URLs
+// of URL added by current user, oldest first.
+func (h *Handlers) GetURLHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	uid := r.Context().Value(contextKeyUID).(string)
 	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
 	defer cancel()
-	m, err := h.svc.FindURLsByUser(ctx, uid)
+	history, err := h.svc.FindURLHistory(ctx, uid, chi.URLParam(r, "shortPath"))
 	if err != nil {
 		if errors.Is(err, storage.ErrNoURLWasFound) {
-			w.Header().Set("Content-Type", "text/plain")
-			w.WriteHeader(http.StatusNoContent)
-			w.Write([]byte(err.Error()))
+			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
-		log.Printf("unable to find full URL: %v\n", err)
-		http.Error(w, "bad request", http.StatusBadRequest)
+		log.Printf("unable to find URL history: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
-	res := make([]userURLs, 0)
-	for key, val := range m {
-		res = append(res, userURLs{
-			ShortURL:    fmt.Sprintf("%s/%s", h.baseURL, key),
-			OriginalURL: val,
-		})
+
+	res := make([]urlRevision, 0, len(history))
+	for _, rev := range history {
+		res = append(res, urlRevision(rev))
 	}
 	json, err := json.Marshal(res)
 	if err != nil {
@@ -149,6 +337,97 @@ func (h *Handlers) GetUserURLsAPIHandler(w http.ResponseWriter, r *http.Request)
 	w.Write(json)
 }
 
+// GetUserTagsHandler returns tags of URLs added by current user
+// along with number of URLs marked with each of them.
+func (h *Handlers) GetUserTagsHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	counts, err := h.svc.FindTagsByUser(ctx, uid)
+	if err != nil {
+		log.Printf("unable to find user tags: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(counts) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	res := make([]userTag, 0, len(counts))
+	for tag, count := range counts {
+		res = append(res, userTag{Tag: tag, Count: count})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Tag < res[j].Tag
+	})
+	json, err := json.Marshal(res)
+	if err != nil {
+		log.Printf("unable to marshal response: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(json)
+}
+
+// PatchURLHandler changes original URL of URL added by current user,
+// returning updated URL. Previous original URL is kept in URL history.
+func (h *Handlers) PatchURLHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	h.patchURL(w, r, func(ctx context.Context, shortPath, originalURL string) (storage.Link, error) {
+		return h.svc.UpdateOriginalURL(ctx, uid, shortPath, originalURL)
+	})
+}
+
+// patchURL changes original URL of link with update, writing updated link.
+func (h *Handlers) patchURL(w http.ResponseWriter, r *http.Request, update func(ctx context.Context, shortPath, originalURL string) (storage.Link, error)) {
+	var req patchURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if len(req.OriginalURL) == 0 {
+		http.Error(w, "Original URL cannot be empty.", http.StatusBadRequest)
+		return
+	}
+	if _, err := url.ParseRequestURI(req.OriginalURL); err != nil {
+		log.Printf("unable to parse request URL: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	l, err := update(ctx, chi.URLParam(r, "shortPath"), req.OriginalURL)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNoURLWasFound):
+			http.Error(w, "not found", http.StatusNotFound)
+		case errors.Is(err, storage.ErrShortenedDeleted):
+			http.Error(w, "URL is deleted", http.StatusGone)
+		case errors.Is(err, storage.ErrNotUniqueOriginalURL):
+			http.Error(w, "original URL is already shortened", http.StatusConflict)
+		default:
+			log.Printf("unable to update URL: %v\n", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	json, err := json.Marshal(h.userURL(l))
+	if err != nil {
+		log.Printf("unable to marshal response: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(json)
+}
+
 // PingHandler provides health status of application.
 func (h *Handlers) PingHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
@@ -165,6 +444,8 @@ func (h *Handlers) PingHandler(w http.ResponseWriter, r *http.Request) {
 
 // PostBatchHandler adds URLs provided by user into storage,
 // returning shortened URLs with corresponding correlation ID.
+// UTM template may be chosen with template query parameter,
+// default template of user is applied otherwise.
 func (h *Handlers) PostBatchHandler(w http.ResponseWriter, r *http.Request) {
 	uid := r.Context().Value(contextKeyUID).(string)
 	var req []postBatchSingleRequest
@@ -175,7 +456,7 @@ func (h *Handlers) PostBatchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	res := make([]postBatchSingleResponse, 0)
-	m := make(map[string]string)
+	links := make([]storage.Link, 0, len(req))
 	for _, sreq := range req {
 		s := shorty.GenerateShortPath()
 		sres := postBatchSingleResponse{
@@ -183,10 +464,29 @@ func (h *Handlers) PostBatchHandler(w http.ResponseWriter, r *http.Request) {
 			ShortURL:      fmt.Sprintf("%s/%s", h.baseURL, s),
 		}
 		res = append(res, sres)
-		m[s] = sreq.OriginalURL
+		l := h.newLink(r, s, sreq.OriginalURL)
+		l.Tags = sreq.Tags
+		links = append(links, l)
 	}
 
-	if err := h.svc.InsertManyURLs(r.Context(), uid, m); err != nil {
+	if err := h.svc.ApplyTemplate(r.Context(), uid, r.URL.Query().Get("template"), links); err != nil {
+		if errors.Is(err, storage.ErrNoTemplateWasFound) {
+			http.Error(w, "template not found", http.StatusBadRequest)
+			return
+		}
+		log.Printf("unable to apply template: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.svc.InsertLinks(r.Context(), uid, links); err != nil {
+		if errors.Is(err, service.ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, storage.ErrInvalidTag) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		log.Printf("unable to insert urls: %v\n", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
@@ -203,9 +503,53 @@ func (h *Handlers) PostBatchHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(json)
 }
 
+// PostPasswordHandler checks password submitted with form for protected short URL
+// and, if it is correct, redirects to the corresponding long URL.
+// After too many wrong attempts URL is locked for a while.
+func (h *Handlers) PostPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	shortPath := shortPathFromRequest(r)
+	if err := r.ParseForm(); err != nil {
+		log.Printf("unable to parse form: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// password check is slow on purpose, so it has more time than other requests
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	l, err := h.svc.ResolveURL(ctx, shortPath, r.PostForm.Get("password"), visitFromRequest(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrShortenedDeleted), errors.Is(err, storage.ErrLinkExhausted), errors.Is(err, storage.ErrLinkDisabled):
+			w.WriteHeader(http.StatusGone)
+		case errors.Is(err, service.ErrSubPathNotAllowed):
+			http.Error(w, "not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrPasswordRequired), errors.Is(err, service.ErrWrongPassword):
+			writePasswordForm(w, http.StatusUnauthorized, "Wrong password.")
+		case errors.Is(err, service.ErrTooManyAttempts):
+			w.Header().Set("Retry-After", strconv.Itoa(int(service.PasswordAttemptWindow.Seconds())))
+			writePasswordForm(w, http.StatusTooManyRequests, "Too many attempts, try again later.")
+		default:
+			log.Printf("unable to find full URL: %v\n", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+		}
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Location", l.OriginalURL)
+	w.WriteHeader(http.StatusSeeOther)
+}
+
 // PostURLApiHandler adds single URL provided by user in JSON format into storage,
-// returning its generated short URL.
+// returning its generated short URL. Parameters of chosen or default
+// UTM template of user are added to original URL.
 func (h *Handlers) PostURLApiHandler(w http.ResponseWriter, r *http.Request) {
+	h.shorten(w, r, uuid.Nil)
+}
+
+// shorten adds single URL provided in JSON format into workspace with provided ID,
+// uuid.Nil meaning personal links of current user.
+func (h *Handlers) shorten(w http.ResponseWriter, r *http.Request, workspaceID uuid.UUID) {
 	var req postShortenRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("unable to decode request's body: %v\n", err)
@@ -221,19 +565,66 @@ func (h *Handlers) PostURLApiHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
+	if req.MaxUses < 0 {
+		http.Error(w, "Max uses cannot be negative.", http.StatusBadRequest)
+		return
+	}
+	if req.RedirectCode != 0 && !redirectCodes[req.RedirectCode] {
+		http.Error(w, ErrInvalidRedirectCode.Error(), http.StatusBadRequest)
+		return
+	}
 	s := shorty.GenerateShortPath()
 	uid := r.Context().Value(contextKeyUID).(string)
 	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
 	defer cancel()
 
+	l := h.newLink(r, s, req.URL)
+	l.Tags = req.Tags
+	l.MaxUses = req.MaxUses
+	l.RemainingUses = req.MaxUses
+	l.RedirectCode = req.RedirectCode
+	l.Passthrough = req.Passthrough
+	l.WorkspaceID = workspaceID
+	var err error
+	if l.PasswordHash, err = service.HashPassword(req.Password); err != nil {
+		if errors.Is(err, service.ErrInvalidPassword) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("unable to hash password: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	links := []storage.Link{l}
+	if err = h.svc.ApplyTemplate(ctx, uid, req.Template, links); err != nil {
+		if errors.Is(err, storage.ErrNoTemplateWasFound) {
+			http.Error(w, "template not found", http.StatusBadRequest)
+			return
+		}
+		log.Printf("unable to apply template: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
 	hadConflict := false
-	if err := h.svc.InsertNewURLPair(ctx, uid, s, req.URL); err != nil {
+	if err = h.svc.InsertLinks(ctx, uid, links); err != nil {
+		if errors.Is(err, service.ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, storage.ErrInvalidTag) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if !errors.Is(err, storage.ErrNotUniqueOriginalURL) {
 			log.Printf("unable to save URL: %v\n", err)
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
 		}
-		s, err = h.svc.FindByOriginalURL(r.Context(), req.URL)
+		if links[0].PasswordHash != "" || links[0].MaxUses > 0 {
+			http.Error(w, ErrProtectedConflict.Error(), http.StatusConflict)
+			return
+		}
+		s, err = h.svc.FindByOriginalURL(r.Context(), links[0].OriginalURL)
 		if err != nil {
 			log.Printf("unable to find original URL: %v\n", err)
 			http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -287,7 +678,11 @@ func (h *Handlers) PostURLHandler(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	hadConflict := false
-	if err = h.svc.InsertNewURLPair(ctx, uid, s, string(b)); err != nil {
+	if err = h.svc.InsertLinks(ctx, uid, []storage.Link{h.newLink(r, s, string(b))}); err != nil {
+		if errors.Is(err, service.ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		if !errors.Is(err, storage.ErrNotUniqueOriginalURL) {
 			log.Printf("unable to save URL: %v\n", err)
 			http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -311,3 +706,254 @@ func (h *Handlers) PostURLHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Write([]byte(shortURL))
 }
+
+// userURL converts link into its API representation.
+func (h *Handlers) userURL(l storage.Link) userURLs {
+	u := userURLs{
+		CreatedAt:         l.CreatedAt,
+		UpdatedAt:         l.UpdatedAt,
+		ShortURL:          fmt.Sprintf("%s/%s", h.baseURL, l.ShortPath),
+		OriginalURL:       l.OriginalURL,
+		CreatorIP:         l.CreatorIP,
+		CreatorUAHash:     l.CreatorUAHash,
+		Tags:              l.Tags,
+		IsDeleted:         l.IsDeleted,
+		IsDisabled:        l.IsDisabled,
+		MaxUses:           l.MaxUses,
+		RedirectCode:      l.RedirectCode,
+		Passthrough:       l.Passthrough,
+		PasswordProtected: l.PasswordHash != "",
+	}
+	if l.IsDeleted {
+		u.DeletedAt = &l.DeletedAt
+	}
+	if l.MaxUses > 0 {
+		u.RemainingUses = &l.RemainingUses
+	}
+	if l.WorkspaceID != uuid.Nil {
+		u.WorkspaceID = l.WorkspaceID.String()
+	}
+	return u
+}
+
+// writeRedirect redirects client to original URL of link with its own
+// or default redirect code. Permanent redirects may be cached for a while,
+// temporary ones must be revalidated and ones by URLs with limited uses
+// or password must not be cached at all.
+func (h *Handlers) writeRedirect(w http.ResponseWriter, l storage.Link) {
+	code := l.RedirectCode
+	if code == 0 {
+		code = h.redirectCode
+	}
+	if code == 0 {
+		code = http.StatusTemporaryRedirect
+	}
+	switch {
+	case l.MaxUses > 0 || l.PasswordHash != "":
+		w.Header().Set("Cache-Control", "no-store")
+	case code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect:
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", permanentRedirectMaxAge))
+	default:
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	w.Header().Add("Location", l.OriginalURL)
+	w.WriteHeader(code)
+}
+
+// newLink creates link on behalf of client that sent request,
+// keeping its IP address and hash of its user agent.
+func (h *Handlers) newLink(r *http.Request, shortPath, originalURL string) storage.Link {
+	l := storage.Link{
+		ShortPath:   shortPath,
+		OriginalURL: originalURL,
+		CreatorIP:   ClientIP(r, h.trustedProxies),
+	}
+	if ua := r.UserAgent(); ua != "" {
+		sum := sha256.Sum256([]byte(ua))
+		l.CreatorUAHash = hex.EncodeToString(sum[:])
+	}
+	return l
+}
+
+// listOptionsFromQuery parses pagination and filtering query parameters:
+// limit, cursor, q (substring of original URL), host, tag, status (active,
+// deleted or all), created_from and created_to (RFC 3339 time or date;
+// created_to date includes the whole day). Page size is capped at maxUserURLsLimit.
+func listOptionsFromQuery(q url.Values) (storage.ListOptions, error) {
+	var opts storage.ListOptions
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return opts, fmt.Errorf("invalid limit %q", v)
+		}
+		opts.Limit = limit
+	}
+	paginated := q.Has("limit") || q.Has("cursor")
+	if paginated && (opts.Limit == 0 || opts.Limit > maxUserURLsLimit) {
+		opts.Limit = maxUserURLsLimit
+	}
+
+	cursor, err := storage.ParseCursor(q.Get("cursor"))
+	if err != nil {
+		return opts, err
+	}
+	opts.After = cursor
+
+	opts.Query = q.Get("q")
+	opts.Host = q.Get("host")
+	opts.Tag = strings.ToLower(strings.TrimSpace(q.Get("tag")))
+
+	switch v := q.Get("status"); v {
+	case "", "active":
+		opts.Status = storage.StatusActive
+	case "deleted":
+		opts.Status = storage.StatusDeleted
+	case "all":
+		opts.Status = storage.StatusAll
+	default:
+		return opts, fmt.Errorf("invalid status %q", v)
+	}
+
+	if opts.CreatedFrom, err = parseTimeParam(q.Get("created_from"), false); err != nil {
+		return opts, err
+	}
+	if opts.CreatedTo, err = parseTimeParam(q.Get("created_to"), true); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// parseTimeParam parses RFC 3339 time or date. If endOfDay is set,
+// date is moved to the beginning of the next day.
+func parseTimeParam(v string, endOfDay bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", v)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// PutTagsHandler replaces tags of URL added by current user
+// with tags provided in request body, returning normalized ones.
+func (h *Handlers) PutTagsHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	var req []string
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	tags, err := h.svc.SetTags(ctx, uid, chi.URLParam(r, "shortPath"), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidTag):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, storage.ErrNoURLWasFound):
+			http.Error(w, "not found", http.StatusNotFound)
+		default:
+			log.Printf("unable to set tags: %v\n", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	json, err := json.Marshal(tags)
+	if err != nil {
+		log.Printf("unable to marshal response: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(json)
+}
+
+// RestoreURLsHandler removes deletion mark from URLs provided by user,
+// returning restored ones. URLs deleted longer than grace period ago
+// are removed permanently and can't be restored.
+func (h *Handlers) RestoreURLsHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+
+	var req []string
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if len(req) == 0 {
+		http.Error(w, "Body cannot be empty.", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	restored, err := h.svc.RestoreURLs(ctx, uid, req)
+	if err != nil {
+		if errors.Is(err, service.ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		log.Printf("unable to restore URLs: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	json, err := json.Marshal(restored)
+	if err != nil {
+		log.Printf("unable to marshal response: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(json)
+}
+
+// shortPathFromRequest extracts short path from router's URL parameters,
+// falling back to request's path if handler is called outside of router.
+func shortPathFromRequest(r *http.Request) string {
+	if shortPath := chi.URLParam(r, "shortPath"); shortPath != "" {
+		return shortPath
+	}
+	shortPath := strings.TrimPrefix(r.URL.Path, "/")
+	if i := strings.IndexByte(shortPath, '/'); i >= 0 {
+		shortPath = shortPath[:i]
+	}
+	return shortPath
+}
+
+// visitFromRequest extracts escaped sub-path following short path
+// and query string of request. Outside of router sub-path is taken from request's path.
+func visitFromRequest(r *http.Request) service.Visit {
+	v := service.Visit{RawQuery: r.URL.RawQuery}
+	if chi.URLParam(r, "shortPath") == "" {
+		path := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+		if i := strings.IndexByte(path, '/'); i >= 0 {
+			v.SubPath = path[i+1:]
+		}
+		return v
+	}
+	// router matches unescaped path unless it contains escaped characters
+	v.SubPath = chi.URLParam(r, "*")
+	if v.SubPath != "" && r.URL.RawPath == "" {
+		segments := strings.Split(v.SubPath, "/")
+		for i, s := range segments {
+			segments[i] = url.PathEscape(s)
+		}
+		v.SubPath = strings.Join(segments, "/")
+	}
+	return v
+}
@@ -4,29 +4,53 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/serjyuriev/shortener/internal/pkg/service"
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
 )
 
+// newExampleService creates service keeping links in memory.
+func newExampleService() (service.Service, error) {
+	store, err := storage.NewFileStore("")
+	if err != nil {
+		return nil, err
+	}
+	return service.NewServiceWithStore(store), nil
+}
+
+// newTestService creates service storing links in temporary directory of test.
+func newTestService(tb testing.TB) service.Service {
+	tb.Helper()
+	store, err := storage.NewFileStore(filepath.Join(tb.TempDir(), "shorten.json"))
+	require.NoError(tb, err)
+	return service.NewServiceWithStore(store)
+}
+
 func ExampleHandlers_DeleteURLsHandler() {
-	svc, err := service.NewService()
+	svc, err := newExampleService()
 	if err != nil {
 		fmt.Printf("unable to initiazlize service: %v\n", err)
 		return
 	}
+	defer svc.Close()
 	h := &Handlers{svc: svc}
 
 	uid := uuid.New().String()
@@ -86,11 +110,12 @@ func ExampleHandlers_DeleteURLsHandler() {
 }
 
 func ExampleHandlers_GetURLHandler() {
-	svc, err := service.NewService()
+	svc, err := newExampleService()
 	if err != nil {
 		fmt.Printf("unable to initiazlize service: %v\n", err)
 		return
 	}
+	defer svc.Close()
 	h := &Handlers{svc: svc}
 
 	uid := uuid.New().String()
@@ -136,11 +161,12 @@ func ExampleHandlers_GetURLHandler() {
 }
 
 func ExampleHandlers_GetUserURLsAPIHandler() {
-	svc, err := service.NewService()
+	svc, err := newExampleService()
 	if err != nil {
 		fmt.Printf("unable to initiazlize service: %v\n", err)
 		return
 	}
+	defer svc.Close()
 	h := &Handlers{svc: svc}
 
 	uid := uuid.New().String()
@@ -204,11 +230,12 @@ func ExampleHandlers_GetUserURLsAPIHandler() {
 }
 
 func ExampleHandlers_PingHandler() {
-	svc, err := service.NewService()
+	svc, err := newExampleService()
 	if err != nil {
 		fmt.Printf("unable to initiazlize service: %v\n", err)
 		return
 	}
+	defer svc.Close()
 	h := &Handlers{svc: svc}
 
 	request, err := http.NewRequest(
@@ -242,11 +269,12 @@ func ExampleHandlers_PingHandler() {
 }
 
 func ExampleHandlers_PostBatchHandler() {
-	svc, err := service.NewService()
+	svc, err := newExampleService()
 	if err != nil {
 		fmt.Printf("unable to initiazlize service: %v\n", err)
 		return
 	}
+	defer svc.Close()
 	h := &Handlers{svc: svc}
 
 	uid := uuid.New().String()
@@ -315,11 +343,12 @@ func ExampleHandlers_PostBatchHandler() {
 }
 
 func ExampleHandlers_PostURLApiHandler() {
-	svc, err := service.NewService()
+	svc, err := newExampleService()
 	if err != nil {
 		fmt.Printf("unable to initiazlize service: %v\n", err)
 		return
 	}
+	defer svc.Close()
 	h := &Handlers{svc: svc}
 
 	uid := uuid.New().String()
@@ -359,11 +388,12 @@ func ExampleHandlers_PostURLApiHandler() {
 }
 
 func ExampleHandlers_PostURLHandler() {
-	svc, err := service.NewService()
+	svc, err := newExampleService()
 	if err != nil {
 		fmt.Printf("unable to initiazlize service: %v\n", err)
 		return
 	}
+	defer svc.Close()
 	h := &Handlers{svc: svc}
 
 	uid := uuid.New().String()
@@ -436,8 +466,8 @@ func TestPostBatchHandler(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			svc, err := service.NewService()
-			require.NoError(t, err)
+			svc := newTestService(t)
+			defer svc.Close()
 			h := &Handlers{
 				baseURL: tt.baseURL,
 				svc:     svc,
@@ -528,8 +558,8 @@ func Test_postURLApiHandler(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			svc, err := service.NewService()
-			require.NoError(t, err)
+			svc := newTestService(t)
+			defer svc.Close()
 			h := &Handlers{
 				baseURL: tt.baseURL,
 				svc:     svc,
@@ -619,8 +649,8 @@ func Test_postURLHandler(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			svc, err := service.NewService()
-			require.NoError(t, err)
+			svc := newTestService(t)
+			defer svc.Close()
 			h := &Handlers{
 				baseURL: tt.baseURL,
 				svc:     svc,
@@ -689,8 +719,8 @@ func Test_getURLHandler(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			svc, err := service.NewService()
-			require.NoError(t, err)
+			svc := newTestService(t)
+			defer svc.Close()
 			h := &Handlers{
 				svc: svc,
 			}
@@ -749,8 +779,8 @@ func TestGetUserURLsAPIHandler(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			svc, err := service.NewService()
-			require.NoError(t, err)
+			svc := newTestService(t)
+			defer svc.Close()
 			h := &Handlers{
 				svc:     svc,
 				baseURL: tt.baseURL,
@@ -772,20 +802,711 @@ func TestGetUserURLsAPIHandler(t *testing.T) {
 			assert.Equal(t, tt.want.statusCode, result.StatusCode)
 			assert.Equal(t, tt.want.contentType, result.Header.Get("Content-Type"))
 			var urls []userURLs
-			err = json.NewDecoder(result.Body).Decode(&urls)
+			err := json.NewDecoder(result.Body).Decode(&urls)
 			require.NoError(t, err)
 			for _, url := range urls {
+				assert.False(t, url.CreatedAt.IsZero())
+				assert.Equal(t, url.CreatedAt, url.UpdatedAt)
+				url.CreatedAt, url.UpdatedAt = time.Time{}, time.Time{}
 				assert.Contains(t, tt.want.response, url)
 			}
 		})
 	}
 }
 
-func BenchmarkGetURLHandler(b *testing.B) {
-	svc, err := service.NewService()
-	if err != nil {
-		b.Errorf("unable to initiazlize service: %v\n", err)
+func TestGetUserURLsAPIHandler_pagination(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New().String()
+	for _, short := range []string{"aaaaaa", "bbbbbb", "cccccc", "dddddd", "eeeeee"} {
+		require.NoError(t, h.svc.InsertNewURLPair(context.Background(), uid, short, "https://example.com/"+short))
+	}
+
+	type page struct {
+		URLs       []userURLs `json:"urls"`
+		NextCursor string     `json:"next_cursor"`
+	}
+	get := func(query string) (*http.Response, page) {
+		request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/urls"+query, nil)
+		request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+		w := httptest.NewRecorder()
+		http.HandlerFunc(h.GetUserURLsAPIHandler).ServeHTTP(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+
+		var p page
+		if result.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&p))
+		}
+		return result, p
+	}
+
+	var shorts []string
+	query := "?limit=2"
+	for i := 0; i < 3; i++ {
+		result, p := get(query)
+		require.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+		for _, u := range p.URLs {
+			shorts = append(shorts, strings.TrimPrefix(u.ShortURL, "http://localhost:8080/"))
+		}
+		if i < 2 {
+			assert.Len(t, p.URLs, 2)
+			require.NotEmpty(t, p.NextCursor)
+		} else {
+			assert.Len(t, p.URLs, 1)
+			assert.Empty(t, p.NextCursor)
+		}
+		query = "?limit=2&cursor=" + p.NextCursor
+	}
+	assert.Equal(t, []string{"aaaaaa", "bbbbbb", "cccccc", "dddddd", "eeeeee"}, shorts)
+
+	result, _ := get("?limit=0")
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	result, _ = get("?cursor=invalid")
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+}
+
+func TestGetUserURLsAPIHandler_filters(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New()
+	day := func(d int) time.Time {
+		return time.Date(2022, 3, d, 12, 0, 0, 0, time.UTC)
+	}
+	require.NoError(t, store.InsertLinks(context.Background(), []storage.Link{
+		{ShortPath: "aaaaaa", OriginalURL: "https://github.com/serjyuriev", CreatedAt: day(1), UserID: uid},
+		{ShortPath: "bbbbbb", OriginalURL: "https://gitlab.com/servady", CreatedAt: day(2), UserID: uid},
+		{ShortPath: "cccccc", OriginalURL: "https://github.com/golang", CreatedAt: day(3), UserID: uid, IsDeleted: true},
+	}))
+
+	tests := []struct {
+		name       string
+		query      string
+		want       []string
+		statusCode int
+	}{
+		{
+			name:       "host",
+			query:      "?host=github.com&status=all",
+			want:       []string{"aaaaaa", "cccccc"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "substring",
+			query:      "?q=servady",
+			want:       []string{"bbbbbb"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "dates",
+			query:      "?created_from=2022-03-02&created_to=2022-03-03&status=all",
+			want:       []string{"bbbbbb", "cccccc"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "deleted",
+			query:      "?status=deleted",
+			want:       []string{"cccccc"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "nothing found",
+			query:      "?q=yandex",
+			statusCode: http.StatusNoContent,
+		},
+		{
+			name:       "invalid status",
+			query:      "?status=unknown",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid date",
+			query:      "?created_from=yesterday",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/urls"+tt.query, nil)
+			request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid.String()))
+			w := httptest.NewRecorder()
+			http.HandlerFunc(h.GetUserURLsAPIHandler).ServeHTTP(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			require.Equal(t, tt.statusCode, result.StatusCode)
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+			var urls []userURLs
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&urls))
+			var got []string
+			for _, u := range urls {
+				got = append(got, strings.TrimPrefix(u.ShortURL, "http://localhost:8080/"))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTags(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New().String()
+
+	do := func(handler http.HandlerFunc, method, target, body, shortPath string) *http.Response {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		ctx := context.WithValue(request.Context(), contextKeyUID, uid)
+		if shortPath != "" {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("shortPath", shortPath)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request.WithContext(ctx))
+		return w.Result()
+	}
+
+	result := do(h.GetUserTagsHandler, http.MethodGet, "/api/user/tags", "", "")
+	result.Body.Close()
+	assert.Equal(t, http.StatusNoContent, result.StatusCode)
+
+	result = do(h.PostURLApiHandler, http.MethodPost, "/api/shorten", `{"url":"https://github.com","tags":["Code"," work "]}`, "")
+	var created postShortenResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&created))
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	short := strings.TrimPrefix(created.Result, "http://localhost:8080/")
+
+	result = do(h.PostBatchHandler, http.MethodPost, "/api/shorten/batch",
+		`[{"correlation_id":"1","original_url":"https://gitlab.com","tags":["code"]}]`, "")
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+
+	result = do(h.PostURLApiHandler, http.MethodPost, "/api/shorten", `{"url":"https://vk.com","tags":["a,b"]}`, "")
+	result.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	result = do(h.GetUserTagsHandler, http.MethodGet, "/api/user/tags", "", "")
+	var tags []userTag
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&tags))
+	result.Body.Close()
+	assert.Equal(t, []userTag{{Tag: "code", Count: 2}, {Tag: "work", Count: 1}}, tags)
+
+	result = do(h.PutTagsHandler, http.MethodPut, "/api/user/urls/"+short+"/tags", `["Personal"]`, short)
+	var updated []string
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&updated))
+	result.Body.Close()
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, []string{"personal"}, updated)
+
+	result = do(h.PutTagsHandler, http.MethodPut, "/api/user/urls/zzzzzz/tags", `["x"]`, "zzzzzz")
+	result.Body.Close()
+	assert.Equal(t, http.StatusNotFound, result.StatusCode)
+
+	result = do(h.GetUserURLsAPIHandler, http.MethodGet, "/api/user/urls?tag=Personal", "", "")
+	var urls []userURLs
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&urls))
+	result.Body.Close()
+	require.Len(t, urls, 1)
+	assert.Equal(t, created.Result, urls[0].ShortURL)
+	assert.Equal(t, []string{"personal"}, urls[0].Tags)
+}
+
+func TestPatchURLHandler(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New()
+	ctx := context.Background()
+	require.NoError(t, store.InsertLinks(ctx, []storage.Link{
+		{ShortPath: "aaaaaa", OriginalURL: "https://github.com", UserID: uid},
+		{ShortPath: "bbbbbb", OriginalURL: "https://gitlab.com", UserID: uid},
+		{ShortPath: "cccccc", OriginalURL: "https://yandex.ru", UserID: uid, IsDeleted: true},
+	}))
+
+	do := func(handler http.HandlerFunc, method, shortPath, body string) *http.Response {
+		request := httptest.NewRequest(method, "/api/user/urls/"+shortPath, strings.NewReader(body))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("shortPath", shortPath)
+		ctx := context.WithValue(request.Context(), contextKeyUID, uid.String())
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request.WithContext(ctx))
+		return w.Result()
+	}
+
+	tests := []struct {
+		name       string
+		shortPath  string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "bad json",
+			shortPath:  "aaaaaa",
+			body:       `{"original_url":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid url",
+			shortPath:  "aaaaaa",
+			body:       `{"original_url":"github"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "missing link",
+			shortPath:  "zzzzzz",
+			body:       `{"original_url":"https://vk.com"}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "deleted link",
+			shortPath:  "cccccc",
+			body:       `{"original_url":"https://vk.com"}`,
+			wantStatus: http.StatusGone,
+		},
+		{
+			name:       "not unique",
+			shortPath:  "aaaaaa",
+			body:       `{"original_url":"https://gitlab.com"}`,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "updated",
+			shortPath:  "aaaaaa",
+			body:       `{"original_url":"https://vk.com"}`,
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := do(h.PatchURLHandler, http.MethodPatch, tt.shortPath, tt.body)
+			defer result.Body.Close()
+			assert.Equal(t, tt.wantStatus, result.StatusCode)
+		})
+	}
+
+	original, err := store.FindOriginalURL(ctx, "aaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, "https://vk.com", original)
+
+	result := do(h.GetURLHistoryHandler, http.MethodGet, "aaaaaa", "")
+	var history []urlRevision
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&history))
+	result.Body.Close()
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	require.Len(t, history, 1)
+	assert.Equal(t, "https://github.com", history[0].OriginalURL)
+
+	require.NoError(t, store.InsertNewURLPair(ctx, uuid.New(), "dddddd", "https://vk.com/feed"))
+	result = do(h.GetURLHistoryHandler, http.MethodGet, "dddddd", "")
+	result.Body.Close()
+	assert.Equal(t, http.StatusNotFound, result.StatusCode)
+}
+
+func TestRestoreURLsHandler(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New()
+	ctx := context.Background()
+	require.NoError(t, store.InsertLinks(ctx, []storage.Link{
+		{ShortPath: "aaaaaa", OriginalURL: "https://github.com", UserID: uid, IsDeleted: true},
+		{ShortPath: "bbbbbb", OriginalURL: "https://gitlab.com", UserID: uid},
+		{ShortPath: "cccccc", OriginalURL: "https://yandex.ru", UserID: uuid.New(), IsDeleted: true},
+	}))
+
+	tests := []struct {
+		name       string
+		body       string
+		want       []string
+		wantStatus int
+	}{
+		{
+			name:       "bad json",
+			body:       `["aaaaaa"`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "empty list",
+			body:       `[]`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "restored",
+			body:       `["aaaaaa","bbbbbb","cccccc"]`,
+			want:       []string{"aaaaaa"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "already restored",
+			body:       `["aaaaaa"]`,
+			want:       []string{},
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/user/urls/restore", strings.NewReader(tt.body))
+			request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid.String()))
+			w := httptest.NewRecorder()
+			h.RestoreURLsHandler(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, result.StatusCode)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var restored []string
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&restored))
+			assert.Equal(t, tt.want, restored)
+		})
+	}
+
+	original, err := store.FindOriginalURL(ctx, "aaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com", original)
+	_, err = store.FindOriginalURL(ctx, "cccccc")
+	assert.ErrorIs(t, err, storage.ErrShortenedDeleted)
+}
+
+func TestPasswordProtectedURL(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New().String()
+
+	post := func(body string) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+		w := httptest.NewRecorder()
+		h.PostURLApiHandler(w, request)
+		return w.Result()
+	}
+	open := func(short, password string) *http.Response {
+		var request *http.Request
+		if password == "" {
+			request = httptest.NewRequest(http.MethodGet, "/"+short, nil)
+		} else {
+			form := url.Values{"password": {password}}
+			request = httptest.NewRequest(http.MethodPost, "/"+short, strings.NewReader(form.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		w := httptest.NewRecorder()
+		if password == "" {
+			h.GetURLHandler(w, request)
+		} else {
+			h.PostPasswordHandler(w, request)
+		}
+		return w.Result()
+	}
+
+	result := post(`{"url":"https://github.com","password":"` + strings.Repeat("a", 73) + `"}`)
+	result.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	result = post(`{"url":"https://github.com","password":"s3cret"}`)
+	var created postShortenResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&created))
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	short := strings.TrimPrefix(created.Result, "http://localhost:8080/")
+
+	l, err := store.FindLink(context.Background(), short)
+	require.NoError(t, err)
+	assert.NotEmpty(t, l.PasswordHash)
+	assert.NotContains(t, l.PasswordHash, "s3cret")
+
+	result = open(short, "")
+	body, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+	result.Body.Close()
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Empty(t, result.Header.Get("Location"))
+	assert.Contains(t, result.Header.Get("Content-Type"), "text/html")
+	assert.Contains(t, string(body), `<form method="post">`)
+
+	result = open(short, "secret")
+	body, err = io.ReadAll(result.Body)
+	require.NoError(t, err)
+	result.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, result.StatusCode)
+	assert.Contains(t, string(body), "Wrong password.")
+
+	result = open(short, "s3cret")
+	result.Body.Close()
+	assert.Equal(t, http.StatusSeeOther, result.StatusCode)
+	assert.Equal(t, "https://github.com", result.Header.Get("Location"))
+
+	for i := 0; i < service.MaxPasswordAttempts; i++ {
+		result = open(short, "secret")
+		result.Body.Close()
+	}
+	result = open(short, "s3cret")
+	result.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, result.StatusCode)
+	assert.Equal(t, "900", result.Header.Get("Retry-After"))
+}
+
+// uniqueStore rejects links with already shortened original URL, as database does.
+type uniqueStore struct {
+	storage.Store
+}
+
+func (s uniqueStore) InsertLinks(ctx context.Context, links []storage.Link) error {
+	for _, l := range links {
+		if _, err := s.FindByOriginalURL(ctx, l.OriginalURL); err == nil {
+			return storage.ErrNotUniqueOriginalURL
+		}
+	}
+	return s.Store.InsertLinks(ctx, links)
+}
+
+func TestPostURLApiHandler_conflict(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(uniqueStore{store})
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New().String()
+
+	post := func(body string) (int, string) {
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+		w := httptest.NewRecorder()
+		h.PostURLApiHandler(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+		b, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		return result.StatusCode, string(b)
+	}
+
+	status, created := post(`{"url":"https://github.com"}`)
+	require.Equal(t, http.StatusCreated, status)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       string
+	}{
+		{name: "same options", body: `{"url":"https://github.com"}`, wantStatus: http.StatusConflict, want: created},
+		{name: "password", body: `{"url":"https://github.com","password":"s3cret"}`, wantStatus: http.StatusConflict, want: ErrProtectedConflict.Error() + "\n"},
+		{name: "max uses", body: `{"url":"https://github.com","max_uses":1}`, wantStatus: http.StatusConflict, want: ErrProtectedConflict.Error() + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := post(tt.body)
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, tt.want, body)
+		})
+	}
+}
+
+func TestOneTimeURL(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New().String()
+
+	post := func(body string) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+		w := httptest.NewRecorder()
+		h.PostURLApiHandler(w, request)
+		return w.Result()
+	}
+
+	result := post(`{"url":"https://github.com","max_uses":-1}`)
+	result.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	result = post(`{"url":"https://github.com","max_uses":1}`)
+	var created postShortenResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&created))
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+
+	wantStatus := []int{http.StatusTemporaryRedirect, http.StatusGone, http.StatusGone}
+	for _, want := range wantStatus {
+		request := httptest.NewRequest(http.MethodGet, created.Result, nil)
+		w := httptest.NewRecorder()
+		h.GetURLHandler(w, request)
+		result = w.Result()
+		result.Body.Close()
+		assert.Equal(t, want, result.StatusCode)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+	w := httptest.NewRecorder()
+	h.GetUserURLsAPIHandler(w, request)
+	result = w.Result()
+	var urls []map[string]interface{}
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&urls))
+	result.Body.Close()
+	require.Len(t, urls, 1)
+	assert.Equal(t, float64(1), urls[0]["max_uses"])
+	assert.Equal(t, float64(0), urls[0]["remaining_uses"])
+}
+
+func TestGetURLHandler_redirectCode(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New().String()
+
+	assert.ErrorIs(t, h.SetRedirectCode(http.StatusOK), ErrInvalidRedirectCode)
+
+	post := func(body string) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+		w := httptest.NewRecorder()
+		h.PostURLApiHandler(w, request)
+		return w.Result()
+	}
+	result := post(`{"url":"https://vk.com","redirect_code":303}`)
+	result.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	tests := []struct {
+		name             string
+		body             string
+		defaultCode      int
+		wantCode         int
+		wantCacheControl string
+	}{
+		{
+			name:             "default code",
+			body:             `{"url":"https://github.com"}`,
+			defaultCode:      http.StatusTemporaryRedirect,
+			wantCode:         http.StatusTemporaryRedirect,
+			wantCacheControl: "private, no-cache",
+		},
+		{
+			name:             "configured default code",
+			body:             `{"url":"https://gitlab.com"}`,
+			defaultCode:      http.StatusMovedPermanently,
+			wantCode:         http.StatusMovedPermanently,
+			wantCacheControl: "public, max-age=86400",
+		},
+		{
+			name:             "permanent",
+			body:             `{"url":"https://yandex.ru","redirect_code":308}`,
+			defaultCode:      http.StatusTemporaryRedirect,
+			wantCode:         http.StatusPermanentRedirect,
+			wantCacheControl: "public, max-age=86400",
+		},
+		{
+			name:             "found",
+			body:             `{"url":"https://ya.ru","redirect_code":302}`,
+			defaultCode:      http.StatusMovedPermanently,
+			wantCode:         http.StatusFound,
+			wantCacheControl: "private, no-cache",
+		},
+		{
+			name:             "limited uses",
+			body:             `{"url":"https://mail.ru","redirect_code":301,"max_uses":10}`,
+			defaultCode:      http.StatusTemporaryRedirect,
+			wantCode:         http.StatusMovedPermanently,
+			wantCacheControl: "no-store",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, h.SetRedirectCode(tt.defaultCode))
+			result := post(tt.body)
+			var created postShortenResponse
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&created))
+			result.Body.Close()
+			require.Equal(t, http.StatusCreated, result.StatusCode)
+
+			request := httptest.NewRequest(http.MethodGet, created.Result, nil)
+			w := httptest.NewRecorder()
+			h.GetURLHandler(w, request)
+			result = w.Result()
+			result.Body.Close()
+			assert.Equal(t, tt.wantCode, result.StatusCode)
+			assert.Equal(t, tt.wantCacheControl, result.Header.Get("Cache-Control"))
+		})
 	}
+}
+
+func TestPostURLHandler_creator(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	proxies, err := ParseNetworks("10.0.0.0/8")
+	require.NoError(t, err)
+	h.SetTrustedProxies(proxies)
+	uid := uuid.New()
+
+	tests := []struct {
+		name         string
+		originalURL  string
+		remoteAddr   string
+		forwardedFor string
+		wantIP       string
+	}{
+		{name: "direct client", originalURL: "https://yandex.ru", remoteAddr: "203.0.113.7:51234", wantIP: "203.0.113.7"},
+		{name: "client behind trusted proxy", originalURL: "https://yandex.ru/maps", remoteAddr: "10.0.0.1:51234", forwardedFor: "203.0.113.8", wantIP: "203.0.113.8"},
+		{name: "spoofed header", originalURL: "https://yandex.ru/news", remoteAddr: "203.0.113.7:51234", forwardedFor: "203.0.113.8", wantIP: "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "http://localhost:8080/", strings.NewReader(tt.originalURL))
+			request.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				request.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			request.Header.Set("User-Agent", "curl/7.79.1")
+			request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid.String()))
+			w := httptest.NewRecorder()
+			http.HandlerFunc(h.PostURLHandler).ServeHTTP(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+			require.Equal(t, http.StatusCreated, result.StatusCode)
+
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			l, err := store.FindLink(context.Background(), strings.TrimPrefix(string(body), "http://localhost:8080/"))
+			require.NoError(t, err)
+			assert.Equal(t, uid, l.UserID)
+			assert.Equal(t, tt.wantIP, l.CreatorIP)
+			assert.Equal(t, "06d351b01e04c17f274a54e1d8a8d95348215736d7a6362f86f4ebcc10db348b", l.CreatorUAHash)
+			assert.False(t, l.CreatedAt.IsZero())
+		})
+	}
+}
+
+func BenchmarkGetURLHandler(b *testing.B) {
+	svc := newTestService(b)
+	defer svc.Close()
 	h := &Handlers{
 		svc: svc,
 	}
@@ -805,7 +1526,8 @@ func BenchmarkGetURLHandler(b *testing.B) {
 }
 
 func BenchmarkPostURLHandler(b *testing.B) {
-	svc, _ := service.NewService()
+	svc := newTestService(b)
+	defer svc.Close()
 	h := &Handlers{
 		baseURL: "http://localhost:8080",
 		svc:     svc,
@@ -825,3 +1547,662 @@ func BenchmarkPostURLHandler(b *testing.B) {
 		hf.ServeHTTP(w, request)
 	}
 }
+
+func TestGetURLHandler_passthrough(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New().String()
+	r := chi.NewRouter()
+	r.Get("/{shortPath}", h.GetURLHandler)
+	r.Get("/{shortPath}/*", h.GetURLHandler)
+
+	post := func(body string) string {
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+		w := httptest.NewRecorder()
+		h.PostURLApiHandler(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+		var created postShortenResponse
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&created))
+		require.Equal(t, http.StatusCreated, result.StatusCode)
+		return strings.TrimPrefix(created.Result, "http://localhost:8080")
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://github.com","passthrough":"fragment"}`))
+	request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+	w := httptest.NewRecorder()
+	h.PostURLApiHandler(w, request)
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	plain := post(`{"url":"https://github.com/serjyuriev"}`)
+	query := post(`{"url":"https://github.com/search?q=go","passthrough":"query"}`)
+	path := post(`{"url":"https://github.com/serjyuriev/","passthrough":"path"}`)
+
+	tests := []struct {
+		name         string
+		request      string
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name:         "query dropped",
+			request:      plain + "?utm_source=x",
+			wantStatus:   http.StatusTemporaryRedirect,
+			wantLocation: "https://github.com/serjyuriev",
+		},
+		{
+			name:       "sub-path not allowed",
+			request:    plain + "/shortener",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:         "query merged",
+			request:      query + "?q=rust&utm_source=a%20b",
+			wantStatus:   http.StatusTemporaryRedirect,
+			wantLocation: "https://github.com/search?q=go&utm_source=a+b",
+		},
+		{
+			name:         "path and query forwarded",
+			request:      path + "/shortener/tree/main?plain=1",
+			wantStatus:   http.StatusTemporaryRedirect,
+			wantLocation: "https://github.com/serjyuriev/shortener/tree/main?plain=1",
+		},
+		{
+			name:         "escaped path forwarded",
+			request:      path + "/a%2Fb/c%20d",
+			wantStatus:   http.StatusTemporaryRedirect,
+			wantLocation: "https://github.com/serjyuriev/a%2Fb/c%20d",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.request, nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, request)
+			result := w.Result()
+			result.Body.Close()
+			assert.Equal(t, tt.wantStatus, result.StatusCode)
+			assert.Equal(t, tt.wantLocation, result.Header.Get("Location"))
+		})
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New().String()
+	r := chi.NewRouter()
+	r.Get("/api/user/templates", h.GetTemplatesHandler)
+	r.Post("/api/user/templates", h.PostTemplateHandler)
+	r.Put("/api/user/templates/{templateID}", h.PutTemplateHandler)
+	r.Delete("/api/user/templates/{templateID}", h.DeleteTemplateHandler)
+	r.Post("/api/shorten", h.PostURLApiHandler)
+	r.Post("/api/shorten/batch", h.PostBatchHandler)
+
+	do := func(method, target, body string) *http.Response {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request)
+		return w.Result()
+	}
+	originalURL := func(shortURL string) string {
+		l, err := store.FindLink(context.Background(), strings.TrimPrefix(shortURL, "http://localhost:8080/"))
+		require.NoError(t, err)
+		return l.OriginalURL
+	}
+
+	result := do(http.MethodGet, "/api/user/templates", "")
+	result.Body.Close()
+	assert.Equal(t, http.StatusNoContent, result.StatusCode)
+
+	result = do(http.MethodPost, "/api/user/templates", `{"name":"bad","params":{"ref":"x"}}`)
+	result.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	result = do(http.MethodPost, "/api/user/templates", `{"name":"email","params":{"utm_source":"newsletter","utm_medium":"email"},"is_default":true}`)
+	var created userTemplate
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&created))
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	assert.NotEmpty(t, created.ID)
+	assert.True(t, created.IsDefault)
+
+	result = do(http.MethodPost, "/api/shorten", `{"url":"https://github.com/serjyuriev"}`)
+	var shortened postShortenResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&shortened))
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	assert.Equal(t, "https://github.com/serjyuriev?utm_medium=email&utm_source=newsletter", originalURL(shortened.Result))
+
+	result = do(http.MethodPost, "/api/shorten", `{"url":"https://gitlab.com","template":"missing"}`)
+	result.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	result = do(http.MethodPut, "/api/user/templates/"+created.ID, `{"name":"email","params":{"utm_source":"digest"}}`)
+	var updated userTemplate
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&updated))
+	result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, map[string]string{"utm_source": "digest"}, updated.Params)
+	assert.False(t, updated.IsDefault)
+
+	result = do(http.MethodPost, "/api/shorten/batch?template="+created.ID, `[{"correlation_id":"1","original_url":"https://gitlab.com/?utm_source=own"}]`)
+	var batch []postBatchSingleResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&batch))
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	require.Len(t, batch, 1)
+	assert.Equal(t, "https://gitlab.com/?utm_source=own", originalURL(batch[0].ShortURL))
+
+	result = do(http.MethodGet, "/api/user/templates", "")
+	var templates []userTemplate
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&templates))
+	result.Body.Close()
+	require.Len(t, templates, 1)
+	assert.Equal(t, "digest", templates[0].Params["utm_source"])
+
+	result = do(http.MethodPut, "/api/user/templates/missing", `{"name":"email","params":{"utm_source":"digest"}}`)
+	result.Body.Close()
+	assert.Equal(t, http.StatusNotFound, result.StatusCode)
+
+	result = do(http.MethodDelete, "/api/user/templates/"+created.ID, "")
+	result.Body.Close()
+	assert.Equal(t, http.StatusNoContent, result.StatusCode)
+	result = do(http.MethodDelete, "/api/user/templates/"+created.ID, "")
+	result.Body.Close()
+	assert.Equal(t, http.StatusNotFound, result.StatusCode)
+}
+
+func TestTokens(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	uid := uuid.New().String()
+	r := chi.NewRouter()
+	r.Get("/api/user/tokens", h.GetTokensHandler)
+	r.Post("/api/user/tokens", h.PostTokenHandler)
+	r.Delete("/api/user/tokens/{tokenID}", h.DeleteTokenHandler)
+
+	do := func(method, target, body string) *http.Response {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request)
+		return w.Result()
+	}
+
+	result := do(http.MethodGet, "/api/user/tokens", "")
+	result.Body.Close()
+	assert.Equal(t, http.StatusNoContent, result.StatusCode)
+
+	for _, body := range []string{`{"name":""}`, `{"name":"ci","scope":"admin"}`, `[`} {
+		result = do(http.MethodPost, "/api/user/tokens", body)
+		result.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode, body)
+	}
+
+	result = do(http.MethodPost, "/api/user/tokens", `{"name":"ci","scope":"read-write"}`)
+	var created userToken
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&created))
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	assert.NotEmpty(t, created.ID)
+	assert.NotEmpty(t, created.Token)
+	assert.Equal(t, storage.ScopeReadWrite, created.Scope)
+
+	result = do(http.MethodGet, "/api/user/tokens", "")
+	body, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+	result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.NotContains(t, string(body), created.Token)
+	var tokens []userToken
+	require.NoError(t, json.Unmarshal(body, &tokens))
+	require.Len(t, tokens, 1)
+	assert.Equal(t, created.ID, tokens[0].ID)
+	assert.Equal(t, "ci", tokens[0].Name)
+
+	result = do(http.MethodDelete, "/api/user/tokens/"+created.ID, "")
+	result.Body.Close()
+	assert.Equal(t, http.StatusNoContent, result.StatusCode)
+	result = do(http.MethodDelete, "/api/user/tokens/"+created.ID, "")
+	result.Body.Close()
+	assert.Equal(t, http.StatusNotFound, result.StatusCode)
+}
+
+func TestAccounts(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	r := chi.NewRouter()
+	r.Post("/api/user/register", h.RegisterHandler)
+	r.Post("/api/user/login", h.LoginHandler)
+	r.Post("/api/user/logout", h.LogoutHandler)
+
+	uid := uuid.New().String()
+	do := func(target, body string, withSession bool) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		ctx := context.WithValue(request.Context(), contextKeyUID, uid)
+		if withSession {
+			ctx = context.WithValue(ctx, contextKeySwitchUser, SwitchUser(func(w http.ResponseWriter, id string) {
+				uid = id
+			}))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request.WithContext(ctx))
+		return w.Result()
+	}
+
+	owner := uid
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		noSession  bool
+		wantStatus int
+	}{
+		{name: "malformed body", target: "/api/user/register", body: "{", wantStatus: http.StatusBadRequest},
+		{name: "invalid login", target: "/api/user/register", body: `{"login":"a b","password":"long enough"}`, wantStatus: http.StatusBadRequest},
+		{name: "weak password", target: "/api/user/register", body: `{"login":"serj","password":"short"}`, wantStatus: http.StatusBadRequest},
+		{name: "with API token", target: "/api/user/register", body: `{"login":"serj","password":"long enough"}`, noSession: true, wantStatus: http.StatusForbidden},
+		{name: "register", target: "/api/user/register", body: `{"login":"serj","password":"long enough"}`, wantStatus: http.StatusCreated},
+		{name: "duplicate login", target: "/api/user/register", body: `{"login":"Serj","password":"long enough"}`, wantStatus: http.StatusConflict},
+		{name: "logout", target: "/api/user/logout", wantStatus: http.StatusNoContent},
+		{name: "wrong password", target: "/api/user/login", body: `{"login":"serj","password":"wrong password"}`, wantStatus: http.StatusUnauthorized},
+		{name: "login", target: "/api/user/login", body: `{"login":"serj","password":"long enough"}`, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		result := do(tt.target, tt.body, !tt.noSession)
+		var a account
+		if tt.wantStatus == http.StatusOK || tt.wantStatus == http.StatusCreated {
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&a), tt.name)
+			assert.Equal(t, "serj", a.Login, tt.name)
+		}
+		result.Body.Close()
+		assert.Equal(t, tt.wantStatus, result.StatusCode, tt.name)
+
+		switch tt.name {
+		case "register", "login":
+			assert.Equal(t, owner, uid, tt.name)
+		case "logout":
+			assert.NotEqual(t, owner, uid)
+		}
+	}
+}
+
+type fakeTransfers map[string]string
+
+func (f fakeTransfers) SignTransfer(uid string) (string, time.Time, error) {
+	token := "token-of-" + uid
+	f[token] = uid
+	return token, time.Unix(1700000000, 0).UTC(), nil
+}
+
+func (f fakeTransfers) RedeemTransfer(token string) (string, error) {
+	uid, ok := f[token]
+	if !ok {
+		return "", errors.New("invalid transfer token")
+	}
+	delete(f, token)
+	f["redeemed:"+token] = uid
+	return uid, nil
+}
+
+func (f fakeTransfers) ReleaseTransfer(token string) {
+	if uid, ok := f["redeemed:"+token]; ok {
+		delete(f, "redeemed:"+token)
+		f[token] = uid
+	}
+}
+
+func TestTransfers(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	from, to := uuid.New(), uuid.New()
+	require.NoError(t, store.InsertLinks(context.Background(), []storage.Link{
+		{ShortPath: "a", OriginalURL: "https://a.test", UserID: from},
+		{ShortPath: "b", OriginalURL: "https://b.test", UserID: from},
+	}))
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	r := chi.NewRouter()
+	r.Post("/api/user/transfers", h.PostTransferHandler)
+	r.Post("/api/user/transfers/redeem", h.RedeemTransferHandler)
+	do := func(target, body string, uid uuid.UUID) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid.String())))
+		return w.Result()
+	}
+
+	result := do("/api/user/transfers", "", from)
+	result.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, result.StatusCode)
+	h.SetTransfers(fakeTransfers{})
+
+	result = do("/api/user/transfers", "", from)
+	var token transferToken
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&token))
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	assert.Equal(t, "token-of-"+from.String(), token.Token)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), token.ExpiresAt)
+
+	svc.SetQuotas(service.Quotas{Default: service.Quota{MaxLinks: 1}})
+	result = do("/api/user/transfers/redeem", `{"token":"`+token.Token+`"}`, to)
+	result.Body.Close()
+	assert.Equal(t, http.StatusForbidden, result.StatusCode, "quota exceeded")
+	svc.SetQuotas(service.Quotas{})
+
+	tests := []struct {
+		name            string
+		body            string
+		wantStatus      int
+		wantTransferred int
+	}{
+		{name: "malformed body", body: "{", wantStatus: http.StatusBadRequest},
+		{name: "unknown token", body: `{"token":"unknown"}`, wantStatus: http.StatusBadRequest},
+		{name: "valid token", body: `{"token":"` + token.Token + `"}`, wantStatus: http.StatusOK, wantTransferred: 2},
+		{name: "redeemed token", body: `{"token":"` + token.Token + `"}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		result := do("/api/user/transfers/redeem", tt.body, to)
+		assert.Equal(t, tt.wantStatus, result.StatusCode, tt.name)
+		if tt.wantStatus == http.StatusOK {
+			var res transferResult
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&res), tt.name)
+			assert.Equal(t, tt.wantTransferred, res.Transferred, tt.name)
+		}
+		result.Body.Close()
+	}
+	urls, err := store.FindURLsByUser(context.Background(), to)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+}
+
+func TestWorkspaces(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	r := chi.NewRouter()
+	r.Delete("/api/workspaces/{workspaceID}/members/{userID}", h.DeleteMemberHandler)
+	r.Delete("/api/workspaces/{workspaceID}/urls", h.DeleteWorkspaceURLsHandler)
+	r.Get("/api/user/workspaces", h.GetWorkspacesHandler)
+	r.Get("/api/workspaces/{workspaceID}/members", h.GetMembersHandler)
+	r.Get("/api/workspaces/{workspaceID}/urls", h.GetWorkspaceURLsHandler)
+	r.Patch("/api/workspaces/{workspaceID}/urls/{shortPath}", h.PatchWorkspaceURLHandler)
+	r.Put("/api/workspaces/{workspaceID}/members/{userID}", h.PutMemberHandler)
+	r.Post("/api/workspaces", h.PostWorkspaceHandler)
+	r.Post("/api/workspaces/{workspaceID}/shorten", h.PostWorkspaceURLHandler)
+
+	owner, editor, viewer, stranger := uuid.New().String(), uuid.New().String(), uuid.New().String(), uuid.New().String()
+	do := func(method, target, body, uid string) (int, []byte) {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid)))
+		result := w.Result()
+		defer result.Body.Close()
+		b, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		return result.StatusCode, b
+	}
+
+	status, _ := do(http.MethodPost, "/api/workspaces", `{"name":""}`, owner)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, b := do(http.MethodPost, "/api/workspaces", `{"name":"team"}`, owner)
+	require.Equal(t, http.StatusCreated, status)
+	var ws userWorkspace
+	require.NoError(t, json.Unmarshal(b, &ws))
+	assert.Equal(t, "team", ws.Name)
+	assert.Equal(t, storage.RoleOwner, ws.Role)
+	base := "/api/workspaces/" + ws.ID
+
+	status, _ = do(http.MethodPut, base+"/members/"+editor, `{"role":"editor"}`, owner)
+	require.Equal(t, http.StatusNoContent, status)
+	status, _ = do(http.MethodPut, base+"/members/"+viewer, `{"role":"viewer"}`, owner)
+	require.Equal(t, http.StatusNoContent, status)
+
+	var short string
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		uid        string
+		wantStatus int
+	}{
+		{name: "stranger lists links", method: http.MethodGet, target: base + "/urls", uid: stranger, wantStatus: http.StatusNotFound},
+		{name: "unknown workspace", method: http.MethodGet, target: "/api/workspaces/" + uuid.New().String() + "/urls", uid: owner, wantStatus: http.StatusNotFound},
+		{name: "viewer shortens", method: http.MethodPost, target: base + "/shorten", body: `{"url":"https://team.test"}`, uid: viewer, wantStatus: http.StatusForbidden},
+		{name: "editor shortens", method: http.MethodPost, target: base + "/shorten", body: `{"url":"https://team.test"}`, uid: editor, wantStatus: http.StatusCreated},
+		{name: "viewer lists links", method: http.MethodGet, target: base + "/urls", uid: viewer, wantStatus: http.StatusOK},
+		{name: "viewer edits link", method: http.MethodPatch, target: base + "/urls/{short}", body: `{"original_url":"https://team.test/v2"}`, uid: viewer, wantStatus: http.StatusForbidden},
+		{name: "owner edits link of editor", method: http.MethodPatch, target: base + "/urls/{short}", body: `{"original_url":"https://team.test/v2"}`, uid: owner, wantStatus: http.StatusOK},
+		{name: "editor manages members", method: http.MethodPut, target: base + "/members/" + stranger, body: `{"role":"owner"}`, uid: editor, wantStatus: http.StatusForbidden},
+		{name: "invalid role", method: http.MethodPut, target: base + "/members/" + stranger, body: `{"role":"admin"}`, uid: owner, wantStatus: http.StatusBadRequest},
+		{name: "last owner leaves", method: http.MethodDelete, target: base + "/members/" + owner, uid: owner, wantStatus: http.StatusConflict},
+		{name: "viewer removes editor", method: http.MethodDelete, target: base + "/members/" + editor, uid: viewer, wantStatus: http.StatusForbidden},
+		{name: "viewer lists members", method: http.MethodGet, target: base + "/members", uid: viewer, wantStatus: http.StatusOK},
+		{name: "viewer leaves", method: http.MethodDelete, target: base + "/members/" + viewer, uid: viewer, wantStatus: http.StatusNoContent},
+		{name: "former viewer lists links", method: http.MethodGet, target: base + "/urls", uid: viewer, wantStatus: http.StatusNotFound},
+		{name: "owner deletes link of editor", method: http.MethodDelete, target: base + "/urls", body: `["{short}"]`, uid: owner, wantStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		target := strings.ReplaceAll(tt.target, "{short}", short)
+		body := strings.ReplaceAll(tt.body, "{short}", short)
+		status, b := do(tt.method, target, body, tt.uid)
+		require.Equal(t, tt.wantStatus, status, tt.name)
+
+		switch tt.name {
+		case "editor shortens":
+			var res postShortenResponse
+			require.NoError(t, json.Unmarshal(b, &res))
+			short = strings.TrimPrefix(res.Result, "http://localhost:8080/")
+		case "viewer lists links":
+			var urls []userURLs
+			require.NoError(t, json.Unmarshal(b, &urls))
+			require.Len(t, urls, 1)
+			assert.Equal(t, ws.ID, urls[0].WorkspaceID)
+		case "viewer lists members":
+			var members []workspaceMember
+			require.NoError(t, json.Unmarshal(b, &members))
+			assert.Len(t, members, 3)
+		}
+	}
+
+	l, err := store.FindLink(context.Background(), short)
+	require.NoError(t, err)
+	assert.Equal(t, editor, l.UserID.String())
+	assert.Equal(t, "https://team.test/v2", l.OriginalURL)
+	assert.True(t, l.IsDeleted)
+
+	status, b = do(http.MethodGet, "/api/user/workspaces", "", editor)
+	require.Equal(t, http.StatusOK, status)
+	var workspaces []userWorkspace
+	require.NoError(t, json.Unmarshal(b, &workspaces))
+	require.Len(t, workspaces, 1)
+	assert.Equal(t, storage.RoleEditor, workspaces[0].Role)
+	status, _ = do(http.MethodGet, "/api/user/workspaces", "", stranger)
+	assert.Equal(t, http.StatusNoContent, status)
+}
+
+func TestAdmin(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	h := NewHandlers(svc, "http://localhost:8080")
+	r := chi.NewRouter()
+	r.Get("/{shortPath}", h.GetURLHandler)
+	r.Get("/api/admin/urls", h.GetAdminURLsHandler)
+	r.Get("/api/admin/users", h.GetAdminUsersHandler)
+	r.Patch("/api/admin/urls/{shortPath}", h.PatchAdminURLHandler)
+
+	do := func(method, target, body string) (int, []byte) {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+		b, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		return result.StatusCode, b
+	}
+
+	status, _ := do(http.MethodGet, "/api/admin/users", "")
+	assert.Equal(t, http.StatusNoContent, status)
+
+	uid, uid2 := uuid.New(), uuid.New()
+	require.NoError(t, store.InsertNewURLPair(context.Background(), uid, "aaaaaa", "https://github.com/serjyuriev"))
+	require.NoError(t, store.InsertNewURLPair(context.Background(), uid2, "bbbbbb", "https://spam.test/a"))
+	require.NoError(t, store.InsertNewURLPair(context.Background(), uid2, "cccccc", "https://spam.test/b"))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{name: "no disabled mark", method: http.MethodPatch, target: "/api/admin/urls/bbbbbb", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown link", method: http.MethodPatch, target: "/api/admin/urls/zzzzzz", body: `{"disabled":true}`, wantStatus: http.StatusNotFound},
+		{name: "disable link", method: http.MethodPatch, target: "/api/admin/urls/bbbbbb", body: `{"disabled":true}`, wantStatus: http.StatusOK},
+		{name: "follow disabled link", method: http.MethodGet, target: "/bbbbbb", wantStatus: http.StatusGone},
+		{name: "follow other link", method: http.MethodGet, target: "/cccccc", wantStatus: http.StatusTemporaryRedirect},
+		{name: "invalid status", method: http.MethodGet, target: "/api/admin/urls?status=gone", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		status, b := do(tt.method, tt.target, tt.body)
+		assert.Equal(t, tt.wantStatus, status, "%s: %s", tt.name, b)
+	}
+
+	status, b := do(http.MethodGet, "/api/admin/urls?q=SPAM", "")
+	require.Equal(t, http.StatusOK, status)
+	var links []adminURL
+	require.NoError(t, json.Unmarshal(b, &links))
+	require.Len(t, links, 2)
+	assert.Equal(t, uid2.String(), links[0].UserID)
+	assert.True(t, links[0].IsDisabled)
+	assert.False(t, links[1].IsDisabled)
+
+	status, b = do(http.MethodGet, "/api/admin/users", "")
+	require.Equal(t, http.StatusOK, status)
+	var users []adminUser
+	require.NoError(t, json.Unmarshal(b, &users))
+	assert.Equal(t, []adminUser{
+		{UserID: uid2.String(), Total: 2, Disabled: 1},
+		{UserID: uid.String(), Total: 1},
+	}, users)
+}
+
+func TestQuota(t *testing.T) {
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := service.NewServiceWithStore(store)
+	defer svc.Close()
+	svc.SetQuotas(service.Quotas{Default: service.Quota{MaxLinks: 2, MaxBatchSize: 1}})
+	h := NewHandlers(svc, "http://localhost:8080")
+	r := chi.NewRouter()
+	r.Post("/", h.PostURLHandler)
+	r.Post("/api/shorten", h.PostURLApiHandler)
+	r.Post("/api/shorten/batch", h.PostBatchHandler)
+	r.Get("/api/user/quota", h.GetQuotaHandler)
+	uid := uuid.New().String()
+
+	do := func(method, target, body string) (int, []byte) {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+		b, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		return result.StatusCode, b
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{name: "batch is too large", target: "/api/shorten/batch", body: `[{"correlation_id":"1","original_url":"https://a.test"},{"correlation_id":"2","original_url":"https://b.test"}]`, wantStatus: http.StatusForbidden},
+		{name: "batch", target: "/api/shorten/batch", body: `[{"correlation_id":"1","original_url":"https://a.test"}]`, wantStatus: http.StatusCreated},
+		{name: "text", target: "/", body: "https://b.test", wantStatus: http.StatusCreated},
+		{name: "text over quota", target: "/", body: "https://c.test", wantStatus: http.StatusForbidden},
+		{name: "JSON over quota", target: "/api/shorten", body: `{"url":"https://c.test"}`, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		status, b := do(http.MethodPost, tt.target, tt.body)
+		assert.Equal(t, tt.wantStatus, status, "%s: %s", tt.name, b)
+	}
+
+	status, b := do(http.MethodGet, "/api/user/quota", "")
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"active_links":2,"max_links":2,"remaining_links":0,"max_batch_size":1}`, string(b))
+
+	svc.SetQuotas(service.Quotas{})
+	status, b = do(http.MethodGet, "/api/user/quota", "")
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"active_links":2}`, string(b))
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseNetworks("10.0.0.0/8, 192.0.2.10, ::1")
+	require.NoError(t, err)
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{name: "direct client", remoteAddr: "198.51.100.1:1234", want: "198.51.100.1"},
+		{name: "untrusted proxy", remoteAddr: "198.51.100.1:1234", forwardedFor: []string{"203.0.113.1"}, want: "198.51.100.1"},
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:1234", forwardedFor: []string{"203.0.113.1"}, want: "203.0.113.1"},
+		{name: "chain of trusted proxies", remoteAddr: "[::1]:1234", forwardedFor: []string{"203.0.113.7, 203.0.113.1", "192.0.2.10"}, want: "203.0.113.1"},
+		{name: "only trusted proxies", remoteAddr: "10.1.2.3:1234", forwardedFor: []string{"10.0.0.5"}, want: "10.0.0.5"},
+		{name: "no header", remoteAddr: "10.1.2.3:1234", want: "10.1.2.3"},
+		{name: "garbage in header", remoteAddr: "10.1.2.3:1234", forwardedFor: []string{"203.0.113.1, unknown"}, want: "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+			request.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				request.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, tt.want, ClientIP(request, proxies))
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks(" 10.0.0.0/8 , 192.0.2.10,,2001:db8::1")
+	require.NoError(t, err)
+	require.Len(t, networks, 3)
+	assert.Equal(t, "10.0.0.0/8", networks[0].String())
+	assert.Equal(t, "192.0.2.10/32", networks[1].String())
+	assert.Equal(t, "2001:db8::1/128", networks[2].String())
+	assert.True(t, networks[1].Contains(net.ParseIP("192.0.2.10")))
+
+	_, err = ParseNetworks("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = ParseNetworks("proxy")
+	assert.Error(t, err)
+}
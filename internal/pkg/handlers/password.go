@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Protected link</title>
+</head>
+<body>
+<form method="post">
+<p>This link is protected with password.</p>
+{{if .}}<p><strong>{{.}}</strong></p>
+{{end}}<input type="password" name="password" autofocus required>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+// writePasswordForm responds with HTML form asking for password of URL,
+// optionally showing provided message.
+func writePasswordForm(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if err := passwordForm.Execute(w, message); err != nil {
+		log.Printf("unable to render password form: %v\n", err)
+	}
+}
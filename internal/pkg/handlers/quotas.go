@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+type userQuota struct {
+	ActiveLinks    int  `json:"active_links"`
+	MaxLinks       int  `json:"max_links,omitempty"`
+	RemainingLinks *int `json:"remaining_links,omitempty"`
+	MaxBatchSize   int  `json:"max_batch_size,omitempty"`
+}
+
+// GetQuotaHandler returns quota of current user along with number
+// of its not deleted links. Missing limits mean there are none.
+func (h *Handlers) GetQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	q, err := h.svc.FindQuota(ctx, uid)
+	if err != nil {
+		log.Printf("unable to find quota: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	res := userQuota{
+		ActiveLinks:  q.ActiveLinks,
+		MaxLinks:     q.MaxLinks,
+		MaxBatchSize: q.MaxBatchSize,
+	}
+	if q.MaxLinks > 0 {
+		remaining := q.MaxLinks - q.ActiveLinks
+		if remaining < 0 {
+			remaining = 0
+		}
+		res.RemainingLinks = &remaining
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// jsonStream writes JSON array elements into response one by one,
+// so large lists are never kept in memory entirely.
+// Response headers are written together with the first element.
+type jsonStream struct {
+	w      http.ResponseWriter
+	prefix string
+	count  int
+}
+
+func (s *jsonStream) write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if s.count == 0 {
+		s.start()
+	} else if _, err = s.w.Write([]byte(",")); err != nil {
+		return err
+	}
+	if _, err = s.w.Write(b); err != nil {
+		return err
+	}
+	s.count++
+	return nil
+}
+
+func (s *jsonStream) start() {
+	s.w.Header().Set("Content-Type", "application/json")
+	s.w.WriteHeader(http.StatusOK)
+	s.w.Write([]byte(s.prefix))
+}
+
+// close finishes response with provided suffix,
+// writing headers and prefix if no elements were written.
+func (s *jsonStream) close(suffix string) {
+	if s.count == 0 {
+		s.start()
+	}
+	s.w.Write([]byte(suffix))
+}
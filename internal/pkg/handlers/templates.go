@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+type (
+	templateRequest struct {
+		Name      string            `json:"name"`
+		Params    map[string]string `json:"params"`
+		IsDefault bool              `json:"is_default"`
+	}
+
+	userTemplate struct {
+		CreatedAt time.Time         `json:"created_at"`
+		UpdatedAt time.Time         `json:"updated_at"`
+		ID        string            `json:"id"`
+		Name      string            `json:"name"`
+		Params    map[string]string `json:"params"`
+		IsDefault bool              `json:"is_default"`
+	}
+)
+
+// DeleteTemplateHandler removes UTM template of current user.
+func (h *Handlers) DeleteTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	if err := h.svc.DeleteTemplate(ctx, uid, chi.URLParam(r, "templateID")); err != nil {
+		if errors.Is(err, storage.ErrNoTemplateWasFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("unable to delete template: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetTemplatesHandler returns UTM templates of current user ordered by creation time.
+func (h *Handlers) GetTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	templates, err := h.svc.FindTemplates(ctx, uid)
+	if err != nil {
+		log.Printf("unable to find templates: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(templates) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	res := make([]userTemplate, 0, len(templates))
+	for _, t := range templates {
+		res = append(res, newUserTemplate(t))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// PostTemplateHandler creates UTM template of current user.
+// Parameters of template are added to original URLs of URLs shortened with it.
+func (h *Handlers) PostTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	var req templateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	t, err := h.svc.CreateTemplate(ctx, uid, storage.Template{
+		Name:      req.Name,
+		Params:    req.Params,
+		IsDefault: req.IsDefault,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidTemplate) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("unable to create template: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newUserTemplate(t))
+}
+
+// PutTemplateHandler replaces UTM template of current user.
+// URLs already shortened with template are not changed.
+func (h *Handlers) PutTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	var req templateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	t, err := h.svc.UpdateTemplate(ctx, uid, storage.Template{
+		ID:        chi.URLParam(r, "templateID"),
+		Name:      req.Name,
+		Params:    req.Params,
+		IsDefault: req.IsDefault,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidTemplate):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, storage.ErrNoTemplateWasFound):
+			http.Error(w, "not found", http.StatusNotFound)
+		default:
+			log.Printf("unable to update template: %v\n", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, newUserTemplate(t))
+}
+
+func newUserTemplate(t storage.Template) userTemplate {
+	return userTemplate{
+		CreatedAt: t.CreatedAt,
+		UpdatedAt: t.UpdatedAt,
+		ID:        t.ID,
+		Name:      t.Name,
+		Params:    t.Params,
+		IsDefault: t.IsDefault,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	json, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("unable to marshal response: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(json)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+type (
+	tokenRequest struct {
+		Name  string             `json:"name"`
+		Scope storage.TokenScope `json:"scope"`
+	}
+
+	userToken struct {
+		CreatedAt time.Time          `json:"created_at"`
+		ID        string             `json:"id"`
+		Name      string             `json:"name"`
+		Scope     storage.TokenScope `json:"scope"`
+		Token     string             `json:"token,omitempty"`
+	}
+)
+
+// DeleteTokenHandler revokes API token of current user.
+func (h *Handlers) DeleteTokenHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	if err := h.svc.RevokeToken(ctx, uid, chi.URLParam(r, "tokenID")); err != nil {
+		if errors.Is(err, storage.ErrNoTokenWasFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("unable to revoke token: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetTokensHandler returns API tokens of current user ordered by creation time.
+// Values of tokens are not returned.
+func (h *Handlers) GetTokensHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	tokens, err := h.svc.FindTokens(ctx, uid)
+	if err != nil {
+		log.Printf("unable to find tokens: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(tokens) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	res := make([]userToken, 0, len(tokens))
+	for _, t := range tokens {
+		res = append(res, newUserToken(t, ""))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// PostTokenHandler creates API token of current user.
+// Value of token is returned only once, in response to this request.
+func (h *Handlers) PostTokenHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	t, token, err := h.svc.CreateToken(ctx, uid, storage.Token{
+		Name:  req.Name,
+		Scope: req.Scope,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) || errors.Is(err, storage.ErrInvalidTokenScope) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("unable to create token: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newUserToken(t, token))
+}
+
+func newUserToken(t storage.Token, token string) userToken {
+	return userToken{
+		CreatedAt: t.CreatedAt,
+		ID:        t.ID,
+		Name:      t.Name,
+		Scope:     t.Scope,
+		Token:     token,
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/serjyuriev/shortener/internal/pkg/service"
+)
+
+// Transfers issues and redeems signed tokens transferring links of one user to another.
+// Token is released if links fail to be transferred, so it can be redeemed again.
+type Transfers interface {
+	SignTransfer(uid string) (string, time.Time, error)
+	RedeemTransfer(token string) (string, error)
+	ReleaseTransfer(token string)
+}
+
+type (
+	transferToken struct {
+		ExpiresAt time.Time `json:"expires_at"`
+		Token     string    `json:"token"`
+	}
+
+	transferResult struct {
+		Transferred int `json:"transferred"`
+	}
+)
+
+// PostTransferHandler issues token transferring links of current user
+// to user who redeems it.
+func (h *Handlers) PostTransferHandler(w http.ResponseWriter, r *http.Request) {
+	if h.transfers == nil {
+		http.Error(w, "transfers are not enabled", http.StatusNotImplemented)
+		return
+	}
+	uid := r.Context().Value(contextKeyUID).(string)
+	token, expires, err := h.transfers.SignTransfer(uid)
+	if err != nil {
+		log.Printf("unable to sign transfer token: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, transferToken{ExpiresAt: expires, Token: token})
+}
+
+// RedeemTransferHandler moves links of user who issued provided token to current user.
+func (h *Handlers) RedeemTransferHandler(w http.ResponseWriter, r *http.Request) {
+	if h.transfers == nil {
+		http.Error(w, "transfers are not enabled", http.StatusNotImplemented)
+		return
+	}
+	uid := r.Context().Value(contextKeyUID).(string)
+	var req transferToken
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	from, err := h.transfers.RedeemTransfer(req.Token)
+	if err != nil {
+		log.Printf("unable to redeem transfer token: %v\n", err)
+		http.Error(w, "invalid transfer token", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	n, err := h.svc.TransferURLs(ctx, from, uid)
+	if err != nil {
+		h.transfers.ReleaseTransfer(req.Token)
+		if errors.Is(err, service.ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		log.Printf("unable to transfer urls: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, transferResult{Transferred: n})
+}
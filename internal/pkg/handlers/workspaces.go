@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+
+	"github.com/serjyuriev/shortener/internal/pkg/service"
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+type (
+	workspaceRequest struct {
+		Name string `json:"name"`
+	}
+
+	userWorkspace struct {
+		CreatedAt time.Time    `json:"created_at"`
+		ID        string       `json:"id"`
+		Name      string       `json:"name"`
+		Role      storage.Role `json:"role"`
+	}
+
+	memberRequest struct {
+		Role storage.Role `json:"role"`
+	}
+
+	workspaceMember struct {
+		AddedAt time.Time    `json:"added_at"`
+		UserID  string       `json:"user_id"`
+		Role    storage.Role `json:"role"`
+	}
+)
+
+// DeleteMemberHandler removes user from workspace. Owners may remove anyone,
+// other members may only leave workspace themselves.
+func (h *Handlers) DeleteMemberHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	userID := chi.URLParam(r, "userID")
+	min := storage.RoleOwner
+	if userID == uid {
+		min = storage.RoleViewer
+	}
+	workspaceID, ok := h.requireRole(w, r, min)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	if err := h.svc.RemoveMember(ctx, workspaceID, userID); err != nil {
+		writeMemberError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteWorkspaceURLsHandler marks provided links of workspace as deleted.
+// Editors may delete links created by any member.
+func (h *Handlers) DeleteWorkspaceURLsHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := h.requireRole(w, r, storage.RoleEditor)
+	if !ok {
+		return
+	}
+	var req []string
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if len(req) == 0 {
+		http.Error(w, "Body cannot be empty.", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if err := h.svc.DeleteWorkspaceURLs(ctx, workspaceID, req); err != nil {
+		log.Printf("unable to delete workspace URLs: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMembersHandler returns members of workspace ordered by time they were added.
+func (h *Handlers) GetMembersHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := h.requireRole(w, r, storage.RoleViewer)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	members, err := h.svc.FindMembers(ctx, workspaceID)
+	if err != nil {
+		log.Printf("unable to find members: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]workspaceMember, 0, len(members))
+	for _, m := range members {
+		res = append(res, workspaceMember{AddedAt: m.AddedAt, UserID: m.UserID.String(), Role: m.Role})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// GetWorkspacesHandler returns workspaces current user is a member of
+// along with role of the user, ordered by creation time.
+func (h *Handlers) GetWorkspacesHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	memberships, err := h.svc.FindWorkspaces(ctx, uid)
+	if err != nil {
+		log.Printf("unable to find workspaces: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(memberships) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	res := make([]userWorkspace, 0, len(memberships))
+	for _, m := range memberships {
+		res = append(res, newUserWorkspace(m.Workspace, m.Role))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// GetWorkspaceURLsHandler streams links of workspace the same way
+// GetUserURLsAPIHandler streams links of current user.
+func (h *Handlers) GetWorkspaceURLsHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := h.requireRole(w, r, storage.RoleViewer)
+	if !ok {
+		return
+	}
+	h.streamURLs(w, r, func(opts storage.ListOptions, fn func(storage.Link) error) error {
+		return h.svc.IterateWorkspaceURLs(r.Context(), workspaceID, opts, fn)
+	})
+}
+
+// PatchWorkspaceURLHandler changes original URL of workspace link.
+// Editors may change links created by any member.
+func (h *Handlers) PatchWorkspaceURLHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := h.requireRole(w, r, storage.RoleEditor)
+	if !ok {
+		return
+	}
+	h.patchURL(w, r, func(ctx context.Context, shortPath, originalURL string) (storage.Link, error) {
+		return h.svc.UpdateWorkspaceURL(ctx, workspaceID, shortPath, originalURL)
+	})
+}
+
+// PostWorkspaceHandler creates workspace owned by current user.
+func (h *Handlers) PostWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	var req workspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	ws, err := h.svc.CreateWorkspace(ctx, uid, storage.Workspace{Name: req.Name})
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidWorkspace) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("unable to create workspace: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newUserWorkspace(ws, storage.RoleOwner))
+}
+
+// PostWorkspaceURLHandler shortens URL the same way PostURLApiHandler does,
+// adding it to workspace.
+func (h *Handlers) PostWorkspaceURLHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := h.requireRole(w, r, storage.RoleEditor)
+	if !ok {
+		return
+	}
+	h.shorten(w, r, uuid.MustParse(workspaceID))
+}
+
+// PutMemberHandler adds user to workspace or changes role of existing member.
+// Only owners may manage members.
+func (h *Handlers) PutMemberHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := h.requireRole(w, r, storage.RoleOwner)
+	if !ok {
+		return
+	}
+	userID := chi.URLParam(r, "userID")
+	if _, err := uuid.Parse(userID); err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("unable to decode request's body: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	if err := h.svc.SetMember(ctx, workspaceID, userID, req.Role); err != nil {
+		writeMemberError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireRole makes sure current user has at least provided role in workspace
+// from URL, returning its ID. Workspaces user is not a member of are reported
+// as missing; if role is insufficient, error is written and false is returned.
+func (h *Handlers) requireRole(w http.ResponseWriter, r *http.Request, min storage.Role) (string, bool) {
+	uid := r.Context().Value(contextKeyUID).(string)
+	workspaceID := chi.URLParam(r, "workspaceID")
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	role, err := h.svc.FindRole(ctx, workspaceID, uid)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoMemberWasFound) {
+			http.Error(w, "workspace not found", http.StatusNotFound)
+			return "", false
+		}
+		log.Printf("unable to find role: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return "", false
+	}
+	if !role.Allows(min) {
+		http.Error(w, "workspace "+min.String()+" role is required", http.StatusForbidden)
+		return "", false
+	}
+	return workspaceID, true
+}
+
+// writeMemberError writes response to failed change of workspace members.
+func writeMemberError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, storage.ErrNoMemberWasFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrLastOwner):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("unable to change workspace members: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+func newUserWorkspace(ws storage.Workspace, role storage.Role) userWorkspace {
+	return userWorkspace{
+		CreatedAt: ws.CreatedAt,
+		ID:        ws.ID.String(),
+		Name:      ws.Name,
+		Role:      role,
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// AdminOptions identifies administrators.
+type AdminOptions struct {
+	// Token is a static bearer token granting admin access, empty disables it.
+	Token string
+	// UserIDs are IDs of users having admin access
+	// when they are identified by cookie, API token or JWT.
+	UserIDs []uuid.UUID
+}
+
+// ParseUserIDs parses user IDs separated by commas.
+func ParseUserIDs(s string) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := uuid.Parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse user id %q:\n%w", part, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// NewAdmin creates middleware allowing only administrators to pass.
+// Requests with admin token sent as "Authorization: Bearer" pass at once,
+// others are identified by provided auth middleware and pass
+// if user is one of admin users. Anonymous requests are rejected
+// without creating new user.
+func NewAdmin(opts AdminOptions, auth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	var tokenHash []byte
+	if opts.Token != "" {
+		sum := sha256.Sum256([]byte(opts.Token))
+		tokenHash = sum[:]
+	}
+	admins := make(map[string]bool, len(opts.UserIDs))
+	for _, id := range opts.UserIDs {
+		admins[id.String()] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		authorized := auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uid, _ := r.Context().Value(contextKeyUID).(string)
+			if !admins[uid] {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if ok && tokenHash != nil {
+				sum := sha256.Sum256([]byte(token))
+				if subtle.ConstantTimeCompare(sum[:], tokenHash) == 1 {
+					ctx := context.WithValue(r.Context(), contextKeyUID, "")
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+			}
+			if _, err := r.Cookie(cookieName); !ok && err != nil {
+				unauthorized(w)
+				return
+			}
+			authorized.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+func TestParseUserIDs(t *testing.T) {
+	ids, err := ParseUserIDs(" 6577f191-a012-4f16-afe4-6ed0d542e523, ,9b1f0c2e-3d4a-4b5c-8d6e-7f8091a2b3c4")
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523"),
+		uuid.MustParse("9b1f0c2e-3d4a-4b5c-8d6e-7f8091a2b3c4"),
+	}, ids)
+
+	ids, err = ParseUserIDs("")
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	_, err = ParseUserIDs("admin")
+	assert.Error(t, err)
+}
+
+func Test_Admin(t *testing.T) {
+	keys, err := NewKeyring(Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	admin, user := uuid.New(), uuid.New()
+	codec := newCookieCodec(keys, CookieOptions{})
+	auth := NewAuth(keys, CookieOptions{}, fakeTokens{
+		"admin-api": {UserID: admin, Scope: storage.ScopeReadWrite},
+		"user-api":  {UserID: user, Scope: storage.ScopeReadWrite},
+	})
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		cookie        *http.Cookie
+		wantStatus    int
+	}{
+		{name: "admin token", token: "s3cret", authorization: "Bearer s3cret", wantStatus: http.StatusOK},
+		{name: "wrong admin token", token: "s3cret", authorization: "Bearer s3cre", wantStatus: http.StatusUnauthorized},
+		{name: "admin token is disabled", authorization: "Bearer ", wantStatus: http.StatusUnauthorized},
+		{name: "admin cookie", cookie: codec.cookie(admin), wantStatus: http.StatusOK},
+		{name: "user cookie", cookie: codec.cookie(user), wantStatus: http.StatusForbidden},
+		{name: "admin API token", authorization: "Bearer admin-api", wantStatus: http.StatusOK},
+		{name: "user API token", authorization: "Bearer user-api", wantStatus: http.StatusForbidden},
+		{name: "anonymous", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+			mid := NewAdmin(AdminOptions{Token: tt.token, UserIDs: []uuid.UUID{admin}}, auth)(nextHandler)
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/admin/urls", nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.cookie != nil {
+				request.AddCookie(tt.cookie)
+			}
+			mid.ServeHTTP(recorder, request)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+			assert.Equal(t, tt.wantStatus == http.StatusOK, called)
+			assert.Empty(t, res.Cookies())
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+// DefaultCookieLifetime is a lifetime of user ID cookie used when none is configured.
+const DefaultCookieLifetime = 30 * 24 * time.Hour
+
+const (
+	// uidLength is a length of user ID in its canonical string form.
+	uidLength = 36
+	// issuedLength is a length of cookie issue time in Unix seconds.
+	issuedLength = 8
+	// maxCookieLength limits length of cookie value accepted for decoding.
+	maxCookieLength = 256
+	// maxClockSkew is how far in the future cookie issue time may be.
+	maxClockSkew = time.Minute
+)
+
+// CookieOptions configure cookie identifying user.
+type CookieOptions struct {
+	// Lifetime is a period after which cookie expires. Cookie is renewed
+	// by requests made after half of its lifetime passes, so active users
+	// keep their ID. Zero means DefaultCookieLifetime.
+	Lifetime time.Duration
+	// Secure restricts cookie to HTTPS requests.
+	Secure bool
+	// JWT, if not nil, issues cookies as JSON Web Tokens, so other services
+	// may verify them, and accepts such tokens in Authorization header too.
+	// Cookies of the previous format are still accepted.
+	JWT *JWT
+	// LegacyUntil is a moment until which cookies issued before issue time
+	// was embedded into them are accepted and renewed, so their users keep
+	// their IDs. Such cookies never expire by themselves, so they are
+	// rejected afterwards. Zero rejects them right away.
+	LegacyUntil time.Time
+	// LegacySecret verifies cookies without key ID issued by versions
+	// that signed them with a hardcoded secret. It never signs cookies
+	// and never verifies cookies with key ID, so keys of keyring stay
+	// the only way to issue new identities.
+	LegacySecret []byte
+	// LegacyRestricted, if not nil, reports whether user may not be identified
+	// by cookie verified with LegacySecret. Anybody knowing the secret can forge
+	// such cookies, so they must not identify administrators or accounts.
+	LegacyRestricted func(ctx context.Context, uid uuid.UUID) bool
+}
+
+// AccountChecker reports whether user is a registered account.
+type AccountChecker interface {
+	HasAccount(ctx context.Context, userID string) (bool, error)
+}
+
+// RestrictLegacy returns CookieOptions.LegacyRestricted denying cookies
+// verified with legacy secret to provided administrators and to accounts.
+// If account can't be checked, cookie is denied too.
+func RestrictLegacy(admins []uuid.UUID, accounts AccountChecker) func(ctx context.Context, uid uuid.UUID) bool {
+	restricted := make(map[uuid.UUID]bool, len(admins))
+	for _, id := range admins {
+		restricted[id] = true
+	}
+	return func(ctx context.Context, uid uuid.UUID) bool {
+		if restricted[uid] {
+			return true
+		}
+		if accounts == nil {
+			return false
+		}
+		ok, err := accounts.HasAccount(ctx, uid.String())
+		if err != nil {
+			log.Printf("unable to check account of legacy cookie: %v\n", err)
+			return true
+		}
+		return ok
+	}
+}
+
+// CheckLegacySecret ensures legacy cookie secret differs from keys of keyring,
+// otherwise everybody knowing it could sign cookies with key ID and transfer tokens.
+func CheckLegacySecret(keys *Keyring, secret []byte) error {
+	for id, key := range keys.keys {
+		if len(secret) > 0 && hmac.Equal(key, secret) {
+			return fmt.Errorf("%w: secret of key %s is used as legacy cookie secret", ErrInvalidKey, id)
+		}
+	}
+	return nil
+}
+
+// userCookie is a decoded user ID cookie.
+// Issue time is zero for cookies issued before it was embedded.
+// Legacy is set for cookies verified with legacy secret.
+type userCookie struct {
+	issued time.Time
+	uid    uuid.UUID
+	legacy bool
+}
+
+// cookieCodec signs and verifies user ID cookies.
+// Cookie value consists of key ID and hex-encoded user ID, issue time
+// and signature of all of them, separated by dot. Cookies issued before
+// issue time was embedded, with or without key ID, are accepted
+// until CookieOptions.LegacyUntil. Cookies without key ID are verified
+// with CookieOptions.LegacySecret only.
+// If JWT is configured, cookies are JSON Web Tokens instead.
+type cookieCodec struct {
+	keys *Keyring
+	now  func() time.Time
+	opts CookieOptions
+}
+
+func newCookieCodec(keys *Keyring, opts CookieOptions) *cookieCodec {
+	if opts.Lifetime <= 0 {
+		opts.Lifetime = DefaultCookieLifetime
+	}
+	return &cookieCodec{
+		keys: keys,
+		now:  time.Now,
+		opts: opts,
+	}
+}
+
+// cookie creates cookie for user issued now.
+func (c *cookieCodec) cookie(uid uuid.UUID) *http.Cookie {
+	now := c.now()
+	return &http.Cookie{
+		Name:     cookieName,
+		Value:    c.encode(uid, now),
+		Path:     "/",
+		Expires:  now.Add(c.opts.Lifetime).UTC(),
+		MaxAge:   int(c.opts.Lifetime.Seconds()),
+		Secure:   c.opts.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// needsRenewal reports whether cookie passed half of its lifetime.
+func (c *cookieCodec) needsRenewal(uc userCookie) bool {
+	return uc.issued.IsZero() || c.now().Sub(uc.issued) >= c.opts.Lifetime/2
+}
+
+// encode returns value of cookie signed with the newest key.
+func (c *cookieCodec) encode(uid uuid.UUID, issued time.Time) string {
+	if c.opts.JWT != nil {
+		token, err := c.opts.JWT.Sign(Claims{
+			Subject:   uid.String(),
+			IssuedAt:  issued.Unix(),
+			ExpiresAt: issued.Add(c.opts.Lifetime).Unix(),
+			Scope:     storage.ScopeReadWrite.String(),
+		})
+		if err == nil {
+			return token
+		}
+		log.Printf("unable to sign JWT: %v\n", err)
+	}
+	id := c.keys.newest
+	payload := make([]byte, uidLength+issuedLength, uidLength+issuedLength+sha256.Size)
+	copy(payload, uid.String())
+	binary.BigEndian.PutUint64(payload[uidLength:], uint64(issued.Unix()))
+	mac, _ := c.keys.sign(id, id+"."+string(payload))
+	return id + "." + hex.EncodeToString(append(payload, mac...))
+}
+
+// decode verifies value of cookie and extracts user ID from it.
+// Every malformed, forged or expired cookie produces errInvalidCookie.
+func (c *cookieCodec) decode(value string) (userCookie, error) {
+	if c.opts.JWT != nil && looksLikeJWT(value) {
+		claims, err := c.opts.JWT.Verify(value, c.now())
+		if err != nil {
+			return userCookie{}, fmt.Errorf("%w: %v", errInvalidCookie, err)
+		}
+		// cookies grant full access, so tokens restricted to reading
+		// are accepted in Authorization header only, where scope is enforced
+		if claims.Scope != storage.ScopeReadWrite.String() {
+			return userCookie{}, fmt.Errorf("%w: JWT scope %q is not %s", errInvalidCookie, claims.Scope, storage.ScopeReadWrite)
+		}
+		return userCookie{
+			issued: time.Unix(claims.IssuedAt, 0),
+			uid:    uuid.MustParse(claims.Subject),
+		}, nil
+	}
+	if len(value) > maxCookieLength {
+		return userCookie{}, fmt.Errorf("%w: cookie is too long", errInvalidCookie)
+	}
+	id, encoded := "", value
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		id, encoded = value[:i], value[i+1:]
+	}
+	decoded, err := hex.DecodeString(encoded)
+	if err != nil {
+		return userCookie{}, fmt.Errorf("%w: %v", errInvalidCookie, err)
+	}
+
+	var uc userCookie
+	var valid bool
+	switch len(decoded) {
+	case uidLength + issuedLength + sha256.Size:
+		if id == "" {
+			return userCookie{}, fmt.Errorf("%w: key id is missing", errInvalidCookie)
+		}
+		payload, mac := decoded[:uidLength+issuedLength], decoded[uidLength+issuedLength:]
+		expected, ok := c.keys.sign(id, id+"."+string(payload))
+		valid = ok && hmac.Equal(mac, expected)
+		issued := int64(binary.BigEndian.Uint64(payload[uidLength:]))
+		uc.issued = time.Unix(issued, 0)
+	case uidLength + sha256.Size:
+		payload, mac := decoded[:uidLength], decoded[uidLength:]
+		if id != "" {
+			expected, ok := c.keys.sign(id, id+"."+string(payload))
+			valid = ok && hmac.Equal(mac, expected)
+			break
+		}
+		if len(c.opts.LegacySecret) == 0 {
+			return userCookie{}, fmt.Errorf("%w: key id is missing", errInvalidCookie)
+		}
+		h := hmac.New(sha256.New, c.opts.LegacySecret)
+		h.Write(payload)
+		valid = hmac.Equal(mac, h.Sum(nil))
+		uc.legacy = true
+	default:
+		return userCookie{}, fmt.Errorf("%w: unexpected length %d", errInvalidCookie, len(decoded))
+	}
+	if !valid {
+		return userCookie{}, errInvalidCookie
+	}
+
+	if uc.uid, err = uuid.Parse(string(decoded[:uidLength])); err != nil {
+		return userCookie{}, fmt.Errorf("%w: %v", errInvalidCookie, err)
+	}
+	now := c.now()
+	if uc.issued.IsZero() {
+		if !now.Before(c.opts.LegacyUntil) {
+			return userCookie{}, fmt.Errorf("%w: cookie of legacy format is no longer accepted", errInvalidCookie)
+		}
+		return uc, nil
+	}
+	if uc.issued.After(now.Add(maxClockSkew)) {
+		return userCookie{}, fmt.Errorf("%w: cookie is issued in the future", errInvalidCookie)
+	}
+	if !uc.issued.Add(c.opts.Lifetime).After(now) {
+		return userCookie{}, fmt.Errorf("%w: cookie is expired", errInvalidCookie)
+	}
+	return uc, nil
+}
@@ -0,0 +1,43 @@
+//go:build go1.18
+
+package middleware
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func FuzzCookieDecode(f *testing.F) {
+	keys, err := NewKeyring(Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")})
+	if err != nil {
+		f.Fatal(err)
+	}
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	codec := newCookieCodec(keys, CookieOptions{LegacyUntil: now.Add(time.Hour), LegacySecret: []byte(publicSecret)})
+	codec.now = func() time.Time { return now }
+
+	f.Add(codec.encode(uuid.New(), now))
+	f.Add("36353737663139312d613031322d346631362d616665342d36656430643534326535323333d92c2814ac0a09d73a675e9a187324028274fd0b7b03f488db1631c4b5328a")
+	f.Add("k1.3635")
+	f.Add("123")
+	f.Add(".")
+	f.Fuzz(func(t *testing.T, value string) {
+		uc, err := codec.decode(value)
+		if err != nil {
+			if !errors.Is(err, errInvalidCookie) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return
+		}
+		if uc.uid == uuid.Nil && uc.issued.IsZero() {
+			t.Fatalf("decoded empty cookie from %q", value)
+		}
+		decoded, err := codec.decode(codec.encode(uc.uid, now))
+		if err != nil || decoded.uid != uc.uid {
+			t.Fatalf("round trip of %q failed: %v", value, err)
+		}
+	})
+}
@@ -0,0 +1,311 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+// Algorithms signing JSON Web Tokens.
+const (
+	// AlgHS256 signs tokens with HMAC-SHA256 using shared secrets.
+	AlgHS256 = "HS256"
+	// AlgEdDSA signs tokens with Ed25519 keys.
+	AlgEdDSA = "EdDSA"
+)
+
+// DefaultJWTLeeway is a clock skew tolerated by default when checking time claims.
+const DefaultJWTLeeway = time.Minute
+
+// maxJWTLength limits length of token accepted for verification.
+const maxJWTLength = 2048
+
+var (
+	ErrInvalidJWTOptions = errors.New("invalid JWT options")
+	errInvalidJWT        = errors.New("invalid JWT")
+)
+
+// JWTOptions configure JSON Web Tokens identifying users.
+type JWTOptions struct {
+	// Algorithm is AlgHS256 or AlgEdDSA. Tokens signed with other
+	// algorithms, including "none", are rejected.
+	Algorithm string
+	// HMACKeys sign HS256 tokens. The last key signs new tokens,
+	// all of them verify existing ones. They must differ from keys signing
+	// cookies, since every holder of them may issue tokens.
+	HMACKeys []Key
+	// EdDSAKeys sign EdDSA tokens. The last key signs new tokens,
+	// all of them verify existing ones.
+	EdDSAKeys []EdDSAKey
+	// Issuer is put into iss claim of new tokens. If not empty,
+	// tokens of other issuers are rejected.
+	Issuer string
+	// Leeway is a clock skew tolerated when checking time claims,
+	// DefaultJWTLeeway if zero.
+	Leeway time.Duration
+}
+
+// EdDSAKey is an Ed25519 key identified by ID put into kid header of tokens.
+type EdDSAKey struct {
+	ID         string
+	PrivateKey ed25519.PrivateKey
+}
+
+// Claims are claims of JSON Web Token identifying user.
+// Subject is user ID, Scope is a name of storage.TokenScope.
+type Claims struct {
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+	Scope     string `json:"scope,omitempty"`
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// JWT signs and verifies JSON Web Tokens identifying users.
+type JWT struct {
+	hmac   *Keyring
+	eddsa  map[string]ed25519.PrivateKey
+	alg    string
+	newest string
+	issuer string
+	leeway time.Duration
+}
+
+// NewJWT creates JSON Web Tokens signer.
+func NewJWT(opts JWTOptions) (*JWT, error) {
+	j := &JWT{
+		alg:    opts.Algorithm,
+		issuer: opts.Issuer,
+		leeway: opts.Leeway,
+	}
+	if j.leeway == 0 {
+		j.leeway = DefaultJWTLeeway
+	}
+	if j.leeway < 0 {
+		return nil, fmt.Errorf("%w: leeway must not be negative", ErrInvalidJWTOptions)
+	}
+
+	switch opts.Algorithm {
+	case AlgHS256:
+		if len(opts.HMACKeys) == 0 {
+			return nil, fmt.Errorf("%w: HS256 requires at least one key", ErrInvalidJWTOptions)
+		}
+		keys, err := NewKeyring(opts.HMACKeys...)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJWTOptions, err)
+		}
+		j.hmac, j.newest = keys, keys.newest
+	case AlgEdDSA:
+		if len(opts.EdDSAKeys) == 0 {
+			return nil, fmt.Errorf("%w: EdDSA requires at least one key", ErrInvalidJWTOptions)
+		}
+		j.eddsa = make(map[string]ed25519.PrivateKey, len(opts.EdDSAKeys))
+		for _, k := range opts.EdDSAKeys {
+			if !validKeyID(k.ID) {
+				return nil, fmt.Errorf("%w: invalid key id %q", ErrInvalidJWTOptions, k.ID)
+			}
+			if len(k.PrivateKey) != ed25519.PrivateKeySize {
+				return nil, fmt.Errorf("%w: key %s is not Ed25519 private key", ErrInvalidJWTOptions, k.ID)
+			}
+			if _, ok := j.eddsa[k.ID]; ok {
+				return nil, fmt.Errorf("%w: duplicate key id %s", ErrInvalidJWTOptions, k.ID)
+			}
+			j.eddsa[k.ID] = k.PrivateKey
+			j.newest = k.ID
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidJWTOptions, opts.Algorithm)
+	}
+	return j, nil
+}
+
+// LoadHMACKeys reads secrets signing HS256 tokens from file at provided path.
+// File contains "id:secret" pairs the same way as file of cookie keys.
+func LoadHMACKeys(path string) ([]Key, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read HMAC keys file:\n%w", err)
+	}
+	keys, err := ParseKeys(string(b))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys in %s", ErrInvalidJWTOptions, path)
+	}
+	return keys, nil
+}
+
+// LoadEdDSAKeys reads PEM-encoded PKCS #8 Ed25519 private keys from file at provided path.
+// ID of key is taken from its "kid" PEM header, if present,
+// otherwise it is derived from public key.
+func LoadEdDSAKeys(path string) ([]EdDSAKey, error) {
+	rest, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read EdDSA keys file:\n%w", err)
+	}
+	var keys []EdDSAKey
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "PRIVATE KEY" {
+			continue
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse private key:\n%w", err)
+		}
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: key is not Ed25519 one", ErrInvalidJWTOptions)
+		}
+		id := block.Headers["kid"]
+		if id == "" {
+			sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+			id = hex.EncodeToString(sum[:8])
+		}
+		keys = append(keys, EdDSAKey{ID: id, PrivateKey: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no private keys in %s", ErrInvalidJWTOptions, path)
+	}
+	return keys, nil
+}
+
+// Sign returns token with provided claims signed with the newest key.
+// Issuer claim is set if JWT has one.
+func (j *JWT) Sign(c Claims) (string, error) {
+	if j.issuer != "" {
+		c.Issuer = j.issuer
+	}
+	header, err := json.Marshal(jwtHeader{Algorithm: j.alg, Type: "JWT", KeyID: j.newest})
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal header:\n%w", err)
+	}
+	claims, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal claims:\n%w", err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sig, _ := j.sign(j.newest, signed)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks signature and claims of token at provided time, returning its claims.
+// Token must have valid user ID as subject and expiration time.
+func (j *JWT) Verify(token string, now time.Time) (Claims, error) {
+	if len(token) > maxJWTLength {
+		return Claims{}, fmt.Errorf("%w: token is too long", errInvalidJWT)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: token must consist of three parts", errInvalidJWT)
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	if header.Algorithm != j.alg {
+		return Claims{}, fmt.Errorf("%w: unexpected algorithm %q", errInvalidJWT, header.Algorithm)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", errInvalidJWT, err)
+	}
+	if !j.verify(header.KeyID, parts[0]+"."+parts[1], sig) {
+		return Claims{}, fmt.Errorf("%w: signature mismatch", errInvalidJWT)
+	}
+
+	var c Claims
+	if err = decodeJWTPart(parts[1], &c); err != nil {
+		return Claims{}, err
+	}
+	if _, err = uuid.Parse(c.Subject); err != nil {
+		return Claims{}, fmt.Errorf("%w: subject is not user ID", errInvalidJWT)
+	}
+	if j.issuer != "" && c.Issuer != j.issuer {
+		return Claims{}, fmt.Errorf("%w: unexpected issuer %q", errInvalidJWT, c.Issuer)
+	}
+	if c.Scope != "" {
+		var scope storage.TokenScope
+		if err = scope.UnmarshalText([]byte(c.Scope)); err != nil {
+			return Claims{}, fmt.Errorf("%w: %v", errInvalidJWT, err)
+		}
+	}
+	if c.ExpiresAt == 0 || !time.Unix(c.ExpiresAt, 0).Add(j.leeway).After(now) {
+		return Claims{}, fmt.Errorf("%w: token is expired", errInvalidJWT)
+	}
+	if time.Unix(c.IssuedAt, 0).After(now.Add(j.leeway)) {
+		return Claims{}, fmt.Errorf("%w: token is issued in the future", errInvalidJWT)
+	}
+	if c.NotBefore != 0 && time.Unix(c.NotBefore, 0).After(now.Add(j.leeway)) {
+		return Claims{}, fmt.Errorf("%w: token is not valid yet", errInvalidJWT)
+	}
+	return c, nil
+}
+
+// sign returns signature of message made with key with provided ID.
+func (j *JWT) sign(id, message string) ([]byte, bool) {
+	if j.alg == AlgHS256 {
+		return j.hmac.sign(id, message)
+	}
+	key, ok := j.eddsa[id]
+	if !ok {
+		return nil, false
+	}
+	return ed25519.Sign(key, []byte(message)), true
+}
+
+// verify reports whether signature of message was made with key with provided ID.
+func (j *JWT) verify(id, message string, sig []byte) bool {
+	if j.alg == AlgHS256 {
+		expected, ok := j.hmac.sign(id, message)
+		return ok && hmac.Equal(sig, expected)
+	}
+	key, ok := j.eddsa[id]
+	if !ok {
+		return false
+	}
+	return ed25519.Verify(key.Public().(ed25519.PublicKey), []byte(message), sig)
+}
+
+// looksLikeJWT reports whether value has form of compact JWT.
+func looksLikeJWT(value string) bool {
+	return strings.Count(value, ".") == 2
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidJWT, err)
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidJWT, err)
+	}
+	return nil
+}
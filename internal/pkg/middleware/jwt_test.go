@@ -0,0 +1,386 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWT_Verify(t *testing.T) {
+	keys := []Key{
+		{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")},
+		{ID: "k2", Secret: []byte("fedcba9876543210fedcba9876543210")},
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	hs, err := NewJWT(JWTOptions{Algorithm: AlgHS256, HMACKeys: keys, Issuer: "shortener"})
+	require.NoError(t, err)
+	ed, err := NewJWT(JWTOptions{Algorithm: AlgEdDSA, EdDSAKeys: []EdDSAKey{{ID: "ed1", PrivateKey: priv}}})
+	require.NoError(t, err)
+	otherEd, err := NewJWT(JWTOptions{Algorithm: AlgEdDSA, EdDSAKeys: []EdDSAKey{{ID: "ed1", PrivateKey: otherPriv}}})
+	require.NoError(t, err)
+
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	uid := "6577f191-a012-4f16-afe4-6ed0d542e523"
+	claims := Claims{
+		Subject:   uid,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		Scope:     "read",
+	}
+	sign := func(j *JWT, c Claims) string {
+		token, err := j.Sign(c)
+		require.NoError(t, err)
+		return token
+	}
+	with := func(f func(c *Claims)) Claims {
+		c := claims
+		f(&c)
+		return c
+	}
+	// forge replaces claims of signed token keeping its header and signature.
+	forge := func(token string, c Claims) string {
+		parts := strings.Split(token, ".")
+		b, err := json.Marshal(c)
+		require.NoError(t, err)
+		return parts[0] + "." + base64.RawURLEncoding.EncodeToString(b) + "." + parts[2]
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." +
+		strings.Split(sign(hs, claims), ".")[1] + "."
+
+	tests := []struct {
+		name    string
+		jwt     *JWT
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "HS256",
+			jwt:   hs,
+			token: sign(hs, claims),
+		},
+		{
+			name:  "EdDSA",
+			jwt:   ed,
+			token: sign(ed, claims),
+		},
+		{
+			name:  "expired within leeway",
+			jwt:   hs,
+			token: sign(hs, with(func(c *Claims) { c.ExpiresAt = now.Add(-30 * time.Second).Unix() })),
+		},
+		{
+			name:    "expired",
+			jwt:     hs,
+			token:   sign(hs, with(func(c *Claims) { c.ExpiresAt = now.Add(-2 * time.Minute).Unix() })),
+			wantErr: true,
+		},
+		{
+			name:    "without expiration",
+			jwt:     hs,
+			token:   sign(hs, with(func(c *Claims) { c.ExpiresAt = 0 })),
+			wantErr: true,
+		},
+		{
+			name:  "issued in the future within leeway",
+			jwt:   hs,
+			token: sign(hs, with(func(c *Claims) { c.IssuedAt = now.Add(30 * time.Second).Unix() })),
+		},
+		{
+			name:    "issued in the future",
+			jwt:     hs,
+			token:   sign(hs, with(func(c *Claims) { c.IssuedAt = now.Add(time.Hour).Unix() })),
+			wantErr: true,
+		},
+		{
+			name:    "not valid yet",
+			jwt:     hs,
+			token:   sign(hs, with(func(c *Claims) { c.NotBefore = now.Add(10 * time.Minute).Unix() })),
+			wantErr: true,
+		},
+		{
+			name:    "subject is not user ID",
+			jwt:     hs,
+			token:   sign(hs, with(func(c *Claims) { c.Subject = "admin" })),
+			wantErr: true,
+		},
+		{
+			name:    "unknown scope",
+			jwt:     hs,
+			token:   sign(hs, with(func(c *Claims) { c.Scope = "admin" })),
+			wantErr: true,
+		},
+		{
+			name:    "another issuer",
+			jwt:     hs,
+			token:   sign(ed, claims),
+			wantErr: true,
+		},
+		{
+			name:    "tampered claims",
+			jwt:     hs,
+			token:   forge(sign(hs, claims), with(func(c *Claims) { c.Subject = uuid.New().String() })),
+			wantErr: true,
+		},
+		{
+			name:    "tampered signature",
+			jwt:     ed,
+			token:   sign(ed, claims)[:len(sign(ed, claims))-4] + "AAAA",
+			wantErr: true,
+		},
+		{
+			name:    "signed with another key",
+			jwt:     ed,
+			token:   sign(otherEd, claims),
+			wantErr: true,
+		},
+		{
+			name:    "unsigned",
+			jwt:     hs,
+			token:   unsigned,
+			wantErr: true,
+		},
+		{
+			name:    "algorithm confusion",
+			jwt:     ed,
+			token:   sign(hs, claims),
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			jwt:     hs,
+			token:   "a.b.c",
+			wantErr: true,
+		},
+		{
+			name:    "too long",
+			jwt:     hs,
+			token:   sign(hs, claims) + strings.Repeat("A", maxJWTLength),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.jwt.Verify(tt.token, now)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errInvalidJWT)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, uid, got.Subject)
+			assert.Equal(t, "read", got.Scope)
+		})
+	}
+}
+
+func TestJWT_rotation(t *testing.T) {
+	old := []Key{{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")}}
+	rotated := append(old, Key{ID: "k2", Secret: []byte("fedcba9876543210fedcba9876543210")})
+	oldJWT, err := NewJWT(JWTOptions{Algorithm: AlgHS256, HMACKeys: old})
+	require.NoError(t, err)
+	rotatedJWT, err := NewJWT(JWTOptions{Algorithm: AlgHS256, HMACKeys: rotated})
+	require.NoError(t, err)
+
+	now := time.Now()
+	c := Claims{Subject: uuid.New().String(), IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}
+	token, err := oldJWT.Sign(c)
+	require.NoError(t, err)
+	_, err = rotatedJWT.Verify(token, now)
+	assert.NoError(t, err)
+
+	token, err = rotatedJWT.Sign(c)
+	require.NoError(t, err)
+	_, err = oldJWT.Verify(token, now)
+	assert.ErrorIs(t, err, errInvalidJWT)
+}
+
+func TestNewJWT(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for name, opts := range map[string]JWTOptions{
+		"unsupported algorithm": {Algorithm: "RS256"},
+		"no algorithm":          {},
+		"negative leeway":       {Algorithm: AlgHS256, HMACKeys: []Key{{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")}}, Leeway: -time.Second},
+		"no HMAC keys":          {Algorithm: AlgHS256},
+		"empty HMAC secret":     {Algorithm: AlgHS256, HMACKeys: []Key{{ID: "k1"}}},
+		"no EdDSA keys":         {Algorithm: AlgEdDSA},
+		"invalid key id":        {Algorithm: AlgEdDSA, EdDSAKeys: []EdDSAKey{{ID: "a.b", PrivateKey: priv}}},
+		"duplicate key id":      {Algorithm: AlgEdDSA, EdDSAKeys: []EdDSAKey{{ID: "ed", PrivateKey: priv}, {ID: "ed", PrivateKey: priv}}},
+		"truncated key":         {Algorithm: AlgEdDSA, EdDSAKeys: []EdDSAKey{{ID: "ed", PrivateKey: priv[:10]}}},
+	} {
+		_, err = NewJWT(opts)
+		assert.ErrorIs(t, err, ErrInvalidJWTOptions, name)
+	}
+}
+
+func TestLoadEdDSAKeys(t *testing.T) {
+	_, first, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, second, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	var data []byte
+	for i, key := range []ed25519.PrivateKey{first, second} {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		if i == 1 {
+			block.Headers = map[string]string{"kid": "ed-2022"}
+		}
+		data = append(data, pem.EncodeToMemory(block)...)
+	}
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	keys, err := LoadEdDSAKeys(path)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Len(t, keys[0].ID, 16)
+	assert.Equal(t, first, keys[0].PrivateKey)
+	assert.Equal(t, "ed-2022", keys[1].ID)
+
+	j, err := NewJWT(JWTOptions{Algorithm: AlgEdDSA, EdDSAKeys: keys})
+	require.NoError(t, err)
+	token, err := j.Sign(Claims{Subject: uuid.New().String(), ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	header, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"alg":"EdDSA","typ":"JWT","kid":"ed-2022"}`, string(header))
+
+	require.NoError(t, os.WriteFile(path, []byte("not a key"), 0600))
+	_, err = LoadEdDSAKeys(path)
+	assert.ErrorIs(t, err, ErrInvalidJWTOptions)
+}
+
+func Test_Auth_JWT(t *testing.T) {
+	keys, err := NewKeyring(Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	j, err := NewJWT(JWTOptions{Algorithm: AlgHS256, HMACKeys: []Key{{ID: "j1", Secret: []byte("fedcba9876543210fedcba9876543210")}}})
+	require.NoError(t, err)
+	opts := CookieOptions{Lifetime: time.Hour, JWT: j}
+
+	owner := uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523")
+	now := time.Now()
+	sign := func(scope string) string {
+		token, err := j.Sign(Claims{Subject: owner.String(), IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix(), Scope: scope})
+		require.NoError(t, err)
+		return token
+	}
+	bearer := func(scope string) string {
+		return "Bearer " + sign(scope)
+	}
+	previous := newCookieCodec(keys, CookieOptions{}).cookie(owner)
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		cookie        *http.Cookie
+		wantStatus    int
+		wantUID       string
+		wantNewUser   bool
+		wantJWTCookie bool
+	}{
+		{
+			name:          "new user gets JWT cookie",
+			method:        http.MethodGet,
+			wantStatus:    http.StatusOK,
+			wantJWTCookie: true,
+		},
+		{
+			name:       "cookie of previous format",
+			method:     http.MethodPost,
+			cookie:     previous,
+			wantStatus: http.StatusOK,
+			wantUID:    owner.String(),
+		},
+		{
+			name:       "read-write JWT cookie",
+			method:     http.MethodPost,
+			cookie:     &http.Cookie{Name: cookieName, Value: sign("read-write")},
+			wantStatus: http.StatusOK,
+			wantUID:    owner.String(),
+		},
+		{
+			name:          "read-only JWT cookie is replaced",
+			method:        http.MethodPost,
+			cookie:        &http.Cookie{Name: cookieName, Value: sign("read")},
+			wantStatus:    http.StatusOK,
+			wantNewUser:   true,
+			wantJWTCookie: true,
+		},
+		{
+			name:          "read-write bearer JWT",
+			method:        http.MethodPost,
+			authorization: bearer("read-write"),
+			wantStatus:    http.StatusOK,
+			wantUID:       owner.String(),
+		},
+		{
+			name:          "bearer JWT without scope is read-only",
+			method:        http.MethodPost,
+			authorization: bearer(""),
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "tampered bearer JWT",
+			method:        http.MethodGet,
+			authorization: bearer("read") + "x",
+			wantStatus:    http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var uid string
+			nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				uid = r.Context().Value(contextKeyUID).(string)
+			})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(tt.method, "http://localhost:8080/api/user/urls", nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.cookie != nil {
+				request.AddCookie(tt.cookie)
+			}
+			NewAuth(keys, opts, nil)(nextHandler).ServeHTTP(recorder, request)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.wantStatus, res.StatusCode)
+			if tt.wantUID != "" {
+				assert.Equal(t, tt.wantUID, uid)
+			}
+			if tt.wantNewUser {
+				assert.NotEqual(t, owner.String(), uid)
+			}
+			if !tt.wantJWTCookie {
+				return
+			}
+			require.Len(t, res.Cookies(), 1)
+			claims, err := j.Verify(res.Cookies()[0].Value, time.Now())
+			require.NoError(t, err)
+			assert.Equal(t, uid, claims.Subject)
+			assert.Equal(t, "read-write", claims.Scope)
+			assert.Equal(t, claims.IssuedAt+3600, claims.ExpiresAt)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	ErrNoKeys     = errors.New("keyring must contain at least one key")
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// minKeyLength is a length of secret below which a warning is logged.
+const minKeyLength = 32
+
+// publicSecret is a secret hardcoded into versions preceding keyring.
+// Since it is publicly known, it may only verify cookies of those versions,
+// see CookieOptions.LegacySecret.
+const publicSecret = "sh0rt7"
+
+// Key is a secret signing user ID cookie, identified by ID embedded into cookie.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring contains keys signing user ID cookies. The newest key signs
+// new cookies, all keys verify existing ones, so keys may be rotated
+// by adding a new key and removing the old one after cookies signed with it expire.
+type Keyring struct {
+	keys map[string][]byte
+	// newest is ID of key used for signing.
+	newest string
+}
+
+// NewKeyring creates keyring of provided keys, the last one being the newest.
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	kr := &Keyring{keys: make(map[string][]byte, len(keys))}
+	for _, k := range keys {
+		if !validKeyID(k.ID) {
+			return nil, fmt.Errorf("%w: id %q must contain 1 to 32 latin letters, digits, '-' or '_'", ErrInvalidKey, k.ID)
+		}
+		if len(k.Secret) == 0 {
+			return nil, fmt.Errorf("%w: secret of key %s is empty", ErrInvalidKey, k.ID)
+		}
+		if _, ok := kr.keys[k.ID]; ok {
+			return nil, fmt.Errorf("%w: duplicate id %s", ErrInvalidKey, k.ID)
+		}
+		if string(k.Secret) == publicSecret {
+			return nil, fmt.Errorf("%w: secret of key %s is publicly known, use it as legacy cookie secret only", ErrInvalidKey, k.ID)
+		}
+		if len(k.Secret) < minKeyLength {
+			log.Printf("secret of key %s is shorter than %d bytes, consider using longer one\n", k.ID, minKeyLength)
+		}
+		kr.keys[k.ID] = append([]byte(nil), k.Secret...)
+		kr.newest = k.ID
+	}
+	return kr, nil
+}
+
+// NewRandomKeyring creates keyring with single random key.
+// Cookies signed with it become invalid once application restarts.
+func NewRandomKeyring() (*Keyring, error) {
+	secret := make([]byte, minKeyLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("unable to generate random key:\n%w", err)
+	}
+	return NewKeyring(Key{ID: "random", Secret: secret})
+}
+
+// LoadKeyring creates keyring of keys read from file at provided path,
+// followed by keys from provided string. Both contain "id:secret" pairs,
+// separated by newlines or commas. Empty lines and lines starting with '#' are skipped.
+// If no keys are provided, random keyring is created.
+func LoadKeyring(keys, path string) (*Keyring, error) {
+	var parsed []Key
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read keys file:\n%w", err)
+		}
+		if parsed, err = ParseKeys(string(b)); err != nil {
+			return nil, err
+		}
+	}
+	fromString, err := ParseKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	parsed = append(parsed, fromString...)
+	if len(parsed) == 0 {
+		log.Println("no keys for signing cookies were provided, using random one: users will lose access to their URLs after restart")
+		return NewRandomKeyring()
+	}
+	return NewKeyring(parsed...)
+}
+
+// ParseKeys parses "id:secret" pairs separated by newlines or commas.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, line := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: expected id:secret pair", ErrInvalidKey)
+		}
+		keys = append(keys, Key{ID: strings.TrimSpace(parts[0]), Secret: []byte(strings.TrimSpace(parts[1]))})
+	}
+	return keys, nil
+}
+
+// sign returns signature of message made with key with provided ID.
+func (kr *Keyring) sign(id, message string) ([]byte, bool) {
+	secret, ok := kr.keys[id]
+	if !ok {
+		return nil, false
+	}
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(message))
+	return h.Sum(nil), true
+}
+
+func validKeyID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	for _, r := range id {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
@@ -4,73 +4,186 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/serjyuriev/shortener/internal/pkg/handlers"
+	"github.com/serjyuriev/shortener/internal/pkg/service"
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
 )
 
-var errInvalidCookie = errors.New("can not validate signature")
+var errInvalidCookie = errors.New("invalid user ID cookie")
 var cookieName = "userID"
-var key = []byte("sh0rt7")
 
-var contextKeyUID = handlers.ContextKey("uid")
+var (
+	contextKeyUID        = handlers.ContextKey("uid")
+	contextKeySwitchUser = handlers.ContextKey("switchUser")
+)
+
+// TokenAuthenticator resolves API tokens sent in Authorization header.
+// JSON Web Tokens are verified by middleware itself.
+type TokenAuthenticator interface {
+	AuthenticateToken(ctx context.Context, token string) (storage.Token, error)
+}
 
-// Auth checks whether current request has cookie and either
-// generates new cookie if no cookie was provided or
-// validates provided cookie.
-func Auth(next http.Handler) http.Handler {
+// NewAuth creates middleware identifying user by API token or signed cookie.
+// API token sent as "Authorization: Bearer" takes precedence over cookie,
+// requests with unknown tokens are rejected and read-only tokens
+// are allowed only safe methods. If tokens is nil, API tokens are rejected.
+// If request has no token and no valid cookie, new user ID is generated
+// and sent in new cookie. Cookies that passed half of their lifetime are renewed.
+// Cookies are signed with keys of provided keyring.
+func NewAuth(keys *Keyring, opts CookieOptions, tokens TokenAuthenticator) func(http.Handler) http.Handler {
+	codec := newCookieCodec(keys, opts)
+	return func(next http.Handler) http.Handler {
+		return auth(codec, tokens, next)
+	}
+}
+
+func auth(codec *cookieCodec, tokens TokenAuthenticator, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie(cookieName)
-		if err != nil {
-			if !errors.Is(err, http.ErrNoCookie) {
-				log.Printf("error getting %s cookie from the request: %v", cookieName, err)
-				http.Error(w, "internal server error", http.StatusInternalServerError)
-				return
+		if token, ok := bearerToken(r); ok {
+			var uid string
+			if codec.opts.JWT != nil && looksLikeJWT(token) {
+				uid, ok = authenticateJWT(w, r, codec, token)
+			} else {
+				uid, ok = authenticateToken(w, r, tokens, token)
 			}
-			uid, newCookieValue := generateNewUserIDCookie()
-			newCookie := &http.Cookie{
-				Name:    cookieName,
-				Value:   newCookieValue,
-				Expires: time.Now().Add(600 * time.Second).UTC(),
-				Path:    "/",
+			if !ok {
+				return
 			}
-			http.SetCookie(w, newCookie)
-			ctx := context.WithValue(r.Context(), contextKeyUID, uid.String())
+			ctx := context.WithValue(r.Context(), contextKeyUID, uid)
 			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
 
-		uid, err := validateCookie(cookie.Value)
-		if err != nil {
-			if !errors.Is(err, errInvalidCookie) {
-				log.Printf("unable to validate cookie: %v", err)
-				http.Error(w, "internal server error", http.StatusInternalServerError)
-				return
+		var uid uuid.UUID
+		renew := true
+		if cookie, err := r.Cookie(cookieName); err == nil {
+			uc, err := codec.decode(cookie.Value)
+			if err == nil && uc.legacy && codec.opts.LegacyRestricted != nil && codec.opts.LegacyRestricted(r.Context(), uc.uid) {
+				err = fmt.Errorf("%w: user %s can't be identified by cookie signed with legacy secret", errInvalidCookie, uc.uid)
 			}
-			var newCookieValue string
-			uid, newCookieValue = generateNewUserIDCookie()
-			newCookie := &http.Cookie{
-				Name:    cookieName,
-				Value:   newCookieValue,
-				Expires: time.Now().Add(60 * time.Second).UTC(),
+			if err == nil {
+				uid = uc.uid
+				renew = codec.needsRenewal(uc)
+			} else {
+				log.Printf("unable to validate cookie: %v\n", err)
 			}
-			http.SetCookie(w, newCookie)
+		}
+		if uid == uuid.Nil {
+			uid = uuid.New()
+		}
+		if renew {
+			http.SetCookie(w, codec.cookie(uid))
 		}
 		ctx := context.WithValue(r.Context(), contextKeyUID, uid.String())
+		ctx = context.WithValue(ctx, contextKeySwitchUser, handlers.SwitchUser(func(w http.ResponseWriter, uid string) {
+			id, err := uuid.Parse(uid)
+			if err != nil {
+				log.Printf("unable to switch user: %v\n", err)
+				return
+			}
+			// cookie renewed for previous user must not reach client
+			header := w.Header()
+			cookies := header["Set-Cookie"][:0]
+			for _, c := range header["Set-Cookie"] {
+				if !strings.HasPrefix(c, cookieName+"=") {
+					cookies = append(cookies, c)
+				}
+			}
+			header["Set-Cookie"] = cookies
+			http.SetCookie(w, codec.cookie(id))
+		}))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// authenticateToken returns ID of user owning API token. If token is unknown
+// or its scope doesn't allow request, error is written and false is returned.
+func authenticateToken(w http.ResponseWriter, r *http.Request, tokens TokenAuthenticator, token string) (string, bool) {
+	if tokens == nil {
+		unauthorized(w)
+		return "", false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer cancel()
+	t, err := tokens.AuthenticateToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownToken) {
+			unauthorized(w)
+			return "", false
+		}
+		log.Printf("unable to authenticate token: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return "", false
+	}
+	if !allowScope(w, r, t.Scope) {
+		return "", false
+	}
+	return t.UserID.String(), true
+}
+
+// authenticateJWT returns ID of user identified by JSON Web Token. If token is invalid
+// or its scope doesn't allow request, error is written and false is returned.
+// Tokens without scope are read-only.
+func authenticateJWT(w http.ResponseWriter, r *http.Request, codec *cookieCodec, token string) (string, bool) {
+	claims, err := codec.opts.JWT.Verify(token, codec.now())
+	if err != nil {
+		log.Printf("unable to verify JWT: %v\n", err)
+		unauthorized(w)
+		return "", false
+	}
+	var scope storage.TokenScope
+	if err = scope.UnmarshalText([]byte(claims.Scope)); err != nil {
+		unauthorized(w)
+		return "", false
+	}
+	if !allowScope(w, r, scope) {
+		return "", false
+	}
+	return claims.Subject, true
+}
+
+// allowScope reports whether token scope allows request,
+// writing error if it doesn't.
+func allowScope(w http.ResponseWriter, r *http.Request, scope storage.TokenScope) bool {
+	if scope == storage.ScopeRead && !safeMethods[r.Method] {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="read-write"`)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// safeMethods are HTTP methods allowed for read-only API tokens.
+var safeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+// bearerToken extracts API token from Authorization header with Bearer scheme.
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < len("Bearer ") || !strings.EqualFold(h[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[len("Bearer "):]), true
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
 // Gzipper checks whether current request was encoded with gzip
 // and if so decodes it.
 func Gzipper(next http.Handler) http.Handler {
@@ -96,32 +209,3 @@ func Gzipper(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-
-func generateNewUserIDCookie() (uuid.UUID, string) {
-	uid := uuid.New()
-	h := hmac.New(sha256.New, key)
-	h.Write([]byte(uid.String()))
-	cookie := append([]byte(uid.String()), h.Sum(nil)...)
-	return uid, hex.EncodeToString(cookie)
-}
-
-func validateCookie(cookie string) (uuid.UUID, error) {
-	decodedCookie, err := hex.DecodeString(cookie)
-	if err != nil {
-		return uuid.Nil, fmt.Errorf("unable to decode cookie:\n%w", err)
-	}
-
-	h := hmac.New(sha256.New, key)
-	if _, err = h.Write(decodedCookie[:36]); err != nil {
-		return uuid.Nil, fmt.Errorf("unable to write decoded cookie:\n%w", err)
-	}
-
-	if hmac.Equal(decodedCookie[36:], h.Sum(nil)) {
-		uid, err := uuid.Parse(string(decodedCookie[:36]))
-		if err != nil {
-			return uuid.Nil, fmt.Errorf("unable to parse uuid:\n%w", err)
-		}
-		return uid, nil
-	}
-	return uuid.Nil, errInvalidCookie
-}
@@ -4,58 +4,281 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/serjyuriev/shortener/internal/pkg/handlers"
+	"github.com/serjyuriev/shortener/internal/pkg/service"
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
 )
 
 func Test_Auth(t *testing.T) {
+	keys, err := NewKeyring(Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	codec := newCookieCodec(keys, CookieOptions{})
+	fresh := codec.cookie(uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523"))
+
 	tests := []struct {
-		name       string
-		requestURL string
-		testURL    string
+		name      string
+		cookie    string
+		wantUID   string
+		wantRenew bool
 	}{
 		{
-			name:       "positive test",
-			requestURL: "http://localhost:8080/",
-			testURL:    "https://github.com/serjyuriev/",
+			name:      "without cookie",
+			wantRenew: true,
+		},
+		{
+			name:      "malformed cookie",
+			cookie:    "123",
+			wantRenew: true,
 		},
 		{
-			name:       "positive test - without encoding",
-			requestURL: "http://localhost:8080/",
-			testURL:    "https://github.com/serjyuriev/",
+			name:    "valid cookie",
+			cookie:  fresh.Value,
+			wantUID: "6577f191-a012-4f16-afe4-6ed0d542e523",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			var requestBody bytes.Buffer
-			w := bufio.NewWriter(&requestBody)
-			_, err := w.Write([]byte(tt.testURL))
+			var uid string
+			nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				uid = r.Context().Value(contextKeyUID).(string)
+			})
+			mid := NewAuth(keys, CookieOptions{Lifetime: time.Hour, Secure: true}, nil)(nextHandler)
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+			if tt.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: cookieName, Value: tt.cookie})
+			}
+			mid.ServeHTTP(recorder, request)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			_, err := uuid.Parse(uid)
 			require.NoError(t, err)
-			require.NoError(t, w.Flush())
+			if tt.wantUID != "" {
+				assert.Equal(t, tt.wantUID, uid)
+			}
+			if !tt.wantRenew {
+				assert.Empty(t, res.Cookies())
+				return
+			}
+			require.Len(t, res.Cookies(), 1)
+			c := res.Cookies()[0]
+			assert.Equal(t, "/", c.Path)
+			assert.Equal(t, 3600, c.MaxAge)
+			assert.True(t, c.Secure)
+			assert.True(t, c.HttpOnly)
+			assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+		})
+	}
+}
+
+type fakeAccounts map[string]bool
+
+func (f fakeAccounts) HasAccount(ctx context.Context, userID string) (bool, error) {
+	ok, known := f[userID]
+	if !known {
+		return false, errors.New("connection refused")
+	}
+	return ok, nil
+}
+
+func Test_Auth_legacyRestricted(t *testing.T) {
+	keys, err := NewKeyring(Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	legacy := func(uid uuid.UUID) string {
+		legacyKeys := &Keyring{keys: map[string][]byte{"": []byte(publicSecret)}}
+		return signedWithoutKeyID(legacyKeys, "", uid)
+	}
+	anonymous, admin, account, unknown := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	accounts := fakeAccounts{anonymous.String(): false, admin.String(): false, account.String(): true}
+	opts := CookieOptions{
+		LegacyUntil:      time.Now().Add(time.Hour),
+		LegacySecret:     []byte(publicSecret),
+		LegacyRestricted: RestrictLegacy([]uuid.UUID{admin}, accounts),
+	}
+	keyed := newCookieCodec(keys, opts).cookie(account).Value
+
+	tests := []struct {
+		name    string
+		cookie  string
+		wantUID uuid.UUID
+	}{
+		{name: "anonymous user", cookie: legacy(anonymous), wantUID: anonymous},
+		{name: "administrator", cookie: legacy(admin)},
+		{name: "account", cookie: legacy(account)},
+		{name: "account can't be checked", cookie: legacy(unknown)},
+		{name: "account with cookie signed by keyring", cookie: keyed, wantUID: account},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var uid string
+			mid := NewAuth(keys, opts, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				uid = r.Context().Value(contextKeyUID).(string)
+			}))
+			request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+			request.AddCookie(&http.Cookie{Name: cookieName, Value: tt.cookie})
+			mid.ServeHTTP(httptest.NewRecorder(), request)
+			if tt.wantUID != uuid.Nil {
+				assert.Equal(t, tt.wantUID.String(), uid)
+				return
+			}
+			assert.NotContains(t, []string{admin.String(), account.String(), unknown.String()}, uid)
+		})
+	}
+}
 
+type fakeTokens map[string]storage.Token
+
+func (f fakeTokens) AuthenticateToken(ctx context.Context, token string) (storage.Token, error) {
+	if token == "broken" {
+		return storage.Token{}, errors.New("connection refused")
+	}
+	t, ok := f[token]
+	if !ok {
+		return storage.Token{}, service.ErrUnknownToken
+	}
+	return t, nil
+}
+
+func Test_Auth_bearer(t *testing.T) {
+	keys, err := NewKeyring(Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	owner := uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523")
+	tokens := fakeTokens{
+		"reader": {UserID: owner, Scope: storage.ScopeRead},
+		"writer": {UserID: owner, Scope: storage.ScopeReadWrite},
+	}
+	cookie := newCookieCodec(keys, CookieOptions{}).cookie(uuid.New())
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		tokens        TokenAuthenticator
+		wantStatus    int
+	}{
+		{
+			name:          "read-write token",
+			method:        http.MethodPost,
+			authorization: "Bearer writer",
+			tokens:        tokens,
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "read-only token reads",
+			method:        http.MethodGet,
+			authorization: "bearer reader",
+			tokens:        tokens,
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "read-only token writes",
+			method:        http.MethodDelete,
+			authorization: "Bearer reader",
+			tokens:        tokens,
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "unknown token",
+			method:        http.MethodGet,
+			authorization: "Bearer stolen",
+			tokens:        tokens,
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "tokens are disabled",
+			method:        http.MethodGet,
+			authorization: "Bearer writer",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "storage failure",
+			method:        http.MethodGet,
+			authorization: "Bearer broken",
+			tokens:        tokens,
+			wantStatus:    http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var uid string
 			nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				URL, err := io.ReadAll(r.Body)
-				require.NoError(t, err)
-				require.Equal(t, tt.testURL, string(URL))
+				uid = r.Context().Value(contextKeyUID).(string)
 			})
-			mid := Auth(nextHandler)
+			mid := NewAuth(keys, CookieOptions{}, tt.tokens)(nextHandler)
 			recorder := httptest.NewRecorder()
-			request := httptest.NewRequest(http.MethodGet, tt.requestURL, &requestBody)
-			request.AddCookie(&http.Cookie{
-				Name:  "userID",
-				Value: "123",
-			})
+			request := httptest.NewRequest(tt.method, "http://localhost:8080/api/user/urls", nil)
+			request.Header.Set("Authorization", tt.authorization)
+			request.AddCookie(cookie)
 			mid.ServeHTTP(recorder, request)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.wantStatus, res.StatusCode)
+			assert.Empty(t, res.Cookies())
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, owner.String(), uid)
+				return
+			}
+			assert.Empty(t, uid)
+			if tt.wantStatus != http.StatusInternalServerError {
+				assert.Contains(t, res.Header.Get("WWW-Authenticate"), "Bearer")
+			}
 		})
 	}
 }
 
+func Test_Auth_switchUser(t *testing.T) {
+	keys, err := NewKeyring(Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	codec := newCookieCodec(keys, CookieOptions{Lifetime: time.Hour})
+	account := uuid.New()
+
+	var hasSwitcher bool
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switchUser, ok := r.Context().Value(contextKeySwitchUser).(handlers.SwitchUser)
+		if hasSwitcher = ok; ok {
+			switchUser(w, account.String())
+		}
+	})
+	mid := NewAuth(keys, CookieOptions{Lifetime: time.Hour}, fakeTokens{"writer": {UserID: account, Scope: storage.ScopeReadWrite}})(nextHandler)
+
+	recorder := httptest.NewRecorder()
+	mid.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/login", nil))
+	res := recorder.Result()
+	defer res.Body.Close()
+	require.True(t, hasSwitcher)
+	require.Len(t, res.Cookies(), 1, "cookie of anonymous user must be replaced")
+	uc, err := codec.decode(res.Cookies()[0].Value)
+	require.NoError(t, err)
+	assert.Equal(t, account, uc.uid)
+
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/login", nil)
+	request.Header.Set("Authorization", "Bearer writer")
+	mid.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.False(t, hasSwitcher, "requests with API tokens must not switch user")
+}
+
 func Test_Gzipper(t *testing.T) {
 	tests := []struct {
 		name               string
@@ -110,35 +333,195 @@ func Test_Gzipper(t *testing.T) {
 	}
 }
 
-func Test_generateNewUserIDCookie(t *testing.T) {
-	uuidOrig, hex := generateNewUserIDCookie()
-	uuidParsed, err := validateCookie(hex)
-	assert.NoError(t, err)
-	assert.Equal(t, uuidOrig, uuidParsed)
-}
+func Test_cookieCodec(t *testing.T) {
+	keys, err := NewKeyring(
+		Key{ID: "k0", Secret: []byte("fedcba9876543210fedcba9876543210")},
+		Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")},
+	)
+	require.NoError(t, err)
+	rotated, err := NewKeyring(Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	// public signs cookies with key ID using legacy secret, as an attacker could.
+	public := &Keyring{keys: map[string][]byte{"legacy": []byte(publicSecret)}, newest: "legacy"}
+
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	newCodec := func(keys *Keyring) *cookieCodec {
+		c := newCookieCodec(keys, CookieOptions{
+			Lifetime:     24 * time.Hour,
+			LegacyUntil:  now.Add(time.Hour),
+			LegacySecret: []byte(publicSecret),
+		})
+		c.now = func() time.Time { return now }
+		return c
+	}
+	uid := uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523")
+	signed := newCodec(keys).encode(uid, now)
+	require.True(t, strings.HasPrefix(signed, "k1."))
+	legacy := "36353737663139312d613031322d346631362d616665342d36656430643534326535323333d92c2814ac0a09d73a675e9a187324028274fd0b7b03f488db1631c4b5328a"
 
-func Test_validateCookie(t *testing.T) {
 	type want struct {
-		uid string
+		renew bool
+		err   bool
 	}
 	tests := []struct {
-		name   string
-		cookie string
-		want   want
+		name           string
+		keys           *Keyring
+		noLegacySecret bool
+		cookie         string
+		want           want
 	}{
 		{
 			name:   "correct cookie",
-			cookie: "36353737663139312d613031322d346631362d616665342d36656430643534326535323333d92c2814ac0a09d73a675e9a187324028274fd0b7b03f488db1631c4b5328a",
-			want: want{
-				uid: "6577f191-a012-4f16-afe4-6ed0d542e523",
-			},
+			keys:   keys,
+			cookie: signed,
+		},
+		{
+			name:   "correct cookie after rotation",
+			keys:   rotated,
+			cookie: signed,
+		},
+		{
+			name:   "legacy cookie without key id",
+			keys:   keys,
+			cookie: legacy,
+			want:   want{renew: true},
+		},
+		{
+			name:           "legacy cookie without legacy secret",
+			keys:           keys,
+			noLegacySecret: true,
+			cookie:         legacy,
+			want:           want{err: true},
+		},
+		{
+			name:   "cookie with key id signed with legacy secret",
+			keys:   keys,
+			cookie: newCodec(public).encode(uid, now),
+			want:   want{err: true},
+		},
+		{
+			name:   "cookie without key id signed with auth key",
+			keys:   keys,
+			cookie: signedWithoutKeyID(keys, "k1", uid),
+			want:   want{err: true},
+		},
+		{
+			name:   "cookie passed half of lifetime",
+			keys:   keys,
+			cookie: newCodec(keys).encode(uid, now.Add(-13*time.Hour)),
+			want:   want{renew: true},
+		},
+		{
+			name:   "expired cookie",
+			keys:   keys,
+			cookie: newCodec(keys).encode(uid, now.Add(-24*time.Hour)),
+			want:   want{err: true},
+		},
+		{
+			name:   "cookie issued in the future",
+			keys:   keys,
+			cookie: newCodec(keys).encode(uid, now.Add(time.Hour)),
+			want:   want{err: true},
+		},
+		{
+			name:   "key id replaced",
+			keys:   keys,
+			cookie: "legacy" + strings.TrimPrefix(signed, "k1"),
+			want:   want{err: true},
+		},
+		{
+			name:   "key id removed",
+			keys:   keys,
+			cookie: strings.TrimPrefix(signed, "k1."),
+			want:   want{err: true},
+		},
+		{
+			name:   "unknown key id",
+			keys:   keys,
+			cookie: "k2" + strings.TrimPrefix(signed, "k1"),
+			want:   want{err: true},
+		},
+		{
+			name:   "truncated cookie",
+			keys:   keys,
+			cookie: "k1.3635",
+			want:   want{err: true},
+		},
+		{
+			name:   "not hex",
+			keys:   keys,
+			cookie: "k1.zz",
+			want:   want{err: true},
+		},
+		{
+			name:   "too long cookie",
+			keys:   keys,
+			cookie: signed + strings.Repeat("0", maxCookieLength),
+			want:   want{err: true},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			uuid, err := validateCookie(tt.cookie)
+			codec := newCodec(tt.keys)
+			if tt.noLegacySecret {
+				codec.opts.LegacySecret = nil
+			}
+			uc, err := codec.decode(tt.cookie)
+			if tt.want.err {
+				assert.ErrorIs(t, err, errInvalidCookie)
+				return
+			}
 			require.NoError(t, err)
-			assert.Equal(t, tt.want.uid, uuid.String())
+			assert.Equal(t, uid, uc.uid)
+			assert.Equal(t, tt.want.renew, codec.needsRenewal(uc))
 		})
 	}
+
+	t.Run("legacy cookie after cutoff", func(t *testing.T) {
+		codec := newCodec(keys)
+		codec.opts.LegacyUntil = now
+		_, err := codec.decode(legacy)
+		assert.ErrorIs(t, err, errInvalidCookie)
+		codec.opts.LegacyUntil = time.Time{}
+		_, err = codec.decode(legacy)
+		assert.ErrorIs(t, err, errInvalidCookie, "legacy cookies are rejected unless cutoff is set")
+	})
+}
+
+// signedWithoutKeyID returns cookie of legacy format signed with key of keyring.
+func signedWithoutKeyID(keys *Keyring, id string, uid uuid.UUID) string {
+	mac, _ := keys.sign(id, uid.String())
+	return hex.EncodeToString(append([]byte(uid.String()), mac...))
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(path, []byte("# rotated monthly\nk1: first secret\n\nk2:second:secret\n"), 0600))
+
+	keys, err := LoadKeyring("k3:third", path)
+	require.NoError(t, err)
+	assert.Equal(t, "k3", keys.newest)
+	assert.Equal(t, []byte("first secret"), keys.keys["k1"])
+	assert.Equal(t, []byte("second:secret"), keys.keys["k2"])
+
+	keys, err = LoadKeyring("", "")
+	require.NoError(t, err)
+	assert.Len(t, keys.keys, 1)
+
+	for _, s := range []string{"k1", "k1:", ":secret", "k 1:secret", "k1:a,k1:b"} {
+		_, err = LoadKeyring(s, "")
+		assert.ErrorIs(t, err, ErrInvalidKey, s)
+	}
+	_, err = LoadKeyring("", filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+	_, err = LoadKeyring("legacy:"+publicSecret, "")
+	assert.ErrorIs(t, err, ErrInvalidKey, "publicly known secret must not sign cookies")
+}
+
+func TestCheckLegacySecret(t *testing.T) {
+	keys, err := NewKeyring(Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	assert.NoError(t, CheckLegacySecret(keys, []byte(publicSecret)))
+	assert.NoError(t, CheckLegacySecret(keys, nil))
+	assert.ErrorIs(t, CheckLegacySecret(keys, []byte("0123456789abcdef0123456789abcdef")), ErrInvalidKey)
 }
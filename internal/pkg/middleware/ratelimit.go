@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/serjyuriev/shortener/internal/pkg/handlers"
+)
+
+// limiterSweepInterval is a period between removals of refilled buckets
+// from MemoryLimiterStore.
+const limiterSweepInterval = time.Minute
+
+// Limit is a token bucket holding up to Burst tokens and refilled
+// with Rate tokens per second, which must be positive. Every request takes one token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// refill returns number of tokens in bucket that had provided number of them
+// elapsed time ago.
+func (l Limit) refill(tokens float64, elapsed time.Duration) float64 {
+	return math.Min(float64(l.Burst), tokens+elapsed.Seconds()*l.Rate)
+}
+
+// LimiterStore keeps token buckets of rate limiters. Implementations
+// must be safe for concurrent use; shared stores allow several
+// application instances to enforce the same limits.
+type LimiterStore interface {
+	// Take takes a token from every bucket with provided keys if all of them
+	// have one, reporting whether they had and, if they hadn't, time until they will.
+	// No tokens are taken when request is rejected.
+	Take(ctx context.Context, keys []string, limit Limit) (bool, time.Duration, error)
+}
+
+type bucket struct {
+	updated time.Time
+	tokens  float64
+	limit   Limit
+}
+
+// MemoryLimiterStore keeps token buckets in memory.
+// Buckets refilled to full are removed from time to time.
+type MemoryLimiterStore struct {
+	now     func() time.Time
+	mu      sync.Mutex
+	buckets map[string]bucket
+	swept   time.Time
+}
+
+// NewMemoryLimiterStore creates empty in-memory store of token buckets.
+func NewMemoryLimiterStore() *MemoryLimiterStore {
+	return &MemoryLimiterStore{
+		now:     time.Now,
+		buckets: make(map[string]bucket),
+	}
+}
+
+// Take takes a token from every bucket with provided keys, creating full buckets if there are none.
+func (s *MemoryLimiterStore) Take(ctx context.Context, keys []string, limit Limit) (bool, time.Duration, error) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.swept) >= limiterSweepInterval {
+		for k, b := range s.buckets {
+			if b.limit.refill(b.tokens, now.Sub(b.updated)) >= float64(b.limit.Burst) {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+
+	buckets := make([]bucket, len(keys))
+	var wait time.Duration
+	for i, key := range keys {
+		b, ok := s.buckets[key]
+		if ok {
+			b.tokens = limit.refill(b.tokens, now.Sub(b.updated))
+		} else {
+			b.tokens = float64(limit.Burst)
+		}
+		b.updated = now
+		b.limit = limit
+		if b.tokens < 1 {
+			if w := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)); w > wait {
+				wait = w
+			}
+		}
+		buckets[i] = b
+	}
+	for i, key := range keys {
+		if wait == 0 {
+			buckets[i].tokens--
+		}
+		s.buckets[key] = buckets[i]
+	}
+	return wait == 0, wait, nil
+}
+
+// RateLimiterOptions configure rate limiter.
+type RateLimiterOptions struct {
+	// Name separates buckets of limiters sharing store.
+	Name string
+	// Limit applies to every user and every client IP separately.
+	// Burst less than one is treated as one.
+	Limit Limit
+	// Store keeps token buckets, new MemoryLimiterStore if nil.
+	Store LimiterStore
+	// TrustedProxies are networks of proxies whose X-Forwarded-For header
+	// is used to find out client IP.
+	TrustedProxies []*net.IPNet
+}
+
+// NewRateLimiter creates middleware limiting rate of requests by every client IP
+// and, if user was already identified, by every user. Requests exceeding
+// the limit are rejected with 429 status and Retry-After header.
+// If store fails, requests are let through.
+func NewRateLimiter(opts RateLimiterOptions) func(http.Handler) http.Handler {
+	if opts.Store == nil {
+		opts.Store = NewMemoryLimiterStore()
+	}
+	if opts.Limit.Burst < 1 {
+		opts.Limit.Burst = 1
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keys := []string{opts.Name + ":ip:" + handlers.ClientIP(r, opts.TrustedProxies)}
+			if uid, _ := r.Context().Value(contextKeyUID).(string); uid != "" {
+				keys = append(keys, opts.Name+":user:"+uid)
+			}
+			ok, wait, err := opts.Store.Take(r.Context(), keys, opts.Limit)
+			if err != nil {
+				log.Printf("unable to take rate limit token: %v\n", err)
+			} else if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/serjyuriev/shortener/internal/pkg/handlers"
+)
+
+func TestMemoryLimiterStore(t *testing.T) {
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := NewMemoryLimiterStore()
+	s.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 3}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		ok, _, err := s.Take(ctx, []string{"a"}, limit)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+	ok, wait, err := s.Take(ctx, []string{"a"}, limit)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	ok, _, err = s.Take(ctx, []string{"b"}, limit)
+	require.NoError(t, err)
+	assert.True(t, ok, "buckets are separate")
+
+	now = now.Add(250 * time.Millisecond)
+	ok, wait, err = s.Take(ctx, []string{"a"}, limit)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 250*time.Millisecond, wait)
+	now = now.Add(250 * time.Millisecond)
+	ok, _, err = s.Take(ctx, []string{"a"}, limit)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	now = now.Add(limiterSweepInterval)
+	ok, _, err = s.Take(ctx, []string{"c"}, limit)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, s.buckets, 1, "refilled buckets are removed")
+
+	ok, wait, err = s.Take(ctx, []string{"c", "d"}, Limit{Rate: 2, Burst: 1})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, wait, err = s.Take(ctx, []string{"e", "d"}, Limit{Rate: 2, Burst: 1})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	ok, _, err = s.Take(ctx, []string{"e"}, Limit{Rate: 2, Burst: 1})
+	require.NoError(t, err)
+	assert.True(t, ok, "token is not spent when other bucket is empty")
+}
+
+type failingLimiterStore struct{}
+
+func (failingLimiterStore) Take(ctx context.Context, keys []string, limit Limit) (bool, time.Duration, error) {
+	return false, 0, errors.New("store is down")
+}
+
+func Test_RateLimiter(t *testing.T) {
+	proxies, err := handlers.ParseNetworks("10.0.0.0/8")
+	require.NoError(t, err)
+	store := NewMemoryLimiterStore()
+	mid := NewRateLimiter(RateLimiterOptions{
+		Name:           "create",
+		Limit:          Limit{Rate: 0.5, Burst: 1},
+		Store:          store,
+		TrustedProxies: proxies,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(remoteAddr, forwardedFor, uid string) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten", nil)
+		request.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			request.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		if uid != "" {
+			request = request.WithContext(context.WithValue(request.Context(), contextKeyUID, uid))
+		}
+		recorder := httptest.NewRecorder()
+		mid.ServeHTTP(recorder, request)
+		return recorder.Result()
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		uid          string
+		wantStatus   int
+	}{
+		{name: "first request", remoteAddr: "192.0.2.1:1234", wantStatus: http.StatusOK},
+		{name: "same IP", remoteAddr: "192.0.2.1:4321", wantStatus: http.StatusTooManyRequests},
+		{name: "other IP", remoteAddr: "192.0.2.2:1234", uid: "u1", wantStatus: http.StatusOK},
+		{name: "same user from other IP", remoteAddr: "192.0.2.3:1234", uid: "u1", wantStatus: http.StatusTooManyRequests},
+		{name: "other user from IP of limited user", remoteAddr: "192.0.2.3:1234", uid: "u2", wantStatus: http.StatusOK},
+		{name: "client behind trusted proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: "192.0.2.4", wantStatus: http.StatusOK},
+		{name: "other client behind trusted proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: "192.0.2.5, 10.0.0.2", wantStatus: http.StatusOK},
+		{name: "same client behind trusted proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: "192.0.2.4", wantStatus: http.StatusTooManyRequests},
+		{name: "spoofed header", remoteAddr: "192.0.2.1:1234", forwardedFor: "192.0.2.9", wantStatus: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		res := do(tt.remoteAddr, tt.forwardedFor, tt.uid)
+		res.Body.Close()
+		require.Equal(t, tt.wantStatus, res.StatusCode, tt.name)
+		if tt.wantStatus == http.StatusTooManyRequests {
+			assert.Equal(t, "2", res.Header.Get("Retry-After"), tt.name)
+		}
+	}
+
+	failing := NewRateLimiter(RateLimiterOptions{
+		Limit: Limit{Rate: 1},
+		Store: failingLimiterStore{},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	recorder := httptest.NewRecorder()
+	failing.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost:8080/abcdef", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
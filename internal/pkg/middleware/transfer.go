@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultTransferLifetime is a lifetime of transfer token used when none is configured.
+const DefaultTransferLifetime = 15 * time.Minute
+
+const (
+	// transferPrefix starts every transfer token and is signed along with it,
+	// so user ID cookies can't be passed off as transfer tokens.
+	transferPrefix = "xfer"
+	// expiresLength is a length of token expiration time in Unix seconds.
+	expiresLength = 8
+	// nonceLength is a length of random part making every token unique.
+	nonceLength = 16
+)
+
+var errInvalidTransferToken = errors.New("invalid transfer token")
+
+// Transfers issues and redeems signed tokens transferring links of one user to another.
+// Token consists of prefix, key ID and hex-encoded user ID, expiration time,
+// nonce and signature of all of them, separated by dot. Every token
+// may be redeemed once; redeemed tokens are remembered in memory until they expire.
+// Memory isn't shared, so token may be redeemed again after restart
+// or by another instance of application until it expires.
+type Transfers struct {
+	keys     *Keyring
+	now      func() time.Time
+	lifetime time.Duration
+	mu       sync.Mutex
+	redeemed map[string]time.Time
+}
+
+// NewTransfers creates transfer tokens issuer signing them with keys of provided keyring.
+// Tokens expire after provided lifetime, DefaultTransferLifetime if it isn't positive.
+func NewTransfers(keys *Keyring, lifetime time.Duration) *Transfers {
+	if lifetime <= 0 {
+		lifetime = DefaultTransferLifetime
+	}
+	return &Transfers{
+		keys:     keys,
+		now:      time.Now,
+		lifetime: lifetime,
+		redeemed: make(map[string]time.Time),
+	}
+}
+
+// SignTransfer returns token transferring links of user with provided ID and its expiration time.
+func (t *Transfers) SignTransfer(uid string) (string, time.Time, error) {
+	id, err := uuid.Parse(uid)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	expires := t.now().Add(t.lifetime)
+	payload := make([]byte, uidLength+expiresLength+nonceLength, uidLength+expiresLength+nonceLength+sha256.Size)
+	copy(payload, id.String())
+	binary.BigEndian.PutUint64(payload[uidLength:], uint64(expires.Unix()))
+	if _, err = rand.Read(payload[uidLength+expiresLength:]); err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to generate nonce:\n%w", err)
+	}
+	key := t.keys.newest
+	mac, _ := t.keys.sign(key, transferPrefix+"."+key+"."+string(payload))
+	return transferPrefix + "." + key + "." + hex.EncodeToString(append(payload, mac...)), expires.UTC(), nil
+}
+
+// RedeemTransfer verifies token and returns ID of user whose links it transfers.
+// Token can't be redeemed again, unless it is released with ReleaseTransfer.
+func (t *Transfers) RedeemTransfer(token string) (string, error) {
+	uid, nonce, expires, err := t.parseTransfer(token)
+	if err != nil {
+		return "", err
+	}
+
+	now := t.now()
+	if !expires.After(now) {
+		return "", fmt.Errorf("%w: token is expired", errInvalidTransferToken)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for nonce, exp := range t.redeemed {
+		if !exp.After(now) {
+			delete(t.redeemed, nonce)
+		}
+	}
+	if _, ok := t.redeemed[nonce]; ok {
+		return "", fmt.Errorf("%w: token is already redeemed", errInvalidTransferToken)
+	}
+	t.redeemed[nonce] = expires
+	return uid.String(), nil
+}
+
+// ReleaseTransfer allows redeemed token to be redeemed again,
+// e.g. when links failed to be transferred.
+func (t *Transfers) ReleaseTransfer(token string) {
+	_, nonce, _, err := t.parseTransfer(token)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.redeemed, nonce)
+}
+
+// parseTransfer verifies signature of token and returns its user ID, nonce and expiration time.
+func (t *Transfers) parseTransfer(token string) (uuid.UUID, string, time.Time, error) {
+	if len(token) > maxCookieLength {
+		return uuid.Nil, "", time.Time{}, fmt.Errorf("%w: token is too long", errInvalidTransferToken)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != transferPrefix {
+		return uuid.Nil, "", time.Time{}, fmt.Errorf("%w: unexpected format", errInvalidTransferToken)
+	}
+	decoded, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return uuid.Nil, "", time.Time{}, fmt.Errorf("%w: %v", errInvalidTransferToken, err)
+	}
+	if len(decoded) != uidLength+expiresLength+nonceLength+sha256.Size {
+		return uuid.Nil, "", time.Time{}, fmt.Errorf("%w: unexpected length %d", errInvalidTransferToken, len(decoded))
+	}
+	payload, mac := decoded[:uidLength+expiresLength+nonceLength], decoded[uidLength+expiresLength+nonceLength:]
+	expected, ok := t.keys.sign(parts[1], transferPrefix+"."+parts[1]+"."+string(payload))
+	if !ok || !hmac.Equal(mac, expected) {
+		return uuid.Nil, "", time.Time{}, errInvalidTransferToken
+	}
+	uid, err := uuid.Parse(string(payload[:uidLength]))
+	if err != nil {
+		return uuid.Nil, "", time.Time{}, fmt.Errorf("%w: %v", errInvalidTransferToken, err)
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(payload[uidLength:])), 0)
+	return uid, string(payload[uidLength+expiresLength:]), expires, nil
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransfers(t *testing.T) {
+	old, err := NewKeyring(Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	keys, err := NewKeyring(
+		Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")},
+		Key{ID: "k2", Secret: []byte("fedcba9876543210fedcba9876543210")},
+	)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	uid := uuid.New().String()
+
+	sign := func(t *testing.T, kr *Keyring, at time.Time) string {
+		tr := NewTransfers(kr, 0)
+		tr.now = func() time.Time { return at }
+		token, expires, err := tr.SignTransfer(uid)
+		require.NoError(t, err)
+		assert.Equal(t, at.Add(DefaultTransferLifetime).UTC(), expires)
+		return token
+	}
+	signed := sign(t, keys, now)
+	public := &Keyring{keys: map[string][]byte{"legacy": []byte(publicSecret)}, newest: "legacy"}
+	cookie := newCookieCodec(keys, CookieOptions{}).encode(uuid.MustParse(uid), now)
+
+	tests := []struct {
+		name    string
+		token   string
+		at      time.Time
+		wantErr bool
+	}{
+		{name: "valid token", token: signed, at: now},
+		{name: "signed with previous key", token: sign(t, old, now), at: now},
+		{name: "expired token", token: signed, at: now.Add(DefaultTransferLifetime), wantErr: true},
+		{name: "empty token", wantErr: true},
+		{name: "cookie", token: cookie, at: now, wantErr: true},
+		{name: "cookie with prefix", token: transferPrefix + "." + cookie, at: now, wantErr: true},
+		{name: "signed with legacy secret", token: sign(t, public, now), at: now, wantErr: true},
+		{name: "unknown key", token: strings.Replace(signed, ".k2.", ".k3.", 1), at: now, wantErr: true},
+		{name: "tampered token", token: signed[:len(signed)-1] + "0", at: now, wantErr: true},
+		{name: "too long token", token: signed + strings.Repeat("0", maxCookieLength), at: now, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTransfers(keys, 0)
+			tr.now = func() time.Time { return tt.at }
+			got, err := tr.RedeemTransfer(tt.token)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errInvalidTransferToken)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, uid, got)
+		})
+	}
+
+	t.Run("redeemed once", func(t *testing.T) {
+		tr := NewTransfers(keys, 0)
+		tr.now = func() time.Time { return now }
+		_, err := tr.RedeemTransfer(signed)
+		require.NoError(t, err)
+		_, err = tr.RedeemTransfer(signed)
+		assert.ErrorIs(t, err, errInvalidTransferToken)
+		_, err = tr.RedeemTransfer(sign(t, keys, now))
+		assert.NoError(t, err)
+
+		later := now.Add(DefaultTransferLifetime)
+		tr.now = func() time.Time { return later }
+		_, err = tr.RedeemTransfer(sign(t, keys, later))
+		require.NoError(t, err)
+		assert.Len(t, tr.redeemed, 1, "expired tokens must be forgotten")
+	})
+
+	t.Run("released", func(t *testing.T) {
+		tr := NewTransfers(keys, 0)
+		tr.now = func() time.Time { return now }
+		_, err := tr.RedeemTransfer(signed)
+		require.NoError(t, err)
+		tr.ReleaseTransfer("unknown")
+		_, err = tr.RedeemTransfer(signed)
+		assert.ErrorIs(t, err, errInvalidTransferToken)
+
+		tr.ReleaseTransfer(signed)
+		got, err := tr.RedeemTransfer(signed)
+		require.NoError(t, err)
+		assert.Equal(t, uid, got)
+	})
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"compress/gzip"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	chimid "github.com/go-chi/chi/middleware"
+
+	"github.com/serjyuriev/shortener/internal/pkg/handlers"
+	"github.com/serjyuriev/shortener/internal/pkg/middleware"
+)
+
+var zippableTypes = []string{
+	"application/javascript",
+	"application/json",
+	"text/css",
+	"text/html",
+	"text/plain",
+	"text/xml",
+}
+
+// Middlewares are application middlewares bound to routes by NewRouter.
+type Middlewares struct {
+	// Auth identifies users, it is required.
+	Auth func(http.Handler) http.Handler
+	// Admin guards admin API under /api/admin, which isn't served if it is nil.
+	Admin func(http.Handler) http.Handler
+	// CreateLimit and RedirectLimit, if set, limit rate of requests
+	// creating links and following them.
+	CreateLimit   func(http.Handler) http.Handler
+	RedirectLimit func(http.Handler) http.Handler
+}
+
+// NewRouter creates new router with application middlewares
+// and binds provided handlers to it.
+func NewRouter(h *handlers.Handlers, m Middlewares) chi.Router {
+	createLimit, redirectLimit := orPass(m.CreateLimit), orPass(m.RedirectLimit)
+	r := chi.NewRouter()
+	r.Use(chimid.Recoverer)
+	r.Use(chimid.Compress(gzip.BestSpeed, zippableTypes...))
+	r.Use(middleware.Gzipper)
+	if m.Admin != nil {
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(m.Admin)
+			r.Get("/urls", h.GetAdminURLsHandler)
+			r.Get("/users", h.GetAdminUsersHandler)
+			r.Patch("/urls/{shortPath}", h.PatchAdminURLHandler)
+		})
+	}
+	r.Group(func(r chi.Router) {
+		r.Use(m.Auth)
+		r.Delete("/api/user/templates/{templateID}", h.DeleteTemplateHandler)
+		r.Delete("/api/user/tokens/{tokenID}", h.DeleteTokenHandler)
+		r.Delete("/api/user/urls", h.DeleteURLsHandler)
+		r.Delete("/api/workspaces/{workspaceID}/members/{userID}", h.DeleteMemberHandler)
+		r.Delete("/api/workspaces/{workspaceID}/urls", h.DeleteWorkspaceURLsHandler)
+		r.Get("/ping", h.PingHandler)
+		r.With(redirectLimit).Get("/{shortPath}", h.GetURLHandler)
+		r.With(redirectLimit).Get("/{shortPath}/*", h.GetURLHandler)
+		r.Get("/api/user/quota", h.GetQuotaHandler)
+		r.Get("/api/user/tags", h.GetUserTagsHandler)
+		r.Get("/api/user/templates", h.GetTemplatesHandler)
+		r.Get("/api/user/tokens", h.GetTokensHandler)
+		r.Get("/api/user/urls", h.GetUserURLsAPIHandler)
+		r.Get("/api/user/urls/{shortPath}/history", h.GetURLHistoryHandler)
+		r.Get("/api/user/workspaces", h.GetWorkspacesHandler)
+		r.Get("/api/workspaces/{workspaceID}/members", h.GetMembersHandler)
+		r.Get("/api/workspaces/{workspaceID}/urls", h.GetWorkspaceURLsHandler)
+		r.Patch("/api/user/urls/{shortPath}", h.PatchURLHandler)
+		r.Patch("/api/workspaces/{workspaceID}/urls/{shortPath}", h.PatchWorkspaceURLHandler)
+		r.Put("/api/user/templates/{templateID}", h.PutTemplateHandler)
+		r.Put("/api/user/urls/{shortPath}/tags", h.PutTagsHandler)
+		r.Put("/api/workspaces/{workspaceID}/members/{userID}", h.PutMemberHandler)
+		r.With(createLimit).Post("/", h.PostURLHandler)
+		r.With(redirectLimit).Post("/{shortPath}", h.PostPasswordHandler)
+		r.With(redirectLimit).Post("/{shortPath}/*", h.PostPasswordHandler)
+		r.With(createLimit).Post("/api/shorten", h.PostURLApiHandler)
+		r.With(createLimit).Post("/api/shorten/batch", h.PostBatchHandler)
+		r.Post("/api/user/login", h.LoginHandler)
+		r.Post("/api/user/logout", h.LogoutHandler)
+		r.Post("/api/user/register", h.RegisterHandler)
+		r.Post("/api/user/templates", h.PostTemplateHandler)
+		r.Post("/api/user/tokens", h.PostTokenHandler)
+		r.Post("/api/user/transfers", h.PostTransferHandler)
+		r.Post("/api/user/transfers/redeem", h.RedeemTransferHandler)
+		r.Post("/api/user/urls/restore", h.RestoreURLsHandler)
+		r.Post("/api/workspaces", h.PostWorkspaceHandler)
+		r.With(createLimit).Post("/api/workspaces/{workspaceID}/shorten", h.PostWorkspaceURLHandler)
+	})
+	return r
+}
+
+// orPass returns provided middleware or, if it is nil, one doing nothing.
+func orPass(m func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	if m != nil {
+		return m
+	}
+	return func(next http.Handler) http.Handler {
+		return next
+	}
+}
@@ -2,13 +2,13 @@ package server
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
@@ -17,15 +17,17 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/go-chi/chi"
-	chimid "github.com/go-chi/chi/middleware"
+	"github.com/google/uuid"
 
 	"github.com/serjyuriev/shortener/internal/pkg/config"
 	"github.com/serjyuriev/shortener/internal/pkg/handlers"
 	"github.com/serjyuriev/shortener/internal/pkg/middleware"
+	"github.com/serjyuriev/shortener/internal/pkg/router"
+	"github.com/serjyuriev/shortener/internal/pkg/service"
 )
 
 // Server provides method for application server management.
@@ -35,17 +37,61 @@ type Server interface {
 
 type server struct {
 	cfg      *config.Config
+	svc      service.Service
 	handlers *handlers.Handlers
+	mw       router.Middlewares
 }
 
 // NewServer initializes server.
 func NewServer() (Server, error) {
-	h, err := handlers.MakeHandlers()
+	svc, err := service.NewService()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create new service:\n%w", err)
+	}
+	h, err := handlers.MakeHandlers(svc)
 	if err != nil {
 		return nil, fmt.Errorf("unable to make handlers:\n%w", err)
 	}
 
 	cfg := config.GetConfig()
+	keys, err := middleware.LoadKeyring(cfg.AuthKeys, cfg.AuthKeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load auth keys:\n%w", err)
+	}
+	if err = middleware.CheckLegacySecret(keys, []byte(cfg.LegacyCookieSecret)); err != nil {
+		return nil, err
+	}
+	jwt, err := newJWT(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure JWT:\n%w", err)
+	}
+
+	legacyUntil, err := parseCutoff(cfg.LegacyCookiesUntil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse legacy cookies cutoff:\n%w", err)
+	}
+
+	h.SetTransfers(middleware.NewTransfers(keys, cfg.TransferLifetime))
+
+	admins, err := middleware.ParseUserIDs(cfg.AdminUserIDs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse admin user ids:\n%w", err)
+	}
+	auth := middleware.NewAuth(keys, middleware.CookieOptions{
+		Lifetime:         cfg.CookieLifetime,
+		Secure:           cfg.EnableHTTPS || strings.HasPrefix(cfg.BaseURL, "https://"),
+		JWT:              jwt,
+		LegacyUntil:      legacyUntil,
+		LegacySecret:     []byte(cfg.LegacyCookieSecret),
+		LegacyRestricted: middleware.RestrictLegacy(admins, svc),
+	}, svc)
+	admin := newAdmin(cfg, admins, auth)
+	proxies, err := handlers.ParseNetworks(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse trusted proxies:\n%w", err)
+	}
+	h.SetTrustedProxies(proxies)
+	limits := middleware.NewMemoryLimiterStore()
 
 	if cfg.EnableHTTPS {
 		if err = createCerfs(); err != nil {
@@ -55,40 +101,100 @@ func NewServer() (Server, error) {
 
 	return &server{
 		cfg:      cfg,
+		svc:      svc,
 		handlers: h,
+		mw: router.Middlewares{
+			Auth:          auth,
+			Admin:         admin,
+			CreateLimit:   newRateLimiter("create", cfg.CreateRateLimit, cfg.CreateRateBurst, limits, proxies),
+			RedirectLimit: newRateLimiter("redirect", cfg.RedirectRateLimit, cfg.RedirectRateBurst, limits, proxies),
+		},
 	}, nil
 }
 
+// newAdmin creates middleware guarding admin API according to configuration.
+// It returns nil if admin API is not enabled.
+func newAdmin(cfg *config.Config, ids []uuid.UUID, auth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	if cfg.AdminToken == "" && len(ids) == 0 {
+		return nil
+	}
+	return middleware.NewAdmin(middleware.AdminOptions{Token: cfg.AdminToken, UserIDs: ids}, auth)
+}
+
+// newRateLimiter creates middleware limiting rate of requests.
+// It returns nil if rate is not positive.
+func newRateLimiter(name string, rate float64, burst int, store middleware.LimiterStore, proxies []*net.IPNet) func(http.Handler) http.Handler {
+	if rate <= 0 {
+		return nil
+	}
+	return middleware.NewRateLimiter(middleware.RateLimiterOptions{
+		Name:           name,
+		Limit:          middleware.Limit{Rate: rate, Burst: burst},
+		Store:          store,
+		TrustedProxies: proxies,
+	})
+}
+
+// parseCutoff parses RFC 3339 time or date, the latter meaning its midnight in UTC.
+// Empty string produces zero time.
+func parseCutoff(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// newJWT creates signer of JSON Web Tokens according to configuration.
+// It returns nil if JWT are not enabled.
+func newJWT(cfg *config.Config) (*middleware.JWT, error) {
+	if cfg.JWTAlgorithm == "" {
+		return nil, nil
+	}
+	opts := middleware.JWTOptions{
+		Algorithm: cfg.JWTAlgorithm,
+		Issuer:    cfg.JWTIssuer,
+		Leeway:    cfg.JWTLeeway,
+	}
+	if cfg.JWTKeysFile == "" {
+		return nil, fmt.Errorf("%w: %s requires JWT keys file", middleware.ErrInvalidJWTOptions, cfg.JWTAlgorithm)
+	}
+	var err error
+	if cfg.JWTAlgorithm == middleware.AlgHS256 {
+		opts.HMACKeys, err = middleware.LoadHMACKeys(cfg.JWTKeysFile)
+	} else {
+		opts.EdDSAKeys, err = middleware.LoadEdDSAKeys(cfg.JWTKeysFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return middleware.NewJWT(opts)
+}
+
 // Start creates new router, binds handlers and starts http server.
 func (s *server) Start() error {
-	r := chi.NewRouter()
-	r.Use(chimid.Recoverer)
-	r.Use(chimid.Compress(gzip.BestSpeed, zippableTypes...))
-	r.Use(middleware.Gzipper)
-	r.Use(middleware.Auth)
-	r.Delete("/api/user/urls", s.handlers.DeleteURLsHandler)
-	r.Get("/ping", s.handlers.PingHandler)
-	r.Get("/{shortPath}", s.handlers.GetURLHandler)
-	r.Get("/api/user/urls", s.handlers.GetUserURLsAPIHandler)
-	r.Post("/", s.handlers.PostURLHandler)
-	r.Post("/api/shorten", s.handlers.PostURLApiHandler)
-	r.Post("/api/shorten/batch", s.handlers.PostBatchHandler)
-
 	server := &http.Server{
 		Addr:    s.cfg.ServerAddress,
-		Handler: r,
+		Handler: router.NewRouter(s.handlers, s.mw),
 	}
 
 	sigChan := make(chan os.Signal, 3)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		sig := <-sigChan
 		log.Printf("\r\nПолучен сигнал: %s", sig.String())
 
 		if err := server.Shutdown(context.Background()); err != nil {
 			log.Printf("HTTP server Shutdown: %v", err)
 		}
+		if err := s.svc.Close(); err != nil {
+			log.Printf("unable to close service: %v", err)
+		}
 	}()
 
 	go func() {
@@ -96,20 +202,16 @@ func (s *server) Start() error {
 	}()
 
 	log.Printf("starting server on %s\n", s.cfg.ServerAddress)
+	var err error
 	if s.cfg.EnableHTTPS {
-		return server.ListenAndServeTLS("cert.pem", "key.pem")
+		err = server.ListenAndServeTLS("cert.pem", "key.pem")
 	} else {
-		return server.ListenAndServe()
+		err = server.ListenAndServe()
 	}
-}
-
-var zippableTypes = []string{
-	"application/javascript",
-	"application/json",
-	"text/css",
-	"text/html",
-	"text/plain",
-	"text/xml",
+	if errors.Is(err, http.ErrServerClosed) {
+		<-stopped
+	}
+	return err
 }
 
 func createCerfs() error {
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+// MinAccountPasswordLength is a minimum length of account password in bytes.
+const MinAccountPasswordLength = 8
+
+var (
+	ErrInvalidCredentials = errors.New("wrong login or password")
+	ErrWeakPassword       = errors.New("password must contain 8 to 72 bytes")
+)
+
+var (
+	// dummyHash is compared with passwords of unknown logins,
+	// so response time doesn't reveal whether account exists.
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// Register creates account with provided credentials owning links
+// of user with provided ID. If the user is already registered,
+// new account with new ID and no links is created.
+func (s *service) Register(ctx context.Context, userID, login, password string) (storage.Account, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return storage.Account{}, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if login, err = storage.NormalizeLogin(login); err != nil {
+		return storage.Account{}, err
+	}
+	if len(password) < MinAccountPasswordLength || len(password) > 72 {
+		return storage.Account{}, ErrWeakPassword
+	}
+
+	if _, err = s.store.FindAccount(ctx, uid); err == nil {
+		uid = uuid.New()
+	} else if !errors.Is(err, storage.ErrNoAccountWasFound) {
+		return storage.Account{}, fmt.Errorf("unable to find account:\n%w", err)
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return storage.Account{}, fmt.Errorf("unable to hash password:\n%w", err)
+	}
+	a := storage.Account{
+		CreatedAt:    storage.Now(),
+		Login:        login,
+		PasswordHash: hash,
+		ID:           uid,
+	}
+	if err = s.store.InsertAccount(ctx, a); err != nil {
+		return storage.Account{}, fmt.Errorf("unable to insert account:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.InsertAccount(ctx, a)
+	})
+	return a, nil
+}
+
+// Login checks credentials of account. Links of user with provided ID
+// are moved to the account, unless the user is another account.
+func (s *service) Login(ctx context.Context, userID, login, password string) (storage.Account, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return storage.Account{}, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if login, err = storage.NormalizeLogin(login); err != nil {
+		return storage.Account{}, ErrInvalidCredentials
+	}
+	key := "login:" + login
+	if !s.attempts.attempt(key) {
+		return storage.Account{}, ErrTooManyAttempts
+	}
+
+	a, err := s.store.FindAccountByLogin(ctx, login)
+	if err != nil && !errors.Is(err, storage.ErrNoAccountWasFound) {
+		return storage.Account{}, fmt.Errorf("unable to find account:\n%w", err)
+	}
+	if err != nil {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+		})
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return storage.Account{}, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(a.PasswordHash), []byte(password)) != nil {
+		return storage.Account{}, ErrInvalidCredentials
+	}
+	s.attempts.reset(key)
+
+	if uid == a.ID {
+		return a, nil
+	}
+	if _, err = s.store.FindAccount(ctx, uid); err == nil {
+		return a, nil
+	} else if !errors.Is(err, storage.ErrNoAccountWasFound) {
+		return storage.Account{}, fmt.Errorf("unable to find account:\n%w", err)
+	}
+	if _, err = s.reassignURLs(ctx, uid, a.ID); err != nil {
+		return storage.Account{}, err
+	}
+	return a, nil
+}
+
+// HasAccount reports whether user with provided ID is a registered account.
+func (s *service) HasAccount(ctx context.Context, userID string) (bool, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return false, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if _, err = s.store.FindAccount(ctx, uid); err != nil {
+		if errors.Is(err, storage.ErrNoAccountWasFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to find account:\n%w", err)
+	}
+	return true, nil
+}
+
+// TransferURLs moves all links of one user to another, returning number of moved links.
+func (s *service) TransferURLs(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	from, err := uuid.Parse(fromUserID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	to, err := uuid.Parse(toUserID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if from == to {
+		return 0, nil
+	}
+	return s.reassignURLs(ctx, from, to)
+}
+
+// reassignURLs moves links of user "from" to user "to" in storage and its mirror.
+// Links of workspaces stay with their creators. Active links being moved
+// count against quota of user "to".
+func (s *service) reassignURLs(ctx context.Context, from, to uuid.UUID) (int, error) {
+	unlock := s.lockQuota(to)
+	defer unlock()
+	if s.quota(to).MaxLinks > 0 {
+		active := 0
+		err := s.store.IterateUserLinks(ctx, from, storage.ListOptions{}, func(l storage.Link) error {
+			if l.WorkspaceID == uuid.Nil {
+				active++
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("unable to count active links:\n%w", err)
+		}
+		if err = s.checkQuota(ctx, to, active); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.store.ReassignURLs(ctx, from, to)
+	if err != nil {
+		return 0, fmt.Errorf("unable to reassign urls:\n%w", err)
+	}
+	if n > 0 {
+		log.Printf("%d urls of user %s were moved to user %s\n", n, from, to)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		_, err := m.ReassignURLs(ctx, from, to)
+		return err
+	})
+	return n, nil
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+// CountURLsByUser returns number of links added by every user,
+// users having more links go first.
+func (s *service) CountURLsByUser(ctx context.Context) ([]storage.UserLinkCount, error) {
+	counts, err := s.store.CountLinksByUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to count urls:\n%w", err)
+	}
+	return counts, nil
+}
+
+// IterateAllURLs calls fn for links of all users matching provided options.
+func (s *service) IterateAllURLs(ctx context.Context, opts storage.ListOptions, fn func(storage.Link) error) error {
+	if err := s.store.IterateAllLinks(ctx, opts, fn); err != nil {
+		return fmt.Errorf("unable to iterate urls:\n%w", err)
+	}
+	return nil
+}
+
+// SetDisabled disables or enables link regardless of its owner,
+// returning the changed link. Disabled links can't be followed.
+func (s *service) SetDisabled(ctx context.Context, shortPath string, disabled bool) (storage.Link, error) {
+	if err := s.store.SetDisabled(ctx, shortPath, disabled); err != nil {
+		return storage.Link{}, fmt.Errorf("unable to set disabled mark:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.SetDisabled(ctx, shortPath, disabled)
+	})
+	l, err := s.store.FindLink(ctx, shortPath)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("unable to find link:\n%w", err)
+	}
+	return l, nil
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+var (
+	ErrSubPathNotAllowed = errors.New("url doesn't forward sub-paths")
+	ErrInvalidSubPath    = errors.New("invalid sub-path")
+)
+
+// Visit describes request to short URL forwarded to original URL
+// according to passthrough mode of the link.
+// SubPath is an escaped path following short URL, without leading slash,
+// RawQuery is an encoded query string without '?'.
+type Visit struct {
+	SubPath  string
+	RawQuery string
+}
+
+// destination returns URL the visit of link is redirected to.
+// Query parameters of original URL take precedence: parameters of request
+// with the same name are dropped, others are appended in sorted order.
+// Sub-path is appended to the path of original URL, dot segments
+// are resolved so that it can't escape original path.
+func destination(l storage.Link, v Visit) (string, error) {
+	if v.SubPath != "" && l.Passthrough != storage.PassthroughPath {
+		return "", ErrSubPathNotAllowed
+	}
+	if l.Passthrough == storage.PassthroughNone || (v.SubPath == "" && v.RawQuery == "") {
+		return l.OriginalURL, nil
+	}
+
+	u, err := url.Parse(l.OriginalURL)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse original url:\n%w", err)
+	}
+	if v.SubPath != "" {
+		sub, err := cleanSubPath(v.SubPath)
+		if err != nil {
+			return "", err
+		}
+		if sub != "" {
+			escaped := strings.TrimSuffix(u.EscapedPath(), "/") + "/" + sub
+			if u.Path, err = url.PathUnescape(escaped); err != nil {
+				return "", ErrInvalidSubPath
+			}
+			u.RawPath = escaped
+		}
+	}
+	if v.RawQuery != "" {
+		// malformed pairs of request's query are skipped
+		incoming, _ := url.ParseQuery(v.RawQuery)
+		mergeQuery(u, incoming)
+	}
+	return u.String(), nil
+}
+
+// mergeQuery appends parameters absent in query of URL to it,
+// sorted by name. Query of URL is kept as is.
+func mergeQuery(u *url.URL, params url.Values) {
+	own := u.Query()
+	extra := make(url.Values)
+	for k, vals := range params {
+		if _, ok := own[k]; !ok {
+			extra[k] = vals
+		}
+	}
+	if q := extra.Encode(); q != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += q
+	}
+}
+
+// cleanSubPath resolves dot segments of escaped sub-path and removes empty ones.
+// Trailing slash is kept.
+func cleanSubPath(sub string) (string, error) {
+	segments := strings.Split(sub, "/")
+	cleaned := make([]string, 0, len(segments))
+	for _, s := range segments {
+		unescaped, err := url.PathUnescape(s)
+		if err != nil {
+			return "", ErrInvalidSubPath
+		}
+		switch unescaped {
+		case "", ".":
+		case "..":
+			if len(cleaned) > 0 {
+				cleaned = cleaned[:len(cleaned)-1]
+			}
+		default:
+			cleaned = append(cleaned, s)
+		}
+	}
+	res := strings.Join(cleaned, "/")
+	if res != "" && strings.HasSuffix(sub, "/") {
+		res += "/"
+	}
+	return res, nil
+}
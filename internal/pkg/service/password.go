@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidPassword  = errors.New("password must not be longer than 72 bytes")
+	ErrWrongPassword    = errors.New("wrong password")
+	ErrTooManyAttempts  = errors.New("too many password attempts")
+	ErrPasswordRequired = errors.New("url is protected with password")
+)
+
+const (
+	// MaxPasswordAttempts is a number of wrong passwords
+	// allowed for a single URL during PasswordAttemptWindow.
+	MaxPasswordAttempts = 5
+	// PasswordAttemptWindow is a period after which
+	// wrong password attempts are forgotten.
+	PasswordAttemptWindow = 15 * time.Minute
+)
+
+// HashPassword returns salted hash of password suitable for storing.
+// Empty password produces empty hash, meaning no protection.
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	if len(password) > 72 {
+		return "", ErrInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+type attempts struct {
+	start time.Time
+	count int
+}
+
+// attemptLimiter counts password attempts per URL.
+type attemptLimiter struct {
+	failures map[string]attempts
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+func newAttemptLimiter() *attemptLimiter {
+	return &attemptLimiter{
+		failures: make(map[string]attempts),
+		now:      time.Now,
+	}
+}
+
+// attempt records an attempt for key and reports whether it may be made.
+// Checking and counting happen under the same lock, so parallel attempts
+// can't exceed the limit. Successful attempt should be followed by reset.
+func (l *attemptLimiter) attempt(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	a, ok := l.failures[key]
+	if !ok || now.Sub(a.start) >= PasswordAttemptWindow {
+		a = attempts{start: now}
+	}
+	if a.count >= MaxPasswordAttempts {
+		return false
+	}
+	a.count++
+	l.failures[key] = a
+
+	// forget expired attempts, so the map doesn't grow infinitely
+	if len(l.failures) > 10000 {
+		for k, v := range l.failures {
+			if now.Sub(v.start) >= PasswordAttemptWindow {
+				delete(l.failures, k)
+			}
+		}
+	}
+	return true
+}
+
+// reset forgets wrong attempts for key.
+func (l *attemptLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
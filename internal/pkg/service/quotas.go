@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ErrQuotaExceeded is returned when user is about to create
+// more links than quota of the user allows.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota caps links user may create. Zero fields mean no limit.
+type Quota struct {
+	// MaxLinks is a maximum number of not deleted links of user.
+	MaxLinks int
+	// MaxBatchSize is a maximum number of links created at once.
+	MaxBatchSize int
+}
+
+// Quotas are quota applied to every user along with
+// quotas of some users replacing it.
+type Quotas struct {
+	Default   Quota
+	Overrides map[uuid.UUID]Quota
+}
+
+// QuotaUsage is a quota of user along with number of links counted against it.
+type QuotaUsage struct {
+	Quota
+	ActiveLinks int
+}
+
+// ParseQuotaOverrides parses quotas of users separated by commas.
+// Every quota is written as "user-id:max-links:max-batch-size",
+// zero or empty number means no limit.
+func ParseQuotaOverrides(s string) (map[uuid.UUID]Quota, error) {
+	overrides := make(map[uuid.UUID]Quota)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("unable to parse quota %q: expected user-id:max-links:max-batch-size", entry)
+		}
+		uid, err := uuid.Parse(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse user id:\n%w", err)
+		}
+		var limits [2]int
+		for i, part := range parts[1:] {
+			if part == "" {
+				continue
+			}
+			if limits[i], err = strconv.Atoi(part); err != nil || limits[i] < 0 {
+				return nil, fmt.Errorf("unable to parse quota %q: invalid number %q", entry, part)
+			}
+		}
+		overrides[uid] = Quota{MaxLinks: limits[0], MaxBatchSize: limits[1]}
+	}
+	return overrides, nil
+}
+
+// FindQuota returns quota of user along with number of its not deleted links.
+func (s *service) FindQuota(ctx context.Context, userID string) (QuotaUsage, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return QuotaUsage{}, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	active, err := s.store.CountActiveLinks(ctx, uid)
+	if err != nil {
+		return QuotaUsage{}, fmt.Errorf("unable to count active links:\n%w", err)
+	}
+	return QuotaUsage{Quota: s.quota(uid), ActiveLinks: active}, nil
+}
+
+// SetQuotas replaces quotas of users. It must be called before service is used.
+func (s *service) SetQuotas(q Quotas) {
+	s.quotas = q
+}
+
+// checkBatch makes sure user may create provided number of links at once.
+func (s *service) checkBatch(uid uuid.UUID, n int) error {
+	q := s.quota(uid)
+	if q.MaxBatchSize > 0 && n > q.MaxBatchSize {
+		return fmt.Errorf("%w: at most %d links may be created at once", ErrQuotaExceeded, q.MaxBatchSize)
+	}
+	return nil
+}
+
+// checkQuota makes sure user may have provided number of active links more.
+// It must be called with lock of user held, see lockQuota.
+func (s *service) checkQuota(ctx context.Context, uid uuid.UUID, n int) error {
+	q := s.quota(uid)
+	if q.MaxLinks == 0 || n == 0 {
+		return nil
+	}
+	active, err := s.store.CountActiveLinks(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("unable to count active links:\n%w", err)
+	}
+	if active+n > q.MaxLinks {
+		return fmt.Errorf("%w: %d of %d links are already used", ErrQuotaExceeded, active, q.MaxLinks)
+	}
+	return nil
+}
+
+// lockQuota serializes requests adding active links to user, so all of them
+// can't pass quota check at once. It returns function releasing lock.
+func (s *service) lockQuota(uid uuid.UUID) func() {
+	return s.quotaLocks.lock(uid)
+}
+
+// userLocks are mutexes of users, kept while somebody holds or waits for them.
+type userLocks struct {
+	mu    sync.Mutex
+	locks map[uuid.UUID]*userLock
+}
+
+type userLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newUserLocks() *userLocks {
+	return &userLocks{locks: make(map[uuid.UUID]*userLock)}
+}
+
+// lock acquires mutex of user, returning function releasing it.
+func (l *userLocks) lock(uid uuid.UUID) func() {
+	l.mu.Lock()
+	ul, ok := l.locks[uid]
+	if !ok {
+		ul = &userLock{}
+		l.locks[uid] = ul
+	}
+	ul.refs++
+	l.mu.Unlock()
+
+	ul.Lock()
+	return func() {
+		ul.Unlock()
+		l.mu.Lock()
+		if ul.refs--; ul.refs == 0 {
+			delete(l.locks, uid)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// quota returns quota of user.
+func (s *service) quota(uid uuid.UUID) Quota {
+	if q, ok := s.quotas.Overrides[uid]; ok {
+		return q
+	}
+	return s.quotas.Default
+}
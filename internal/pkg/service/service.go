@@ -2,10 +2,14 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/serjyuriev/shortener/internal/pkg/config"
 	"github.com/serjyuriev/shortener/internal/pkg/storage"
@@ -20,58 +24,169 @@ type Job struct {
 
 // Service provides method of application service layer.
 type Service interface {
+	ApplyTemplate(ctx context.Context, userID, templateID string, links []storage.Link) error
+	AuthenticateToken(ctx context.Context, token string) (storage.Token, error)
+	Close() error
+	CountURLsByUser(ctx context.Context) ([]storage.UserLinkCount, error)
+	CreateTemplate(ctx context.Context, userID string, t storage.Template) (storage.Template, error)
+	CreateToken(ctx context.Context, userID string, t storage.Token) (storage.Token, string, error)
+	CreateWorkspace(ctx context.Context, userID string, w storage.Workspace) (storage.Workspace, error)
+	DeleteTemplate(ctx context.Context, userID, templateID string) error
 	DeleteURLs(userID string, urls []string)
+	DeleteWorkspaceURLs(ctx context.Context, workspaceID string, urls []string) error
 	FindByOriginalURL(ctx context.Context, originalURL string) (string, error)
+	FindMembers(ctx context.Context, workspaceID string) ([]storage.Member, error)
 	FindOriginalURL(ctx context.Context, shortPath string) (string, error)
+	FindQuota(ctx context.Context, userID string) (QuotaUsage, error)
+	FindRole(ctx context.Context, workspaceID, userID string) (storage.Role, error)
+	FindTagsByUser(ctx context.Context, userID string) (map[string]int, error)
+	FindTemplates(ctx context.Context, userID string) ([]storage.Template, error)
+	FindTokens(ctx context.Context, userID string) ([]storage.Token, error)
+	FindURLHistory(ctx context.Context, userID, shortPath string) ([]storage.LinkRevision, error)
 	FindURLsByUser(ctx context.Context, userID string) (map[string]string, error)
+	FindWorkspaces(ctx context.Context, userID string) ([]storage.Membership, error)
+	HasAccount(ctx context.Context, userID string) (bool, error)
+	InsertLinks(ctx context.Context, userID string, links []storage.Link) error
 	InsertManyURLs(ctx context.Context, userID string, urls map[string]string) error
 	InsertNewURLPair(ctx context.Context, userID, shortPath, originalURL string) error
+	IterateAllURLs(ctx context.Context, opts storage.ListOptions, fn func(storage.Link) error) error
+	IterateUserURLs(ctx context.Context, userID string, opts storage.ListOptions, fn func(storage.Link) error) error
+	IterateWorkspaceURLs(ctx context.Context, workspaceID string, opts storage.ListOptions, fn func(storage.Link) error) error
+	Login(ctx context.Context, userID, login, password string) (storage.Account, error)
 	Ping(ctx context.Context) error
+	Register(ctx context.Context, userID, login, password string) (storage.Account, error)
+	RemoveMember(ctx context.Context, workspaceID, userID string) error
+	ResolveURL(ctx context.Context, shortPath, password string, visit Visit) (storage.Link, error)
+	RestoreURLs(ctx context.Context, userID string, urls []string) ([]string, error)
+	RevokeToken(ctx context.Context, userID, tokenID string) error
+	SetDisabled(ctx context.Context, shortPath string, disabled bool) (storage.Link, error)
+	SetMember(ctx context.Context, workspaceID, userID string, role storage.Role) error
+	SetQuotas(q Quotas)
+	SetTags(ctx context.Context, userID, shortPath string, tags []string) ([]string, error)
+	TransferURLs(ctx context.Context, fromUserID, toUserID string) (int, error)
+	UpdateOriginalURL(ctx context.Context, userID, shortPath, originalURL string) (storage.Link, error)
+	UpdateTemplate(ctx context.Context, userID string, t storage.Template) (storage.Template, error)
+	UpdateWorkspaceURL(ctx context.Context, workspaceID, shortPath, originalURL string) (storage.Link, error)
 }
 
 type service struct {
-	jobChan chan *Job
-	store   storage.Store
+	jobChan     chan *Job
+	store       storage.Store
+	mirror      storage.Store
+	attempts    *attemptLimiter
+	gracePeriod time.Duration
+	quotas      Quotas
+	quotaLocks  *userLocks
+	// done is closed by Close to stop background workers tracked by wg.
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
-// NewService initializes application service layer.
+// purgeInterval is a period between removals of URLs
+// that were deleted longer than grace period ago.
+const purgeInterval = time.Hour
+
+// NewService initializes application service layer
+// with storage chosen according to application configuration.
 func NewService() (Service, error) {
 	cfg := config.GetConfig()
 
-	var s storage.Store
-	var err error
-	if cfg.DatabaseDSN != "" {
-		s, err = storage.NewPgStore(cfg.DatabaseDSN)
-	} else {
-		s, err = storage.NewFileStore(cfg.FileStoragePath)
-	}
+	s, err := storage.NewStore(cfg.DatabaseDSN, cfg.FileStoragePath)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create new storage:\n%w", err)
 	}
 
+	var mirror storage.Store
+	if cfg.MirrorDatabaseDSN != "" || cfg.MirrorFileStoragePath != "" {
+		mirror, err = storage.NewStore(cfg.MirrorDatabaseDSN, cfg.MirrorFileStoragePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create mirror storage:\n%w", err)
+		}
+	}
+
+	overrides, err := ParseQuotaOverrides(cfg.QuotaOverrides)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse quota overrides:\n%w", err)
+	}
+
+	svc := newService(s, mirror)
+	svc.gracePeriod = cfg.RestoreGracePeriod
+	svc.quotas = Quotas{
+		Default:   Quota{MaxLinks: cfg.MaxLinksPerUser, MaxBatchSize: cfg.MaxBatchSize},
+		Overrides: overrides,
+	}
+	if svc.gracePeriod > 0 {
+		svc.wg.Add(1)
+		go svc.purgeLoop(purgeInterval)
+	}
+	return svc, nil
+}
+
+// NewServiceWithStore initializes application service layer
+// on top of provided storage.
+func NewServiceWithStore(s storage.Store) Service {
+	return newService(s, nil)
+}
+
+// NewDualWriteService initializes application service layer
+// reading from primary storage and duplicating every write into mirror one.
+// Writes are sent to mirror only after they succeed in primary storage;
+// mirror failures are logged and don't affect clients.
+func NewDualWriteService(primary, mirror storage.Store) Service {
+	return newService(primary, mirror)
+}
+
+func newService(s, mirror storage.Store) *service {
 	svc := &service{
-		store:   s,
-		jobChan: make(chan *Job),
+		store:      s,
+		mirror:     mirror,
+		attempts:   newAttemptLimiter(),
+		quotaLocks: newUserLocks(),
+		jobChan:    make(chan *Job),
+		done:       make(chan struct{}),
 	}
 
 	for i := 0; i < 5; i++ {
+		svc.wg.Add(1)
 		go func() {
-			for job := range svc.jobChan {
-				svc.deleteURLs(job.Ctx, job.UserID, job.URLs)
+			defer svc.wg.Done()
+			for {
+				select {
+				case job := <-svc.jobChan:
+					svc.deleteURLs(job.Ctx, job.UserID, job.URLs)
+				case <-svc.done:
+					return
+				}
 			}
 		}()
 	}
 
-	return svc, nil
+	return svc
+}
+
+// Close stops background workers of service, waiting for running jobs to finish.
+// URLs sent for removal after that are not removed.
+func (s *service) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+	return nil
 }
 
 // DeleteURLs creates a job for removing URLs from storage and sends it into job channel.
 func (s *service) DeleteURLs(userID string, urls []string) {
-	s.jobChan <- &Job{
+	job := &Job{
 		Ctx:    context.Background(),
 		UserID: userID,
 		URLs:   urls,
 	}
+	select {
+	case s.jobChan <- job:
+	case <-s.done:
+		log.Printf("unable to delete urls: service is closed\n")
+	}
 }
 
 // FindByOriginalURL searches for short URL with corresponding original URL in application storage.
@@ -92,6 +207,44 @@ func (s *service) FindOriginalURL(ctx context.Context, shortPath string) (string
 	return original, nil
 }
 
+// FindTagsByUser returns tags of URLs added by user with provided ID
+// along with number of URLs marked with each of them.
+func (s *service) FindTagsByUser(ctx context.Context, userID string) (map[string]int, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+
+	counts, err := s.store.FindTagsByUser(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find tags by user:\n%w", err)
+	}
+	return counts, nil
+}
+
+// FindURLHistory returns previous original URLs of URL
+// added by user with provided ID, oldest first.
+func (s *service) FindURLHistory(ctx context.Context, userID, shortPath string) ([]storage.LinkRevision, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+
+	l, err := s.store.FindLink(ctx, shortPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find link:\n%w", err)
+	}
+	if l.UserID != uid {
+		return nil, fmt.Errorf("unable to find link:\n%w", storage.ErrNoURLWasFound)
+	}
+
+	history, err := s.store.FindLinkHistory(ctx, shortPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find link history:\n%w", err)
+	}
+	return history, nil
+}
+
 // FindURLsByUser returns all URLs from application storage that were added by user with provided ID.
 func (s *service) FindURLsByUser(ctx context.Context, userID string) (map[string]string, error) {
 	uid, err := uuid.Parse(userID)
@@ -106,28 +259,71 @@ func (s *service) FindURLsByUser(ctx context.Context, userID string) (map[string
 	return m, nil
 }
 
-// InsertManyURLs inserts provided short URL - original URL pairs into application storage.
-func (s *service) InsertManyURLs(ctx context.Context, userID string, urls map[string]string) error {
+// InsertLinks inserts provided links on behalf of user with provided ID
+// into application storage. Missing timestamps are set to current time.
+func (s *service) InsertLinks(ctx context.Context, userID string, links []storage.Link) error {
 	uid, err := uuid.Parse(userID)
 	if err != nil {
 		return fmt.Errorf("unable to parse user id:\n%w", err)
 	}
+	if err = s.checkBatch(uid, len(links)); err != nil {
+		return err
+	}
+	unlock := s.lockQuota(uid)
+	defer unlock()
+	if err = s.checkQuota(ctx, uid, len(links)); err != nil {
+		return err
+	}
 
-	if err = s.store.InsertManyURLs(ctx, uid, urls); err != nil {
-		return fmt.Errorf("unable to insert many urls:\n%w", err)
+	now := storage.Now()
+	owned := make([]storage.Link, len(links))
+	for i, l := range links {
+		if l.Tags, err = storage.NormalizeTags(l.Tags); err != nil {
+			return err
+		}
+		l.UserID = uid
+		if l.CreatedAt.IsZero() {
+			l.CreatedAt = now
+		}
+		if l.UpdatedAt.IsZero() {
+			l.UpdatedAt = l.CreatedAt
+		}
+		owned[i] = l
 	}
+
+	if err = s.store.InsertLinks(ctx, owned); err != nil {
+		return fmt.Errorf("unable to insert links:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.InsertLinks(ctx, owned)
+	})
 	return nil
 }
 
+// InsertManyURLs inserts provided short URL - original URL pairs into application storage.
+func (s *service) InsertManyURLs(ctx context.Context, userID string, urls map[string]string) error {
+	links := make([]storage.Link, 0, len(urls))
+	for short, long := range urls {
+		links = append(links, storage.Link{ShortPath: short, OriginalURL: long})
+	}
+	return s.InsertLinks(ctx, userID, links)
+}
+
 // InsertNewURLPair inserts provided short URL - original URL pair into application storage.
 func (s *service) InsertNewURLPair(ctx context.Context, userID, shortPath, originalURL string) error {
+	return s.InsertLinks(ctx, userID, []storage.Link{{ShortPath: shortPath, OriginalURL: originalURL}})
+}
+
+// IterateUserURLs calls fn for URLs added by user with provided ID
+// ordered by creation time and restricted by provided options.
+func (s *service) IterateUserURLs(ctx context.Context, userID string, opts storage.ListOptions, fn func(storage.Link) error) error {
 	uid, err := uuid.Parse(userID)
 	if err != nil {
 		return fmt.Errorf("unable to parse user id:\n%w", err)
 	}
 
-	if err = s.store.InsertNewURLPair(ctx, uid, shortPath, originalURL); err != nil {
-		return fmt.Errorf("unable to insert url pair:\n%w", err)
+	if err = s.store.IterateUserLinks(ctx, uid, opts, fn); err != nil {
+		return fmt.Errorf("unable to iterate user urls:\n%w", err)
 	}
 	return nil
 }
@@ -140,14 +336,232 @@ func (s *service) Ping(ctx context.Context) error {
 	return nil
 }
 
+// ResolveURL returns link with original URL corresponding to short URL.
+// URLs protected with password require correct one,
+// wrong passwords are throttled per URL.
+// Every successful call uses up one of remaining uses of limited URLs.
+// Original URL of returned link is replaced with destination of the visit.
+func (s *service) ResolveURL(ctx context.Context, shortPath, password string, visit Visit) (storage.Link, error) {
+	l, err := s.store.FindLink(ctx, shortPath)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("unable to find original url:\n%w", err)
+	}
+	if visit.SubPath != "" && l.Passthrough != storage.PassthroughPath {
+		return storage.Link{}, ErrSubPathNotAllowed
+	}
+	if l.IsDeleted {
+		return storage.Link{}, fmt.Errorf("unable to find original url:\n%w", storage.ErrShortenedDeleted)
+	}
+	if l.IsDisabled {
+		return storage.Link{}, fmt.Errorf("unable to find original url:\n%w", storage.ErrLinkDisabled)
+	}
+	if l.Exhausted() {
+		return storage.Link{}, fmt.Errorf("unable to find original url:\n%w", storage.ErrLinkExhausted)
+	}
+
+	if l.PasswordHash != "" {
+		if password == "" {
+			return storage.Link{}, ErrPasswordRequired
+		}
+		if !s.attempts.attempt(shortPath) {
+			return storage.Link{}, ErrTooManyAttempts
+		}
+		if err = bcrypt.CompareHashAndPassword([]byte(l.PasswordHash), []byte(password)); err != nil {
+			return storage.Link{}, ErrWrongPassword
+		}
+		s.attempts.reset(shortPath)
+	}
+
+	if l.MaxUses > 0 {
+		if l.OriginalURL, err = s.store.ConsumeUse(ctx, shortPath); err != nil {
+			return storage.Link{}, fmt.Errorf("unable to use url:\n%w", err)
+		}
+		l.RemainingUses--
+		s.mirrorWrite(func(m storage.Store) error {
+			_, err := m.ConsumeUse(ctx, shortPath)
+			return err
+		})
+	}
+	if l.OriginalURL, err = destination(l, visit); err != nil {
+		return storage.Link{}, fmt.Errorf("unable to build destination url:\n%w", err)
+	}
+	return l, nil
+}
+
+// RestoreURLs removes deletion mark from provided URLs added by user with provided ID,
+// returning restored ones. URLs deleted longer than grace period ago are not restored.
+// Restored URLs count against quota of user.
+func (s *service) RestoreURLs(ctx context.Context, userID string, urls []string) ([]string, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+
+	var deletedAfter time.Time
+	if s.gracePeriod > 0 {
+		deletedAfter = storage.Now().Add(-s.gracePeriod)
+	}
+	if urls, err = s.personalURLs(ctx, urls); err != nil {
+		return nil, err
+	}
+	unlock := s.lockQuota(uid)
+	defer unlock()
+	if s.quota(uid).MaxLinks > 0 {
+		n, err := s.countRestorable(ctx, uid, urls, deletedAfter)
+		if err != nil {
+			return nil, err
+		}
+		if err = s.checkQuota(ctx, uid, n); err != nil {
+			return nil, err
+		}
+	}
+	restored, err := s.store.RestoreManyURLs(ctx, uid, urls, deletedAfter)
+	if err != nil {
+		return nil, fmt.Errorf("unable to restore urls:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		_, err := m.RestoreManyURLs(ctx, uid, restored, time.Time{})
+		return err
+	})
+	return restored, nil
+}
+
+// countRestorable returns number of provided URLs added by user
+// that would be restored by RestoreManyURLs with provided deletedAfter.
+func (s *service) countRestorable(ctx context.Context, uid uuid.UUID, urls []string, deletedAfter time.Time) (int, error) {
+	n := 0
+	seen := make(map[string]bool, len(urls))
+	for _, short := range urls {
+		if seen[short] {
+			continue
+		}
+		seen[short] = true
+		l, err := s.store.FindLink(ctx, short)
+		if errors.Is(err, storage.ErrNoURLWasFound) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("unable to find url:\n%w", err)
+		}
+		if l.UserID == uid && l.IsDeleted && !l.DeletedAt.Before(deletedAfter) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// SetTags replaces tags of URL added by user with provided ID,
+// returning normalized tags.
+func (s *service) SetTags(ctx context.Context, userID, shortPath string, tags []string) ([]string, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if tags, err = storage.NormalizeTags(tags); err != nil {
+		return nil, err
+	}
+	if err = s.checkPersonal(ctx, shortPath); err != nil {
+		return nil, fmt.Errorf("unable to set tags:\n%w", err)
+	}
+
+	if err = s.store.SetTags(ctx, uid, shortPath, tags); err != nil {
+		return nil, fmt.Errorf("unable to set tags:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.SetTags(ctx, uid, shortPath, tags)
+	})
+	return tags, nil
+}
+
+// UpdateOriginalURL changes original URL of URL added by user with provided ID,
+// returning updated URL. URLs of workspaces are changed with UpdateWorkspaceURL only.
+func (s *service) UpdateOriginalURL(ctx context.Context, userID, shortPath, originalURL string) (storage.Link, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if err = s.checkPersonal(ctx, shortPath); err != nil {
+		return storage.Link{}, fmt.Errorf("unable to update original url:\n%w", err)
+	}
+	return s.updateOriginalURL(ctx, uid, shortPath, originalURL)
+}
+
+// updateOriginalURL changes original URL of URL added by user with provided ID
+// regardless of workspace it belongs to.
+func (s *service) updateOriginalURL(ctx context.Context, uid uuid.UUID, shortPath, originalURL string) (storage.Link, error) {
+	if err := s.store.UpdateOriginalURL(ctx, uid, shortPath, originalURL); err != nil {
+		return storage.Link{}, fmt.Errorf("unable to update original url:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.UpdateOriginalURL(ctx, uid, shortPath, originalURL)
+	})
+
+	l, err := s.store.FindLink(ctx, shortPath)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("unable to find link:\n%w", err)
+	}
+	return l, nil
+}
+
 func (s *service) deleteURLs(ctx context.Context, userID string, urls []string) {
 	uid, err := uuid.Parse(userID)
 	if err != nil {
 		log.Printf("unable to parse user id (%s): %v", userID, err)
 		return
 	}
+	if urls, err = s.personalURLs(ctx, urls); err != nil {
+		log.Printf("unable to delete urls: %v", err)
+		return
+	}
 
 	if err = s.store.DeleteManyURLs(ctx, uid, urls); err != nil {
 		log.Printf("unable to delete urls: %v", err)
+		return
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.DeleteManyURLs(ctx, uid, urls)
+	})
+}
+
+// purgeLoop removes URLs deleted longer than grace period ago
+// right away and then once per interval until service is closed.
+func (s *service) purgeLoop(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.purgeDeletedURLs(context.Background())
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// purgeDeletedURLs permanently removes URLs deleted longer than grace period ago.
+func (s *service) purgeDeletedURLs(ctx context.Context) {
+	deletedBefore := storage.Now().Add(-s.gracePeriod)
+	n, err := s.store.PurgeDeletedURLs(ctx, deletedBefore)
+	if err != nil {
+		log.Printf("unable to purge deleted urls: %v\n", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("purged %d deleted urls\n", n)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		_, err := m.PurgeDeletedURLs(ctx, deletedBefore)
+		return err
+	})
+}
+
+// mirrorWrite repeats write operation in mirror storage, if there is one.
+func (s *service) mirrorWrite(fn func(m storage.Store) error) {
+	if s.mirror == nil {
+		return
+	}
+	if err := fn(s.mirror); err != nil {
+		log.Printf("unable to write into mirror storage: %v\n", err)
 	}
 }
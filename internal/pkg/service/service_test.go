@@ -0,0 +1,816 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+func TestNewDualWriteService(t *testing.T) {
+	ctx := context.Background()
+	uid := uuid.New()
+
+	primary, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	mirror, err := storage.NewFileStore("")
+	require.NoError(t, err)
+
+	svc := NewDualWriteService(primary, mirror)
+	defer svc.Close()
+	require.NoError(t, svc.InsertNewURLPair(ctx, uid.String(), "abcdef", "https://github.com/serjyuriev"))
+	require.NoError(t, svc.InsertManyURLs(ctx, uid.String(), map[string]string{
+		"lkasdj": "https://gitlab.com/servady",
+	}))
+
+	m, err := mirror.FindURLsByUser(ctx, uid)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"abcdef": "https://github.com/serjyuriev",
+		"lkasdj": "https://gitlab.com/servady",
+	}, m)
+
+	svc.DeleteURLs(uid.String(), []string{"abcdef"})
+	assert.Eventually(t, func() bool {
+		l, err := mirror.FindLink(ctx, "abcdef")
+		return err == nil && l.IsDeleted
+	}, time.Second, 10*time.Millisecond)
+
+	l, err := primary.FindLink(ctx, "abcdef")
+	require.NoError(t, err)
+	assert.True(t, l.IsDeleted)
+}
+
+type failingStore struct {
+	storage.Store
+}
+
+func (failingStore) InsertLinks(context.Context, []storage.Link) error {
+	return errors.New("mirror is unavailable")
+}
+
+func TestNewDualWriteService_mirrorFailure(t *testing.T) {
+	ctx := context.Background()
+	uid := uuid.New()
+
+	primary, err := storage.NewFileStore("")
+	require.NoError(t, err)
+
+	svc := NewDualWriteService(primary, failingStore{})
+	defer svc.Close()
+	require.NoError(t, svc.InsertNewURLPair(ctx, uid.String(), "abcdef", "https://github.com/serjyuriev"))
+
+	original, err := primary.FindOriginalURL(ctx, "abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/serjyuriev", original)
+}
+
+func TestRestoreURLs_gracePeriod(t *testing.T) {
+	ctx := context.Background()
+	uid := uuid.New()
+
+	primary, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	mirror, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	now := storage.Now()
+	links := []storage.Link{
+		{ShortPath: "aaaaaa", OriginalURL: "https://github.com", UserID: uid, IsDeleted: true, DeletedAt: now.Add(-time.Hour)},
+		{ShortPath: "bbbbbb", OriginalURL: "https://gitlab.com", UserID: uid, IsDeleted: true, DeletedAt: now.Add(-48 * time.Hour)},
+	}
+	require.NoError(t, primary.InsertLinks(ctx, links))
+	require.NoError(t, mirror.InsertLinks(ctx, links))
+
+	svc := newService(primary, mirror)
+	defer svc.Close()
+	svc.gracePeriod = 24 * time.Hour
+
+	restored, err := svc.RestoreURLs(ctx, uid.String(), []string{"aaaaaa", "bbbbbb"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aaaaaa"}, restored)
+
+	svc.purgeDeletedURLs(ctx)
+	for _, s := range []storage.Store{primary, mirror} {
+		l, err := s.FindLink(ctx, "aaaaaa")
+		require.NoError(t, err)
+		assert.False(t, l.IsDeleted)
+		_, err = s.FindLink(ctx, "bbbbbb")
+		assert.ErrorIs(t, err, storage.ErrNoURLWasFound)
+	}
+}
+
+func TestService_Close(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	uid := uuid.New()
+	require.NoError(t, store.InsertNewURLPair(ctx, uid, "aaaaaa", "https://github.com"))
+
+	svc := newService(store, nil)
+	svc.gracePeriod = time.Hour
+	svc.wg.Add(1)
+	go svc.purgeLoop(time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		assert.NoError(t, svc.Close())
+		assert.NoError(t, svc.Close(), "service may be closed twice")
+		svc.DeleteURLs(uid.String(), []string{"aaaaaa"})
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("service was not closed")
+	}
+	l, err := store.FindLink(ctx, "aaaaaa")
+	require.NoError(t, err)
+	assert.False(t, l.IsDeleted, "URLs are not removed after service is closed")
+}
+
+func TestResolveURL(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	hash, err := HashPassword("s3cret")
+	require.NoError(t, err)
+	require.NoError(t, store.InsertLinks(ctx, []storage.Link{
+		{ShortPath: "aaaaaa", OriginalURL: "https://github.com", UserID: uuid.New()},
+		{ShortPath: "bbbbbb", OriginalURL: "https://gitlab.com", UserID: uuid.New(), PasswordHash: hash},
+		{ShortPath: "cccccc", OriginalURL: "https://yandex.ru", UserID: uuid.New(), IsDeleted: true},
+	}))
+
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	svc := newService(store, nil)
+	defer svc.Close()
+	svc.attempts.now = func() time.Time { return now }
+
+	tests := []struct {
+		name      string
+		shortPath string
+		password  string
+		want      string
+		wantErr   error
+	}{
+		{
+			name:      "not protected",
+			shortPath: "aaaaaa",
+			want:      "https://github.com",
+		},
+		{
+			name:      "deleted",
+			shortPath: "cccccc",
+			wantErr:   storage.ErrShortenedDeleted,
+		},
+		{
+			name:      "missing",
+			shortPath: "zzzzzz",
+			wantErr:   storage.ErrNoURLWasFound,
+		},
+		{
+			name:      "password required",
+			shortPath: "bbbbbb",
+			wantErr:   ErrPasswordRequired,
+		},
+		{
+			name:      "wrong password",
+			shortPath: "bbbbbb",
+			password:  "secret",
+			wantErr:   ErrWrongPassword,
+		},
+		{
+			name:      "correct password",
+			shortPath: "bbbbbb",
+			password:  "s3cret",
+			want:      "https://gitlab.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := svc.ResolveURL(ctx, tt.shortPath, tt.password, Visit{})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.OriginalURL)
+		})
+	}
+
+	t.Run("throttling", func(t *testing.T) {
+		for i := 0; i < MaxPasswordAttempts; i++ {
+			_, err := svc.ResolveURL(ctx, "bbbbbb", "secret", Visit{})
+			assert.ErrorIs(t, err, ErrWrongPassword)
+		}
+		_, err := svc.ResolveURL(ctx, "bbbbbb", "s3cret", Visit{})
+		assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+		now = now.Add(PasswordAttemptWindow)
+		got, err := svc.ResolveURL(ctx, "bbbbbb", "s3cret", Visit{})
+		require.NoError(t, err)
+		assert.Equal(t, "https://gitlab.com", got.OriginalURL)
+	})
+
+	t.Run("parallel throttling", func(t *testing.T) {
+		now = now.Add(PasswordAttemptWindow)
+		var wg sync.WaitGroup
+		var wrong atomic.Int32
+		for i := 0; i < 4*MaxPasswordAttempts; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := svc.ResolveURL(ctx, "bbbbbb", "secret", Visit{}); errors.Is(err, ErrWrongPassword) {
+					wrong.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(MaxPasswordAttempts), wrong.Load())
+	})
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("")
+	require.NoError(t, err)
+	assert.Empty(t, hash)
+
+	_, err = HashPassword(strings.Repeat("a", 73))
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+
+	hash, err = HashPassword("s3cret")
+	require.NoError(t, err)
+	assert.NotContains(t, hash, "s3cret")
+}
+
+func TestDestination(t *testing.T) {
+	tests := []struct {
+		name        string
+		original    string
+		passthrough storage.Passthrough
+		visit       Visit
+		want        string
+		wantErr     error
+	}{
+		{
+			name:     "passthrough disabled",
+			original: "https://github.com/serjyuriev",
+			visit:    Visit{RawQuery: "utm_source=x"},
+			want:     "https://github.com/serjyuriev",
+		},
+		{
+			name:     "sub-path with passthrough disabled",
+			original: "https://github.com/serjyuriev",
+			visit:    Visit{SubPath: "shortener"},
+			wantErr:  ErrSubPathNotAllowed,
+		},
+		{
+			name:        "sub-path with query passthrough",
+			original:    "https://github.com/serjyuriev",
+			passthrough: storage.PassthroughQuery,
+			visit:       Visit{SubPath: "shortener"},
+			wantErr:     ErrSubPathNotAllowed,
+		},
+		{
+			name:        "query appended",
+			original:    "https://github.com/serjyuriev",
+			passthrough: storage.PassthroughQuery,
+			visit:       Visit{RawQuery: "utm_source=x&utm_medium=email"},
+			want:        "https://github.com/serjyuriev?utm_medium=email&utm_source=x",
+		},
+		{
+			name:        "original parameters take precedence",
+			original:    "https://github.com/search?q=go&utm_source=site#top",
+			passthrough: storage.PassthroughQuery,
+			visit:       Visit{RawQuery: "utm_source=x&utm_source=y&page=2"},
+			want:        "https://github.com/search?q=go&utm_source=site&page=2#top",
+		},
+		{
+			name:        "query escaped",
+			original:    "https://github.com/search",
+			passthrough: storage.PassthroughQuery,
+			visit:       Visit{RawQuery: "q=a+b%26c&bad=%zz&x=%3C"},
+			want:        "https://github.com/search?q=a+b%26c&x=%3C",
+		},
+		{
+			name:        "sub-path appended",
+			original:    "https://github.com/serjyuriev/",
+			passthrough: storage.PassthroughPath,
+			visit:       Visit{SubPath: "shortener/blob/main/", RawQuery: "plain=1"},
+			want:        "https://github.com/serjyuriev/shortener/blob/main/?plain=1",
+		},
+		{
+			name:        "sub-path keeps escaping",
+			original:    "https://example.com/docs",
+			passthrough: storage.PassthroughPath,
+			visit:       Visit{SubPath: "a%2Fb/c%20d"},
+			want:        "https://example.com/docs/a%2Fb/c%20d",
+		},
+		{
+			name:        "sub-path can't escape original path",
+			original:    "https://example.com/docs",
+			passthrough: storage.PassthroughPath,
+			visit:       Visit{SubPath: "../%2e%2e/admin/./x/../y"},
+			want:        "https://example.com/docs/admin/y",
+		},
+		{
+			name:        "invalid sub-path",
+			original:    "https://example.com/docs",
+			passthrough: storage.PassthroughPath,
+			visit:       Visit{SubPath: "%zz"},
+			wantErr:     ErrInvalidSubPath,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := destination(storage.Link{
+				OriginalURL: tt.original,
+				Passthrough: tt.passthrough,
+			}, tt.visit)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestResolveURL_subPathKeepsUses(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	require.NoError(t, store.InsertLinks(ctx, []storage.Link{{
+		ShortPath:     "aaaaaa",
+		OriginalURL:   "https://github.com",
+		UserID:        uuid.New(),
+		MaxUses:       1,
+		RemainingUses: 1,
+	}}))
+	svc := newService(store, nil)
+	defer svc.Close()
+
+	_, err = svc.ResolveURL(ctx, "aaaaaa", "", Visit{SubPath: "x"})
+	assert.ErrorIs(t, err, ErrSubPathNotAllowed)
+	got, err := svc.ResolveURL(ctx, "aaaaaa", "", Visit{RawQuery: "a=b"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com", got.OriginalURL)
+}
+
+func TestApplyTemplate(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := newService(store, nil)
+	defer svc.Close()
+	uid := uuid.New().String()
+
+	links := []storage.Link{{OriginalURL: "https://github.com/search?q=go"}}
+	require.NoError(t, svc.ApplyTemplate(ctx, uid, "", links))
+	assert.Equal(t, "https://github.com/search?q=go", links[0].OriginalURL)
+
+	_, err = svc.CreateTemplate(ctx, uid, storage.Template{Name: "bad", Params: map[string]string{"ref": "x"}})
+	assert.ErrorIs(t, err, storage.ErrInvalidTemplate)
+
+	email, err := svc.CreateTemplate(ctx, uid, storage.Template{
+		Name:   "email",
+		Params: map[string]string{"utm_source": "newsletter", "utm_medium": "email"},
+	})
+	require.NoError(t, err)
+	social, err := svc.CreateTemplate(ctx, uid, storage.Template{
+		Name:      "social",
+		Params:    map[string]string{"utm_source": "twitter", "utm_campaign": "spring sale"},
+		IsDefault: true,
+	})
+	require.NoError(t, err)
+
+	links = []storage.Link{
+		{OriginalURL: "https://github.com/search?q=go"},
+		{OriginalURL: "https://gitlab.com/?utm_source=own"},
+	}
+	require.NoError(t, svc.ApplyTemplate(ctx, uid, "", links))
+	assert.Equal(t, "https://github.com/search?q=go&utm_campaign=spring+sale&utm_source=twitter", links[0].OriginalURL)
+	assert.Equal(t, "https://gitlab.com/?utm_source=own&utm_campaign=spring+sale", links[1].OriginalURL)
+
+	links = []storage.Link{{OriginalURL: "https://github.com"}}
+	require.NoError(t, svc.ApplyTemplate(ctx, uid, email.ID, links))
+	assert.Equal(t, "https://github.com?utm_medium=email&utm_source=newsletter", links[0].OriginalURL)
+
+	err = svc.ApplyTemplate(ctx, uuid.New().String(), social.ID, links)
+	assert.ErrorIs(t, err, storage.ErrNoTemplateWasFound)
+
+	require.NoError(t, svc.DeleteTemplate(ctx, uid, social.ID))
+	links = []storage.Link{{OriginalURL: "https://github.com"}}
+	require.NoError(t, svc.ApplyTemplate(ctx, uid, "", links))
+	assert.Equal(t, "https://github.com", links[0].OriginalURL)
+}
+
+func TestTokens(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := newService(store, nil)
+	defer svc.Close()
+	uid := uuid.New().String()
+
+	_, _, err = svc.CreateToken(ctx, uid, storage.Token{Name: " "})
+	assert.ErrorIs(t, err, storage.ErrInvalidToken)
+	_, _, err = svc.CreateToken(ctx, uid, storage.Token{Name: "admin", Scope: 7})
+	assert.ErrorIs(t, err, storage.ErrInvalidTokenScope)
+
+	created, token, err := svc.CreateToken(ctx, uid, storage.Token{Name: " nightly export ", Scope: storage.ScopeReadWrite})
+	require.NoError(t, err)
+	assert.Equal(t, "nightly export", created.Name)
+	assert.True(t, strings.HasPrefix(token, tokenPrefix))
+	assert.NotContains(t, created.Hash, token)
+
+	authenticated, err := svc.AuthenticateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, authenticated.ID)
+	assert.Equal(t, uid, authenticated.UserID.String())
+	assert.Equal(t, storage.ScopeReadWrite, authenticated.Scope)
+
+	for _, forged := range []string{"", token[len(tokenPrefix):], token + "x", tokenPrefix + strings.Repeat("A", 43)} {
+		_, err = svc.AuthenticateToken(ctx, forged)
+		assert.ErrorIs(t, err, ErrUnknownToken, forged)
+	}
+
+	tokens, err := svc.FindTokens(ctx, uid)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, created.ID, tokens[0].ID)
+
+	assert.ErrorIs(t, svc.RevokeToken(ctx, uuid.New().String(), created.ID), storage.ErrNoTokenWasFound)
+	require.NoError(t, svc.RevokeToken(ctx, uid, created.ID))
+	_, err = svc.AuthenticateToken(ctx, token)
+	assert.ErrorIs(t, err, ErrUnknownToken)
+}
+
+func TestAccounts(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := newService(store, nil)
+	defer svc.Close()
+	owner := uuid.New()
+	anonymous := uuid.New()
+	require.NoError(t, store.InsertLinks(ctx, []storage.Link{
+		{ShortPath: "own", OriginalURL: "https://owner.test", UserID: owner},
+		{ShortPath: "anon", OriginalURL: "https://anonymous.test", UserID: anonymous},
+	}))
+
+	_, err = svc.Register(ctx, owner.String(), "x", "long enough")
+	assert.ErrorIs(t, err, storage.ErrInvalidLogin)
+	_, err = svc.Register(ctx, owner.String(), "serj", "short")
+	assert.ErrorIs(t, err, ErrWeakPassword)
+
+	a, err := svc.Register(ctx, owner.String(), " Serj ", "long enough")
+	require.NoError(t, err)
+	assert.Equal(t, owner, a.ID)
+	assert.Equal(t, "serj", a.Login)
+	assert.NotEqual(t, "long enough", a.PasswordHash)
+	_, err = svc.Register(ctx, anonymous.String(), "serj", "another one")
+	assert.ErrorIs(t, err, storage.ErrAccountExists)
+
+	second, err := svc.Register(ctx, owner.String(), "second", "long enough")
+	require.NoError(t, err)
+	assert.NotEqual(t, owner, second.ID)
+
+	_, err = svc.Login(ctx, anonymous.String(), "serj", "wrong password")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = svc.Login(ctx, anonymous.String(), "nobody", "long enough")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	urls, err := store.FindURLsByUser(ctx, owner)
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+
+	a, err = svc.Login(ctx, anonymous.String(), "SERJ", "long enough")
+	require.NoError(t, err)
+	assert.Equal(t, owner, a.ID)
+	urls, err = store.FindURLsByUser(ctx, owner)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	_, err = svc.Login(ctx, owner.String(), "second", "long enough")
+	require.NoError(t, err)
+	_, err = store.FindURLsByUser(ctx, second.ID)
+	assert.ErrorIs(t, err, storage.ErrNoURLWasFound)
+
+	for i := 0; i < MaxPasswordAttempts; i++ {
+		_, err = svc.Login(ctx, anonymous.String(), "serj", "wrong password")
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+	_, err = svc.Login(ctx, anonymous.String(), "serj", "long enough")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+}
+
+func TestTransferURLs(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	mirror, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := newService(store, mirror)
+	defer svc.Close()
+	from, to := uuid.New(), uuid.New()
+	links := []storage.Link{
+		{ShortPath: "a", OriginalURL: "https://a.test", UserID: from},
+		{ShortPath: "b", OriginalURL: "https://b.test", UserID: from},
+	}
+	require.NoError(t, store.InsertLinks(ctx, links))
+	require.NoError(t, mirror.InsertLinks(ctx, links))
+
+	_, err = svc.TransferURLs(ctx, "not a uuid", to.String())
+	assert.Error(t, err)
+	n, err := svc.TransferURLs(ctx, from.String(), from.String())
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	n, err = svc.TransferURLs(ctx, from.String(), to.String())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	for _, s := range []storage.Store{store, mirror} {
+		urls, err := s.FindURLsByUser(ctx, to)
+		require.NoError(t, err)
+		assert.Len(t, urls, 2)
+		_, err = s.FindURLsByUser(ctx, from)
+		assert.ErrorIs(t, err, storage.ErrNoURLWasFound)
+	}
+}
+
+func TestWorkspaces(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := newService(store, nil)
+	defer svc.Close()
+	owner, editor := uuid.New().String(), uuid.New().String()
+
+	_, err = svc.CreateWorkspace(ctx, owner, storage.Workspace{Name: "  "})
+	assert.ErrorIs(t, err, storage.ErrInvalidWorkspace)
+	ws, err := svc.CreateWorkspace(ctx, owner, storage.Workspace{Name: " team "})
+	require.NoError(t, err)
+	assert.Equal(t, "team", ws.Name)
+	wid := ws.ID.String()
+
+	role, err := svc.FindRole(ctx, wid, owner)
+	require.NoError(t, err)
+	assert.Equal(t, storage.RoleOwner, role)
+	_, err = svc.FindRole(ctx, wid, editor)
+	assert.ErrorIs(t, err, storage.ErrNoMemberWasFound)
+	_, err = svc.FindRole(ctx, "not a uuid", owner)
+	assert.ErrorIs(t, err, storage.ErrNoMemberWasFound)
+
+	assert.ErrorIs(t, svc.SetMember(ctx, wid, editor, storage.Role(7)), storage.ErrInvalidRole)
+	require.NoError(t, svc.SetMember(ctx, wid, editor, storage.RoleEditor))
+	assert.ErrorIs(t, svc.SetMember(ctx, wid, owner, storage.RoleEditor), ErrLastOwner)
+	assert.ErrorIs(t, svc.RemoveMember(ctx, wid, owner), ErrLastOwner)
+	memberships, err := svc.FindWorkspaces(ctx, editor)
+	require.NoError(t, err)
+	require.Len(t, memberships, 1)
+	assert.Equal(t, storage.RoleEditor, memberships[0].Role)
+
+	wsLinks := []storage.Link{{ShortPath: "ws", OriginalURL: "https://ws.test", WorkspaceID: ws.ID}}
+	require.NoError(t, svc.InsertLinks(ctx, owner, wsLinks))
+	require.NoError(t, svc.InsertNewURLPair(ctx, owner, "own", "https://own.test"))
+
+	l, err := svc.UpdateWorkspaceURL(ctx, wid, "ws", "https://ws.test/v2")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ws.test/v2", l.OriginalURL)
+	_, err = svc.UpdateWorkspaceURL(ctx, wid, "own", "https://own.test/v2")
+	assert.ErrorIs(t, err, storage.ErrNoURLWasFound)
+
+	require.NoError(t, svc.DeleteWorkspaceURLs(ctx, wid, []string{"ws", "own", "missing"}))
+	l, err = store.FindLink(ctx, "ws")
+	require.NoError(t, err)
+	assert.True(t, l.IsDeleted)
+	l, err = store.FindLink(ctx, "own")
+	require.NoError(t, err)
+	assert.False(t, l.IsDeleted, "personal links must not be deleted through workspace")
+
+	require.NoError(t, svc.SetMember(ctx, wid, editor, storage.RoleOwner))
+	require.NoError(t, svc.RemoveMember(ctx, wid, owner))
+	members, err := svc.FindMembers(ctx, wid)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, editor, members[0].UserID.String())
+}
+
+func TestWorkspaces_removedMember(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := newService(store, nil)
+	defer svc.Close()
+	owner, member := uuid.New().String(), uuid.New()
+
+	ws, err := svc.CreateWorkspace(ctx, owner, storage.Workspace{Name: "team"})
+	require.NoError(t, err)
+	wid := ws.ID.String()
+	require.NoError(t, svc.SetMember(ctx, wid, member.String(), storage.RoleEditor))
+	require.NoError(t, svc.InsertLinks(ctx, member.String(), []storage.Link{
+		{ShortPath: "ws", OriginalURL: "https://ws.test", WorkspaceID: ws.ID},
+		{ShortPath: "deleted", OriginalURL: "https://deleted.test", WorkspaceID: ws.ID},
+	}))
+	require.NoError(t, svc.DeleteWorkspaceURLs(ctx, wid, []string{"deleted"}))
+	require.NoError(t, svc.RemoveMember(ctx, wid, member.String()))
+
+	_, err = svc.UpdateOriginalURL(ctx, member.String(), "ws", "https://stolen.test")
+	assert.ErrorIs(t, err, storage.ErrNoURLWasFound)
+	_, err = svc.SetTags(ctx, member.String(), "ws", []string{"stolen"})
+	assert.ErrorIs(t, err, storage.ErrNoURLWasFound)
+	svc.deleteURLs(ctx, member.String(), []string{"ws"})
+	restored, err := svc.RestoreURLs(ctx, member.String(), []string{"deleted"})
+	require.NoError(t, err)
+	assert.Empty(t, restored)
+	other := uuid.New().String()
+	n, err := svc.TransferURLs(ctx, member.String(), other)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	l, err := store.FindLink(ctx, "ws")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ws.test", l.OriginalURL)
+	assert.Empty(t, l.Tags)
+	assert.False(t, l.IsDeleted)
+	assert.Equal(t, member, l.UserID)
+	l, err = store.FindLink(ctx, "deleted")
+	require.NoError(t, err)
+	assert.True(t, l.IsDeleted)
+
+	_, err = svc.UpdateWorkspaceURL(ctx, wid, "ws", "https://ws.test/v2")
+	assert.NoError(t, err, "workspace keeps access to links of removed members")
+}
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	mirror, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := newService(store, mirror)
+	defer svc.Close()
+	uid, uid2 := uuid.New(), uuid.New()
+	require.NoError(t, svc.InsertNewURLPair(ctx, uid.String(), "aaaaaa", "https://github.com/serjyuriev"))
+	require.NoError(t, svc.InsertNewURLPair(ctx, uid2.String(), "bbbbbb", "https://spam.test"))
+
+	l, err := svc.SetDisabled(ctx, "bbbbbb", true)
+	require.NoError(t, err)
+	assert.True(t, l.IsDisabled)
+	_, err = svc.SetDisabled(ctx, "zzzzzz", true)
+	assert.ErrorIs(t, err, storage.ErrNoURLWasFound)
+	ml, err := mirror.FindLink(ctx, "bbbbbb")
+	require.NoError(t, err)
+	assert.True(t, ml.IsDisabled)
+
+	_, err = svc.ResolveURL(ctx, "bbbbbb", "", Visit{})
+	assert.ErrorIs(t, err, storage.ErrLinkDisabled)
+	_, err = svc.ResolveURL(ctx, "aaaaaa", "", Visit{})
+	assert.NoError(t, err)
+
+	var shorts []string
+	require.NoError(t, svc.IterateAllURLs(ctx, storage.ListOptions{Query: "spam"}, func(l storage.Link) error {
+		shorts = append(shorts, l.ShortPath)
+		return nil
+	}))
+	assert.Equal(t, []string{"bbbbbb"}, shorts)
+
+	counts, err := svc.CountURLsByUser(ctx)
+	require.NoError(t, err)
+	assert.Len(t, counts, 2)
+
+	_, err = svc.SetDisabled(ctx, "bbbbbb", false)
+	require.NoError(t, err)
+	_, err = svc.ResolveURL(ctx, "bbbbbb", "", Visit{})
+	assert.NoError(t, err)
+}
+
+func TestQuotas(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := newService(store, nil)
+	defer svc.Close()
+	uid, vip := uuid.New(), uuid.New()
+	svc.SetQuotas(Quotas{
+		Default:   Quota{MaxLinks: 3, MaxBatchSize: 2},
+		Overrides: map[uuid.UUID]Quota{vip: {MaxLinks: 0, MaxBatchSize: 5}},
+	})
+
+	err = svc.InsertManyURLs(ctx, uid.String(), map[string]string{
+		"aaaaaa": "https://a.test", "bbbbbb": "https://b.test", "cccccc": "https://c.test",
+	})
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	require.NoError(t, svc.InsertManyURLs(ctx, uid.String(), map[string]string{
+		"aaaaaa": "https://a.test", "bbbbbb": "https://b.test",
+	}))
+	require.NoError(t, svc.InsertNewURLPair(ctx, uid.String(), "cccccc", "https://c.test"))
+	err = svc.InsertNewURLPair(ctx, uid.String(), "dddddd", "https://d.test")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	q, err := svc.FindQuota(ctx, uid.String())
+	require.NoError(t, err)
+	assert.Equal(t, QuotaUsage{Quota: Quota{MaxLinks: 3, MaxBatchSize: 2}, ActiveLinks: 3}, q)
+
+	require.NoError(t, store.DeleteManyURLs(ctx, uid, []string{"aaaaaa"}))
+	require.NoError(t, svc.InsertNewURLPair(ctx, uid.String(), "dddddd", "https://d.test"), "deleted links are not counted")
+
+	_, err = svc.RestoreURLs(ctx, uid.String(), []string{"aaaaaa"})
+	assert.ErrorIs(t, err, ErrQuotaExceeded, "restored links are counted")
+	require.NoError(t, store.DeleteManyURLs(ctx, uid, []string{"bbbbbb"}))
+	restored, err := svc.RestoreURLs(ctx, uid.String(), []string{"aaaaaa", "cccccc"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aaaaaa"}, restored)
+
+	other := uuid.New()
+	require.NoError(t, store.InsertLinks(ctx, []storage.Link{{ShortPath: "xxxxxx", OriginalURL: "https://x.test", UserID: other}}))
+	_, err = svc.TransferURLs(ctx, other.String(), uid.String())
+	assert.ErrorIs(t, err, ErrQuotaExceeded, "transferred links are counted")
+	urls, err := store.FindURLsByUser(ctx, other)
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+
+	require.NoError(t, svc.InsertManyURLs(ctx, vip.String(), map[string]string{
+		"eeeeee": "https://e.test", "ffffff": "https://f.test", "gggggg": "https://g.test", "hhhhhh": "https://h.test",
+	}))
+	q, err = svc.FindQuota(ctx, vip.String())
+	require.NoError(t, err)
+	assert.Equal(t, QuotaUsage{Quota: Quota{MaxBatchSize: 5}, ActiveLinks: 4}, q)
+}
+
+func TestQuotas_concurrent(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore("")
+	require.NoError(t, err)
+	svc := newService(store, nil)
+	defer svc.Close()
+	svc.SetQuotas(Quotas{Default: Quota{MaxLinks: 3}})
+	uid := uuid.New()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- svc.InsertNewURLPair(ctx, uid.String(), fmt.Sprintf("short%d", i), fmt.Sprintf("https://%d.test", i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	inserted := 0
+	for err := range errs {
+		if err == nil {
+			inserted++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrQuotaExceeded)
+	}
+	assert.Equal(t, 3, inserted)
+	n, err := store.CountActiveLinks(ctx, uid)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestParseQuotaOverrides(t *testing.T) {
+	uid := uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523")
+	uid2 := uuid.MustParse("9b1f0c2e-3d4a-4b5c-8d6e-7f8091a2b3c4")
+	tests := []struct {
+		name    string
+		s       string
+		want    map[uuid.UUID]Quota
+		wantErr bool
+	}{
+		{name: "empty", s: "", want: map[uuid.UUID]Quota{}},
+		{
+			name: "several users",
+			s:    " 6577f191-a012-4f16-afe4-6ed0d542e523:100:10, ,9b1f0c2e-3d4a-4b5c-8d6e-7f8091a2b3c4::50",
+			want: map[uuid.UUID]Quota{uid: {MaxLinks: 100, MaxBatchSize: 10}, uid2: {MaxBatchSize: 50}},
+		},
+		{name: "missing batch size", s: "6577f191-a012-4f16-afe4-6ed0d542e523:100", wantErr: true},
+		{name: "invalid user id", s: "admin:100:10", wantErr: true},
+		{name: "negative number", s: "6577f191-a012-4f16-afe4-6ed0d542e523:-1:10", wantErr: true},
+		{name: "not a number", s: "6577f191-a012-4f16-afe4-6ed0d542e523:many:10", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQuotaOverrides(tt.s)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/google/uuid"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+// ApplyTemplate adds parameters of template with provided ID to original URLs
+// of links created by user with provided ID. If ID is empty, default template
+// of user is applied, if there is one. Parameters already present
+// in original URL are not changed.
+func (s *service) ApplyTemplate(ctx context.Context, userID, templateID string, links []storage.Link) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	templates, err := s.store.FindTemplatesByUser(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("unable to find templates:\n%w", err)
+	}
+
+	var t *storage.Template
+	for i := range templates {
+		if templates[i].ID == templateID || (templateID == "" && templates[i].IsDefault) {
+			t = &templates[i]
+			break
+		}
+	}
+	if t == nil {
+		if templateID == "" {
+			return nil
+		}
+		return fmt.Errorf("unable to apply template %s:\n%w", templateID, storage.ErrNoTemplateWasFound)
+	}
+
+	for i := range links {
+		if links[i].OriginalURL, err = applyParams(links[i].OriginalURL, t.Params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateTemplate saves new template of user with provided ID, returning it.
+func (s *service) CreateTemplate(ctx context.Context, userID string, t storage.Template) (storage.Template, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return storage.Template{}, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if t, err = storage.NormalizeTemplate(t); err != nil {
+		return storage.Template{}, err
+	}
+	t.ID = uuid.New().String()
+	t.UserID = uid
+	t.CreatedAt = storage.Now()
+	t.UpdatedAt = t.CreatedAt
+
+	if err = s.store.InsertTemplate(ctx, t); err != nil {
+		return storage.Template{}, fmt.Errorf("unable to insert template:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.InsertTemplate(ctx, t)
+	})
+	return t, nil
+}
+
+// DeleteTemplate removes template of user with provided ID.
+// Links created with template are not changed.
+func (s *service) DeleteTemplate(ctx context.Context, userID, templateID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if err = s.store.DeleteTemplate(ctx, uid, templateID); err != nil {
+		return fmt.Errorf("unable to delete template:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.DeleteTemplate(ctx, uid, templateID)
+	})
+	return nil
+}
+
+// FindTemplates returns templates of user with provided ID ordered by creation time.
+func (s *service) FindTemplates(ctx context.Context, userID string) ([]storage.Template, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	templates, err := s.store.FindTemplatesByUser(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find templates:\n%w", err)
+	}
+	return templates, nil
+}
+
+// UpdateTemplate replaces template of user with provided ID, returning updated one.
+func (s *service) UpdateTemplate(ctx context.Context, userID string, t storage.Template) (storage.Template, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return storage.Template{}, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if t, err = storage.NormalizeTemplate(t); err != nil {
+		return storage.Template{}, err
+	}
+	t.UserID = uid
+
+	if err = s.store.UpdateTemplate(ctx, t); err != nil {
+		return storage.Template{}, fmt.Errorf("unable to update template:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.UpdateTemplate(ctx, t)
+	})
+
+	templates, err := s.store.FindTemplatesByUser(ctx, uid)
+	if err != nil {
+		return storage.Template{}, fmt.Errorf("unable to find templates:\n%w", err)
+	}
+	for _, updated := range templates {
+		if updated.ID == t.ID {
+			return updated, nil
+		}
+	}
+	return storage.Template{}, fmt.Errorf("unable to find updated template:\n%w", storage.ErrNoTemplateWasFound)
+}
+
+// applyParams appends parameters absent in query of raw URL to it.
+func applyParams(rawURL string, params map[string]string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse original url:\n%w", err)
+	}
+	values := make(url.Values, len(params))
+	for k, v := range params {
+		values.Set(k, v)
+	}
+	mergeQuery(u, values)
+	return u.String(), nil
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+// tokenPrefix starts every API token, so leaked tokens are easy to recognize.
+const tokenPrefix = "shrt_"
+
+// tokenLength is a number of random bytes of API token.
+const tokenLength = 32
+
+// ErrUnknownToken is returned when API token is malformed, revoked or never existed.
+var ErrUnknownToken = errors.New("unknown API token")
+
+// AuthenticateToken returns API token with provided value.
+func (s *service) AuthenticateToken(ctx context.Context, token string) (storage.Token, error) {
+	if !strings.HasPrefix(token, tokenPrefix) ||
+		base64.RawURLEncoding.DecodedLen(len(token)-len(tokenPrefix)) != tokenLength {
+		return storage.Token{}, ErrUnknownToken
+	}
+	t, err := s.store.FindTokenByHash(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrNoTokenWasFound) {
+			return storage.Token{}, ErrUnknownToken
+		}
+		return storage.Token{}, fmt.Errorf("unable to find token:\n%w", err)
+	}
+	return t, nil
+}
+
+// CreateToken saves new API token of user with provided ID,
+// returning it along with its value. Value is not stored and can't be obtained later.
+func (s *service) CreateToken(ctx context.Context, userID string, t storage.Token) (storage.Token, string, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return storage.Token{}, "", fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if t, err = storage.NormalizeToken(t); err != nil {
+		return storage.Token{}, "", err
+	}
+	b := make([]byte, tokenLength)
+	if _, err = rand.Read(b); err != nil {
+		return storage.Token{}, "", fmt.Errorf("unable to generate token:\n%w", err)
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	t.ID = uuid.New().String()
+	t.Hash = hashToken(token)
+	t.UserID = uid
+	t.CreatedAt = storage.Now()
+
+	if err = s.store.InsertToken(ctx, t); err != nil {
+		return storage.Token{}, "", fmt.Errorf("unable to insert token:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.InsertToken(ctx, t)
+	})
+	return t, token, nil
+}
+
+// FindTokens returns API tokens of user with provided ID ordered by creation time.
+func (s *service) FindTokens(ctx context.Context, userID string) ([]storage.Token, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	tokens, err := s.store.FindTokensByUser(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find tokens:\n%w", err)
+	}
+	return tokens, nil
+}
+
+// RevokeToken removes API token of user with provided ID.
+func (s *service) RevokeToken(ctx context.Context, userID, tokenID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if err = s.store.DeleteToken(ctx, uid, tokenID); err != nil {
+		return fmt.Errorf("unable to delete token:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.DeleteToken(ctx, uid, tokenID)
+	})
+	return nil
+}
+
+// hashToken returns hex-encoded SHA-256 hash of API token.
+// Tokens are random enough for fast unsalted hash to be safe.
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
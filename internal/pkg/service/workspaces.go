@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+// ErrLastOwner is returned when the only owner of workspace
+// is about to be removed or demoted.
+var ErrLastOwner = errors.New("workspace must have at least one owner")
+
+// CreateWorkspace creates workspace owned by user with provided ID.
+func (s *service) CreateWorkspace(ctx context.Context, userID string, w storage.Workspace) (storage.Workspace, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return storage.Workspace{}, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if w, err = storage.NormalizeWorkspace(w); err != nil {
+		return storage.Workspace{}, err
+	}
+	w.ID = uuid.New()
+	w.CreatedAt = storage.Now()
+
+	if err = s.store.InsertWorkspace(ctx, w, uid); err != nil {
+		return storage.Workspace{}, fmt.Errorf("unable to insert workspace:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.InsertWorkspace(ctx, w, uid)
+	})
+	return w, nil
+}
+
+// DeleteWorkspaceURLs marks provided links of workspace as deleted
+// regardless of members who created them. Links of other workspaces are skipped.
+func (s *service) DeleteWorkspaceURLs(ctx context.Context, workspaceID string, urls []string) error {
+	wid, err := uuid.Parse(workspaceID)
+	if err != nil {
+		return fmt.Errorf("unable to parse workspace id:\n%w", err)
+	}
+
+	byCreator := make(map[uuid.UUID][]string)
+	for _, short := range urls {
+		l, err := s.store.FindLink(ctx, short)
+		if errors.Is(err, storage.ErrNoURLWasFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to find link:\n%w", err)
+		}
+		if l.WorkspaceID == wid {
+			byCreator[l.UserID] = append(byCreator[l.UserID], short)
+		}
+	}
+	for uid, shorts := range byCreator {
+		uid, shorts := uid, shorts
+		if err = s.store.DeleteManyURLs(ctx, uid, shorts); err != nil {
+			return fmt.Errorf("unable to delete urls:\n%w", err)
+		}
+		s.mirrorWrite(func(m storage.Store) error {
+			return m.DeleteManyURLs(ctx, uid, shorts)
+		})
+	}
+	return nil
+}
+
+// FindMembers returns members of workspace ordered by time they were added.
+func (s *service) FindMembers(ctx context.Context, workspaceID string) ([]storage.Member, error) {
+	wid, err := uuid.Parse(workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse workspace id:\n%w", err)
+	}
+	members, err := s.store.FindMembers(ctx, wid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find members:\n%w", err)
+	}
+	return members, nil
+}
+
+// FindRole returns role of user in workspace.
+// storage.ErrNoMemberWasFound is returned if user is not a member of it.
+func (s *service) FindRole(ctx context.Context, workspaceID, userID string) (storage.Role, error) {
+	wid, err := uuid.Parse(workspaceID)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", storage.ErrNoMemberWasFound, err)
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	m, err := s.store.FindMember(ctx, wid, uid)
+	if err != nil {
+		return 0, fmt.Errorf("unable to find member:\n%w", err)
+	}
+	return m.Role, nil
+}
+
+// FindWorkspaces returns workspaces user is a member of along with role of user.
+func (s *service) FindWorkspaces(ctx context.Context, userID string) ([]storage.Membership, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	memberships, err := s.store.FindWorkspacesByUser(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find workspaces:\n%w", err)
+	}
+	return memberships, nil
+}
+
+// IterateWorkspaceURLs calls fn for links of workspace matching provided options.
+func (s *service) IterateWorkspaceURLs(ctx context.Context, workspaceID string, opts storage.ListOptions, fn func(storage.Link) error) error {
+	wid, err := uuid.Parse(workspaceID)
+	if err != nil {
+		return fmt.Errorf("unable to parse workspace id:\n%w", err)
+	}
+	if err = s.store.IterateWorkspaceLinks(ctx, wid, opts, fn); err != nil {
+		return fmt.Errorf("unable to iterate workspace urls:\n%w", err)
+	}
+	return nil
+}
+
+// RemoveMember removes user from workspace. The last owner can't be removed.
+func (s *service) RemoveMember(ctx context.Context, workspaceID, userID string) error {
+	wid, uid, err := s.checkOwners(ctx, workspaceID, userID, storage.RoleViewer)
+	if err != nil {
+		return err
+	}
+	if err = s.store.DeleteMember(ctx, wid, uid); err != nil {
+		return fmt.Errorf("unable to delete member:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.DeleteMember(ctx, wid, uid)
+	})
+	return nil
+}
+
+// SetMember adds user to workspace or changes role of existing member.
+// The last owner can't be demoted.
+func (s *service) SetMember(ctx context.Context, workspaceID, userID string, role storage.Role) error {
+	if _, err := role.MarshalText(); err != nil {
+		return err
+	}
+	wid, uid, err := s.checkOwners(ctx, workspaceID, userID, role)
+	if err != nil {
+		return err
+	}
+	member := storage.Member{
+		AddedAt:     storage.Now(),
+		WorkspaceID: wid,
+		UserID:      uid,
+		Role:        role,
+	}
+	if err = s.store.SetMember(ctx, member); err != nil {
+		return fmt.Errorf("unable to set member:\n%w", err)
+	}
+	s.mirrorWrite(func(m storage.Store) error {
+		return m.SetMember(ctx, member)
+	})
+	return nil
+}
+
+// UpdateWorkspaceURL changes original URL of workspace link
+// regardless of member who created it.
+func (s *service) UpdateWorkspaceURL(ctx context.Context, workspaceID, shortPath, originalURL string) (storage.Link, error) {
+	wid, err := uuid.Parse(workspaceID)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("unable to parse workspace id:\n%w", err)
+	}
+	l, err := s.store.FindLink(ctx, shortPath)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("unable to find link:\n%w", err)
+	}
+	if l.WorkspaceID != wid {
+		return storage.Link{}, fmt.Errorf("unable to find link:\n%w", storage.ErrNoURLWasFound)
+	}
+	return s.updateOriginalURL(ctx, l.UserID, shortPath, originalURL)
+}
+
+// checkPersonal makes sure link doesn't belong to workspace. Links of workspaces
+// are changed through workspace API only, where current role of member is checked,
+// so their creators lose access to them once they are removed or demoted.
+func (s *service) checkPersonal(ctx context.Context, shortPath string) error {
+	l, err := s.store.FindLink(ctx, shortPath)
+	if err != nil {
+		return err
+	}
+	if l.WorkspaceID != uuid.Nil {
+		return storage.ErrNoURLWasFound
+	}
+	return nil
+}
+
+// personalURLs returns provided URLs that don't belong to any workspace.
+// Unknown URLs are skipped.
+func (s *service) personalURLs(ctx context.Context, urls []string) ([]string, error) {
+	personal := make([]string, 0, len(urls))
+	for _, short := range urls {
+		err := s.checkPersonal(ctx, short)
+		if errors.Is(err, storage.ErrNoURLWasFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to find link:\n%w", err)
+		}
+		personal = append(personal, short)
+	}
+	return personal, nil
+}
+
+// checkOwners parses IDs of workspace and user and makes sure
+// workspace keeps an owner once user gets provided role in it.
+func (s *service) checkOwners(ctx context.Context, workspaceID, userID string, role storage.Role) (uuid.UUID, uuid.UUID, error) {
+	wid, err := uuid.Parse(workspaceID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("unable to parse workspace id:\n%w", err)
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	if role == storage.RoleOwner {
+		return wid, uid, nil
+	}
+	members, err := s.store.FindMembers(ctx, wid)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("unable to find members:\n%w", err)
+	}
+	owners, isOwner := 0, false
+	for _, m := range members {
+		if m.Role == storage.RoleOwner {
+			owners++
+			isOwner = isOwner || m.UserID == uid
+		}
+	}
+	if isOwner && owners == 1 {
+		return uuid.Nil, uuid.Nil, ErrLastOwner
+	}
+	return wid, uid, nil
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// MinLoginLength is a minimum length of account login.
+	MinLoginLength = 3
+	// MaxLoginLength is a maximum length of account login.
+	MaxLoginLength = 64
+)
+
+var (
+	ErrAccountExists     = errors.New("account with this login already exists")
+	ErrInvalidLogin      = errors.New("invalid login")
+	ErrNoAccountWasFound = errors.New("no account was found")
+)
+
+// Account is a registered user. ID of account is ID of user owning links,
+// so links created before registration stay with the account.
+type Account struct {
+	CreatedAt    time.Time `json:"created_at"`
+	Login        string    `json:"login"`
+	PasswordHash string    `json:"password_hash"`
+	ID           uuid.UUID `json:"id"`
+}
+
+// NormalizeLogin trims and lowercases login. Login must contain
+// MinLoginLength to MaxLoginLength latin letters, digits, '.', '_', '-' or '@'.
+func NormalizeLogin(login string) (string, error) {
+	login = strings.ToLower(strings.TrimSpace(login))
+	if len(login) < MinLoginLength || len(login) > MaxLoginLength {
+		return "", fmt.Errorf("%w: login must contain %d to %d characters", ErrInvalidLogin, MinLoginLength, MaxLoginLength)
+	}
+	for _, r := range login {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && !strings.ContainsRune("._-@", r) {
+			return "", fmt.Errorf("%w: login must contain only latin letters, digits, '.', '_', '-' or '@'", ErrInvalidLogin)
+		}
+	}
+	return login, nil
+}
+
+// accountStore keeps accounts of file storages in memory
+// and, if path is provided, in a JSON file.
+type accountStore struct {
+	accounts map[uuid.UUID]Account
+	path     string
+	mu       sync.RWMutex
+}
+
+func newAccountStore(path string) (*accountStore, error) {
+	s := &accountStore{
+		accounts: make(map[uuid.UUID]Account),
+		path:     path,
+	}
+	if err := readSidecar(path, &s.accounts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FindAccount returns account with provided ID.
+func (s *accountStore) FindAccount(ctx context.Context, id uuid.UUID) (Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.accounts[id]
+	if !ok {
+		return Account{}, ErrNoAccountWasFound
+	}
+	return a, nil
+}
+
+// FindAccountByLogin returns account with provided login.
+func (s *accountStore) FindAccountByLogin(ctx context.Context, login string) (Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, a := range s.accounts {
+		if a.Login == login {
+			return a, nil
+		}
+	}
+	return Account{}, ErrNoAccountWasFound
+}
+
+// IterateAccounts calls fn for every account ordered by ID,
+// stopping at the first error returned by fn.
+func (s *accountStore) IterateAccounts(ctx context.Context, fn func(Account) error) error {
+	s.mu.RLock()
+	accounts := make([]Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].ID.String() < accounts[j].ID.String()
+	})
+	for _, a := range accounts {
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertAccount saves new account. Both ID and login of account must be unique.
+func (s *accountStore) InsertAccount(ctx context.Context, a Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.accounts {
+		if v.Login == a.Login || v.ID == a.ID {
+			return ErrAccountExists
+		}
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = Now()
+	}
+	s.accounts[a.ID] = a
+	if err := writeSidecar(s.path, s.accounts); err != nil {
+		delete(s.accounts, a.ID)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeLogin(t *testing.T) {
+	tests := []struct {
+		login   string
+		want    string
+		wantErr bool
+	}{
+		{login: " Serj.Yuriev@Mail.ru ", want: "serj.yuriev@mail.ru"},
+		{login: "bob_42-x", want: "bob_42-x"},
+		{login: "ab", wantErr: true},
+		{login: "with space", wantErr: true},
+		{login: "кирилл", wantErr: true},
+		{login: string(make([]byte, MaxLoginLength+1)), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.login, func(t *testing.T) {
+			got, err := NormalizeLogin(tt.login)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidLogin)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_fileStore_Accounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shorten.json")
+	s, err := NewFileStore(path)
+	require.NoError(t, err)
+	testAccounts(t, s)
+	assert.FileExists(t, filepath.Join(filepath.Dir(path), "shorten_accounts.json"))
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+	a, err := reopened.FindAccountByLogin(context.Background(), "serj")
+	require.NoError(t, err)
+	assert.Equal(t, testAccountUser, a.ID)
+	urls, err := reopened.FindURLsByUser(context.Background(), testAccountUser)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+}
+
+func Test_fileArrayStore_Accounts(t *testing.T) {
+	s, err := NewFileArrayStore("")
+	require.NoError(t, err)
+	testAccounts(t, s)
+}
+
+var testAccountUser = uuid.MustParse("2d7c5a1e-8b4f-4e6a-9c3d-1f2e3a4b5c6d")
+
+// testAccounts checks accounts management and reassignment
+// of links common to all storages.
+func testAccounts(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	anonymous := uuid.New()
+	stranger := uuid.New()
+
+	_, err := s.FindAccount(ctx, testAccountUser)
+	assert.ErrorIs(t, err, ErrNoAccountWasFound)
+	require.NoError(t, s.InsertAccount(ctx, Account{ID: testAccountUser, Login: "serj", PasswordHash: "hash"}))
+	assert.ErrorIs(t, s.InsertAccount(ctx, Account{ID: uuid.New(), Login: "serj", PasswordHash: "hash"}), ErrAccountExists)
+	assert.ErrorIs(t, s.InsertAccount(ctx, Account{ID: testAccountUser, Login: "other", PasswordHash: "hash"}), ErrAccountExists)
+
+	a, err := s.FindAccountByLogin(ctx, "serj")
+	require.NoError(t, err)
+	assert.Equal(t, testAccountUser, a.ID)
+	assert.Equal(t, "hash", a.PasswordHash)
+	assert.False(t, a.CreatedAt.IsZero())
+	a, err = s.FindAccount(ctx, testAccountUser)
+	require.NoError(t, err)
+	assert.Equal(t, "serj", a.Login)
+	_, err = s.FindAccountByLogin(ctx, "nobody")
+	assert.ErrorIs(t, err, ErrNoAccountWasFound)
+	var accounts []Account
+	require.NoError(t, s.IterateAccounts(ctx, func(a Account) error {
+		accounts = append(accounts, a)
+		return nil
+	}))
+	require.Len(t, accounts, 1)
+	assert.Equal(t, "serj", accounts[0].Login)
+
+	require.NoError(t, s.InsertLinks(ctx, []Link{
+		{ShortPath: "acc1", OriginalURL: "https://account.test/1", UserID: testAccountUser},
+		{ShortPath: "anon1", OriginalURL: "https://anonymous.test/1", UserID: anonymous},
+		{ShortPath: "anonws", OriginalURL: "https://anonymous.test/ws", UserID: anonymous, WorkspaceID: uuid.New()},
+		{ShortPath: "other1", OriginalURL: "https://stranger.test/1", UserID: stranger},
+	}))
+	n, err := s.ReassignURLs(ctx, anonymous, testAccountUser)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = s.ReassignURLs(ctx, anonymous, testAccountUser)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	urls, err := s.FindURLsByUser(ctx, testAccountUser)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"acc1": "https://account.test/1", "anon1": "https://anonymous.test/1"}, urls)
+	urls, err = s.FindURLsByUser(ctx, stranger)
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+	urls, err = s.FindURLsByUser(ctx, anonymous)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"anonws": "https://anonymous.test/ws"}, urls, "workspace links stay with their creators")
+}
@@ -7,6 +7,9 @@ import (
 	"io"
 	"log"
 	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -17,8 +20,13 @@ type arrayLink struct {
 }
 
 type fileArrayStore struct {
+	*accountStore
+	*templateStore
+	*tokenStore
+	*workspaceStore
 	URLs            []arrayLink
 	fileStoragePath string
+	mu              sync.RWMutex
 	useFileStorage  bool
 }
 
@@ -28,6 +36,26 @@ func NewFileArrayStore(fileStoragePath string) (Store, error) {
 		fileStoragePath: fileStoragePath,
 		useFileStorage:  fileStoragePath != "",
 	}
+	accounts, err := newAccountStore(sidecarPath(fileStoragePath, "accounts"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load accounts from file: %w", err)
+	}
+	s.accountStore = accounts
+	templates, err := newTemplateStore(sidecarPath(fileStoragePath, "templates"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load templates from file: %w", err)
+	}
+	s.templateStore = templates
+	tokens, err := newTokenStore(sidecarPath(fileStoragePath, "tokens"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load tokens from file: %w", err)
+	}
+	s.tokenStore = tokens
+	workspaces, err := newWorkspaceStore(sidecarPath(fileStoragePath, "workspaces"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load workspaces from file: %w", err)
+	}
+	s.workspaceStore = workspaces
 	if s.useFileStorage {
 		if err := s.loadDataFromFile(); err != nil {
 			return nil, fmt.Errorf("unable to load data from file: %w", err)
@@ -38,25 +66,118 @@ func NewFileArrayStore(fileStoragePath string) (Store, error) {
 	return s, nil
 }
 
-// DeleteManyURLs removes provided URLs from file.
+// ConsumeUse decrements number of remaining uses of not deleted link
+// with limited number of uses, returning its original URL.
+func (s *fileArrayStore) ConsumeUse(ctx context.Context, shortPath string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.URLs {
+		if v.Shortened != shortPath {
+			continue
+		}
+		if v.IsDeleted {
+			return "", ErrShortenedDeleted
+		}
+		if v.MaxUses == 0 || v.RemainingUses <= 0 {
+			return "", ErrLinkExhausted
+		}
+		s.URLs[i].RemainingUses--
+		if s.useFileStorage {
+			if err := s.writeDataToFile(); err != nil {
+				s.URLs[i].RemainingUses++
+				return "", err
+			}
+		}
+		return v.Original, nil
+	}
+	return "", ErrNoURLWasFound
+}
+
+// CountActiveLinks returns number of not deleted links added by user.
+func (s *fileArrayStore) CountActiveLinks(ctx context.Context, userID uuid.UUID) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, v := range s.URLs {
+		if v.User == userID && !v.IsDeleted {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// CountLinksByUser returns number of links added by every user.
+func (s *fileArrayStore) CountLinksByUser(ctx context.Context) ([]UserLinkCount, error) {
+	s.mu.RLock()
+	links := make([]Link, 0, len(s.URLs))
+	for _, v := range s.URLs {
+		links = append(links, v.toLink(v.Shortened))
+	}
+	s.mu.RUnlock()
+
+	return countLinks(links), nil
+}
+
+// DeleteManyURLs marks provided URLs added by user as deleted.
 func (s *fileArrayStore) DeleteManyURLs(ctx context.Context, userID uuid.UUID, urls []string) error {
-	return ErrNotImplementedYet
+	_, err := s.setDeleted(userID, urls, true, time.Time{})
+	return err
 }
 
 // FindByOriginalURL searches for short URL with corresponding original URL.
 func (s *fileArrayStore) FindByOriginalURL(ctx context.Context, originalURL string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	isDeleted := false
 	for _, v := range s.URLs {
 		if v.Original == originalURL {
-			return v.Shortened, nil
+			if !v.IsDeleted {
+				return v.Shortened, nil
+			}
+			isDeleted = true
 		}
 	}
+	if isDeleted {
+		return "", ErrShortenedDeleted
+	}
 	return "", ErrNoURLWasFound
 }
 
+// FindLink returns full information about link with provided short URL,
+// including deleted ones.
+func (s *fileArrayStore) FindLink(ctx context.Context, shortPath string) (Link, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.URLs {
+		if v.Shortened == shortPath {
+			return v.toLink(v.Shortened), nil
+		}
+	}
+	return Link{}, ErrNoURLWasFound
+}
+
+// FindLinkHistory returns previous original URLs of link
+// with provided short URL, oldest first.
+func (s *fileArrayStore) FindLinkHistory(ctx context.Context, shortPath string) ([]LinkRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.URLs {
+		if v.Shortened == shortPath {
+			return append([]LinkRevision{}, v.History...), nil
+		}
+	}
+	return nil, ErrNoURLWasFound
+}
+
 // FindOriginalURL searches for original URL with corresponding short URL.
 func (s *fileArrayStore) FindOriginalURL(ctx context.Context, shortPath string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	for _, v := range s.URLs {
 		if v.Shortened == shortPath {
+			if v.IsDeleted {
+				return "", ErrShortenedDeleted
+			}
 			return v.Original, nil
 		}
 	}
@@ -65,9 +186,11 @@ func (s *fileArrayStore) FindOriginalURL(ctx context.Context, shortPath string)
 
 // FindURLsByUser returns all URLs from application storage that were added by user with provided ID.
 func (s *fileArrayStore) FindURLsByUser(ctx context.Context, userID uuid.UUID) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	userURLs := make(map[string]string)
 	for _, v := range s.URLs {
-		if v.User == userID {
+		if v.User == userID && !v.IsDeleted {
 			userURLs[v.Shortened] = v.Original
 		}
 	}
@@ -77,60 +200,362 @@ func (s *fileArrayStore) FindURLsByUser(ctx context.Context, userID uuid.UUID) (
 	return userURLs, nil
 }
 
-// InsertManyURLs writes provided short URL - original URL pairs into a file.
-func (s *fileArrayStore) InsertManyURLs(ctx context.Context, userID uuid.UUID, urls map[string]string) error {
-	links := make([]arrayLink, 0)
-	for k, v := range urls {
-		links = append(
-			links,
-			arrayLink{
-				Shortened: k,
-				link: link{
-					Original: v,
-					User:     userID,
-				},
-			},
-		)
-	}
-	oldURLs := s.URLs
-	s.URLs = append(s.URLs, links...)
+// FindTagsByUser returns tags of not deleted links added by user
+// along with number of links marked with each of them.
+func (s *fileArrayStore) FindTagsByUser(ctx context.Context, userID uuid.UUID) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	links := make([]Link, 0)
+	for _, v := range s.URLs {
+		if v.User == userID && !v.IsDeleted {
+			links = append(links, Link{Tags: v.Tags})
+		}
+	}
+	return countTags(links), nil
+}
+
+// InsertLinks writes provided links into a file.
+func (s *fileArrayStore) InsertLinks(ctx context.Context, links []Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldLen := len(s.URLs)
+	for _, l := range withTimestamps(links) {
+		s.URLs = append(s.URLs, arrayLink{
+			Shortened: l.ShortPath,
+			link:      newLink(l),
+		})
+	}
 	if s.useFileStorage {
 		if err := s.writeDataToFile(); err != nil {
-			s.URLs = oldURLs
+			s.URLs = s.URLs[:oldLen]
 			return err
 		}
 	}
 	return nil
 }
 
+// InsertManyURLs writes provided short URL - original URL pairs into a file.
+func (s *fileArrayStore) InsertManyURLs(ctx context.Context, userID uuid.UUID, urls map[string]string) error {
+	return s.InsertLinks(ctx, pairsToLinks(userID, urls))
+}
+
 // InsertNewURLPair writes provided short URL - original URL pair into a file.
 func (s *fileArrayStore) InsertNewURLPair(ctx context.Context, userID uuid.UUID, shortPath, originalURL string) error {
-	newLink := arrayLink{
-		Shortened: shortPath,
-		link: link{
-			Original: originalURL,
-			User:     userID,
-		},
-	}
-	s.URLs = append(s.URLs, newLink)
+	return s.InsertLinks(ctx, []Link{{
+		ShortPath:   shortPath,
+		OriginalURL: originalURL,
+		UserID:      userID,
+	}})
+}
+
+// IterateLinks calls fn for every stored link ordered by short URL,
+// stopping at the first error returned by fn.
+func (s *fileArrayStore) IterateLinks(ctx context.Context, fn func(Link) error) error {
+	s.mu.RLock()
+	links := make([]Link, 0, len(s.URLs))
+	for _, v := range s.URLs {
+		links = append(links, v.toLink(v.Shortened))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].ShortPath < links[j].ShortPath
+	})
+	for _, l := range links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IterateAllLinks calls fn for links of all users matching options
+// ordered by creation time, stopping at the first error returned by fn.
+func (s *fileArrayStore) IterateAllLinks(ctx context.Context, opts ListOptions, fn func(Link) error) error {
+	s.mu.RLock()
+	links := make([]Link, 0, len(s.URLs))
+	for _, v := range s.URLs {
+		links = append(links, v.toLink(v.Shortened))
+	}
+	s.mu.RUnlock()
+
+	return iterateSorted(ctx, links, opts, fn)
+}
+
+// IterateUserLinks calls fn for links added by user matching options
+// ordered by creation time, stopping at the first error returned by fn.
+func (s *fileArrayStore) IterateUserLinks(ctx context.Context, userID uuid.UUID, opts ListOptions, fn func(Link) error) error {
+	s.mu.RLock()
+	links := make([]Link, 0)
+	for _, v := range s.URLs {
+		if v.User == userID {
+			links = append(links, v.toLink(v.Shortened))
+		}
+	}
+	s.mu.RUnlock()
+
+	return iterateSorted(ctx, links, opts, fn)
+}
+
+// IterateWorkspaceLinks calls fn for links of workspace matching options
+// ordered by creation time, stopping at the first error returned by fn.
+func (s *fileArrayStore) IterateWorkspaceLinks(ctx context.Context, workspaceID uuid.UUID, opts ListOptions, fn func(Link) error) error {
+	s.mu.RLock()
+	links := make([]Link, 0)
+	for _, v := range s.URLs {
+		if v.Workspace == workspaceID && workspaceID != uuid.Nil {
+			links = append(links, v.toLink(v.Shortened))
+		}
+	}
+	s.mu.RUnlock()
+
+	return iterateSorted(ctx, links, opts, fn)
+}
+
+// Ping does nothing.
+func (s *fileArrayStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// PurgeDeletedURLs permanently removes links deleted before provided time,
+// returning number of removed links.
+func (s *fileArrayStore) PurgeDeletedURLs(ctx context.Context, deletedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := make([]arrayLink, 0, len(s.URLs))
+	for _, v := range s.URLs {
+		if !v.IsDeleted || !v.Deleted.Before(deletedBefore) {
+			kept = append(kept, v)
+		}
+	}
+	purged := len(s.URLs) - len(kept)
+	if purged == 0 {
+		return 0, nil
+	}
+	prev := s.URLs
+	s.URLs = kept
 	if s.useFileStorage {
 		if err := s.writeDataToFile(); err != nil {
-			if len(s.URLs) == 1 {
-				s.URLs = make([]arrayLink, 0)
-			} else {
-				s.URLs = s.URLs[:len(s.URLs)-2]
+			s.URLs = prev
+			return 0, err
+		}
+	}
+	return purged, nil
+}
+
+// ReassignURLs makes user "to" the owner of all links added by user "from"
+// that don't belong to workspaces, returning number of reassigned links.
+func (s *fileArrayStore) ReassignURLs(ctx context.Context, from, to uuid.UUID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var moved []int
+	for i, v := range s.URLs {
+		if v.User == from && v.Workspace == uuid.Nil {
+			s.URLs[i].User = to
+			moved = append(moved, i)
+		}
+	}
+	if s.useFileStorage && len(moved) > 0 {
+		if err := s.writeDataToFile(); err != nil {
+			for _, i := range moved {
+				s.URLs[i].User = from
 			}
+			return 0, err
+		}
+	}
+	return len(moved), nil
+}
+
+// RestoreManyURLs removes deletion mark from provided URLs added by user
+// that were deleted at or after deletedAfter, returning restored ones.
+// Zero deletedAfter allows to restore links regardless of deletion time.
+func (s *fileArrayStore) RestoreManyURLs(ctx context.Context, userID uuid.UUID, urls []string, deletedAfter time.Time) ([]string, error) {
+	return s.setDeleted(userID, urls, false, deletedAfter)
+}
+
+// ReplaceLink replaces every field of existing link with the same short URL
+// regardless of user who added it, keeping history of its original URLs.
+// Original URL must not be used by other links.
+func (s *fileArrayStore) ReplaceLink(ctx context.Context, l Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := -1
+	for i, v := range s.URLs {
+		if v.Shortened == l.ShortPath {
+			idx = i
+		} else if v.Original == l.OriginalURL {
+			return ErrNotUniqueOriginalURL
+		}
+	}
+	if idx == -1 {
+		return ErrNoURLWasFound
+	}
+	prev := s.URLs[idx].link
+	replaced := newLink(withTimestamps([]Link{l})[0])
+	replaced.History = prev.History
+	s.URLs[idx].link = replaced
+	if s.useFileStorage {
+		if err := s.writeDataToFile(); err != nil {
+			s.URLs[idx].link = prev
 			return err
 		}
 	}
 	return nil
 }
 
-// Ping does nothing.
-func (s *fileArrayStore) Ping(ctx context.Context) error {
+// SetLinkHistory replaces previous original URLs of link
+// with provided short URL.
+func (s *fileArrayStore) SetLinkHistory(ctx context.Context, shortPath string, history []LinkRevision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.URLs {
+		if v.Shortened != shortPath {
+			continue
+		}
+		prev := v.History
+		s.URLs[i].History = append([]LinkRevision{}, history...)
+		if s.useFileStorage {
+			if err := s.writeDataToFile(); err != nil {
+				s.URLs[i].History = prev
+				return err
+			}
+		}
+		return nil
+	}
+	return ErrNoURLWasFound
+}
+
+// SetDisabled sets or removes disabled mark of link with provided short URL
+// regardless of user who added it.
+func (s *fileArrayStore) SetDisabled(ctx context.Context, shortPath string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.URLs {
+		if v.Shortened != shortPath {
+			continue
+		}
+		s.URLs[i].IsDisabled = disabled
+		if s.useFileStorage {
+			if err := s.writeDataToFile(); err != nil {
+				s.URLs[i].IsDisabled = v.IsDisabled
+				return err
+			}
+		}
+		return nil
+	}
+	return ErrNoURLWasFound
+}
+
+// SetTags replaces tags of link with provided short URL added by user.
+func (s *fileArrayStore) SetTags(ctx context.Context, userID uuid.UUID, shortPath string, tags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.URLs {
+		if v.Shortened != shortPath {
+			continue
+		}
+		if v.User != userID {
+			return ErrNoURLWasFound
+		}
+		prev := v.link
+		s.URLs[i].Tags = sortedTags(tags)
+		s.URLs[i].Updated = Now()
+		if s.useFileStorage {
+			if err := s.writeDataToFile(); err != nil {
+				s.URLs[i].link = prev
+				return err
+			}
+		}
+		return nil
+	}
+	return ErrNoURLWasFound
+}
+
+// UpdateOriginalURL changes original URL of not deleted link
+// with provided short URL added by user, keeping the previous one in its history.
+// Original URL must not be used by other links.
+func (s *fileArrayStore) UpdateOriginalURL(ctx context.Context, userID uuid.UUID, shortPath, originalURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := -1
+	for i, v := range s.URLs {
+		if v.Shortened == shortPath {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 || s.URLs[idx].User != userID {
+		return ErrNoURLWasFound
+	}
+	l := s.URLs[idx].link
+	if l.IsDeleted {
+		return ErrShortenedDeleted
+	}
+	if l.Original == originalURL {
+		return nil
+	}
+	for _, v := range s.URLs {
+		if v.Original == originalURL {
+			return ErrNotUniqueOriginalURL
+		}
+	}
+	prev := l
+	l.Updated = Now()
+	l.History = append(l.History[:len(l.History):len(l.History)], LinkRevision{
+		ReplacedAt:  l.Updated,
+		OriginalURL: l.Original,
+	})
+	l.Original = originalURL
+	s.URLs[idx].link = l
+	if s.useFileStorage {
+		if err := s.writeDataToFile(); err != nil {
+			s.URLs[idx].link = prev
+			return err
+		}
+	}
 	return nil
 }
 
+func (s *fileArrayStore) setDeleted(userID uuid.UUID, urls []string, isDeleted bool, deletedAfter time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	toChange := make(map[string]bool, len(urls))
+	for _, short := range urls {
+		toChange[short] = true
+	}
+	prev := make(map[int]link, len(urls))
+	changed := make([]string, 0, len(urls))
+	updated := Now()
+	for i, v := range s.URLs {
+		if !toChange[v.Shortened] || v.User != userID || v.IsDeleted == isDeleted {
+			continue
+		}
+		if !isDeleted && !canRestore(v.Deleted, deletedAfter) {
+			continue
+		}
+		prev[i] = v.link
+		changed = append(changed, v.Shortened)
+		s.URLs[i].IsDeleted = isDeleted
+		s.URLs[i].Updated = updated
+		s.URLs[i].Deleted = time.Time{}
+		if isDeleted {
+			s.URLs[i].Deleted = updated
+		}
+	}
+	if s.useFileStorage && len(prev) > 0 {
+		if err := s.writeDataToFile(); err != nil {
+			for i, l := range prev {
+				s.URLs[i].link = l
+			}
+			return nil, err
+		}
+	}
+	return changed, nil
+}
+
 func (s *fileArrayStore) loadDataFromFile() error {
 	file, err := os.OpenFile(s.fileStoragePath, os.O_RDONLY|os.O_CREATE, 0777)
 	if err != nil {
@@ -156,7 +581,7 @@ func (s *fileArrayStore) loadDataFromFile() error {
 }
 
 func (s *fileArrayStore) writeDataToFile() error {
-	file, err := os.OpenFile(s.fileStoragePath, os.O_WRONLY|os.O_CREATE, 0777)
+	file, err := os.OpenFile(s.fileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
 	if err != nil {
 		log.Printf("unable to open file %s: %v\n", s.fileStoragePath, err)
 		return err
@@ -7,18 +7,62 @@ import (
 	"io"
 	"log"
 	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type link struct {
-	Original string
-	User     uuid.UUID
+	Created       time.Time
+	Updated       time.Time
+	Deleted       time.Time
+	Original      string
+	CreatorIP     string         `json:",omitempty"`
+	CreatorUAHash string         `json:",omitempty"`
+	PasswordHash  string         `json:",omitempty"`
+	Tags          []string       `json:",omitempty"`
+	History       []LinkRevision `json:",omitempty"`
+	MaxUses       int            `json:",omitempty"`
+	RemainingUses int            `json:",omitempty"`
+	RedirectCode  int            `json:",omitempty"`
+	Passthrough   Passthrough    `json:",omitempty"`
+	User          uuid.UUID
+	Workspace     uuid.UUID
+	IsDeleted     bool
+	IsDisabled    bool `json:",omitempty"`
+}
+
+func newLink(l Link) link {
+	return link{
+		Created:       l.CreatedAt,
+		Updated:       l.UpdatedAt,
+		Deleted:       l.DeletedAt,
+		Original:      l.OriginalURL,
+		CreatorIP:     l.CreatorIP,
+		CreatorUAHash: l.CreatorUAHash,
+		PasswordHash:  l.PasswordHash,
+		Tags:          sortedTags(l.Tags),
+		MaxUses:       l.MaxUses,
+		RemainingUses: l.RemainingUses,
+		RedirectCode:  l.RedirectCode,
+		Passthrough:   l.Passthrough,
+		User:          l.UserID,
+		Workspace:     l.WorkspaceID,
+		IsDeleted:     l.IsDeleted,
+		IsDisabled:    l.IsDisabled,
+	}
 }
 
 type fileStore struct {
+	*accountStore
+	*templateStore
+	*tokenStore
+	*workspaceStore
 	URLs            map[string]link
 	fileStoragePath string
+	mu              sync.RWMutex
 	useFileStorage  bool
 }
 
@@ -28,6 +72,26 @@ func NewFileStore(fileStoragePath string) (Store, error) {
 		fileStoragePath: fileStoragePath,
 		useFileStorage:  fileStoragePath != "",
 	}
+	accounts, err := newAccountStore(sidecarPath(fileStoragePath, "accounts"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load accounts from file: %w", err)
+	}
+	s.accountStore = accounts
+	templates, err := newTemplateStore(sidecarPath(fileStoragePath, "templates"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load templates from file: %w", err)
+	}
+	s.templateStore = templates
+	tokens, err := newTokenStore(sidecarPath(fileStoragePath, "tokens"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load tokens from file: %w", err)
+	}
+	s.tokenStore = tokens
+	workspaces, err := newWorkspaceStore(sidecarPath(fileStoragePath, "workspaces"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load workspaces from file: %w", err)
+	}
+	s.workspaceStore = workspaces
 	if s.useFileStorage {
 		if err := s.loadDataFromFile(); err != nil {
 			// log.Printf("unable to load data from file: %v\n", err)
@@ -39,35 +103,128 @@ func NewFileStore(fileStoragePath string) (Store, error) {
 	return s, nil
 }
 
-// DeleteManyURLs removes provided URLs from file.
+// ConsumeUse decrements number of remaining uses of not deleted link
+// with limited number of uses, returning its original URL.
+func (s *fileStore) ConsumeUse(ctx context.Context, shortPath string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.URLs[shortPath]
+	if !ok {
+		return "", ErrNoURLWasFound
+	}
+	if l.IsDeleted {
+		return "", ErrShortenedDeleted
+	}
+	if l.MaxUses == 0 || l.RemainingUses <= 0 {
+		return "", ErrLinkExhausted
+	}
+	prev := l
+	l.RemainingUses--
+	s.URLs[shortPath] = l
+	if s.useFileStorage {
+		if err := s.writeDataToFile(); err != nil {
+			s.URLs[shortPath] = prev
+			return "", err
+		}
+	}
+	return l.Original, nil
+}
+
+// CountActiveLinks returns number of not deleted links added by user.
+func (s *fileStore) CountActiveLinks(ctx context.Context, userID uuid.UUID) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, v := range s.URLs {
+		if v.User == userID && !v.IsDeleted {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// CountLinksByUser returns number of links added by every user.
+func (s *fileStore) CountLinksByUser(ctx context.Context) ([]UserLinkCount, error) {
+	s.mu.RLock()
+	links := make([]Link, 0, len(s.URLs))
+	for k, v := range s.URLs {
+		links = append(links, v.toLink(k))
+	}
+	s.mu.RUnlock()
+
+	return countLinks(links), nil
+}
+
+// DeleteManyURLs marks provided URLs added by user as deleted.
 func (s *fileStore) DeleteManyURLs(ctx context.Context, userID uuid.UUID, urls []string) error {
-	return ErrNotImplementedYet
+	_, err := s.setDeleted(userID, urls, true, time.Time{})
+	return err
 }
 
 // FindByOriginalURL searches for short URL with corresponding original URL.
 func (s *fileStore) FindByOriginalURL(ctx context.Context, originalURL string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	isDeleted := false
 	for k, v := range s.URLs {
 		if v.Original == originalURL {
-			return k, nil
+			if !v.IsDeleted {
+				return k, nil
+			}
+			isDeleted = true
 		}
 	}
+	if isDeleted {
+		return "", ErrShortenedDeleted
+	}
 	return "", ErrNoURLWasFound
 }
 
+// FindLink returns full information about link with provided short URL,
+// including deleted ones.
+func (s *fileStore) FindLink(ctx context.Context, shortPath string) (Link, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.URLs[shortPath]
+	if !ok {
+		return Link{}, ErrNoURLWasFound
+	}
+	return l.toLink(shortPath), nil
+}
+
+// FindLinkHistory returns previous original URLs of link
+// with provided short URL, oldest first.
+func (s *fileStore) FindLinkHistory(ctx context.Context, shortPath string) ([]LinkRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.URLs[shortPath]
+	if !ok {
+		return nil, ErrNoURLWasFound
+	}
+	return append([]LinkRevision{}, l.History...), nil
+}
+
 // FindOriginalURL searches for original URL with corresponding short URL.
 func (s *fileStore) FindOriginalURL(ctx context.Context, shortPath string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	l, ok := s.URLs[shortPath]
 	if !ok {
 		return "", ErrNoURLWasFound
 	}
+	if l.IsDeleted {
+		return "", ErrShortenedDeleted
+	}
 	return l.Original, nil
 }
 
 // FindURLsByUser returns all URLs from application storage that were added by user with provided ID.
 func (s *fileStore) FindURLsByUser(ctx context.Context, userID uuid.UUID) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	userURLs := make(map[string]string)
 	for k, v := range s.URLs {
-		if v.User == userID {
+		if v.User == userID && !v.IsDeleted {
 			userURLs[k] = v.Original
 		}
 	}
@@ -77,50 +234,352 @@ func (s *fileStore) FindURLsByUser(ctx context.Context, userID uuid.UUID) (map[s
 	return userURLs, nil
 }
 
-// InsertManyURLs writes provided short URL - original URL pairs into a file.
-func (s *fileStore) InsertManyURLs(ctx context.Context, userID uuid.UUID, urls map[string]string) error {
-	oldMap := make(map[string]link)
-	for v, k := range s.URLs {
-		oldMap[v] = k
+// FindTagsByUser returns tags of not deleted links added by user
+// along with number of links marked with each of them.
+func (s *fileStore) FindTagsByUser(ctx context.Context, userID uuid.UUID) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	links := make([]Link, 0)
+	for _, v := range s.URLs {
+		if v.User == userID && !v.IsDeleted {
+			links = append(links, Link{Tags: v.Tags})
+		}
 	}
+	return countTags(links), nil
+}
 
-	for short, long := range urls {
-		newLink := link{
-			Original: long,
-			User:     userID,
+// InsertLinks writes provided links into a file.
+func (s *fileStore) InsertLinks(ctx context.Context, links []Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := make(map[string]link, len(links))
+	for _, l := range withTimestamps(links) {
+		if old, ok := s.URLs[l.ShortPath]; ok {
+			prev[l.ShortPath] = old
 		}
-		s.URLs[short] = newLink
+		s.URLs[l.ShortPath] = newLink(l)
 	}
 	if s.useFileStorage {
 		if err := s.writeDataToFile(); err != nil {
-			s.URLs = oldMap
+			for _, l := range links {
+				if old, ok := prev[l.ShortPath]; ok {
+					s.URLs[l.ShortPath] = old
+				} else {
+					delete(s.URLs, l.ShortPath)
+				}
+			}
 			return err
 		}
 	}
 	return nil
 }
 
+// InsertManyURLs writes provided short URL - original URL pairs into a file.
+func (s *fileStore) InsertManyURLs(ctx context.Context, userID uuid.UUID, urls map[string]string) error {
+	return s.InsertLinks(ctx, pairsToLinks(userID, urls))
+}
+
 // InsertNewURLPair writes provided short URL - original URL pair into a file.
 func (s *fileStore) InsertNewURLPair(ctx context.Context, userID uuid.UUID, shortPath, originalURL string) error {
-	newLink := link{
-		Original: originalURL,
-		User:     userID,
+	return s.InsertLinks(ctx, []Link{{
+		ShortPath:   shortPath,
+		OriginalURL: originalURL,
+		UserID:      userID,
+	}})
+}
+
+// IterateLinks calls fn for every stored link ordered by short URL,
+// stopping at the first error returned by fn.
+func (s *fileStore) IterateLinks(ctx context.Context, fn func(Link) error) error {
+	s.mu.RLock()
+	links := make([]Link, 0, len(s.URLs))
+	for k, v := range s.URLs {
+		links = append(links, v.toLink(k))
 	}
-	s.URLs[shortPath] = newLink
-	if s.useFileStorage {
-		if err := s.writeDataToFile(); err != nil {
-			delete(s.URLs, shortPath)
+	s.mu.RUnlock()
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].ShortPath < links[j].ShortPath
+	})
+	for _, l := range links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(l); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// IterateAllLinks calls fn for links of all users matching options
+// ordered by creation time, stopping at the first error returned by fn.
+func (s *fileStore) IterateAllLinks(ctx context.Context, opts ListOptions, fn func(Link) error) error {
+	s.mu.RLock()
+	links := make([]Link, 0, len(s.URLs))
+	for k, v := range s.URLs {
+		links = append(links, v.toLink(k))
+	}
+	s.mu.RUnlock()
+
+	return iterateSorted(ctx, links, opts, fn)
+}
+
+// IterateUserLinks calls fn for links added by user matching options
+// ordered by creation time, stopping at the first error returned by fn.
+func (s *fileStore) IterateUserLinks(ctx context.Context, userID uuid.UUID, opts ListOptions, fn func(Link) error) error {
+	s.mu.RLock()
+	links := make([]Link, 0)
+	for k, v := range s.URLs {
+		if v.User == userID {
+			links = append(links, v.toLink(k))
+		}
+	}
+	s.mu.RUnlock()
+
+	return iterateSorted(ctx, links, opts, fn)
+}
+
+// IterateWorkspaceLinks calls fn for links of workspace matching options
+// ordered by creation time, stopping at the first error returned by fn.
+func (s *fileStore) IterateWorkspaceLinks(ctx context.Context, workspaceID uuid.UUID, opts ListOptions, fn func(Link) error) error {
+	s.mu.RLock()
+	links := make([]Link, 0)
+	for k, v := range s.URLs {
+		if v.Workspace == workspaceID && workspaceID != uuid.Nil {
+			links = append(links, v.toLink(k))
+		}
+	}
+	s.mu.RUnlock()
+
+	return iterateSorted(ctx, links, opts, fn)
+}
+
 // Ping does nothing.
 func (s *fileStore) Ping(ctx context.Context) error {
 	return nil
 }
 
+// PurgeDeletedURLs permanently removes links deleted before provided time,
+// returning number of removed links.
+func (s *fileStore) PurgeDeletedURLs(ctx context.Context, deletedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	purged := make(map[string]link)
+	for short, l := range s.URLs {
+		if l.IsDeleted && l.Deleted.Before(deletedBefore) {
+			purged[short] = l
+			delete(s.URLs, short)
+		}
+	}
+	if s.useFileStorage && len(purged) > 0 {
+		if err := s.writeDataToFile(); err != nil {
+			for short, l := range purged {
+				s.URLs[short] = l
+			}
+			return 0, err
+		}
+	}
+	return len(purged), nil
+}
+
+// ReassignURLs makes user "to" the owner of all links added by user "from"
+// that don't belong to workspaces, returning number of reassigned links.
+func (s *fileStore) ReassignURLs(ctx context.Context, from, to uuid.UUID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var moved []string
+	for short, l := range s.URLs {
+		if l.User == from && l.Workspace == uuid.Nil {
+			l.User = to
+			s.URLs[short] = l
+			moved = append(moved, short)
+		}
+	}
+	if s.useFileStorage && len(moved) > 0 {
+		if err := s.writeDataToFile(); err != nil {
+			for _, short := range moved {
+				l := s.URLs[short]
+				l.User = from
+				s.URLs[short] = l
+			}
+			return 0, err
+		}
+	}
+	return len(moved), nil
+}
+
+// ReplaceLink replaces every field of existing link with the same short URL
+// regardless of user who added it, keeping history of its original URLs.
+// Original URL must not be used by other links.
+func (s *fileStore) ReplaceLink(ctx context.Context, l Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.URLs[l.ShortPath]
+	if !ok {
+		return ErrNoURLWasFound
+	}
+	for k, v := range s.URLs {
+		if k != l.ShortPath && v.Original == l.OriginalURL {
+			return ErrNotUniqueOriginalURL
+		}
+	}
+	replaced := newLink(withTimestamps([]Link{l})[0])
+	replaced.History = prev.History
+	s.URLs[l.ShortPath] = replaced
+	if s.useFileStorage {
+		if err := s.writeDataToFile(); err != nil {
+			s.URLs[l.ShortPath] = prev
+			return err
+		}
+	}
+	return nil
+}
+
+// SetLinkHistory replaces previous original URLs of link
+// with provided short URL.
+func (s *fileStore) SetLinkHistory(ctx context.Context, shortPath string, history []LinkRevision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.URLs[shortPath]
+	if !ok {
+		return ErrNoURLWasFound
+	}
+	prev := l.History
+	l.History = append([]LinkRevision{}, history...)
+	s.URLs[shortPath] = l
+	if s.useFileStorage {
+		if err := s.writeDataToFile(); err != nil {
+			l.History = prev
+			s.URLs[shortPath] = l
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreManyURLs removes deletion mark from provided URLs added by user
+// that were deleted at or after deletedAfter, returning restored ones.
+// Zero deletedAfter allows to restore links regardless of deletion time.
+func (s *fileStore) RestoreManyURLs(ctx context.Context, userID uuid.UUID, urls []string, deletedAfter time.Time) ([]string, error) {
+	return s.setDeleted(userID, urls, false, deletedAfter)
+}
+
+// SetDisabled sets or removes disabled mark of link with provided short URL
+// regardless of user who added it.
+func (s *fileStore) SetDisabled(ctx context.Context, shortPath string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.URLs[shortPath]
+	if !ok {
+		return ErrNoURLWasFound
+	}
+	prev := l
+	l.IsDisabled = disabled
+	s.URLs[shortPath] = l
+	if s.useFileStorage {
+		if err := s.writeDataToFile(); err != nil {
+			s.URLs[shortPath] = prev
+			return err
+		}
+	}
+	return nil
+}
+
+// SetTags replaces tags of link with provided short URL added by user.
+func (s *fileStore) SetTags(ctx context.Context, userID uuid.UUID, shortPath string, tags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.URLs[shortPath]
+	if !ok || l.User != userID {
+		return ErrNoURLWasFound
+	}
+	prev := l
+	l.Tags = sortedTags(tags)
+	l.Updated = Now()
+	s.URLs[shortPath] = l
+	if s.useFileStorage {
+		if err := s.writeDataToFile(); err != nil {
+			s.URLs[shortPath] = prev
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateOriginalURL changes original URL of not deleted link
+// with provided short URL added by user, keeping the previous one in its history.
+// Original URL must not be used by other links.
+func (s *fileStore) UpdateOriginalURL(ctx context.Context, userID uuid.UUID, shortPath, originalURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.URLs[shortPath]
+	if !ok || l.User != userID {
+		return ErrNoURLWasFound
+	}
+	if l.IsDeleted {
+		return ErrShortenedDeleted
+	}
+	if l.Original == originalURL {
+		return nil
+	}
+	for k, v := range s.URLs {
+		if k != shortPath && v.Original == originalURL {
+			return ErrNotUniqueOriginalURL
+		}
+	}
+	prev := l
+	l.Updated = Now()
+	l.History = append(l.History[:len(l.History):len(l.History)], LinkRevision{
+		ReplacedAt:  l.Updated,
+		OriginalURL: l.Original,
+	})
+	l.Original = originalURL
+	s.URLs[shortPath] = l
+	if s.useFileStorage {
+		if err := s.writeDataToFile(); err != nil {
+			s.URLs[shortPath] = prev
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileStore) setDeleted(userID uuid.UUID, urls []string, isDeleted bool, deletedAfter time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := make(map[string]link, len(urls))
+	changed := make([]string, 0, len(urls))
+	updated := Now()
+	for _, short := range urls {
+		l, ok := s.URLs[short]
+		if !ok || l.User != userID || l.IsDeleted == isDeleted {
+			continue
+		}
+		if !isDeleted && !canRestore(l.Deleted, deletedAfter) {
+			continue
+		}
+		prev[short] = l
+		changed = append(changed, short)
+		l.IsDeleted = isDeleted
+		l.Updated = updated
+		l.Deleted = time.Time{}
+		if isDeleted {
+			l.Deleted = updated
+		}
+		s.URLs[short] = l
+	}
+	if s.useFileStorage && len(prev) > 0 {
+		if err := s.writeDataToFile(); err != nil {
+			for short, l := range prev {
+				s.URLs[short] = l
+			}
+			return nil, err
+		}
+	}
+	return changed, nil
+}
+
 func (s *fileStore) loadDataFromFile() error {
 	file, err := os.OpenFile(s.fileStoragePath, os.O_RDONLY|os.O_CREATE, 0777)
 	if err != nil {
@@ -146,7 +605,7 @@ func (s *fileStore) loadDataFromFile() error {
 }
 
 func (s *fileStore) writeDataToFile() error {
-	file, err := os.OpenFile(s.fileStoragePath, os.O_WRONLY|os.O_CREATE, 0777)
+	file, err := os.OpenFile(s.fileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
 	if err != nil {
 		log.Printf("unable to open file %s: %v\n", s.fileStoragePath, err)
 		return err
@@ -166,3 +625,25 @@ func (s *fileStore) writeDataToFile() error {
 	log.Printf("Number of bytes written: %d", num)
 	return nil
 }
+
+func (l link) toLink(shortPath string) Link {
+	return Link{
+		CreatedAt:     l.Created,
+		UpdatedAt:     l.Updated,
+		DeletedAt:     l.Deleted,
+		ShortPath:     shortPath,
+		OriginalURL:   l.Original,
+		CreatorIP:     l.CreatorIP,
+		CreatorUAHash: l.CreatorUAHash,
+		PasswordHash:  l.PasswordHash,
+		Tags:          l.Tags,
+		MaxUses:       l.MaxUses,
+		RemainingUses: l.RemainingUses,
+		RedirectCode:  l.RedirectCode,
+		Passthrough:   l.Passthrough,
+		UserID:        l.User,
+		WorkspaceID:   l.Workspace,
+		IsDeleted:     l.IsDeleted,
+		IsDisabled:    l.IsDisabled,
+	}
+}
@@ -2,8 +2,13 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -16,17 +21,56 @@ const (
 )
 
 func Test_fileStore_DeleteManyURLs(t *testing.T) {
+	type want struct {
+		err         error
+		originalURL string
+	}
 	tests := []struct {
 		name      string
 		storeType string
+		ownerID   uuid.UUID
+		deleterID uuid.UUID
+		want      want
 	}{
 		{
-			name:      "delete many (map)",
+			name:      "owner deletes URL (map)",
 			storeType: mapStore,
+			ownerID:   uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523"),
+			deleterID: uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523"),
+			want: want{
+				err:         ErrShortenedDeleted,
+				originalURL: "",
+			},
 		},
 		{
-			name:      "delete many (array)",
+			name:      "other user deletes URL (map)",
+			storeType: mapStore,
+			ownerID:   uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523"),
+			deleterID: uuid.New(),
+			want: want{
+				err:         nil,
+				originalURL: "https://yandex.ru",
+			},
+		},
+		{
+			name:      "owner deletes URL (array)",
+			storeType: arrayStore,
+			ownerID:   uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523"),
+			deleterID: uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523"),
+			want: want{
+				err:         ErrShortenedDeleted,
+				originalURL: "",
+			},
+		},
+		{
+			name:      "other user deletes URL (array)",
 			storeType: arrayStore,
+			ownerID:   uuid.MustParse("6577f191-a012-4f16-afe4-6ed0d542e523"),
+			deleterID: uuid.New(),
+			want: want{
+				err:         nil,
+				originalURL: "https://yandex.ru",
+			},
 		},
 	}
 	for _, tt := range tests {
@@ -43,8 +87,749 @@ func Test_fileStore_DeleteManyURLs(t *testing.T) {
 			}
 			require.NoError(t, err)
 
-			err = s.DeleteManyURLs(context.Background(), uuid.Nil, nil)
-			require.ErrorIs(t, err, ErrNotImplementedYet)
+			urls := map[string]string{
+				"abcdef": "https://github.com/serjyuriev",
+				"lkasdj": "https://yandex.ru",
+			}
+			err = s.InsertManyURLs(context.Background(), tt.ownerID, urls)
+			require.NoError(t, err)
+
+			err = s.DeleteManyURLs(context.Background(), tt.deleterID, []string{"lkasdj", "nonexi"})
+			require.NoError(t, err)
+
+			original, err := s.FindOriginalURL(context.Background(), "lkasdj")
+			assert.ErrorIs(t, err, tt.want.err)
+			assert.Equal(t, tt.want.originalURL, original)
+
+			original, err = s.FindOriginalURL(context.Background(), "abcdef")
+			assert.NoError(t, err)
+			assert.Equal(t, "https://github.com/serjyuriev", original)
+		})
+	}
+}
+
+func Test_fileStore_RestoreManyURLs(t *testing.T) {
+	for _, storeType := range []string{mapStore, arrayStore} {
+		t.Run(storeType, func(t *testing.T) {
+			path := t.TempDir() + "/shorten.json"
+			var (
+				s   Store
+				err error
+			)
+			newStore := func() {
+				switch storeType {
+				case mapStore:
+					s, err = NewFileStore(path)
+				case arrayStore:
+					s, err = NewFileArrayStore(path)
+				}
+				require.NoError(t, err)
+			}
+			newStore()
+
+			uid := uuid.New()
+			err = s.InsertManyURLs(context.Background(), uid, map[string]string{
+				"abcdef": "https://github.com/serjyuriev",
+				"lkasdj": "https://yandex.ru",
+			})
+			require.NoError(t, err)
+			require.NoError(t, s.DeleteManyURLs(context.Background(), uid, []string{"abcdef", "lkasdj"}))
+
+			restored, err := s.RestoreManyURLs(context.Background(), uuid.New(), []string{"abcdef"}, time.Time{})
+			require.NoError(t, err)
+			assert.Empty(t, restored)
+			_, err = s.FindOriginalURL(context.Background(), "abcdef")
+			assert.ErrorIs(t, err, ErrShortenedDeleted)
+
+			l, err := s.FindLink(context.Background(), "abcdef")
+			require.NoError(t, err)
+			assert.False(t, l.DeletedAt.IsZero())
+			restored, err = s.RestoreManyURLs(context.Background(), uid, []string{"abcdef"}, l.DeletedAt.Add(time.Microsecond))
+			require.NoError(t, err)
+			assert.Empty(t, restored)
+
+			restored, err = s.RestoreManyURLs(context.Background(), uid, []string{"abcdef", "qwerty"}, l.DeletedAt)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"abcdef"}, restored)
+
+			newStore()
+			original, err := s.FindOriginalURL(context.Background(), "abcdef")
+			assert.NoError(t, err)
+			assert.Equal(t, "https://github.com/serjyuriev", original)
+			l, err = s.FindLink(context.Background(), "abcdef")
+			require.NoError(t, err)
+			assert.True(t, l.DeletedAt.IsZero())
+			_, err = s.FindOriginalURL(context.Background(), "lkasdj")
+			assert.ErrorIs(t, err, ErrShortenedDeleted)
+		})
+	}
+}
+
+func Test_fileStore_PurgeDeletedURLs(t *testing.T) {
+	for _, storeType := range []string{mapStore, arrayStore} {
+		t.Run(storeType, func(t *testing.T) {
+			path := t.TempDir() + "/shorten.json"
+			var (
+				s   Store
+				err error
+			)
+			newStore := func() {
+				switch storeType {
+				case mapStore:
+					s, err = NewFileStore(path)
+				case arrayStore:
+					s, err = NewFileArrayStore(path)
+				}
+				require.NoError(t, err)
+			}
+			newStore()
+
+			ctx := context.Background()
+			uid := uuid.New()
+			deletedAt := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+			require.NoError(t, s.InsertLinks(ctx, []Link{
+				{ShortPath: "aaaaaa", OriginalURL: "https://github.com", UserID: uid},
+				{ShortPath: "bbbbbb", OriginalURL: "https://gitlab.com", UserID: uid, IsDeleted: true, DeletedAt: deletedAt},
+				{ShortPath: "cccccc", OriginalURL: "https://yandex.ru", UserID: uid, IsDeleted: true, DeletedAt: deletedAt.AddDate(0, 0, 7)},
+			}))
+
+			n, err := s.PurgeDeletedURLs(ctx, deletedAt)
+			require.NoError(t, err)
+			assert.Equal(t, 0, n)
+
+			n, err = s.PurgeDeletedURLs(ctx, deletedAt.AddDate(0, 0, 1))
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+
+			newStore()
+			_, err = s.FindLink(ctx, "bbbbbb")
+			assert.ErrorIs(t, err, ErrNoURLWasFound)
+			for _, short := range []string{"aaaaaa", "cccccc"} {
+				_, err = s.FindLink(ctx, short)
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_fileStore_FindLink(t *testing.T) {
+	for _, storeType := range []string{mapStore, arrayStore} {
+		t.Run(storeType, func(t *testing.T) {
+			var (
+				s   Store
+				err error
+			)
+			switch storeType {
+			case mapStore:
+				s, err = NewFileStore("")
+			case arrayStore:
+				s, err = NewFileArrayStore("")
+			}
+			require.NoError(t, err)
+
+			uid := uuid.New()
+			err = s.InsertNewURLPair(context.Background(), uid, "abcdef", "https://github.com/serjyuriev")
+			require.NoError(t, err)
+			require.NoError(t, s.DeleteManyURLs(context.Background(), uid, []string{"abcdef"}))
+
+			l, err := s.FindLink(context.Background(), "abcdef")
+			require.NoError(t, err)
+			assert.False(t, l.CreatedAt.IsZero())
+			l.CreatedAt = time.Time{}
+			l.UpdatedAt = time.Time{}
+			l.DeletedAt = time.Time{}
+			assert.Equal(t, Link{
+				ShortPath:   "abcdef",
+				OriginalURL: "https://github.com/serjyuriev",
+				UserID:      uid,
+				IsDeleted:   true,
+			}, l)
+
+			_, err = s.FindLink(context.Background(), "fedcba")
+			assert.ErrorIs(t, err, ErrNoURLWasFound)
+		})
+	}
+}
+
+func Test_fileStore_IterateLinks(t *testing.T) {
+	for _, storeType := range []string{mapStore, arrayStore} {
+		t.Run(storeType, func(t *testing.T) {
+			var (
+				s   Store
+				err error
+			)
+			switch storeType {
+			case mapStore:
+				s, err = NewFileStore("")
+			case arrayStore:
+				s, err = NewFileArrayStore("")
+			}
+			require.NoError(t, err)
+
+			uid, uid2 := uuid.New(), uuid.New()
+			require.NoError(t, s.InsertManyURLs(context.Background(), uid, map[string]string{
+				"lkasdj": "https://yandex.ru",
+				"abcdef": "https://github.com/serjyuriev",
+			}))
+			require.NoError(t, s.InsertNewURLPair(context.Background(), uid2, "fedcba", "https://gitlab.com/servady"))
+
+			var links []Link
+			err = s.IterateLinks(context.Background(), func(l Link) error {
+				l.CreatedAt = time.Time{}
+				l.UpdatedAt = time.Time{}
+				l.DeletedAt = time.Time{}
+				links = append(links, l)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []Link{
+				{ShortPath: "abcdef", OriginalURL: "https://github.com/serjyuriev", UserID: uid},
+				{ShortPath: "fedcba", OriginalURL: "https://gitlab.com/servady", UserID: uid2},
+				{ShortPath: "lkasdj", OriginalURL: "https://yandex.ru", UserID: uid},
+			}, links)
+
+			errStop := errors.New("stop")
+			calls := 0
+			err = s.IterateLinks(context.Background(), func(l Link) error {
+				calls++
+				return errStop
+			})
+			assert.ErrorIs(t, err, errStop)
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
+
+func Test_fileStore_IterateUserLinks(t *testing.T) {
+	for _, storeType := range []string{mapStore, arrayStore} {
+		t.Run(storeType, func(t *testing.T) {
+			var (
+				s   Store
+				err error
+			)
+			switch storeType {
+			case mapStore:
+				s, err = NewFileStore("")
+			case arrayStore:
+				s, err = NewFileArrayStore("")
+			}
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			uid := uuid.New()
+			for _, short := range []string{"bbbbbb", "cccccc", "aaaaaa", "dddddd", "eeeeee"} {
+				require.NoError(t, s.InsertNewURLPair(ctx, uid, short, "https://example.com/"+short))
+			}
+			require.NoError(t, s.InsertNewURLPair(ctx, uuid.New(), "ffffff", "https://example.com/ffffff"))
+			require.NoError(t, s.DeleteManyURLs(ctx, uid, []string{"dddddd"}))
+
+			list := func(opts ListOptions) []string {
+				var shorts []string
+				err := s.IterateUserLinks(ctx, uid, opts, func(l Link) error {
+					shorts = append(shorts, l.ShortPath)
+					return nil
+				})
+				require.NoError(t, err)
+				return shorts
+			}
+
+			all := list(ListOptions{})
+			assert.Len(t, all, 4)
+			assert.NotContains(t, all, "dddddd")
+			assert.NotContains(t, all, "ffffff")
+
+			var paged []string
+			var after Cursor
+			for {
+				var page []Link
+				err = s.IterateUserLinks(ctx, uid, ListOptions{After: after, Limit: 3}, func(l Link) error {
+					page = append(page, l)
+					return nil
+				})
+				require.NoError(t, err)
+				for _, l := range page {
+					paged = append(paged, l.ShortPath)
+				}
+				if len(page) < 3 {
+					break
+				}
+				after = page[len(page)-1].Cursor()
+			}
+			assert.Equal(t, all, paged)
+		})
+	}
+}
+
+func Test_fileStore_IterateUserLinks_legacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shorten.json")
+	uid := uuid.New()
+	data := fmt.Sprintf(`{"aaaaaa":{"Original":"https://example.com/a","User":"%[1]s"},`+
+		`"bbbbbb":{"Original":"https://example.com/b","User":"%[1]s"},`+
+		`"cccccc":{"Original":"https://example.com/c","User":"%[1]s"}}`, uid)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	s, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	var paged []string
+	var after Cursor
+	for {
+		var page []Link
+		err = s.IterateUserLinks(ctx, uid, ListOptions{After: after, Limit: 1}, func(l Link) error {
+			page = append(page, l)
+			return nil
+		})
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		require.True(t, page[0].CreatedAt.IsZero())
+		paged = append(paged, page[0].ShortPath)
+		after, err = ParseCursor(page[0].Cursor().String())
+		require.NoError(t, err)
+		require.Equal(t, page[0].Cursor(), after)
+	}
+	assert.Equal(t, []string{"aaaaaa", "bbbbbb", "cccccc"}, paged)
+}
+
+func Test_fileStore_IterateUserLinks_filters(t *testing.T) {
+	uid := uuid.New()
+	day := func(d int) time.Time {
+		return time.Date(2022, 3, d, 12, 0, 0, 0, time.UTC)
+	}
+	links := []Link{
+		{ShortPath: "aaaaaa", OriginalURL: "https://github.com/serjyuriev", CreatedAt: day(1)},
+		{ShortPath: "bbbbbb", OriginalURL: "https://GitHub.com/golang/go", CreatedAt: day(2)},
+		{ShortPath: "cccccc", OriginalURL: "https://gitlab.com/servady", CreatedAt: day(3), IsDeleted: true},
+		{ShortPath: "dddddd", OriginalURL: "https://yandex.ru/search?text=github", CreatedAt: day(4)},
+		{ShortPath: "eeeeee", OriginalURL: "https://user@github.com:443/x", CreatedAt: day(5)},
+	}
+	for i := range links {
+		links[i].UserID = uid
+	}
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want []string
+	}{
+		{
+			name: "active by default",
+			want: []string{"aaaaaa", "bbbbbb", "dddddd", "eeeeee"},
+		},
+		{
+			name: "deleted",
+			opts: ListOptions{Status: StatusDeleted},
+			want: []string{"cccccc"},
+		},
+		{
+			name: "all",
+			opts: ListOptions{Status: StatusAll},
+			want: []string{"aaaaaa", "bbbbbb", "cccccc", "dddddd", "eeeeee"},
+		},
+		{
+			name: "substring ignoring case",
+			opts: ListOptions{Query: "GITHUB"},
+			want: []string{"aaaaaa", "bbbbbb", "dddddd", "eeeeee"},
+		},
+		{
+			name: "host",
+			opts: ListOptions{Host: "github.com", Status: StatusAll},
+			want: []string{"aaaaaa", "bbbbbb", "eeeeee"},
+		},
+		{
+			name: "created range",
+			opts: ListOptions{CreatedFrom: day(2), CreatedTo: day(4), Status: StatusAll},
+			want: []string{"bbbbbb", "cccccc"},
+		},
+		{
+			name: "combined with limit",
+			opts: ListOptions{Host: "github.com", CreatedFrom: day(2), Limit: 1},
+			want: []string{"bbbbbb"},
+		},
+	}
+	for _, storeType := range []string{mapStore, arrayStore} {
+		var (
+			s   Store
+			err error
+		)
+		switch storeType {
+		case mapStore:
+			s, err = NewFileStore("")
+		case arrayStore:
+			s, err = NewFileArrayStore("")
+		}
+		require.NoError(t, err)
+		require.NoError(t, s.InsertLinks(context.Background(), links))
+		require.NoError(t, s.InsertNewURLPair(context.Background(), uuid.New(), "ffffff", "https://github.com"))
+
+		for _, tt := range tests {
+			t.Run(storeType+"/"+tt.name, func(t *testing.T) {
+				var got []string
+				err := s.IterateUserLinks(context.Background(), uid, tt.opts, func(l Link) error {
+					got = append(got, l.ShortPath)
+					return nil
+				})
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		}
+	}
+}
+
+func Test_fileStore_SetTags(t *testing.T) {
+	for _, storeType := range []string{mapStore, arrayStore} {
+		t.Run(storeType, func(t *testing.T) {
+			path := t.TempDir() + "/shorten.json"
+			var (
+				s   Store
+				err error
+			)
+			switch storeType {
+			case mapStore:
+				s, err = NewFileStore(path)
+			case arrayStore:
+				s, err = NewFileArrayStore(path)
+			}
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			uid := uuid.New()
+			require.NoError(t, s.InsertLinks(ctx, []Link{
+				{ShortPath: "aaaaaa", OriginalURL: "https://github.com", UserID: uid, Tags: []string{"work", "code"}},
+				{ShortPath: "bbbbbb", OriginalURL: "https://gitlab.com", UserID: uid, Tags: []string{"code"}},
+				{ShortPath: "cccccc", OriginalURL: "https://yandex.ru", UserID: uid, Tags: []string{"code"}, IsDeleted: true},
+			}))
+
+			counts, err := s.FindTagsByUser(ctx, uid)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]int{"code": 2, "work": 1}, counts)
+
+			err = s.SetTags(ctx, uuid.New(), "aaaaaa", []string{"stolen"})
+			assert.ErrorIs(t, err, ErrNoURLWasFound)
+			err = s.SetTags(ctx, uid, "zzzzzz", []string{"missing"})
+			assert.ErrorIs(t, err, ErrNoURLWasFound)
+
+			require.NoError(t, s.SetTags(ctx, uid, "bbbbbb", []string{"work/reports", "personal"}))
+			l, err := s.FindLink(ctx, "bbbbbb")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"personal", "work/reports"}, l.Tags)
+			assert.True(t, l.UpdatedAt.After(l.CreatedAt) || l.UpdatedAt.Equal(l.CreatedAt))
+
+			var tagged []string
+			err = s.IterateUserLinks(ctx, uid, ListOptions{Tag: "work/reports"}, func(l Link) error {
+				tagged = append(tagged, l.ShortPath)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"bbbbbb"}, tagged)
+
+			switch storeType {
+			case mapStore:
+				s, err = NewFileStore(path)
+			case arrayStore:
+				s, err = NewFileArrayStore(path)
+			}
+			require.NoError(t, err)
+			counts, err = s.FindTagsByUser(ctx, uid)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]int{"code": 1, "personal": 1, "work": 1, "work/reports": 1}, counts)
+		})
+	}
+}
+
+func Test_fileStore_UpdateOriginalURL(t *testing.T) {
+	for _, storeType := range []string{mapStore, arrayStore} {
+		t.Run(storeType, func(t *testing.T) {
+			path := t.TempDir() + "/shorten.json"
+			var (
+				s   Store
+				err error
+			)
+			switch storeType {
+			case mapStore:
+				s, err = NewFileStore(path)
+			case arrayStore:
+				s, err = NewFileArrayStore(path)
+			}
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			uid := uuid.New()
+			require.NoError(t, s.InsertLinks(ctx, []Link{
+				{ShortPath: "aaaaaa", OriginalURL: "https://github.com", UserID: uid},
+				{ShortPath: "bbbbbb", OriginalURL: "https://gitlab.com", UserID: uid},
+				{ShortPath: "cccccc", OriginalURL: "https://yandex.ru", UserID: uid, IsDeleted: true},
+			}))
+
+			tests := []struct {
+				name        string
+				userID      uuid.UUID
+				shortPath   string
+				originalURL string
+				wantErr     error
+			}{
+				{
+					name:        "missing link",
+					userID:      uid,
+					shortPath:   "zzzzzz",
+					originalURL: "https://vk.com",
+					wantErr:     ErrNoURLWasFound,
+				},
+				{
+					name:        "another user",
+					userID:      uuid.New(),
+					shortPath:   "aaaaaa",
+					originalURL: "https://vk.com",
+					wantErr:     ErrNoURLWasFound,
+				},
+				{
+					name:        "deleted link",
+					userID:      uid,
+					shortPath:   "cccccc",
+					originalURL: "https://vk.com",
+					wantErr:     ErrShortenedDeleted,
+				},
+				{
+					name:        "not unique",
+					userID:      uid,
+					shortPath:   "aaaaaa",
+					originalURL: "https://yandex.ru",
+					wantErr:     ErrNotUniqueOriginalURL,
+				},
+				{
+					name:        "same url",
+					userID:      uid,
+					shortPath:   "aaaaaa",
+					originalURL: "https://github.com",
+				},
+				{
+					name:        "first change",
+					userID:      uid,
+					shortPath:   "aaaaaa",
+					originalURL: "https://github.com/serjyuriev",
+				},
+				{
+					name:        "second change",
+					userID:      uid,
+					shortPath:   "aaaaaa",
+					originalURL: "https://vk.com",
+				},
+			}
+			for _, tt := range tests {
+				err := s.UpdateOriginalURL(ctx, tt.userID, tt.shortPath, tt.originalURL)
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, err, tt.wantErr, tt.name)
+				} else {
+					assert.NoError(t, err, tt.name)
+				}
+			}
+
+			switch storeType {
+			case mapStore:
+				s, err = NewFileStore(path)
+			case arrayStore:
+				s, err = NewFileArrayStore(path)
+			}
+			require.NoError(t, err)
+
+			original, err := s.FindOriginalURL(ctx, "aaaaaa")
+			require.NoError(t, err)
+			assert.Equal(t, "https://vk.com", original)
+
+			history, err := s.FindLinkHistory(ctx, "aaaaaa")
+			require.NoError(t, err)
+			require.Len(t, history, 2)
+			assert.Equal(t, "https://github.com", history[0].OriginalURL)
+			assert.Equal(t, "https://github.com/serjyuriev", history[1].OriginalURL)
+			assert.False(t, history[1].ReplacedAt.Before(history[0].ReplacedAt))
+
+			history, err = s.FindLinkHistory(ctx, "bbbbbb")
+			require.NoError(t, err)
+			assert.Empty(t, history)
+
+			_, err = s.FindLinkHistory(ctx, "zzzzzz")
+			assert.ErrorIs(t, err, ErrNoURLWasFound)
+		})
+	}
+}
+
+func Test_fileStore_ReplaceLink(t *testing.T) {
+	for _, storeType := range []string{mapStore, arrayStore} {
+		t.Run(storeType, func(t *testing.T) {
+			path := t.TempDir() + "/shorten.json"
+			var (
+				s   Store
+				err error
+			)
+			switch storeType {
+			case mapStore:
+				s, err = NewFileStore(path)
+			case arrayStore:
+				s, err = NewFileArrayStore(path)
+			}
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			uid := uuid.New()
+			require.NoError(t, s.InsertLinks(ctx, []Link{
+				{ShortPath: "aaaaaa", OriginalURL: "https://github.com", UserID: uid},
+				{ShortPath: "bbbbbb", OriginalURL: "https://gitlab.com", UserID: uid},
+			}))
+			require.NoError(t, s.UpdateOriginalURL(ctx, uid, "aaaaaa", "https://github.com/serjyuriev"))
+
+			replaced := Link{
+				ShortPath:     "aaaaaa",
+				OriginalURL:   "https://github.com/serjyuriev",
+				UserID:        uid,
+				WorkspaceID:   uuid.New(),
+				PasswordHash:  "hash",
+				Tags:          []string{"go"},
+				MaxUses:       5,
+				RemainingUses: 3,
+				RedirectCode:  308,
+				Passthrough:   PassthroughPath,
+				IsDisabled:    true,
+			}
+			assert.ErrorIs(t, s.ReplaceLink(ctx, Link{ShortPath: "zzzzzz", OriginalURL: "https://vk.com"}), ErrNoURLWasFound)
+			assert.ErrorIs(t, s.ReplaceLink(ctx, Link{ShortPath: "aaaaaa", OriginalURL: "https://gitlab.com"}), ErrNotUniqueOriginalURL)
+			require.NoError(t, s.ReplaceLink(ctx, replaced))
+
+			switch storeType {
+			case mapStore:
+				s, err = NewFileStore(path)
+			case arrayStore:
+				s, err = NewFileArrayStore(path)
+			}
+			require.NoError(t, err)
+
+			l, err := s.FindLink(ctx, "aaaaaa")
+			require.NoError(t, err)
+			assert.Equal(t, linkFields(replaced), linkFields(l))
+
+			history, err := s.FindLinkHistory(ctx, "aaaaaa")
+			require.NoError(t, err)
+			require.Len(t, history, 1)
+			assert.Equal(t, "https://github.com", history[0].OriginalURL)
+		})
+	}
+}
+
+func Test_fileStore_ConsumeUse(t *testing.T) {
+	for _, storeType := range []string{mapStore, arrayStore} {
+		t.Run(storeType, func(t *testing.T) {
+			path := t.TempDir() + "/shorten.json"
+			var (
+				s   Store
+				err error
+			)
+			newStore := func() {
+				switch storeType {
+				case mapStore:
+					s, err = NewFileStore(path)
+				case arrayStore:
+					s, err = NewFileArrayStore(path)
+				}
+				require.NoError(t, err)
+			}
+			newStore()
+
+			ctx := context.Background()
+			uid := uuid.New()
+			require.NoError(t, s.InsertLinks(ctx, []Link{
+				{ShortPath: "aaaaaa", OriginalURL: "https://github.com", UserID: uid, MaxUses: 5, RemainingUses: 5},
+				{ShortPath: "bbbbbb", OriginalURL: "https://gitlab.com", UserID: uid},
+				{ShortPath: "cccccc", OriginalURL: "https://yandex.ru", UserID: uid, MaxUses: 1, RemainingUses: 1, IsDeleted: true},
+			}))
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			used := 0
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					original, err := s.ConsumeUse(ctx, "aaaaaa")
+					if err != nil {
+						assert.ErrorIs(t, err, ErrLinkExhausted)
+						return
+					}
+					assert.Equal(t, "https://github.com", original)
+					mu.Lock()
+					used++
+					mu.Unlock()
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, 5, used)
+
+			newStore()
+			l, err := s.FindLink(ctx, "aaaaaa")
+			require.NoError(t, err)
+			assert.Equal(t, 0, l.RemainingUses)
+			assert.True(t, l.Exhausted())
+
+			_, err = s.ConsumeUse(ctx, "bbbbbb")
+			assert.ErrorIs(t, err, ErrLinkExhausted)
+			_, err = s.ConsumeUse(ctx, "cccccc")
+			assert.ErrorIs(t, err, ErrShortenedDeleted)
+			_, err = s.ConsumeUse(ctx, "zzzzzz")
+			assert.ErrorIs(t, err, ErrNoURLWasFound)
+		})
+	}
+}
+
+func Test_fileStore_InsertLinks(t *testing.T) {
+	for _, storeType := range []string{mapStore, arrayStore} {
+		t.Run(storeType, func(t *testing.T) {
+			path := t.TempDir() + "/shorten.json"
+			var (
+				s   Store
+				err error
+			)
+			switch storeType {
+			case mapStore:
+				s, err = NewFileStore(path)
+			case arrayStore:
+				s, err = NewFileArrayStore(path)
+			}
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			created := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+			want := Link{
+				CreatedAt:     created,
+				UpdatedAt:     created,
+				ShortPath:     "abcdef",
+				OriginalURL:   "https://github.com/serjyuriev",
+				CreatorIP:     "203.0.113.7",
+				CreatorUAHash: "06d351b0",
+				UserID:        uuid.New(),
+			}
+			require.NoError(t, s.InsertLinks(ctx, []Link{want}))
+			require.NoError(t, s.InsertNewURLPair(ctx, want.UserID, "fedcba", "https://gitlab.com/servady"))
+
+			switch storeType {
+			case mapStore:
+				s, err = NewFileStore(path)
+			case arrayStore:
+				s, err = NewFileArrayStore(path)
+			}
+			require.NoError(t, err)
+			l, err := s.FindLink(ctx, "abcdef")
+			require.NoError(t, err)
+			assert.Equal(t, want, l)
+
+			l, err = s.FindLink(ctx, "fedcba")
+			require.NoError(t, err)
+			assert.False(t, l.CreatedAt.IsZero())
+			assert.Equal(t, l.CreatedAt, l.UpdatedAt)
+
+			require.NoError(t, s.DeleteManyURLs(ctx, want.UserID, []string{"abcdef"}))
+			l, err = s.FindLink(ctx, "abcdef")
+			require.NoError(t, err)
+			assert.Equal(t, created, l.CreatedAt)
+			assert.True(t, l.UpdatedAt.After(created))
 		})
 	}
 }
@@ -1139,3 +1924,83 @@ func Test_fileStore_writeDataToFile(t *testing.T) {
 		})
 	}
 }
+
+func Test_fileStore_AdminLinks(t *testing.T) {
+	for _, storeType := range []string{mapStore, arrayStore} {
+		t.Run(storeType, func(t *testing.T) {
+			path := t.TempDir() + "/shorten.json"
+			open := func() Store {
+				var (
+					s   Store
+					err error
+				)
+				switch storeType {
+				case mapStore:
+					s, err = NewFileStore(path)
+				case arrayStore:
+					s, err = NewFileArrayStore(path)
+				}
+				require.NoError(t, err)
+				return s
+			}
+			testAdminLinks(t, open())
+
+			l, err := open().FindLink(context.Background(), "bbbbbb")
+			require.NoError(t, err)
+			assert.True(t, l.IsDisabled)
+		})
+	}
+}
+
+// testAdminLinks checks operations counting, listing and disabling links of all users.
+// Link "bbbbbb" is left disabled.
+func testAdminLinks(t *testing.T, s Store) {
+	ctx := context.Background()
+	uid, uid2 := uuid.MustParse("11111111-1111-1111-1111-111111111111"), uuid.MustParse("22222222-2222-2222-2222-222222222222")
+	created := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, s.InsertLinks(ctx, []Link{
+		{CreatedAt: created, ShortPath: "aaaaaa", OriginalURL: "https://github.com/serjyuriev", UserID: uid},
+		{CreatedAt: created.Add(time.Second), ShortPath: "bbbbbb", OriginalURL: "https://spam.example.com/a", UserID: uid2},
+		{CreatedAt: created.Add(2 * time.Second), ShortPath: "cccccc", OriginalURL: "https://spam.example.com/b", UserID: uid2},
+	}))
+	require.NoError(t, s.DeleteManyURLs(ctx, uid2, []string{"cccccc"}))
+
+	require.NoError(t, s.SetDisabled(ctx, "bbbbbb", true))
+	require.NoError(t, s.SetDisabled(ctx, "aaaaaa", true))
+	require.NoError(t, s.SetDisabled(ctx, "aaaaaa", false))
+	assert.ErrorIs(t, s.SetDisabled(ctx, "zzzzzz", true), ErrNoURLWasFound)
+
+	l, err := s.FindLink(ctx, "bbbbbb")
+	require.NoError(t, err)
+	assert.True(t, l.IsDisabled)
+	l, err = s.FindLink(ctx, "aaaaaa")
+	require.NoError(t, err)
+	assert.False(t, l.IsDisabled)
+
+	collect := func(opts ListOptions) []string {
+		var shorts []string
+		require.NoError(t, s.IterateAllLinks(ctx, opts, func(l Link) error {
+			shorts = append(shorts, l.ShortPath)
+			return nil
+		}))
+		return shorts
+	}
+	assert.Equal(t, []string{"aaaaaa", "bbbbbb"}, collect(ListOptions{}))
+	assert.Equal(t, []string{"aaaaaa", "bbbbbb", "cccccc"}, collect(ListOptions{Status: StatusAll}))
+	assert.Equal(t, []string{"bbbbbb", "cccccc"}, collect(ListOptions{Status: StatusAll, Query: "SPAM"}))
+	assert.Equal(t, []string{"bbbbbb"}, collect(ListOptions{Status: StatusAll, Limit: 1, After: Cursor{CreatedAt: created, ShortPath: "aaaaaa"}}))
+
+	n, err := s.CountActiveLinks(ctx, uid2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = s.CountActiveLinks(ctx, uuid.New())
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	counts, err := s.CountLinksByUser(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []UserLinkCount{
+		{UserID: uid2, Total: 2, Deleted: 1, Disabled: 1},
+		{UserID: uid, Total: 1},
+	}, counts)
+}
@@ -0,0 +1,546 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// CopyResult describes what happened to a link copied into another storage.
+type CopyResult int
+
+const (
+	// CopyInserted means link was absent in destination and was inserted.
+	CopyInserted CopyResult = iota
+	// CopyUpdated means link was present in destination
+	// and its fields were synchronized.
+	CopyUpdated
+	// CopyUnchanged means destination already contained the same link.
+	CopyUnchanged
+	// CopyConflicted means destination contains link with the same short URL
+	// added by another user or another link with the same original URL.
+	CopyConflicted
+)
+
+// MigrationReport contains statistics of links and other entities
+// copied between storages.
+type MigrationReport struct {
+	Inserted   int
+	Updated    int
+	Unchanged  int
+	Conflicted []string
+	Accounts   EntityReport
+	Templates  EntityReport
+	Tokens     EntityReport
+	Workspaces EntityReport
+}
+
+// EntityReport contains statistics of accounts, API tokens, templates
+// or workspaces copied between storages. Deleted counts entities
+// removed from destination as they are absent in source.
+type EntityReport struct {
+	Inserted   int
+	Updated    int
+	Unchanged  int
+	Deleted    int
+	Conflicted []string
+}
+
+func (r *EntityReport) add(res CopyResult, id string) {
+	switch res {
+	case CopyInserted:
+		r.Inserted++
+	case CopyUpdated:
+		r.Updated++
+	case CopyUnchanged:
+		r.Unchanged++
+	case CopyConflicted:
+		r.Conflicted = append(r.Conflicted, id)
+	}
+}
+
+// Summary describes storage content.
+// Checksum does not depend on order of links and other entities,
+// so summaries of different storages may be compared.
+type Summary struct {
+	Checksum   string
+	Count      int
+	Accounts   int
+	Templates  int
+	Tokens     int
+	Workspaces int
+}
+
+// CopyLink writes link into destination storage preserving its short URL,
+// owner, deletion mark, tags, metadata and history of original URLs.
+// Links of the same owner already present in destination have the rest
+// of their fields and history synchronized with provided ones.
+// Nil history means it is unknown: history present in destination is kept
+// and replaced original URL of destination link is appended to it.
+func CopyLink(ctx context.Context, dst Store, l Link, history []LinkRevision) (CopyResult, error) {
+	existing, err := dst.FindLink(ctx, l.ShortPath)
+	if err == nil {
+		if existing.UserID != l.UserID {
+			return CopyConflicted, nil
+		}
+		return syncLink(ctx, dst, existing, l, history)
+	}
+	if !errors.Is(err, ErrNoURLWasFound) {
+		return 0, fmt.Errorf("unable to check link %s:\n%w", l.ShortPath, err)
+	}
+
+	if err = dst.InsertLinks(ctx, []Link{l}); err != nil {
+		if errors.Is(err, ErrNotUniqueOriginalURL) {
+			return CopyConflicted, nil
+		}
+		return 0, fmt.Errorf("unable to insert link %s:\n%w", l.ShortPath, err)
+	}
+	if len(history) > 0 {
+		if err = dst.SetLinkHistory(ctx, l.ShortPath, history); err != nil {
+			return 0, fmt.Errorf("unable to set history of link %s:\n%w", l.ShortPath, err)
+		}
+	}
+	return CopyInserted, nil
+}
+
+// syncLink updates existing link of the same owner in destination storage.
+func syncLink(ctx context.Context, dst Store, existing, l Link, history []LinkRevision) (CopyResult, error) {
+	prev, err := dst.FindLinkHistory(ctx, l.ShortPath)
+	if err != nil {
+		return 0, fmt.Errorf("unable to find history of link %s:\n%w", l.ShortPath, err)
+	}
+	if history == nil {
+		history = prev
+		if existing.OriginalURL != l.OriginalURL {
+			replacedAt := l.UpdatedAt
+			if replacedAt.IsZero() {
+				replacedAt = Now()
+			}
+			history = append(history[:len(history):len(history)], LinkRevision{
+				ReplacedAt:  replacedAt,
+				OriginalURL: existing.OriginalURL,
+			})
+		}
+	}
+
+	res := CopyUnchanged
+	if linkFields(existing) != linkFields(l) {
+		if err = dst.ReplaceLink(ctx, l); err != nil {
+			if errors.Is(err, ErrNotUniqueOriginalURL) {
+				return CopyConflicted, nil
+			}
+			return 0, fmt.Errorf("unable to update link %s:\n%w", l.ShortPath, err)
+		}
+		res = CopyUpdated
+	}
+	if historyFields(prev) != historyFields(history) {
+		if err = dst.SetLinkHistory(ctx, l.ShortPath, history); err != nil {
+			return 0, fmt.Errorf("unable to set history of link %s:\n%w", l.ShortPath, err)
+		}
+		res = CopyUpdated
+	}
+	return res, nil
+}
+
+// Migrate streams every account, workspace with its members, template,
+// API token and link from source storage into destination one.
+// Migration may be repeated: entities already copied are skipped
+// and their fields are synchronized, while templates and API tokens
+// absent in source are removed from destination.
+func Migrate(ctx context.Context, src, dst Store) (MigrationReport, error) {
+	var report MigrationReport
+	var err error
+	if report.Accounts, err = migrateAccounts(ctx, src, dst); err != nil {
+		return report, err
+	}
+	if report.Workspaces, err = migrateWorkspaces(ctx, src, dst); err != nil {
+		return report, err
+	}
+	if report.Templates, err = migrateTemplates(ctx, src, dst); err != nil {
+		return report, err
+	}
+	if report.Tokens, err = migrateTokens(ctx, src, dst); err != nil {
+		return report, err
+	}
+
+	err = src.IterateLinks(ctx, func(l Link) error {
+		history, err := src.FindLinkHistory(ctx, l.ShortPath)
+		if err != nil {
+			return fmt.Errorf("unable to find history of link %s:\n%w", l.ShortPath, err)
+		}
+		res, err := CopyLink(ctx, dst, l, history)
+		if err != nil {
+			return err
+		}
+		switch res {
+		case CopyInserted:
+			report.Inserted++
+		case CopyUpdated:
+			report.Updated++
+		case CopyUnchanged:
+			report.Unchanged++
+		case CopyConflicted:
+			report.Conflicted = append(report.Conflicted, l.ShortPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("unable to migrate links:\n%w", err)
+	}
+	return report, nil
+}
+
+// Summarize counts links, accounts, templates, API tokens and workspaces
+// in storage and calculates their checksum. History of original URLs
+// and members of workspaces are included, while timestamps are not
+// taken into account, since mirrored writes set them separately in each storage.
+func Summarize(ctx context.Context, s Store) (Summary, error) {
+	var sum [sha256.Size]byte
+	add := func(fields string) {
+		h := sha256.Sum256([]byte(fields))
+		for i, b := range h {
+			sum[i] ^= b
+		}
+	}
+
+	var summary Summary
+	err := s.IterateLinks(ctx, func(l Link) error {
+		history, err := s.FindLinkHistory(ctx, l.ShortPath)
+		if err != nil {
+			return fmt.Errorf("unable to find history of link %s:\n%w", l.ShortPath, err)
+		}
+		add(linkFields(l) + "\x01" + historyFields(history))
+		summary.Count++
+		return nil
+	})
+	if err != nil {
+		return Summary{}, fmt.Errorf("unable to summarize links:\n%w", err)
+	}
+
+	if err = s.IterateAccounts(ctx, func(a Account) error {
+		add("account\x01" + accountFields(a))
+		summary.Accounts++
+		return nil
+	}); err != nil {
+		return Summary{}, fmt.Errorf("unable to summarize accounts:\n%w", err)
+	}
+	if err = s.IterateTemplates(ctx, func(t Template) error {
+		add("template\x01" + templateFields(t))
+		summary.Templates++
+		return nil
+	}); err != nil {
+		return Summary{}, fmt.Errorf("unable to summarize templates:\n%w", err)
+	}
+	if err = s.IterateTokens(ctx, func(t Token) error {
+		add("token\x01" + tokenFields(t))
+		summary.Tokens++
+		return nil
+	}); err != nil {
+		return Summary{}, fmt.Errorf("unable to summarize tokens:\n%w", err)
+	}
+	if err = s.IterateWorkspaces(ctx, func(w Workspace) error {
+		members, err := s.FindMembers(ctx, w.ID)
+		if err != nil {
+			return fmt.Errorf("unable to find members of workspace %s:\n%w", w.ID, err)
+		}
+		add("workspace\x01" + workspaceFields(w, members))
+		summary.Workspaces++
+		return nil
+	}); err != nil {
+		return Summary{}, fmt.Errorf("unable to summarize workspaces:\n%w", err)
+	}
+
+	summary.Checksum = hex.EncodeToString(sum[:])
+	return summary, nil
+}
+
+// migrateAccounts copies accounts absent in destination.
+// Accounts can't be changed, so different accounts
+// with the same ID or login are reported as conflicts.
+func migrateAccounts(ctx context.Context, src, dst Store) (EntityReport, error) {
+	var report EntityReport
+	err := src.IterateAccounts(ctx, func(a Account) error {
+		existing, err := dst.FindAccount(ctx, a.ID)
+		if err == nil {
+			if accountFields(existing) == accountFields(a) {
+				report.add(CopyUnchanged, a.ID.String())
+			} else {
+				report.add(CopyConflicted, a.ID.String())
+			}
+			return nil
+		}
+		if !errors.Is(err, ErrNoAccountWasFound) {
+			return fmt.Errorf("unable to check account %s:\n%w", a.ID, err)
+		}
+		if err = dst.InsertAccount(ctx, a); err != nil {
+			if errors.Is(err, ErrAccountExists) {
+				report.add(CopyConflicted, a.ID.String())
+				return nil
+			}
+			return fmt.Errorf("unable to insert account %s:\n%w", a.ID, err)
+		}
+		report.add(CopyInserted, a.ID.String())
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("unable to migrate accounts:\n%w", err)
+	}
+	return report, nil
+}
+
+// migrateWorkspaces copies workspaces absent in destination
+// and synchronizes members of every workspace. Workspaces can't be renamed,
+// so workspace with the same ID and another name is reported as conflict.
+func migrateWorkspaces(ctx context.Context, src, dst Store) (EntityReport, error) {
+	var report EntityReport
+	existing := make(map[uuid.UUID]Workspace)
+	if err := dst.IterateWorkspaces(ctx, func(w Workspace) error {
+		existing[w.ID] = w
+		return nil
+	}); err != nil {
+		return report, fmt.Errorf("unable to list workspaces of destination:\n%w", err)
+	}
+
+	err := src.IterateWorkspaces(ctx, func(w Workspace) error {
+		members, err := src.FindMembers(ctx, w.ID)
+		if err != nil {
+			return fmt.Errorf("unable to find members of workspace %s:\n%w", w.ID, err)
+		}
+		res := CopyUnchanged
+		if prev, ok := existing[w.ID]; ok {
+			if prev.Name != w.Name {
+				report.add(CopyConflicted, w.ID.String())
+				return nil
+			}
+		} else {
+			// members are synchronized below, including the owner
+			owner := uuid.Nil
+			for _, m := range members {
+				if m.Role == RoleOwner {
+					owner = m.UserID
+					break
+				}
+			}
+			if err = dst.InsertWorkspace(ctx, w, owner); err != nil {
+				if errors.Is(err, ErrWorkspaceExists) {
+					report.add(CopyConflicted, w.ID.String())
+					return nil
+				}
+				return fmt.Errorf("unable to insert workspace %s:\n%w", w.ID, err)
+			}
+			res = CopyInserted
+		}
+
+		changed, err := syncMembers(ctx, dst, w.ID, members)
+		if err != nil {
+			return err
+		}
+		if changed && res == CopyUnchanged {
+			res = CopyUpdated
+		}
+		report.add(res, w.ID.String())
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("unable to migrate workspaces:\n%w", err)
+	}
+	return report, nil
+}
+
+// syncMembers sets roles of workspace members in destination
+// and removes members absent in provided ones, reporting whether anything changed.
+func syncMembers(ctx context.Context, dst Store, workspaceID uuid.UUID, members []Member) (bool, error) {
+	existing, err := dst.FindMembers(ctx, workspaceID)
+	if err != nil {
+		return false, fmt.Errorf("unable to find members of workspace %s:\n%w", workspaceID, err)
+	}
+	roles := make(map[uuid.UUID]Role, len(existing))
+	for _, m := range existing {
+		roles[m.UserID] = m.Role
+	}
+
+	changed := false
+	for _, m := range members {
+		role, ok := roles[m.UserID]
+		delete(roles, m.UserID)
+		if ok && role == m.Role {
+			continue
+		}
+		if err = dst.SetMember(ctx, m); err != nil {
+			return false, fmt.Errorf("unable to set member %s of workspace %s:\n%w", m.UserID, workspaceID, err)
+		}
+		changed = true
+	}
+	for userID := range roles {
+		if err = dst.DeleteMember(ctx, workspaceID, userID); err != nil && !errors.Is(err, ErrNoMemberWasFound) {
+			return false, fmt.Errorf("unable to delete member %s of workspace %s:\n%w", userID, workspaceID, err)
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// migrateTemplates copies templates into destination, updating changed ones
+// and removing ones absent in source. Template with the same ID
+// belonging to another user is reported as conflict.
+func migrateTemplates(ctx context.Context, src, dst Store) (EntityReport, error) {
+	var report EntityReport
+	existing := make(map[string]Template)
+	if err := dst.IterateTemplates(ctx, func(t Template) error {
+		existing[t.ID] = t
+		return nil
+	}); err != nil {
+		return report, fmt.Errorf("unable to list templates of destination:\n%w", err)
+	}
+
+	err := src.IterateTemplates(ctx, func(t Template) error {
+		prev, ok := existing[t.ID]
+		delete(existing, t.ID)
+		switch {
+		case !ok:
+			if err := dst.InsertTemplate(ctx, t); err != nil {
+				return fmt.Errorf("unable to insert template %s:\n%w", t.ID, err)
+			}
+			report.add(CopyInserted, t.ID)
+		case prev.UserID != t.UserID:
+			report.add(CopyConflicted, t.ID)
+		case templateFields(prev) == templateFields(t):
+			report.add(CopyUnchanged, t.ID)
+		default:
+			if err := dst.UpdateTemplate(ctx, t); err != nil {
+				return fmt.Errorf("unable to update template %s:\n%w", t.ID, err)
+			}
+			report.add(CopyUpdated, t.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("unable to migrate templates:\n%w", err)
+	}
+
+	for _, t := range existing {
+		if err = dst.DeleteTemplate(ctx, t.UserID, t.ID); err == nil {
+			report.Deleted++
+		} else if !errors.Is(err, ErrNoTemplateWasFound) {
+			return report, fmt.Errorf("unable to delete template %s:\n%w", t.ID, err)
+		}
+	}
+	return report, nil
+}
+
+// migrateTokens copies API tokens into destination and removes
+// ones absent in source, so revoked tokens stop working after migration.
+// Tokens can't be changed, so different token with the same ID
+// is reported as conflict.
+func migrateTokens(ctx context.Context, src, dst Store) (EntityReport, error) {
+	var report EntityReport
+	existing := make(map[string]Token)
+	if err := dst.IterateTokens(ctx, func(t Token) error {
+		existing[t.ID] = t
+		return nil
+	}); err != nil {
+		return report, fmt.Errorf("unable to list tokens of destination:\n%w", err)
+	}
+
+	err := src.IterateTokens(ctx, func(t Token) error {
+		prev, ok := existing[t.ID]
+		delete(existing, t.ID)
+		switch {
+		case !ok:
+			if err := dst.InsertToken(ctx, t); err != nil {
+				return fmt.Errorf("unable to insert token %s:\n%w", t.ID, err)
+			}
+			report.add(CopyInserted, t.ID)
+		case tokenFields(prev) == tokenFields(t):
+			report.add(CopyUnchanged, t.ID)
+		default:
+			report.add(CopyConflicted, t.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("unable to migrate tokens:\n%w", err)
+	}
+
+	for _, t := range existing {
+		if err = dst.DeleteToken(ctx, t.UserID, t.ID); err == nil {
+			report.Deleted++
+		} else if !errors.Is(err, ErrNoTokenWasFound) {
+			return report, fmt.Errorf("unable to delete token %s:\n%w", t.ID, err)
+		}
+	}
+	return report, nil
+}
+
+// linkFields encodes every persisted field of link except timestamps.
+func linkFields(l Link) string {
+	return strings.Join([]string{
+		l.ShortPath,
+		l.OriginalURL,
+		l.UserID.String(),
+		l.WorkspaceID.String(),
+		l.CreatorIP,
+		l.CreatorUAHash,
+		l.PasswordHash,
+		strings.Join(l.Tags, ","),
+		strconv.Itoa(l.MaxUses),
+		strconv.Itoa(l.RemainingUses),
+		strconv.Itoa(l.RedirectCode),
+		l.Passthrough.String(),
+		strconv.FormatBool(l.IsDeleted),
+		strconv.FormatBool(l.IsDisabled),
+	}, "\x00")
+}
+
+// historyFields encodes previous original URLs of link except replacement time.
+func historyFields(history []LinkRevision) string {
+	urls := make([]string, len(history))
+	for i, r := range history {
+		urls[i] = r.OriginalURL
+	}
+	return strings.Join(urls, "\x00")
+}
+
+// accountFields encodes every persisted field of account except creation time.
+func accountFields(a Account) string {
+	return strings.Join([]string{a.ID.String(), a.Login, a.PasswordHash}, "\x00")
+}
+
+// templateFields encodes every persisted field of template except timestamps.
+func templateFields(t Template) string {
+	params := make([]string, 0, len(t.Params))
+	for k, v := range t.Params {
+		params = append(params, k+"="+v)
+	}
+	sort.Strings(params)
+	return strings.Join([]string{
+		t.ID,
+		t.UserID.String(),
+		t.Name,
+		strings.Join(params, "\x01"),
+		strconv.FormatBool(t.IsDefault),
+	}, "\x00")
+}
+
+// tokenFields encodes every persisted field of API token except creation time.
+func tokenFields(t Token) string {
+	return strings.Join([]string{t.ID, t.UserID.String(), t.Name, t.Hash, t.Scope.String()}, "\x00")
+}
+
+// workspaceFields encodes workspace along with roles of its members,
+// except creation time and time members were added.
+func workspaceFields(w Workspace, members []Member) string {
+	roles := make([]string, len(members))
+	for i, m := range members {
+		roles[i] = m.UserID.String() + ":" + m.Role.String()
+	}
+	sort.Strings(roles)
+	return strings.Join([]string{w.ID.String(), w.Name, strings.Join(roles, "\x01")}, "\x00")
+}
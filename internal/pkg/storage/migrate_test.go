@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	uid, uid2 := uuid.New(), uuid.New()
+
+	src, err := NewFileStore("")
+	require.NoError(t, err)
+	require.NoError(t, src.InsertManyURLs(ctx, uid, map[string]string{
+		"abcdef": "https://github.com/serjyuriev",
+		"lkasdj": "https://yandex.ru",
+		"qwerty": "https://google.com",
+	}))
+	require.NoError(t, src.InsertNewURLPair(ctx, uid2, "fedcba", "https://gitlab.com/servady"))
+	require.NoError(t, src.DeleteManyURLs(ctx, uid, []string{"lkasdj"}))
+
+	dst, err := NewFileArrayStore("")
+	require.NoError(t, err)
+	require.NoError(t, dst.InsertNewURLPair(ctx, uid, "abcdef", "https://github.com/serjyuriev"))
+	require.NoError(t, dst.InsertNewURLPair(ctx, uuid.New(), "fedcba", "https://gitlab.com/other"))
+
+	report, err := Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, MigrationReport{
+		Inserted:   2,
+		Unchanged:  1,
+		Conflicted: []string{"fedcba"},
+	}, report)
+
+	l, err := dst.FindLink(ctx, "lkasdj")
+	require.NoError(t, err)
+	assert.True(t, l.IsDeleted)
+
+	_, err = src.RestoreManyURLs(ctx, uid, []string{"lkasdj"}, time.Time{})
+	require.NoError(t, err)
+	report, err = Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Updated)
+	assert.Equal(t, 2, report.Unchanged)
+}
+
+func TestMigrate_history(t *testing.T) {
+	ctx := context.Background()
+	uid := uuid.New()
+
+	src, err := NewFileStore("")
+	require.NoError(t, err)
+	require.NoError(t, src.InsertNewURLPair(ctx, uid, "abcdef", "https://github.com"))
+	dst, err := NewFileArrayStore("")
+	require.NoError(t, err)
+
+	// link was read by migration before it was updated in source
+	// and mirrored update failed, so destination keeps previous URL.
+	report, err := Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, MigrationReport{Inserted: 1}, report)
+	require.NoError(t, src.UpdateOriginalURL(ctx, uid, "abcdef", "https://github.com/serjyuriev"))
+	require.NoError(t, src.UpdateOriginalURL(ctx, uid, "abcdef", "https://vk.com"))
+
+	report, err = Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, MigrationReport{Updated: 1}, report)
+
+	original, err := dst.FindOriginalURL(ctx, "abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, "https://vk.com", original)
+	want, err := src.FindLinkHistory(ctx, "abcdef")
+	require.NoError(t, err)
+	history, err := dst.FindLinkHistory(ctx, "abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, want, history)
+
+	srcSum, err := Summarize(ctx, src)
+	require.NoError(t, err)
+	dstSum, err := Summarize(ctx, dst)
+	require.NoError(t, err)
+	assert.Equal(t, srcSum, dstSum)
+
+	other, err := NewFileStore("")
+	require.NoError(t, err)
+	report, err = Migrate(ctx, src, other)
+	require.NoError(t, err)
+	assert.Equal(t, MigrationReport{Inserted: 1}, report)
+	history, err = other.FindLinkHistory(ctx, "abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, want, history)
+}
+
+func TestCopyLink_unknownHistory(t *testing.T) {
+	ctx := context.Background()
+	uid := uuid.New()
+
+	dst, err := NewFileStore("")
+	require.NoError(t, err)
+	require.NoError(t, dst.InsertNewURLPair(ctx, uid, "abcdef", "https://github.com"))
+
+	l, err := dst.FindLink(ctx, "abcdef")
+	require.NoError(t, err)
+	l.OriginalURL = "https://vk.com"
+	res, err := CopyLink(ctx, dst, l, nil)
+	require.NoError(t, err)
+	assert.Equal(t, CopyUpdated, res)
+
+	history, err := dst.FindLinkHistory(ctx, "abcdef")
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, "https://github.com", history[0].OriginalURL)
+
+	res, err = CopyLink(ctx, dst, l, nil)
+	require.NoError(t, err)
+	assert.Equal(t, CopyUnchanged, res)
+
+	l.UserID = uuid.New()
+	res, err = CopyLink(ctx, dst, l, nil)
+	require.NoError(t, err)
+	assert.Equal(t, CopyConflicted, res)
+}
+
+func TestMigrate_syncsFields(t *testing.T) {
+	ctx := context.Background()
+	uid, ws := uuid.New(), uuid.New()
+	protected := Link{
+		ShortPath:     "abcdef",
+		OriginalURL:   "https://github.com/serjyuriev",
+		UserID:        uid,
+		WorkspaceID:   ws,
+		PasswordHash:  "hash",
+		Tags:          []string{"go"},
+		MaxUses:       5,
+		RemainingUses: 3,
+		RedirectCode:  308,
+		Passthrough:   PassthroughQuery,
+		IsDisabled:    true,
+	}
+
+	src, err := NewFileStore("")
+	require.NoError(t, err)
+	require.NoError(t, src.InsertLinks(ctx, []Link{protected}))
+	dst, err := NewFileArrayStore("")
+	require.NoError(t, err)
+	require.NoError(t, dst.InsertNewURLPair(ctx, uid, "abcdef", "https://github.com/serjyuriev"))
+
+	report, err := Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, MigrationReport{Updated: 1}, report)
+
+	l, err := dst.FindLink(ctx, "abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, linkFields(protected), linkFields(l))
+
+	srcSum, err := Summarize(ctx, src)
+	require.NoError(t, err)
+	dstSum, err := Summarize(ctx, dst)
+	require.NoError(t, err)
+	assert.Equal(t, srcSum, dstSum)
+
+	report, err = Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, MigrationReport{Unchanged: 1}, report)
+}
+
+func TestMigrate_entities(t *testing.T) {
+	ctx := context.Background()
+	uid, uid2 := uuid.New(), uuid.New()
+	ws := Workspace{ID: uuid.New(), Name: "team"}
+
+	src, err := NewFileStore("")
+	require.NoError(t, err)
+	require.NoError(t, src.InsertAccount(ctx, Account{ID: uid, Login: "serj", PasswordHash: "hash"}))
+	require.NoError(t, src.InsertAccount(ctx, Account{ID: uid2, Login: "other", PasswordHash: "hash"}))
+	require.NoError(t, src.InsertWorkspace(ctx, ws, uid))
+	require.NoError(t, src.SetMember(ctx, Member{WorkspaceID: ws.ID, UserID: uid2, Role: RoleEditor}))
+	require.NoError(t, src.InsertTemplate(ctx, Template{
+		ID:        "tpl1",
+		Name:      "newsletter",
+		Params:    map[string]string{"utm_source": "news"},
+		UserID:    uid,
+		IsDefault: true,
+	}))
+	require.NoError(t, src.InsertToken(ctx, Token{ID: "tok1", Name: "ci", Hash: "h1", UserID: uid}))
+
+	dst, err := NewFileArrayStore("")
+	require.NoError(t, err)
+	require.NoError(t, dst.InsertAccount(ctx, Account{ID: uuid.New(), Login: "other", PasswordHash: "hash"}))
+	require.NoError(t, dst.InsertToken(ctx, Token{ID: "revoked", Name: "old", Hash: "h0", UserID: uid}))
+
+	report, err := Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, EntityReport{Inserted: 1, Conflicted: []string{uid2.String()}}, report.Accounts)
+	assert.Equal(t, EntityReport{Inserted: 1}, report.Workspaces)
+	assert.Equal(t, EntityReport{Inserted: 1}, report.Templates)
+	assert.Equal(t, EntityReport{Inserted: 1, Deleted: 1}, report.Tokens)
+
+	a, err := dst.FindAccountByLogin(ctx, "serj")
+	require.NoError(t, err)
+	assert.Equal(t, uid, a.ID)
+	members, err := dst.FindMembers(ctx, ws.ID)
+	require.NoError(t, err)
+	assert.Len(t, members, 2)
+	_, err = dst.FindTokenByHash(ctx, "h0")
+	assert.ErrorIs(t, err, ErrNoTokenWasFound)
+
+	// the only difference left is conflicting account
+	srcSum, err := Summarize(ctx, src)
+	require.NoError(t, err)
+	dstSum, err := Summarize(ctx, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 2, srcSum.Accounts)
+	assert.Equal(t, 1, srcSum.Workspaces)
+	assert.Equal(t, 1, srcSum.Templates)
+	assert.Equal(t, 1, srcSum.Tokens)
+	assert.NotEqual(t, srcSum.Checksum, dstSum.Checksum)
+
+	require.NoError(t, src.UpdateTemplate(ctx, Template{
+		ID:     "tpl1",
+		Name:   "newsletter",
+		Params: map[string]string{"utm_source": "mail"},
+		UserID: uid,
+	}))
+	require.NoError(t, src.DeleteMember(ctx, ws.ID, uid2))
+	require.NoError(t, src.DeleteToken(ctx, uid, "tok1"))
+
+	report, err = Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, EntityReport{Updated: 1}, report.Workspaces)
+	assert.Equal(t, EntityReport{Updated: 1}, report.Templates)
+	assert.Equal(t, EntityReport{Deleted: 1}, report.Tokens)
+
+	templates, err := dst.FindTemplatesByUser(ctx, uid)
+	require.NoError(t, err)
+	require.Len(t, templates, 1)
+	assert.Equal(t, map[string]string{"utm_source": "mail"}, templates[0].Params)
+	assert.False(t, templates[0].IsDefault)
+	members, err = dst.FindMembers(ctx, ws.ID)
+	require.NoError(t, err)
+	assert.Len(t, members, 1)
+
+	report, err = Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, EntityReport{Unchanged: 1}, report.Workspaces)
+	assert.Equal(t, EntityReport{Unchanged: 1}, report.Templates)
+	assert.Equal(t, EntityReport{}, report.Tokens)
+
+	other, err := NewFileStore("")
+	require.NoError(t, err)
+	_, err = Migrate(ctx, src, other)
+	require.NoError(t, err)
+	srcSum, err = Summarize(ctx, src)
+	require.NoError(t, err)
+	otherSum, err := Summarize(ctx, other)
+	require.NoError(t, err)
+	assert.Equal(t, srcSum, otherSum)
+}
+
+func TestSummarize(t *testing.T) {
+	ctx := context.Background()
+	uid := uuid.New()
+	urls := map[string]string{
+		"abcdef": "https://github.com/serjyuriev",
+		"lkasdj": "https://yandex.ru",
+	}
+
+	mapStore, err := NewFileStore("")
+	require.NoError(t, err)
+	arrayStore, err := NewFileArrayStore("")
+	require.NoError(t, err)
+
+	empty, err := Summarize(ctx, mapStore)
+	require.NoError(t, err)
+	assert.Equal(t, 0, empty.Count)
+
+	require.NoError(t, mapStore.InsertManyURLs(ctx, uid, urls))
+	require.NoError(t, arrayStore.InsertManyURLs(ctx, uid, urls))
+
+	mapSum, err := Summarize(ctx, mapStore)
+	require.NoError(t, err)
+	arraySum, err := Summarize(ctx, arrayStore)
+	require.NoError(t, err)
+	assert.Equal(t, 2, mapSum.Count)
+	assert.Equal(t, mapSum, arraySum)
+
+	require.NoError(t, arrayStore.DeleteManyURLs(ctx, uid, []string{"abcdef"}))
+	arraySum, err = Summarize(ctx, arrayStore)
+	require.NoError(t, err)
+	assert.Equal(t, mapSum.Count, arraySum.Count)
+	assert.NotEqual(t, mapSum.Checksum, arraySum.Checksum)
+
+	require.NoError(t, mapStore.DeleteManyURLs(ctx, uid, []string{"abcdef"}))
+	require.NoError(t, mapStore.SetDisabled(ctx, "lkasdj", true))
+	mapSum, err = Summarize(ctx, mapStore)
+	require.NoError(t, err)
+	assert.NotEqual(t, mapSum.Checksum, arraySum.Checksum)
+}
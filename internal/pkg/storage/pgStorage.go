@@ -3,8 +3,10 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
@@ -41,37 +43,174 @@ func NewPgStore(connectionString string) (Store, error) {
 			added_by_user TEXT NOT NULL,
 			is_deleted BOOLEAN NOT NULL
 		);
-		CREATE UNIQUE INDEX IF NOT EXISTS original_url_idx ON urls (original_url);`); err != nil {
+		CREATE UNIQUE INDEX IF NOT EXISTS original_url_idx ON urls (original_url);
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS creator_ip TEXT NOT NULL DEFAULT '';
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS creator_ua_hash TEXT NOT NULL DEFAULT '';
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash TEXT NOT NULL DEFAULT '';
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_uses INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS remaining_uses INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_code INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS passthrough INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS workspace_id TEXT NOT NULL DEFAULT '';
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS is_disabled BOOLEAN NOT NULL DEFAULT FALSE;
+		UPDATE urls SET deleted_at = updated_at WHERE is_deleted AND deleted_at IS NULL;
+		CREATE INDEX IF NOT EXISTS deleted_at_idx ON urls (deleted_at) WHERE is_deleted;
+		CREATE INDEX IF NOT EXISTS user_created_idx ON urls (added_by_user, created_at, short_id);
+		CREATE INDEX IF NOT EXISTS user_host_idx ON urls (added_by_user, (` + urlHostExpr + `));
+		CREATE INDEX IF NOT EXISTS workspace_created_idx ON urls (workspace_id, created_at, short_id) WHERE workspace_id <> '';
+		CREATE TABLE IF NOT EXISTS link_tags (
+			short_id TEXT NOT NULL REFERENCES urls (short_id) ON DELETE CASCADE,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (short_id, tag)
+		);
+		CREATE INDEX IF NOT EXISTS link_tags_tag_idx ON link_tags (tag, short_id);
+		CREATE TABLE IF NOT EXISTS url_history (
+			short_id TEXT NOT NULL REFERENCES urls (short_id) ON DELETE CASCADE,
+			original_url TEXT NOT NULL,
+			replaced_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS url_history_short_idx ON url_history (short_id, replaced_at);
+		CREATE TABLE IF NOT EXISTS templates (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			params JSONB NOT NULL,
+			is_default BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS templates_user_idx ON templates (user_id, created_at, id);
+		CREATE UNIQUE INDEX IF NOT EXISTS templates_default_idx ON templates (user_id) WHERE is_default;
+		CREATE TABLE IF NOT EXISTS tokens (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			hash TEXT NOT NULL UNIQUE,
+			scope INTEGER NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS tokens_user_idx ON tokens (user_id, created_at, id);
+		CREATE TABLE IF NOT EXISTS accounts (
+			id TEXT PRIMARY KEY,
+			login TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS workspaces (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS workspace_members (
+			workspace_id TEXT NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+			user_id TEXT NOT NULL,
+			role INTEGER NOT NULL,
+			added_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (workspace_id, user_id)
+		);
+		CREATE INDEX IF NOT EXISTS workspace_members_user_idx ON workspace_members (user_id);`); err != nil {
 		return nil, fmt.Errorf("unable to execute create statements:\n%w", err)
 	}
 
+	// trigram index speeds up substring search, but requires pg_trgm extension
+	if _, err = s.db.Exec(
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm;
+		CREATE INDEX IF NOT EXISTS original_url_trgm_idx ON urls USING gin (original_url gin_trgm_ops);`,
+	); err != nil {
+		log.Printf("unable to create trigram index: %v\n", err)
+	}
+
 	return s, nil
 }
 
-// DeleteManyURLs removes provided URLs from database.
-func (s *pgStore) DeleteManyURLs(ctx context.Context, userID uuid.UUID, urls []string) error {
-	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false})
+// ConsumeUse decrements number of remaining uses of not deleted link
+// with limited number of uses, returning its original URL.
+func (s *pgStore) ConsumeUse(ctx context.Context, shortPath string) (string, error) {
+	var original string
+	err := s.db.QueryRowContext(
+		ctx,
+		`UPDATE urls SET remaining_uses = remaining_uses - 1
+		WHERE short_id = $1 AND is_deleted = FALSE AND max_uses > 0 AND remaining_uses > 0
+		RETURNING original_url`,
+		shortPath,
+	).Scan(&original)
+	if err == nil {
+		return original, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+
+	// find out why link wasn't updated
+	l, err := s.FindLink(ctx, shortPath)
 	if err != nil {
-		return fmt.Errorf("unable to begin transaction:\n%w", err)
+		return "", err
 	}
-	defer tx.Rollback()
+	if l.IsDeleted {
+		return "", ErrShortenedDeleted
+	}
+	return "", ErrLinkExhausted
+}
 
-	cmd := fmt.Sprintf(
-		"UPDATE urls SET is_deleted = TRUE WHERE added_by_user = $1 AND short_id in ('%s');",
-		strings.Join(urls, "','"),
-	)
+// CountActiveLinks returns number of not deleted links added by user.
+func (s *pgStore) CountActiveLinks(ctx context.Context, userID uuid.UUID) (int, error) {
+	var n int
+	if err := s.db.QueryRowContext(
+		ctx,
+		"SELECT count(*) FROM urls WHERE added_by_user = $1 AND is_deleted = FALSE",
+		userID.String(),
+	).Scan(&n); err != nil {
+		return 0, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return n, nil
+}
 
-	stmt, err := tx.Prepare(cmd)
+// CountLinksByUser returns number of links added by every user.
+func (s *pgStore) CountLinksByUser(ctx context.Context) ([]UserLinkCount, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT added_by_user, count(*), count(*) FILTER (WHERE is_deleted), count(*) FILTER (WHERE is_disabled)
+		FROM urls GROUP BY added_by_user ORDER BY count(*) DESC, added_by_user`,
+	)
 	if err != nil {
-		return fmt.Errorf("unable to prepare sql statement:\n%w", err)
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
 	}
-	defer stmt.Close()
+	defer rows.Close()
 
-	if _, err = stmt.Exec(userID.String()); err != nil {
-		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	counts := make([]UserLinkCount, 0)
+	for rows.Next() {
+		var c UserLinkCount
+		var user string
+		if err = rows.Scan(&user, &c.Total, &c.Deleted, &c.Disabled); err != nil {
+			return nil, fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		if c.UserID, err = uuid.Parse(user); err != nil {
+			return nil, fmt.Errorf("unable to parse user id:\n%w", err)
+		}
+		counts = append(counts, c)
 	}
 
-	return tx.Commit()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return counts, nil
+}
+
+// DeleteManyURLs marks provided URLs added by user as deleted.
+func (s *pgStore) DeleteManyURLs(ctx context.Context, userID uuid.UUID, urls []string) error {
+	if _, err := s.db.ExecContext(
+		ctx,
+		`UPDATE urls SET is_deleted = TRUE, updated_at = now(), deleted_at = now()
+		WHERE added_by_user = $1 AND short_id = ANY($2) AND is_deleted = FALSE`,
+		userID.String(),
+		urls,
+	); err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	return nil
 }
 
 // FindByOriginalURL searches for short URL with corresponding original URL in database.
@@ -92,6 +231,65 @@ func (s *pgStore) FindByOriginalURL(ctx context.Context, originalURL string) (st
 	return originalURL, nil
 }
 
+// FindLink returns full information about link with provided short URL,
+// including deleted ones.
+func (s *pgStore) FindLink(ctx context.Context, shortPath string) (Link, error) {
+	row := s.db.QueryRowContext(
+		ctx,
+		"SELECT "+linkColumns+" FROM urls WHERE short_id = $1",
+		shortPath,
+	)
+	l, err := scanLink(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Link{}, ErrNoURLWasFound
+		}
+		return Link{}, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return l, nil
+}
+
+// FindLinkHistory returns previous original URLs of link
+// with provided short URL, oldest first.
+func (s *pgStore) FindLinkHistory(ctx context.Context, shortPath string) ([]LinkRevision, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM urls WHERE short_id = $1)",
+		shortPath,
+	).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	if !exists {
+		return nil, ErrNoURLWasFound
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT replaced_at, original_url FROM url_history WHERE short_id = $1 ORDER BY replaced_at",
+		shortPath,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	history := make([]LinkRevision, 0)
+	for rows.Next() {
+		var r LinkRevision
+		if err = rows.Scan(&r.ReplacedAt, &r.OriginalURL); err != nil {
+			return nil, fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		r.ReplacedAt = r.ReplacedAt.UTC()
+		history = append(history, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return history, nil
+}
+
 // FindOriginalURL searches for original URL with corresponding short URL in database.
 func (s *pgStore) FindOriginalURL(ctx context.Context, shortPath string) (string, error) {
 	row := s.db.QueryRowContext(ctx, "SELECT original_url, is_deleted FROM urls WHERE short_id = $1", shortPath)
@@ -137,50 +335,1185 @@ func (s *pgStore) FindURLsByUser(ctx context.Context, userID uuid.UUID) (map[str
 	return urls, nil
 }
 
-// InsertManyURLs writes provided short URL - original URL pairs into database.
-func (s *pgStore) InsertManyURLs(ctx context.Context, userID uuid.UUID, urls map[string]string) error {
+// InsertLinks writes provided links into database in a single transaction.
+func (s *pgStore) InsertLinks(ctx context.Context, links []Link) error {
 	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false})
 	if err != nil {
 		return fmt.Errorf("unable to begin transaction:\n%w", err)
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare("INSERT INTO urls(short_id, original_url, added_by_user, is_deleted) VALUES ($1, $2, $3, FALSE)")
+	stmt, err := tx.PrepareContext(
+		ctx,
+		`INSERT INTO urls(`+insertColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+	)
 	if err != nil {
 		return fmt.Errorf("unable to prepare sql statement:\n%w", err)
 	}
 	defer stmt.Close()
 
-	for short, long := range urls {
-		if _, err = stmt.Exec(short, long, userID.String()); err != nil {
+	for _, l := range withTimestamps(links) {
+		if _, err = stmt.ExecContext(
+			ctx,
+			l.ShortPath,
+			l.OriginalURL,
+			l.UserID.String(),
+			l.IsDeleted,
+			l.CreatedAt,
+			l.UpdatedAt,
+			l.CreatorIP,
+			l.CreatorUAHash,
+			nullTime(l.DeletedAt),
+			l.PasswordHash,
+			l.MaxUses,
+			l.RemainingUses,
+			l.RedirectCode,
+			int(l.Passthrough),
+			workspaceColumn(l.WorkspaceID),
+			l.IsDisabled,
+		); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+				return ErrNotUniqueOriginalURL
+			}
 			return fmt.Errorf("unable to execute sql statement:\n%w", err)
 		}
+		if err = insertTags(ctx, tx, l.ShortPath, l.Tags); err != nil {
+			return err
+		}
 	}
 
 	return tx.Commit()
 }
 
+// InsertManyURLs writes provided short URL - original URL pairs into database.
+func (s *pgStore) InsertManyURLs(ctx context.Context, userID uuid.UUID, urls map[string]string) error {
+	return s.InsertLinks(ctx, pairsToLinks(userID, urls))
+}
+
 // InsertNewURLPair writes provided short URL - original URL pair into database.
 func (s *pgStore) InsertNewURLPair(ctx context.Context, userID uuid.UUID, shortPath, originalURL string) error {
-	if _, err := s.db.ExecContext(ctx, "INSERT INTO urls VALUES ($1, $2, $3, $4)",
-		shortPath,
-		originalURL,
-		userID.String(),
-		false,
-	); err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			if pgErr.Code == pgerrcode.UniqueViolation {
-				return ErrNotUniqueOriginalURL
-			}
+	return s.InsertLinks(ctx, []Link{{
+		ShortPath:   shortPath,
+		OriginalURL: originalURL,
+		UserID:      userID,
+	}})
+}
+
+// IterateLinks calls fn for every stored link ordered by short URL,
+// stopping at the first error returned by fn.
+func (s *pgStore) IterateLinks(ctx context.Context, fn func(Link) error) error {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT "+linkColumns+" FROM urls ORDER BY short_id",
+	)
+	if err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		l, err := scanLink(rows)
+		if err != nil {
+			return fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		if err = fn(l); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return nil
+}
+
+// IterateAllLinks calls fn for links of all users matching options
+// ordered by creation time, stopping at the first error returned by fn.
+func (s *pgStore) IterateAllLinks(ctx context.Context, opts ListOptions, fn func(Link) error) error {
+	return s.iterateLinks(ctx, "", "", opts, fn)
+}
+
+// IterateUserLinks calls fn for links added by user matching options
+// ordered by creation time, stopping at the first error returned by fn.
+func (s *pgStore) IterateUserLinks(ctx context.Context, userID uuid.UUID, opts ListOptions, fn func(Link) error) error {
+	return s.iterateLinks(ctx, "added_by_user", userID.String(), opts, fn)
+}
+
+// IterateWorkspaceLinks calls fn for links of workspace matching options
+// ordered by creation time, stopping at the first error returned by fn.
+func (s *pgStore) IterateWorkspaceLinks(ctx context.Context, workspaceID uuid.UUID, opts ListOptions, fn func(Link) error) error {
+	if workspaceID == uuid.Nil {
+		return nil
+	}
+	return s.iterateLinks(ctx, "workspace_id", workspaceID.String(), opts, fn)
+}
+
+// iterateLinks calls fn for links having provided value of column
+// and matching options, ordered by creation time. Empty column means links of all users.
+func (s *pgStore) iterateLinks(ctx context.Context, column, value string, opts ListOptions, fn func(Link) error) error {
+	var conds []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if column != "" {
+		conds = append(conds, column+" = "+arg(value))
+	}
+
+	switch opts.Status {
+	case StatusActive:
+		conds = append(conds, "is_deleted = FALSE")
+	case StatusDeleted:
+		conds = append(conds, "is_deleted = TRUE")
+	}
+	if !opts.CreatedFrom.IsZero() {
+		conds = append(conds, "created_at >= "+arg(opts.CreatedFrom))
+	}
+	if !opts.CreatedTo.IsZero() {
+		conds = append(conds, "created_at < "+arg(opts.CreatedTo))
+	}
+	if opts.Query != "" {
+		conds = append(conds, "original_url ILIKE "+arg("%"+escapeLike(opts.Query)+"%"))
+	}
+	if opts.Host != "" {
+		conds = append(conds, urlHostExpr+" = "+arg(strings.ToLower(opts.Host)))
+	}
+	if opts.Tag != "" {
+		conds = append(conds, "EXISTS (SELECT 1 FROM link_tags t WHERE t.short_id = urls.short_id AND t.tag = "+arg(opts.Tag)+")")
+	}
+	if !opts.After.IsZero() {
+		conds = append(conds, fmt.Sprintf(
+			"(created_at, short_id) > (%s, %s)",
+			arg(opts.After.CreatedAt),
+			arg(opts.After.ShortPath),
+		))
+	}
+
+	query := "SELECT " + linkColumns + " FROM urls"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY created_at, short_id"
+	if opts.Limit > 0 {
+		query += " LIMIT " + arg(opts.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		l, err := scanLink(rows)
+		if err != nil {
+			return fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		if err = fn(l); err != nil {
+			return err
 		}
-		return fmt.Errorf("unable to insert values:\n%w", err)
 	}
 
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
 	return nil
 }
 
+// FindTagsByUser returns tags of not deleted links added by user
+// along with number of links marked with each of them.
+func (s *pgStore) FindTagsByUser(ctx context.Context, userID uuid.UUID) (map[string]int, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT t.tag, count(*) FROM link_tags t JOIN urls u ON u.short_id = t.short_id
+		WHERE u.added_by_user = $1 AND u.is_deleted = FALSE GROUP BY t.tag`,
+		userID.String(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var tag string
+		var count int
+		if err = rows.Scan(&tag, &count); err != nil {
+			return nil, fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		counts[tag] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return counts, nil
+}
+
 // Ping checks connection with database.
 func (s *pgStore) Ping(ctx context.Context) error {
 	return s.db.PingContext(ctx)
 }
+
+// PurgeDeletedURLs permanently removes links deleted before provided time,
+// returning number of removed links.
+func (s *pgStore) PurgeDeletedURLs(ctx context.Context, deletedBefore time.Time) (int, error) {
+	res, err := s.db.ExecContext(
+		ctx,
+		"DELETE FROM urls WHERE is_deleted = TRUE AND deleted_at < $1",
+		deletedBefore,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get affected rows:\n%w", err)
+	}
+	return int(n), nil
+}
+
+// ReassignURLs makes user "to" the owner of all links added by user "from"
+// that don't belong to workspaces, returning number of reassigned links.
+func (s *pgStore) ReassignURLs(ctx context.Context, from, to uuid.UUID) (int, error) {
+	res, err := s.db.ExecContext(
+		ctx,
+		"UPDATE urls SET added_by_user = $2 WHERE added_by_user = $1 AND workspace_id = ''",
+		from.String(),
+		to.String(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get number of reassigned rows:\n%w", err)
+	}
+	return int(n), nil
+}
+
+// ReplaceLink replaces every field of existing link with the same short URL
+// regardless of user who added it, keeping history of its original URLs.
+func (s *pgStore) ReplaceLink(ctx context.Context, l Link) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false})
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction:\n%w", err)
+	}
+	defer tx.Rollback()
+
+	l = withTimestamps([]Link{l})[0]
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE urls SET (`+insertColumns+`) =
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		WHERE short_id = $1`,
+		l.ShortPath,
+		l.OriginalURL,
+		l.UserID.String(),
+		l.IsDeleted,
+		l.CreatedAt,
+		l.UpdatedAt,
+		l.CreatorIP,
+		l.CreatorUAHash,
+		nullTime(l.DeletedAt),
+		l.PasswordHash,
+		l.MaxUses,
+		l.RemainingUses,
+		l.RedirectCode,
+		int(l.Passthrough),
+		workspaceColumn(l.WorkspaceID),
+		l.IsDisabled,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrNotUniqueOriginalURL
+		}
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("unable to get affected rows:\n%w", err)
+	} else if n == 0 {
+		return ErrNoURLWasFound
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM link_tags WHERE short_id = $1", l.ShortPath); err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	if err = insertTags(ctx, tx, l.ShortPath, l.Tags); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetLinkHistory replaces previous original URLs of link
+// with provided short URL.
+func (s *pgStore) SetLinkHistory(ctx context.Context, shortPath string, history []LinkRevision) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false})
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction:\n%w", err)
+	}
+	defer tx.Rollback()
+
+	var found int
+	if err = tx.QueryRowContext(
+		ctx,
+		"SELECT 1 FROM urls WHERE short_id = $1 FOR UPDATE",
+		shortPath,
+	).Scan(&found); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoURLWasFound
+		}
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM url_history WHERE short_id = $1", shortPath); err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	for _, r := range history {
+		if _, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO url_history (short_id, original_url, replaced_at) VALUES ($1, $2, $3)",
+			shortPath,
+			r.OriginalURL,
+			r.ReplacedAt,
+		); err != nil {
+			return fmt.Errorf("unable to execute sql statement:\n%w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// RestoreManyURLs removes deletion mark from provided URLs added by user
+// that were deleted at or after deletedAfter, returning restored ones.
+// Zero deletedAfter allows to restore links regardless of deletion time.
+func (s *pgStore) RestoreManyURLs(ctx context.Context, userID uuid.UUID, urls []string, deletedAfter time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`UPDATE urls SET is_deleted = FALSE, updated_at = now(), deleted_at = NULL
+		WHERE added_by_user = $1 AND short_id = ANY($2) AND is_deleted = TRUE
+		AND ($3::timestamptz IS NULL OR deleted_at >= $3)
+		RETURNING short_id`,
+		userID.String(),
+		urls,
+		nullTime(deletedAfter),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	defer rows.Close()
+
+	restored := make([]string, 0, len(urls))
+	for rows.Next() {
+		var short string
+		if err = rows.Scan(&short); err != nil {
+			return nil, fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		restored = append(restored, short)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	return restored, nil
+}
+
+// SetDisabled sets or removes disabled mark of link with provided short URL
+// regardless of user who added it.
+func (s *pgStore) SetDisabled(ctx context.Context, shortPath string, disabled bool) error {
+	res, err := s.db.ExecContext(
+		ctx,
+		"UPDATE urls SET is_disabled = $2 WHERE short_id = $1",
+		shortPath,
+		disabled,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("unable to get affected rows:\n%w", err)
+	} else if n == 0 {
+		return ErrNoURLWasFound
+	}
+	return nil
+}
+
+// SetTags replaces tags of link with provided short URL added by user.
+func (s *pgStore) SetTags(ctx context.Context, userID uuid.UUID, shortPath string, tags []string) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false})
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction:\n%w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		"UPDATE urls SET updated_at = now() WHERE short_id = $1 AND added_by_user = $2",
+		shortPath,
+		userID.String(),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("unable to get affected rows:\n%w", err)
+	} else if n == 0 {
+		return ErrNoURLWasFound
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM link_tags WHERE short_id = $1", shortPath); err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	if err = insertTags(ctx, tx, shortPath, tags); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateOriginalURL changes original URL of not deleted link
+// with provided short URL added by user, keeping the previous one in its history.
+func (s *pgStore) UpdateOriginalURL(ctx context.Context, userID uuid.UUID, shortPath, originalURL string) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false})
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction:\n%w", err)
+	}
+	defer tx.Rollback()
+
+	var prev, user string
+	var isDeleted bool
+	if err = tx.QueryRowContext(
+		ctx,
+		"SELECT original_url, added_by_user, is_deleted FROM urls WHERE short_id = $1 FOR UPDATE",
+		shortPath,
+	).Scan(&prev, &user, &isDeleted); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoURLWasFound
+		}
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	if user != userID.String() {
+		return ErrNoURLWasFound
+	}
+	if isDeleted {
+		return ErrShortenedDeleted
+	}
+	if prev == originalURL {
+		return nil
+	}
+
+	now := Now()
+	if _, err = tx.ExecContext(
+		ctx,
+		"UPDATE urls SET original_url = $1, updated_at = $2 WHERE short_id = $3",
+		originalURL,
+		now,
+		shortPath,
+	); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrNotUniqueOriginalURL
+		}
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	if _, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO url_history (short_id, original_url, replaced_at) VALUES ($1, $2, $3)",
+		shortPath,
+		prev,
+		now,
+	); err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	return tx.Commit()
+}
+
+// DeleteTemplate removes template of user.
+func (s *pgStore) DeleteTemplate(ctx context.Context, userID uuid.UUID, id string) error {
+	res, err := s.db.ExecContext(
+		ctx,
+		"DELETE FROM templates WHERE id = $1 AND user_id = $2",
+		id,
+		userID.String(),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to get number of deleted rows:\n%w", err)
+	}
+	if n == 0 {
+		return ErrNoTemplateWasFound
+	}
+	return nil
+}
+
+// FindTemplatesByUser returns templates of user ordered by creation time.
+func (s *pgStore) FindTemplatesByUser(ctx context.Context, userID uuid.UUID) ([]Template, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, name, params, is_default, created_at, updated_at FROM templates
+		WHERE user_id = $1 ORDER BY created_at, id`,
+		userID.String(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	templates := make([]Template, 0)
+	for rows.Next() {
+		t := Template{UserID: userID}
+		var params []byte
+		if err = rows.Scan(&t.ID, &t.Name, &params, &t.IsDefault, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		if err = json.Unmarshal(params, &t.Params); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal template parameters:\n%w", err)
+		}
+		templates = append(templates, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return templates, nil
+}
+
+// InsertTemplate saves new template. If template is default,
+// other templates of its user stop being default.
+func (s *pgStore) InsertTemplate(ctx context.Context, t Template) error {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = Now()
+	}
+	if t.UpdatedAt.IsZero() {
+		t.UpdatedAt = t.CreatedAt
+	}
+	params, err := json.Marshal(t.Params)
+	if err != nil {
+		return fmt.Errorf("unable to marshal template parameters:\n%w", err)
+	}
+	return s.saveTemplate(
+		ctx,
+		t,
+		`INSERT INTO templates (id, user_id, name, params, is_default, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		t.ID,
+		t.UserID.String(),
+		t.Name,
+		string(params),
+		t.IsDefault,
+		t.CreatedAt,
+		t.UpdatedAt,
+	)
+}
+
+// IterateTemplates calls fn for every template ordered by ID,
+// stopping at the first error returned by fn.
+func (s *pgStore) IterateTemplates(ctx context.Context, fn func(Template) error) error {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT id, user_id, name, params, is_default, created_at, updated_at FROM templates ORDER BY id",
+	)
+	if err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Template
+		var userID string
+		var params []byte
+		if err = rows.Scan(&t.ID, &userID, &t.Name, &params, &t.IsDefault, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		if t.UserID, err = uuid.Parse(userID); err != nil {
+			return fmt.Errorf("unable to parse user id:\n%w", err)
+		}
+		if err = json.Unmarshal(params, &t.Params); err != nil {
+			return fmt.Errorf("unable to unmarshal template parameters:\n%w", err)
+		}
+		if err = fn(t); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return nil
+}
+
+// UpdateTemplate replaces name, parameters and default mark of template of user.
+func (s *pgStore) UpdateTemplate(ctx context.Context, t Template) error {
+	params, err := json.Marshal(t.Params)
+	if err != nil {
+		return fmt.Errorf("unable to marshal template parameters:\n%w", err)
+	}
+	return s.saveTemplate(
+		ctx,
+		t,
+		`UPDATE templates SET name = $3, params = $4, is_default = $5, updated_at = $6
+		WHERE id = $1 AND user_id = $2`,
+		t.ID,
+		t.UserID.String(),
+		t.Name,
+		string(params),
+		t.IsDefault,
+		Now(),
+	)
+}
+
+// saveTemplate executes statement inserting or updating template
+// after removing default mark from other templates of user, if needed.
+func (s *pgStore) saveTemplate(ctx context.Context, t Template, statement string, args ...interface{}) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false})
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction:\n%w", err)
+	}
+	defer tx.Rollback()
+
+	if t.IsDefault {
+		if _, err = tx.ExecContext(
+			ctx,
+			"UPDATE templates SET is_default = FALSE WHERE user_id = $1 AND id <> $2 AND is_default",
+			t.UserID.String(),
+			t.ID,
+		); err != nil {
+			return fmt.Errorf("unable to execute sql statement:\n%w", err)
+		}
+	}
+	res, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to get number of saved rows:\n%w", err)
+	}
+	if n == 0 {
+		return ErrNoTemplateWasFound
+	}
+	return tx.Commit()
+}
+
+// FindAccount returns account with provided ID.
+func (s *pgStore) FindAccount(ctx context.Context, id uuid.UUID) (Account, error) {
+	return s.findAccount(ctx, "id", id.String())
+}
+
+// FindAccountByLogin returns account with provided login.
+func (s *pgStore) FindAccountByLogin(ctx context.Context, login string) (Account, error) {
+	return s.findAccount(ctx, "login", login)
+}
+
+// findAccount returns account with provided value of unique column.
+func (s *pgStore) findAccount(ctx context.Context, column, value string) (Account, error) {
+	var a Account
+	var id string
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT id, login, password_hash, created_at FROM accounts WHERE "+column+" = $1",
+		value,
+	).Scan(&id, &a.Login, &a.PasswordHash, &a.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Account{}, ErrNoAccountWasFound
+		}
+		return Account{}, fmt.Errorf("unable to scan values:\n%w", err)
+	}
+	if a.ID, err = uuid.Parse(id); err != nil {
+		return Account{}, fmt.Errorf("unable to parse account id:\n%w", err)
+	}
+	return a, nil
+}
+
+// IterateAccounts calls fn for every account ordered by ID,
+// stopping at the first error returned by fn.
+func (s *pgStore) IterateAccounts(ctx context.Context, fn func(Account) error) error {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT id, login, password_hash, created_at FROM accounts ORDER BY id",
+	)
+	if err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Account
+		var id string
+		if err = rows.Scan(&id, &a.Login, &a.PasswordHash, &a.CreatedAt); err != nil {
+			return fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		if a.ID, err = uuid.Parse(id); err != nil {
+			return fmt.Errorf("unable to parse account id:\n%w", err)
+		}
+		if err = fn(a); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return nil
+}
+
+// InsertAccount saves new account. Both ID and login of account must be unique.
+func (s *pgStore) InsertAccount(ctx context.Context, a Account) error {
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = Now()
+	}
+	if _, err := s.db.ExecContext(
+		ctx,
+		"INSERT INTO accounts (id, login, password_hash, created_at) VALUES ($1, $2, $3, $4)",
+		a.ID.String(),
+		a.Login,
+		a.PasswordHash,
+		a.CreatedAt,
+	); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrAccountExists
+		}
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	return nil
+}
+
+// DeleteToken removes API token of user.
+func (s *pgStore) DeleteToken(ctx context.Context, userID uuid.UUID, id string) error {
+	res, err := s.db.ExecContext(
+		ctx,
+		"DELETE FROM tokens WHERE id = $1 AND user_id = $2",
+		id,
+		userID.String(),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to get number of deleted rows:\n%w", err)
+	}
+	if n == 0 {
+		return ErrNoTokenWasFound
+	}
+	return nil
+}
+
+// FindTokenByHash returns API token with provided hash.
+func (s *pgStore) FindTokenByHash(ctx context.Context, hash string) (Token, error) {
+	t := Token{Hash: hash}
+	var userID string
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT id, user_id, name, scope, created_at FROM tokens WHERE hash = $1",
+		hash,
+	).Scan(&t.ID, &userID, &t.Name, &t.Scope, &t.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Token{}, ErrNoTokenWasFound
+		}
+		return Token{}, fmt.Errorf("unable to scan values:\n%w", err)
+	}
+	if t.UserID, err = uuid.Parse(userID); err != nil {
+		return Token{}, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	return t, nil
+}
+
+// FindTokensByUser returns API tokens of user ordered by creation time.
+func (s *pgStore) FindTokensByUser(ctx context.Context, userID uuid.UUID) ([]Token, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, name, hash, scope, created_at FROM tokens
+		WHERE user_id = $1 ORDER BY created_at, id`,
+		userID.String(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]Token, 0)
+	for rows.Next() {
+		t := Token{UserID: userID}
+		if err = rows.Scan(&t.ID, &t.Name, &t.Hash, &t.Scope, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return tokens, nil
+}
+
+// IterateTokens calls fn for every API token ordered by ID,
+// stopping at the first error returned by fn.
+func (s *pgStore) IterateTokens(ctx context.Context, fn func(Token) error) error {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT id, user_id, name, hash, scope, created_at FROM tokens ORDER BY id",
+	)
+	if err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Token
+		var userID string
+		if err = rows.Scan(&t.ID, &userID, &t.Name, &t.Hash, &t.Scope, &t.CreatedAt); err != nil {
+			return fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		if t.UserID, err = uuid.Parse(userID); err != nil {
+			return fmt.Errorf("unable to parse user id:\n%w", err)
+		}
+		if err = fn(t); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return nil
+}
+
+// InsertToken saves new API token.
+func (s *pgStore) InsertToken(ctx context.Context, t Token) error {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = Now()
+	}
+	if _, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO tokens (id, user_id, name, hash, scope, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		t.ID,
+		t.UserID.String(),
+		t.Name,
+		t.Hash,
+		int(t.Scope),
+		t.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	return nil
+}
+
+// DeleteMember removes user from workspace.
+func (s *pgStore) DeleteMember(ctx context.Context, workspaceID, userID uuid.UUID) error {
+	res, err := s.db.ExecContext(
+		ctx,
+		"DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2",
+		workspaceID.String(),
+		userID.String(),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to get number of deleted rows:\n%w", err)
+	}
+	if n == 0 {
+		return ErrNoMemberWasFound
+	}
+	return nil
+}
+
+// FindMember returns membership of user in workspace.
+func (s *pgStore) FindMember(ctx context.Context, workspaceID, userID uuid.UUID) (Member, error) {
+	m := Member{WorkspaceID: workspaceID, UserID: userID}
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT role, added_at FROM workspace_members WHERE workspace_id = $1 AND user_id = $2",
+		workspaceID.String(),
+		userID.String(),
+	).Scan(&m.Role, &m.AddedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Member{}, ErrNoMemberWasFound
+		}
+		return Member{}, fmt.Errorf("unable to scan values:\n%w", err)
+	}
+	m.AddedAt = m.AddedAt.UTC()
+	return m, nil
+}
+
+// FindMembers returns members of workspace ordered by time they were added.
+func (s *pgStore) FindMembers(ctx context.Context, workspaceID uuid.UUID) ([]Member, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT w.id, m.user_id, m.role, m.added_at FROM workspaces w
+		LEFT JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE w.id = $1 ORDER BY m.added_at, m.user_id`,
+		workspaceID.String(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	found := false
+	members := make([]Member, 0)
+	for rows.Next() {
+		found = true
+		var id string
+		var userID sql.NullString
+		var role sql.NullInt64
+		var added sql.NullTime
+		if err = rows.Scan(&id, &userID, &role, &added); err != nil {
+			return nil, fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		if !userID.Valid {
+			continue
+		}
+		m := Member{WorkspaceID: workspaceID, Role: Role(role.Int64), AddedAt: added.Time.UTC()}
+		if m.UserID, err = uuid.Parse(userID.String); err != nil {
+			return nil, fmt.Errorf("unable to parse user id:\n%w", err)
+		}
+		members = append(members, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	if !found {
+		return nil, ErrNoWorkspaceWasFound
+	}
+	return members, nil
+}
+
+// FindWorkspacesByUser returns workspaces user is a member of
+// along with role of user, ordered by creation time.
+func (s *pgStore) FindWorkspacesByUser(ctx context.Context, userID uuid.UUID) ([]Membership, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT w.id, w.name, w.created_at, m.role FROM workspaces w
+		JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE m.user_id = $1 ORDER BY w.created_at, w.id`,
+		userID.String(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	memberships := make([]Membership, 0)
+	for rows.Next() {
+		var m Membership
+		var id string
+		if err = rows.Scan(&id, &m.Workspace.Name, &m.Workspace.CreatedAt, &m.Role); err != nil {
+			return nil, fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		if m.Workspace.ID, err = uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("unable to parse workspace id:\n%w", err)
+		}
+		m.Workspace.CreatedAt = m.Workspace.CreatedAt.UTC()
+		memberships = append(memberships, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return memberships, nil
+}
+
+// InsertWorkspace saves new workspace with provided user as its owner.
+func (s *pgStore) InsertWorkspace(ctx context.Context, w Workspace, owner uuid.UUID) error {
+	if w.CreatedAt.IsZero() {
+		w.CreatedAt = Now()
+	}
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false})
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction:\n%w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO workspaces (id, name, created_at) VALUES ($1, $2, $3)",
+		w.ID.String(),
+		w.Name,
+		w.CreatedAt,
+	); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrWorkspaceExists
+		}
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	if _, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO workspace_members (workspace_id, user_id, role, added_at) VALUES ($1, $2, $3, $4)",
+		w.ID.String(),
+		owner.String(),
+		int(RoleOwner),
+		w.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	return tx.Commit()
+}
+
+// IterateWorkspaces calls fn for every workspace ordered by ID,
+// stopping at the first error returned by fn.
+func (s *pgStore) IterateWorkspaces(ctx context.Context, fn func(Workspace) error) error {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT id, name, created_at FROM workspaces ORDER BY id",
+	)
+	if err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var w Workspace
+		var id string
+		if err = rows.Scan(&id, &w.Name, &w.CreatedAt); err != nil {
+			return fmt.Errorf("unable to scan values:\n%w", err)
+		}
+		if w.ID, err = uuid.Parse(id); err != nil {
+			return fmt.Errorf("unable to parse workspace id:\n%w", err)
+		}
+		w.CreatedAt = w.CreatedAt.UTC()
+		if err = fn(w); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("unable to execute query:\n%w", err)
+	}
+	return nil
+}
+
+// SetMember adds user to workspace or changes role of existing member.
+// Time existing member was added is kept.
+func (s *pgStore) SetMember(ctx context.Context, m Member) error {
+	if m.AddedAt.IsZero() {
+		m.AddedAt = Now()
+	}
+	if _, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO workspace_members (workspace_id, user_id, role, added_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = EXCLUDED.role`,
+		m.WorkspaceID.String(),
+		m.UserID.String(),
+		int(m.Role),
+		m.AddedAt,
+	); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+			return ErrNoWorkspaceWasFound
+		}
+		return fmt.Errorf("unable to execute sql statement:\n%w", err)
+	}
+	return nil
+}
+
+func insertTags(ctx context.Context, tx *sql.Tx, shortPath string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO link_tags (short_id, tag) SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING",
+		shortPath,
+		tags,
+	); err != nil {
+		return fmt.Errorf("unable to insert tags:\n%w", err)
+	}
+	return nil
+}
+
+// urlHostExpr extracts lowercase host from original URL.
+const urlHostExpr = `lower(substring(original_url from '^[^:/?#]+://(?:[^/?#@]*@)?([^/?#:]+)'))`
+
+// escapeLike escapes wildcard characters of LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// insertColumns lists columns of urls table filled on insert.
+const insertColumns = "short_id, original_url, added_by_user, is_deleted, " +
+	"created_at, updated_at, creator_ip, creator_ua_hash, deleted_at, password_hash, max_uses, remaining_uses, redirect_code, passthrough, workspace_id, is_disabled"
+
+// linkColumns lists columns scanned by scanLink.
+// Tags are aggregated into comma-separated string, as they can't contain commas.
+const linkColumns = insertColumns + ", " +
+	"array_to_string(ARRAY(SELECT tag FROM link_tags t WHERE t.short_id = urls.short_id ORDER BY tag), ',')"
+
+// workspaceColumn converts ID of workspace into value of workspace_id column,
+// empty for personal links.
+func workspaceColumn(id uuid.UUID) string {
+	if id == uuid.Nil {
+		return ""
+	}
+	return id.String()
+}
+
+// nullTime converts zero time into NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanLink(row rowScanner) (Link, error) {
+	var l Link
+	var user, workspace, tags string
+	var deleted sql.NullTime
+	if err := row.Scan(
+		&l.ShortPath,
+		&l.OriginalURL,
+		&user,
+		&l.IsDeleted,
+		&l.CreatedAt,
+		&l.UpdatedAt,
+		&l.CreatorIP,
+		&l.CreatorUAHash,
+		&deleted,
+		&l.PasswordHash,
+		&l.MaxUses,
+		&l.RemainingUses,
+		&l.RedirectCode,
+		&l.Passthrough,
+		&workspace,
+		&l.IsDisabled,
+		&tags,
+	); err != nil {
+		return Link{}, err
+	}
+	uid, err := uuid.Parse(user)
+	if err != nil {
+		return Link{}, fmt.Errorf("unable to parse user id:\n%w", err)
+	}
+	l.UserID = uid
+	if workspace != "" {
+		if l.WorkspaceID, err = uuid.Parse(workspace); err != nil {
+			return Link{}, fmt.Errorf("unable to parse workspace id:\n%w", err)
+		}
+	}
+	if tags != "" {
+		l.Tags = strings.Split(tags, ",")
+	}
+	l.CreatedAt = l.CreatedAt.UTC()
+	l.UpdatedAt = l.UpdatedAt.UTC()
+	if deleted.Valid {
+		l.DeletedAt = deleted.Time.UTC()
+	}
+	return l, nil
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sidecarPath returns path of a file kept next to links file
+// of file storages, e.g. "shorten_templates.json" for "shorten.json"
+// and "templates" suffix. Empty path means storage is kept in memory only.
+func sidecarPath(fileStoragePath, suffix string) string {
+	if fileStoragePath == "" {
+		return ""
+	}
+	ext := filepath.Ext(fileStoragePath)
+	return strings.TrimSuffix(fileStoragePath, ext) + "_" + suffix + ext
+}
+
+// readSidecar unmarshals JSON contents of file at provided path into v.
+// Missing or empty file leaves v untouched.
+func readSidecar(path string, v interface{}) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		log.Printf("unable to open file %s: %v\n", path, err)
+		return err
+	}
+	defer file.Close()
+
+	b, err := io.ReadAll(file)
+	if err != nil {
+		log.Printf("unable to read from file: %v\n", err)
+		return err
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		log.Printf("unable to unmarshal json: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// writeSidecar replaces contents of file at provided path with v encoded as JSON.
+func writeSidecar(path string, v interface{}) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("unable to marshal map to json: %v\n", err)
+		return err
+	}
+	if err = os.WriteFile(path, data, 0666); err != nil {
+		log.Printf("unable to write data to file: %v\n", err)
+		return err
+	}
+	return nil
+}
@@ -2,7 +2,14 @@ package storage
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -12,14 +19,398 @@ var (
 	ErrNoURLWasFound        = errors.New("no URL was found")
 	ErrNotUniqueOriginalURL = errors.New("original URL already presented")
 	ErrShortenedDeleted     = errors.New("shortened url is deleted")
+	ErrInvalidCursor        = errors.New("invalid cursor")
+	ErrLinkExhausted        = errors.New("link has no uses left")
+	ErrLinkDisabled         = errors.New("link is disabled")
+	ErrInvalidPassthrough   = errors.New("passthrough must be one of none, query, path")
 )
 
+// Passthrough selects parts of request to short URL
+// that are forwarded to original URL on redirect.
+type Passthrough int
+
+const (
+	// PassthroughNone redirects to original URL as is.
+	PassthroughNone Passthrough = iota
+	// PassthroughQuery merges query string of request into original URL.
+	PassthroughQuery
+	// PassthroughPath merges query string and appends path
+	// following short URL to the path of original URL.
+	PassthroughPath
+)
+
+var passthroughNames = []string{"none", "query", "path"}
+
+// String returns name of passthrough mode.
+func (p Passthrough) String() string {
+	if p < 0 || int(p) >= len(passthroughNames) {
+		return "Passthrough(" + strconv.Itoa(int(p)) + ")"
+	}
+	return passthroughNames[p]
+}
+
+// MarshalText encodes passthrough mode as its name.
+func (p Passthrough) MarshalText() ([]byte, error) {
+	if p < 0 || int(p) >= len(passthroughNames) {
+		return nil, ErrInvalidPassthrough
+	}
+	return []byte(passthroughNames[p]), nil
+}
+
+// UnmarshalText decodes passthrough mode from its name.
+// Empty name means PassthroughNone.
+func (p *Passthrough) UnmarshalText(b []byte) error {
+	if len(b) == 0 {
+		*p = PassthroughNone
+		return nil
+	}
+	for i, name := range passthroughNames {
+		if string(b) == name {
+			*p = Passthrough(i)
+			return nil
+		}
+	}
+	return ErrInvalidPassthrough
+}
+
+// Link contains full information about shortened URL.
+// MaxUses limits number of redirects by the link, zero means no limit.
+// RedirectCode is HTTP status code of redirect, zero means application default.
+// Passthrough selects parts of request forwarded to original URL.
+// WorkspaceID is ID of workspace sharing the link, uuid.Nil for personal links.
+// Disabled links are kept by their owners but can't be followed.
+type Link struct {
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+	DeletedAt     time.Time   `json:"deleted_at"`
+	ShortPath     string      `json:"short_id"`
+	OriginalURL   string      `json:"original_url"`
+	CreatorIP     string      `json:"creator_ip,omitempty"`
+	CreatorUAHash string      `json:"creator_ua_hash,omitempty"`
+	PasswordHash  string      `json:"password_hash,omitempty"`
+	Tags          []string    `json:"tags,omitempty"`
+	MaxUses       int         `json:"max_uses,omitempty"`
+	RemainingUses int         `json:"remaining_uses,omitempty"`
+	RedirectCode  int         `json:"redirect_code,omitempty"`
+	Passthrough   Passthrough `json:"passthrough,omitempty"`
+	UserID        uuid.UUID   `json:"user_id"`
+	WorkspaceID   uuid.UUID   `json:"workspace_id"`
+	IsDeleted     bool        `json:"is_deleted"`
+	IsDisabled    bool        `json:"is_disabled"`
+}
+
+// UserLinkCount is a number of links added by user.
+type UserLinkCount struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Total    int       `json:"total"`
+	Deleted  int       `json:"deleted"`
+	Disabled int       `json:"disabled"`
+}
+
+// Exhausted reports whether link with limited number of uses has none left.
+func (l Link) Exhausted() bool {
+	return l.MaxUses > 0 && l.RemainingUses <= 0
+}
+
+// LinkRevision is a previous original URL of a link.
+type LinkRevision struct {
+	ReplacedAt  time.Time `json:"replaced_at"`
+	OriginalURL string    `json:"original_url"`
+}
+
+// Cursor returns position of link in a list ordered by creation time.
+func (l Link) Cursor() Cursor {
+	return Cursor{CreatedAt: l.CreatedAt, ShortPath: l.ShortPath}
+}
+
+// Cursor points to a link in a list ordered by creation time and short URL.
+// Zero value points to the beginning of a list.
+type Cursor struct {
+	CreatedAt time.Time
+	ShortPath string
+}
+
+// ParseCursor decodes cursor previously encoded with Cursor.String.
+// Empty string is decoded to zero cursor.
+func ParseCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+	secs, nanos := parts[0], "0"
+	if i := strings.IndexByte(parts[0], '.'); i >= 0 {
+		secs, nanos = parts[0][:i], parts[0][i+1:]
+	}
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	nsec, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil || nsec < 0 || nsec >= int64(time.Second) {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{CreatedAt: time.Unix(sec, nsec).UTC(), ShortPath: parts[1]}, nil
+}
+
+// IsZero reports whether cursor points to the beginning of a list.
+func (c Cursor) IsZero() bool {
+	return c.ShortPath == "" && c.CreatedAt.IsZero()
+}
+
+// String encodes cursor into opaque URL-safe string.
+func (c Cursor) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	// seconds and nanoseconds are encoded separately, since UnixNano overflows
+	// for zero creation time of links stored before it was recorded
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%d.%d:%s", c.CreatedAt.Unix(), c.CreatedAt.Nanosecond(), c.ShortPath)),
+	)
+}
+
+// Less reports whether cursor precedes another one in a list ordered
+// by creation time and short URL.
+func (c Cursor) Less(o Cursor) bool {
+	if !c.CreatedAt.Equal(o.CreatedAt) {
+		return c.CreatedAt.Before(o.CreatedAt)
+	}
+	return c.ShortPath < o.ShortPath
+}
+
+// LinkStatus selects links by their deletion mark.
+type LinkStatus int
+
+const (
+	// StatusActive selects links that are not deleted.
+	StatusActive LinkStatus = iota
+	// StatusDeleted selects deleted links only.
+	StatusDeleted
+	// StatusAll selects links regardless of deletion mark.
+	StatusAll
+)
+
+// ListOptions restricts links returned by IterateAllLinks, IterateUserLinks
+// and IterateWorkspaceLinks.
+type ListOptions struct {
+	// After is a position of the last link of previous page.
+	After Cursor
+	// CreatedFrom keeps links created at or after provided time.
+	CreatedFrom time.Time
+	// CreatedTo keeps links created before provided time.
+	CreatedTo time.Time
+	// Query keeps links whose original URL contains it, ignoring case.
+	Query string
+	// Host keeps links whose original URL has provided host, ignoring case.
+	Host string
+	// Tag keeps links marked with provided tag.
+	Tag string
+	// Limit is a maximum number of links, zero means no limit.
+	Limit int
+	// Status selects links by deletion mark, active ones by default.
+	Status LinkStatus
+}
+
+// Match reports whether link satisfies filters of options.
+// Cursor and limit are not taken into account.
+func (o ListOptions) Match(l Link) bool {
+	switch o.Status {
+	case StatusActive:
+		if l.IsDeleted {
+			return false
+		}
+	case StatusDeleted:
+		if !l.IsDeleted {
+			return false
+		}
+	}
+	if !o.CreatedFrom.IsZero() && l.CreatedAt.Before(o.CreatedFrom) {
+		return false
+	}
+	if !o.CreatedTo.IsZero() && !l.CreatedAt.Before(o.CreatedTo) {
+		return false
+	}
+	if o.Query != "" && !strings.Contains(strings.ToLower(l.OriginalURL), strings.ToLower(o.Query)) {
+		return false
+	}
+	if o.Host != "" {
+		u, err := url.Parse(l.OriginalURL)
+		if err != nil || !strings.EqualFold(u.Hostname(), o.Host) {
+			return false
+		}
+	}
+	if o.Tag != "" && !hasTag(l.Tags, o.Tag) {
+		return false
+	}
+	return true
+}
+
 type Store interface {
+	ConsumeUse(ctx context.Context, shortPath string) (string, error)
+	CountActiveLinks(ctx context.Context, userID uuid.UUID) (int, error)
+	CountLinksByUser(ctx context.Context) ([]UserLinkCount, error)
 	DeleteManyURLs(ctx context.Context, userID uuid.UUID, urls []string) error
+	DeleteMember(ctx context.Context, workspaceID, userID uuid.UUID) error
+	DeleteTemplate(ctx context.Context, userID uuid.UUID, id string) error
+	DeleteToken(ctx context.Context, userID uuid.UUID, id string) error
+	FindAccount(ctx context.Context, id uuid.UUID) (Account, error)
+	FindAccountByLogin(ctx context.Context, login string) (Account, error)
 	FindByOriginalURL(ctx context.Context, originalURL string) (string, error)
+	FindLink(ctx context.Context, shortPath string) (Link, error)
+	FindLinkHistory(ctx context.Context, shortPath string) ([]LinkRevision, error)
+	FindMember(ctx context.Context, workspaceID, userID uuid.UUID) (Member, error)
+	FindMembers(ctx context.Context, workspaceID uuid.UUID) ([]Member, error)
 	FindOriginalURL(ctx context.Context, shortPath string) (string, error)
+	FindTagsByUser(ctx context.Context, userID uuid.UUID) (map[string]int, error)
+	FindTemplatesByUser(ctx context.Context, userID uuid.UUID) ([]Template, error)
+	FindTokenByHash(ctx context.Context, hash string) (Token, error)
+	FindTokensByUser(ctx context.Context, userID uuid.UUID) ([]Token, error)
 	FindURLsByUser(ctx context.Context, userID uuid.UUID) (map[string]string, error)
+	FindWorkspacesByUser(ctx context.Context, userID uuid.UUID) ([]Membership, error)
+	InsertAccount(ctx context.Context, a Account) error
 	InsertManyURLs(ctx context.Context, userID uuid.UUID, urls map[string]string) error
+	InsertLinks(ctx context.Context, links []Link) error
 	InsertNewURLPair(ctx context.Context, userID uuid.UUID, shortPath, originalURL string) error
+	InsertTemplate(ctx context.Context, t Template) error
+	InsertToken(ctx context.Context, t Token) error
+	InsertWorkspace(ctx context.Context, w Workspace, owner uuid.UUID) error
+	IterateAccounts(ctx context.Context, fn func(Account) error) error
+	IterateAllLinks(ctx context.Context, opts ListOptions, fn func(Link) error) error
+	IterateLinks(ctx context.Context, fn func(Link) error) error
+	IterateTemplates(ctx context.Context, fn func(Template) error) error
+	IterateTokens(ctx context.Context, fn func(Token) error) error
+	IterateUserLinks(ctx context.Context, userID uuid.UUID, opts ListOptions, fn func(Link) error) error
+	IterateWorkspaceLinks(ctx context.Context, workspaceID uuid.UUID, opts ListOptions, fn func(Link) error) error
+	IterateWorkspaces(ctx context.Context, fn func(Workspace) error) error
 	Ping(ctx context.Context) error
+	PurgeDeletedURLs(ctx context.Context, deletedBefore time.Time) (int, error)
+	ReassignURLs(ctx context.Context, from, to uuid.UUID) (int, error)
+	ReplaceLink(ctx context.Context, l Link) error
+	RestoreManyURLs(ctx context.Context, userID uuid.UUID, urls []string, deletedAfter time.Time) ([]string, error)
+	SetDisabled(ctx context.Context, shortPath string, disabled bool) error
+	SetLinkHistory(ctx context.Context, shortPath string, history []LinkRevision) error
+	SetMember(ctx context.Context, m Member) error
+	SetTags(ctx context.Context, userID uuid.UUID, shortPath string, tags []string) error
+	UpdateOriginalURL(ctx context.Context, userID uuid.UUID, shortPath, originalURL string) error
+	UpdateTemplate(ctx context.Context, t Template) error
+}
+
+// NewStore initializes PostgreSQL storage if data source name is provided,
+// falling back to file storage otherwise.
+func NewStore(databaseDSN, fileStoragePath string) (Store, error) {
+	if databaseDSN != "" {
+		return NewPgStore(databaseDSN)
+	}
+	return NewFileStore(fileStoragePath)
+}
+
+// Now returns current time truncated to precision supported by all storages.
+func Now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// withTimestamps fills missing creation, update and deletion time of links.
+func withTimestamps(links []Link) []Link {
+	t := Now()
+	res := make([]Link, len(links))
+	for i, l := range links {
+		if l.CreatedAt.IsZero() {
+			l.CreatedAt = t
+		}
+		if l.UpdatedAt.IsZero() {
+			l.UpdatedAt = l.CreatedAt
+		}
+		if !l.IsDeleted {
+			l.DeletedAt = time.Time{}
+		} else if l.DeletedAt.IsZero() {
+			l.DeletedAt = l.UpdatedAt
+		}
+		res[i] = l
+	}
+	return res
+}
+
+// canRestore reports whether link may be restored
+// if it was deleted at or after deletedAfter. Zero deletedAfter means no restriction.
+func canRestore(deletedAt, deletedAfter time.Time) bool {
+	return deletedAfter.IsZero() || !deletedAt.Before(deletedAfter)
+}
+
+// pairsToLinks converts short URL - original URL pairs into links owned by user.
+func pairsToLinks(userID uuid.UUID, urls map[string]string) []Link {
+	links := make([]Link, 0, len(urls))
+	for short, long := range urls {
+		links = append(links, Link{
+			ShortPath:   short,
+			OriginalURL: long,
+			UserID:      userID,
+		})
+	}
+	return links
+}
+
+// countLinks counts links added by every user, ordering counts
+// by total number of links in descending order and user ID.
+func countLinks(links []Link) []UserLinkCount {
+	byUser := make(map[uuid.UUID]*UserLinkCount)
+	for _, l := range links {
+		c, ok := byUser[l.UserID]
+		if !ok {
+			c = &UserLinkCount{UserID: l.UserID}
+			byUser[l.UserID] = c
+		}
+		c.Total++
+		if l.IsDeleted {
+			c.Deleted++
+		}
+		if l.IsDisabled {
+			c.Disabled++
+		}
+	}
+	counts := make([]UserLinkCount, 0, len(byUser))
+	for _, c := range byUser {
+		counts = append(counts, *c)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Total != counts[j].Total {
+			return counts[i].Total > counts[j].Total
+		}
+		return counts[i].UserID.String() < counts[j].UserID.String()
+	})
+	return counts
+}
+
+// iterateSorted orders links by creation time and calls fn
+// for those matching options, stopping at the first error.
+func iterateSorted(ctx context.Context, links []Link, opts ListOptions, fn func(Link) error) error {
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Cursor().Less(links[j].Cursor())
+	})
+	count := 0
+	for _, l := range links {
+		if !opts.Match(l) {
+			continue
+		}
+		if !opts.After.IsZero() && !opts.After.Less(l.Cursor()) {
+			continue
+		}
+		if opts.Limit > 0 && count == opts.Limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(l); err != nil {
+			return err
+		}
+		count++
+	}
+	return nil
 }
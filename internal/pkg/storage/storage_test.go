@@ -3,8 +3,11 @@ package storage
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func BenchmarkFindOriginalURL(b *testing.B) {
@@ -161,3 +164,41 @@ func BenchmarkFindURLsByUser(b *testing.B) {
 		}
 	})
 }
+
+func TestParseCursor(t *testing.T) {
+	c := Cursor{
+		CreatedAt: time.Date(2022, 3, 14, 15, 9, 26, 535897000, time.UTC),
+		ShortPath: "abcdef",
+	}
+	parsed, err := ParseCursor(c.String())
+	require.NoError(t, err)
+	assert.True(t, c.CreatedAt.Equal(parsed.CreatedAt))
+	assert.Equal(t, c.ShortPath, parsed.ShortPath)
+
+	parsed, err = ParseCursor("")
+	require.NoError(t, err)
+	assert.True(t, parsed.IsZero())
+	assert.Equal(t, "", parsed.String())
+
+	for _, s := range []string{"!!!", "YWJj", "YWJjOmRlZg"} {
+		_, err = ParseCursor(s)
+		assert.ErrorIs(t, err, ErrInvalidCursor, s)
+	}
+}
+
+func TestPassthrough_text(t *testing.T) {
+	for _, p := range []Passthrough{PassthroughNone, PassthroughQuery, PassthroughPath} {
+		b, err := p.MarshalText()
+		require.NoError(t, err)
+		var parsed Passthrough
+		require.NoError(t, parsed.UnmarshalText(b))
+		assert.Equal(t, p, parsed)
+	}
+
+	var p Passthrough = PassthroughPath
+	require.NoError(t, p.UnmarshalText(nil))
+	assert.Equal(t, PassthroughNone, p)
+	assert.ErrorIs(t, p.UnmarshalText([]byte("fragment")), ErrInvalidPassthrough)
+	_, err := Passthrough(3).MarshalText()
+	assert.ErrorIs(t, err, ErrInvalidPassthrough)
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTagsPerLink is a maximum number of tags attached to a single link.
+	MaxTagsPerLink = 20
+	// MaxTagLength is a maximum length of a tag in characters.
+	MaxTagLength = 64
+)
+
+// ErrInvalidTag is returned for tags violating naming rules.
+var ErrInvalidTag = errors.New("invalid tag")
+
+// NormalizeTags trims and lowercases tags, removing duplicates.
+// Tags may contain letters, digits, spaces and "-_./" characters,
+// so slash-separated tags may be used as folders, e.g. "work/reports".
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	res := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || utf8.RuneCountInString(tag) > MaxTagLength {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+		}
+		for _, r := range tag {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_./ ", r) {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+			}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			res = append(res, tag)
+		}
+	}
+	if len(res) > MaxTagsPerLink {
+		return nil, fmt.Errorf("%w: more than %d tags", ErrInvalidTag, MaxTagsPerLink)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// sortedTags returns sorted copy of tags, or nil if there are none.
+func sortedTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	res := append([]string(nil), tags...)
+	sort.Strings(res)
+	return res
+}
+
+// hasTag reports whether sorted tags contain provided one.
+func hasTag(tags []string, tag string) bool {
+	i := sort.SearchStrings(tags, tag)
+	return i < len(tags) && tags[i] == tag
+}
+
+// countTags counts tags of provided links.
+func countTags(links []Link) map[string]int {
+	counts := make(map[string]int)
+	for _, l := range links {
+		for _, tag := range l.Tags {
+			counts[tag]++
+		}
+	}
+	return counts
+}
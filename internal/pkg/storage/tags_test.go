@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "trims, lowercases and sorts",
+			tags: []string{" Work/Reports ", "go", "GO", "чтение"},
+			want: []string{"go", "work/reports", "чтение"},
+		},
+		{
+			name: "empty list",
+			tags: nil,
+			want: []string{},
+		},
+		{
+			name:    "blank tag",
+			tags:    []string{"go", "  "},
+			wantErr: true,
+		},
+		{
+			name:    "comma",
+			tags:    []string{"a,b"},
+			wantErr: true,
+		},
+		{
+			name:    "too long",
+			tags:    []string{strings.Repeat("a", MaxTagLength+1)},
+			wantErr: true,
+		},
+		{
+			name:    "too many",
+			tags:    strings.Split("a b c d e f g h i j k l m n o p q r s t u", " "),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeTags(tt.tags)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTag)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
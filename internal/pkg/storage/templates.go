@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxTemplateParams is a maximum number of parameters of a single template.
+	MaxTemplateParams = 10
+	// MaxTemplateNameLength is a maximum length of template name in characters.
+	MaxTemplateNameLength = 100
+	// MaxTemplateValueLength is a maximum length of parameter value in characters.
+	MaxTemplateValueLength = 200
+)
+
+var (
+	ErrInvalidTemplate    = errors.New("invalid template")
+	ErrNoTemplateWasFound = errors.New("no template was found")
+)
+
+// Template is a set of utm_* query parameters added by user
+// to original URLs of links created with it.
+// Default template is used when link is created without explicit one,
+// every user has at most one default template.
+type Template struct {
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Params    map[string]string `json:"params"`
+	UserID    uuid.UUID         `json:"user_id"`
+	IsDefault bool              `json:"is_default"`
+}
+
+// NormalizeTemplate trims name of template and lowercases names of its parameters.
+// Parameters must be named utm_* and contain only lowercase latin letters,
+// digits and underscores, their values must not be empty.
+func NormalizeTemplate(t Template) (Template, error) {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" || utf8.RuneCountInString(t.Name) > MaxTemplateNameLength {
+		return Template{}, fmt.Errorf("%w: name must contain 1 to %d characters", ErrInvalidTemplate, MaxTemplateNameLength)
+	}
+	if len(t.Params) == 0 || len(t.Params) > MaxTemplateParams {
+		return Template{}, fmt.Errorf("%w: template must contain 1 to %d parameters", ErrInvalidTemplate, MaxTemplateParams)
+	}
+	params := make(map[string]string, len(t.Params))
+	for k, v := range t.Params {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if !validParamName(k) {
+			return Template{}, fmt.Errorf("%w: invalid parameter %q", ErrInvalidTemplate, k)
+		}
+		v = strings.TrimSpace(v)
+		if v == "" || utf8.RuneCountInString(v) > MaxTemplateValueLength {
+			return Template{}, fmt.Errorf("%w: invalid value of parameter %q", ErrInvalidTemplate, k)
+		}
+		if _, ok := params[k]; ok {
+			return Template{}, fmt.Errorf("%w: duplicate parameter %q", ErrInvalidTemplate, k)
+		}
+		params[k] = v
+	}
+	t.Params = params
+	return t, nil
+}
+
+func validParamName(name string) bool {
+	if !strings.HasPrefix(name, "utm_") || len(name) == len("utm_") || len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// sortTemplates orders templates by creation time and ID.
+func sortTemplates(templates []Template) {
+	sort.Slice(templates, func(i, j int) bool {
+		if !templates[i].CreatedAt.Equal(templates[j].CreatedAt) {
+			return templates[i].CreatedAt.Before(templates[j].CreatedAt)
+		}
+		return templates[i].ID < templates[j].ID
+	})
+}
+
+// templateStore keeps templates of file storages in memory
+// and, if path is provided, in a JSON file.
+type templateStore struct {
+	templates map[string]Template
+	path      string
+	mu        sync.RWMutex
+}
+
+func newTemplateStore(path string) (*templateStore, error) {
+	s := &templateStore{
+		templates: make(map[string]Template),
+		path:      path,
+	}
+	if err := readSidecar(path, &s.templates); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DeleteTemplate removes template of user.
+func (s *templateStore) DeleteTemplate(ctx context.Context, userID uuid.UUID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.templates[id]
+	if !ok || t.UserID != userID {
+		return ErrNoTemplateWasFound
+	}
+	delete(s.templates, id)
+	if err := s.write(); err != nil {
+		s.templates[id] = t
+		return err
+	}
+	return nil
+}
+
+// FindTemplatesByUser returns templates of user ordered by creation time.
+func (s *templateStore) FindTemplatesByUser(ctx context.Context, userID uuid.UUID) ([]Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]Template, 0)
+	for _, t := range s.templates {
+		if t.UserID == userID {
+			res = append(res, t)
+		}
+	}
+	sortTemplates(res)
+	return res, nil
+}
+
+// InsertTemplate saves new template. If template is default,
+// other templates of its user stop being default.
+func (s *templateStore) InsertTemplate(ctx context.Context, t Template) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.templates[t.ID]; ok {
+		return fmt.Errorf("template %s already exists", t.ID)
+	}
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = Now()
+	}
+	if t.UpdatedAt.IsZero() {
+		t.UpdatedAt = t.CreatedAt
+	}
+	return s.save(t)
+}
+
+// IterateTemplates calls fn for every template ordered by ID,
+// stopping at the first error returned by fn.
+func (s *templateStore) IterateTemplates(ctx context.Context, fn func(Template) error) error {
+	s.mu.RLock()
+	templates := make([]Template, 0, len(s.templates))
+	for _, t := range s.templates {
+		templates = append(templates, t)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].ID < templates[j].ID
+	})
+	for _, t := range templates {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateTemplate replaces name, parameters and default mark of template of user.
+func (s *templateStore) UpdateTemplate(ctx context.Context, t Template) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.templates[t.ID]
+	if !ok || prev.UserID != t.UserID {
+		return ErrNoTemplateWasFound
+	}
+	t.CreatedAt = prev.CreatedAt
+	t.UpdatedAt = Now()
+	return s.save(t)
+}
+
+// save puts template into the store, rolling changes back if file can't be written.
+func (s *templateStore) save(t Template) error {
+	backup := make(map[string]Template, len(s.templates))
+	for k, v := range s.templates {
+		backup[k] = v
+	}
+	if t.IsDefault {
+		for k, v := range s.templates {
+			if v.UserID == t.UserID && v.IsDefault {
+				v.IsDefault = false
+				s.templates[k] = v
+			}
+		}
+	}
+	params := make(map[string]string, len(t.Params))
+	for k, v := range t.Params {
+		params[k] = v
+	}
+	t.Params = params
+	s.templates[t.ID] = t
+	if err := s.write(); err != nil {
+		s.templates = backup
+		return err
+	}
+	return nil
+}
+
+func (s *templateStore) write() error {
+	return writeSidecar(s.path, s.templates)
+}
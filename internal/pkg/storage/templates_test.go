@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template Template
+		want     Template
+		wantErr  bool
+	}{
+		{
+			name: "normalized",
+			template: Template{
+				Name:   "  Newsletter ",
+				Params: map[string]string{" UTM_Source": " newsletter ", "utm_medium": "email"},
+			},
+			want: Template{
+				Name:   "Newsletter",
+				Params: map[string]string{"utm_source": "newsletter", "utm_medium": "email"},
+			},
+		},
+		{
+			name:     "empty name",
+			template: Template{Name: " ", Params: map[string]string{"utm_source": "x"}},
+			wantErr:  true,
+		},
+		{
+			name:     "no parameters",
+			template: Template{Name: "empty"},
+			wantErr:  true,
+		},
+		{
+			name:     "not utm parameter",
+			template: Template{Name: "ref", Params: map[string]string{"ref": "x"}},
+			wantErr:  true,
+		},
+		{
+			name:     "bare prefix",
+			template: Template{Name: "utm", Params: map[string]string{"utm_": "x"}},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid characters",
+			template: Template{Name: "utm", Params: map[string]string{"utm_a&b": "x"}},
+			wantErr:  true,
+		},
+		{
+			name:     "empty value",
+			template: Template{Name: "utm", Params: map[string]string{"utm_source": " "}},
+			wantErr:  true,
+		},
+		{
+			name:     "duplicate parameter",
+			template: Template{Name: "utm", Params: map[string]string{"utm_source": "a", "UTM_SOURCE": "b"}},
+			wantErr:  true,
+		},
+		{
+			name:     "too long value",
+			template: Template{Name: "utm", Params: map[string]string{"utm_source": strings.Repeat("a", MaxTemplateValueLength+1)}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeTemplate(tt.template)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTemplate)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_fileStore_Templates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shorten.json")
+	s, err := NewFileStore(path)
+	require.NoError(t, err)
+	testTemplates(t, s)
+	assert.FileExists(t, filepath.Join(filepath.Dir(path), "shorten_templates.json"))
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+	a, err := s.FindTemplatesByUser(context.Background(), testTemplateUser)
+	require.NoError(t, err)
+	b, err := reopened.FindTemplatesByUser(context.Background(), testTemplateUser)
+	require.NoError(t, err)
+	require.Len(t, b, len(a))
+	for i := range a {
+		assert.Equal(t, a[i].ID, b[i].ID)
+		assert.Equal(t, a[i].Params, b[i].Params)
+		assert.Equal(t, a[i].IsDefault, b[i].IsDefault)
+	}
+}
+
+func Test_fileArrayStore_Templates(t *testing.T) {
+	s, err := NewFileArrayStore("")
+	require.NoError(t, err)
+	testTemplates(t, s)
+}
+
+var testTemplateUser = uuid.MustParse("0b3f3a4e-6a39-4c5e-8b9f-0d2c4e1a7f11")
+
+// testTemplates checks templates management common to all storages.
+func testTemplates(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	other := uuid.New()
+
+	templates, err := s.FindTemplatesByUser(ctx, testTemplateUser)
+	require.NoError(t, err)
+	assert.Empty(t, templates)
+
+	require.NoError(t, s.InsertTemplate(ctx, Template{
+		ID:        "t1",
+		Name:      "newsletter",
+		Params:    map[string]string{"utm_source": "newsletter"},
+		UserID:    testTemplateUser,
+		IsDefault: true,
+	}))
+	require.NoError(t, s.InsertTemplate(ctx, Template{
+		ID:        "t2",
+		Name:      "twitter",
+		Params:    map[string]string{"utm_source": "twitter"},
+		UserID:    testTemplateUser,
+		IsDefault: true,
+	}))
+	require.NoError(t, s.InsertTemplate(ctx, Template{
+		ID:        "t3",
+		Name:      "foreign",
+		Params:    map[string]string{"utm_source": "foreign"},
+		UserID:    other,
+		IsDefault: true,
+	}))
+
+	templates, err = s.FindTemplatesByUser(ctx, testTemplateUser)
+	require.NoError(t, err)
+	require.Len(t, templates, 2)
+	assert.Equal(t, "t1", templates[0].ID)
+	assert.False(t, templates[0].IsDefault)
+	assert.Equal(t, "t2", templates[1].ID)
+	assert.True(t, templates[1].IsDefault)
+
+	err = s.UpdateTemplate(ctx, Template{ID: "t3", Name: "stolen", Params: map[string]string{"utm_source": "x"}, UserID: testTemplateUser})
+	assert.ErrorIs(t, err, ErrNoTemplateWasFound)
+	require.NoError(t, s.UpdateTemplate(ctx, Template{
+		ID:        "t1",
+		Name:      "email",
+		Params:    map[string]string{"utm_source": "email", "utm_medium": "email"},
+		UserID:    testTemplateUser,
+		IsDefault: true,
+	}))
+	templates, err = s.FindTemplatesByUser(ctx, testTemplateUser)
+	require.NoError(t, err)
+	require.Len(t, templates, 2)
+	assert.Equal(t, "email", templates[0].Name)
+	assert.Equal(t, map[string]string{"utm_source": "email", "utm_medium": "email"}, templates[0].Params)
+	assert.True(t, templates[0].IsDefault)
+	assert.False(t, templates[1].IsDefault)
+
+	assert.ErrorIs(t, s.DeleteTemplate(ctx, testTemplateUser, "t3"), ErrNoTemplateWasFound)
+	require.NoError(t, s.DeleteTemplate(ctx, testTemplateUser, "t2"))
+	assert.ErrorIs(t, s.DeleteTemplate(ctx, testTemplateUser, "t2"), ErrNoTemplateWasFound)
+
+	templates, err = s.FindTemplatesByUser(ctx, other)
+	require.NoError(t, err)
+	require.Len(t, templates, 1)
+	assert.True(t, templates[0].IsDefault)
+
+	var all []Template
+	require.NoError(t, s.IterateTemplates(ctx, func(tpl Template) error {
+		all = append(all, tpl)
+		return nil
+	}))
+	require.Len(t, all, 2)
+	assert.Equal(t, "t1", all[0].ID)
+	assert.Equal(t, map[string]string{"utm_source": "email", "utm_medium": "email"}, all[0].Params)
+	assert.Equal(t, other, all[1].UserID)
+}
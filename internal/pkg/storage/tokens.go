@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// MaxTokenNameLength is a maximum length of API token name in characters.
+const MaxTokenNameLength = 100
+
+var (
+	ErrInvalidToken      = errors.New("invalid API token")
+	ErrInvalidTokenScope = errors.New("scope must be one of read, read-write")
+	ErrNoTokenWasFound   = errors.New("no API token was found")
+)
+
+// TokenScope restricts requests authenticated with API token.
+type TokenScope int
+
+const (
+	// ScopeRead allows only requests that don't change data.
+	ScopeRead TokenScope = iota
+	// ScopeReadWrite allows any request.
+	ScopeReadWrite
+)
+
+var tokenScopeNames = []string{"read", "read-write"}
+
+// String returns name of token scope.
+func (s TokenScope) String() string {
+	if s < 0 || int(s) >= len(tokenScopeNames) {
+		return "TokenScope(" + strconv.Itoa(int(s)) + ")"
+	}
+	return tokenScopeNames[s]
+}
+
+// MarshalText encodes token scope as its name.
+func (s TokenScope) MarshalText() ([]byte, error) {
+	if s < 0 || int(s) >= len(tokenScopeNames) {
+		return nil, ErrInvalidTokenScope
+	}
+	return []byte(tokenScopeNames[s]), nil
+}
+
+// UnmarshalText decodes token scope from its name.
+// Empty name means ScopeRead.
+func (s *TokenScope) UnmarshalText(b []byte) error {
+	if len(b) == 0 {
+		*s = ScopeRead
+		return nil
+	}
+	for i, name := range tokenScopeNames {
+		if string(b) == name {
+			*s = TokenScope(i)
+			return nil
+		}
+	}
+	return ErrInvalidTokenScope
+}
+
+// Token is an API token identifying user by Authorization header.
+// Only hash of token is stored, token itself is shown once on creation.
+type Token struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	UserID    uuid.UUID  `json:"user_id"`
+	Scope     TokenScope `json:"scope"`
+}
+
+// NormalizeToken trims name of token and checks its scope.
+func NormalizeToken(t Token) (Token, error) {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" || utf8.RuneCountInString(t.Name) > MaxTokenNameLength {
+		return Token{}, fmt.Errorf("%w: name must contain 1 to %d characters", ErrInvalidToken, MaxTokenNameLength)
+	}
+	if t.Scope < 0 || int(t.Scope) >= len(tokenScopeNames) {
+		return Token{}, ErrInvalidTokenScope
+	}
+	return t, nil
+}
+
+// sortTokens orders tokens by creation time and ID.
+func sortTokens(tokens []Token) {
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+}
+
+// tokenStore keeps API tokens of file storages in memory
+// and, if path is provided, in a JSON file.
+type tokenStore struct {
+	tokens map[string]Token
+	path   string
+	mu     sync.RWMutex
+}
+
+func newTokenStore(path string) (*tokenStore, error) {
+	s := &tokenStore{
+		tokens: make(map[string]Token),
+		path:   path,
+	}
+	if err := readSidecar(path, &s.tokens); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DeleteToken removes API token of user.
+func (s *tokenStore) DeleteToken(ctx context.Context, userID uuid.UUID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok || t.UserID != userID {
+		return ErrNoTokenWasFound
+	}
+	delete(s.tokens, id)
+	if err := writeSidecar(s.path, s.tokens); err != nil {
+		s.tokens[id] = t
+		return err
+	}
+	return nil
+}
+
+// FindTokenByHash returns API token with provided hash.
+func (s *tokenStore) FindTokenByHash(ctx context.Context, hash string) (Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tokens {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
+	return Token{}, ErrNoTokenWasFound
+}
+
+// FindTokensByUser returns API tokens of user ordered by creation time.
+func (s *tokenStore) FindTokensByUser(ctx context.Context, userID uuid.UUID) ([]Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]Token, 0)
+	for _, t := range s.tokens {
+		if t.UserID == userID {
+			res = append(res, t)
+		}
+	}
+	sortTokens(res)
+	return res, nil
+}
+
+// IterateTokens calls fn for every API token ordered by ID,
+// stopping at the first error returned by fn.
+func (s *tokenStore) IterateTokens(ctx context.Context, fn func(Token) error) error {
+	s.mu.RLock()
+	tokens := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID < tokens[j].ID
+	})
+	for _, t := range tokens {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertToken saves new API token.
+func (s *tokenStore) InsertToken(ctx context.Context, t Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[t.ID]; ok {
+		return fmt.Errorf("token %s already exists", t.ID)
+	}
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = Now()
+	}
+	s.tokens[t.ID] = t
+	if err := writeSidecar(s.path, s.tokens); err != nil {
+		delete(s.tokens, t.ID)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenScope_text(t *testing.T) {
+	b, err := json.Marshal(struct {
+		Scope TokenScope `json:"scope"`
+	}{ScopeReadWrite})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"scope":"read-write"}`, string(b))
+
+	var s TokenScope
+	require.NoError(t, s.UnmarshalText([]byte("")))
+	assert.Equal(t, ScopeRead, s)
+	require.NoError(t, s.UnmarshalText([]byte("read-write")))
+	assert.Equal(t, ScopeReadWrite, s)
+	assert.ErrorIs(t, s.UnmarshalText([]byte("admin")), ErrInvalidTokenScope)
+	_, err = TokenScope(5).MarshalText()
+	assert.ErrorIs(t, err, ErrInvalidTokenScope)
+}
+
+func Test_fileStore_Tokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shorten.json")
+	s, err := NewFileStore(path)
+	require.NoError(t, err)
+	testTokens(t, s)
+	assert.FileExists(t, filepath.Join(filepath.Dir(path), "shorten_tokens.json"))
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+	tokens, err := reopened.FindTokensByUser(context.Background(), testTokenUser)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, "k1", tokens[0].ID)
+	assert.Equal(t, ScopeReadWrite, tokens[0].Scope)
+}
+
+func Test_fileArrayStore_Tokens(t *testing.T) {
+	s, err := NewFileArrayStore("")
+	require.NoError(t, err)
+	testTokens(t, s)
+}
+
+var testTokenUser = uuid.MustParse("6f1c2b8e-3d4a-4f5e-9a1b-2c3d4e5f6a7b")
+
+// testTokens checks API tokens management common to all storages.
+func testTokens(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	other := uuid.New()
+
+	tokens, err := s.FindTokensByUser(ctx, testTokenUser)
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	require.NoError(t, s.InsertToken(ctx, Token{ID: "k1", Name: "backup", Hash: "h1", UserID: testTokenUser, Scope: ScopeReadWrite}))
+	require.NoError(t, s.InsertToken(ctx, Token{ID: "k2", Name: "stats", Hash: "h2", UserID: testTokenUser}))
+	require.NoError(t, s.InsertToken(ctx, Token{ID: "k3", Name: "foreign", Hash: "h3", UserID: other}))
+
+	tokens, err = s.FindTokensByUser(ctx, testTokenUser)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, "k1", tokens[0].ID)
+	assert.Equal(t, "k2", tokens[1].ID)
+	assert.Equal(t, ScopeRead, tokens[1].Scope)
+
+	token, err := s.FindTokenByHash(ctx, "h3")
+	require.NoError(t, err)
+	assert.Equal(t, "k3", token.ID)
+	assert.Equal(t, other, token.UserID)
+	_, err = s.FindTokenByHash(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNoTokenWasFound)
+
+	assert.ErrorIs(t, s.DeleteToken(ctx, testTokenUser, "k3"), ErrNoTokenWasFound)
+	require.NoError(t, s.DeleteToken(ctx, testTokenUser, "k2"))
+	assert.ErrorIs(t, s.DeleteToken(ctx, testTokenUser, "k2"), ErrNoTokenWasFound)
+	_, err = s.FindTokenByHash(ctx, "h2")
+	assert.ErrorIs(t, err, ErrNoTokenWasFound)
+
+	var ids []string
+	require.NoError(t, s.IterateTokens(ctx, func(token Token) error {
+		ids = append(ids, token.ID)
+		return nil
+	}))
+	assert.Equal(t, []string{"k1", "k3"}, ids)
+}
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// MaxWorkspaceNameLength is a maximum length of workspace name in characters.
+const MaxWorkspaceNameLength = 100
+
+var (
+	ErrInvalidWorkspace    = errors.New("invalid workspace")
+	ErrInvalidRole         = errors.New("role must be one of viewer, editor, owner")
+	ErrNoWorkspaceWasFound = errors.New("no workspace was found")
+	ErrNoMemberWasFound    = errors.New("no workspace member was found")
+	ErrWorkspaceExists     = errors.New("workspace already exists")
+)
+
+// Role restricts actions of workspace member. Every role
+// allows everything allowed by roles preceding it.
+type Role int
+
+const (
+	// RoleViewer allows to list links of workspace.
+	RoleViewer Role = iota
+	// RoleEditor allows to create, edit and delete links of workspace.
+	RoleEditor
+	// RoleOwner allows to manage members of workspace.
+	RoleOwner
+)
+
+var roleNames = []string{"viewer", "editor", "owner"}
+
+// String returns name of role.
+func (r Role) String() string {
+	if r < 0 || int(r) >= len(roleNames) {
+		return "Role(" + strconv.Itoa(int(r)) + ")"
+	}
+	return roleNames[r]
+}
+
+// MarshalText encodes role as its name.
+func (r Role) MarshalText() ([]byte, error) {
+	if r < 0 || int(r) >= len(roleNames) {
+		return nil, ErrInvalidRole
+	}
+	return []byte(roleNames[r]), nil
+}
+
+// UnmarshalText decodes role from its name.
+// Empty name means RoleViewer.
+func (r *Role) UnmarshalText(b []byte) error {
+	if len(b) == 0 {
+		*r = RoleViewer
+		return nil
+	}
+	for i, name := range roleNames {
+		if string(b) == name {
+			*r = Role(i)
+			return nil
+		}
+	}
+	return ErrInvalidRole
+}
+
+// Allows reports whether role allows everything allowed by provided one.
+func (r Role) Allows(min Role) bool {
+	return r >= min
+}
+
+// Workspace is a set of links shared by its members.
+type Workspace struct {
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// Member is a user having role in workspace.
+type Member struct {
+	AddedAt     time.Time `json:"added_at"`
+	WorkspaceID uuid.UUID `json:"workspace_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Role        Role      `json:"role"`
+}
+
+// Membership is a workspace along with role of user in it.
+type Membership struct {
+	Workspace Workspace
+	Role      Role
+}
+
+// NormalizeWorkspace trims name of workspace.
+func NormalizeWorkspace(w Workspace) (Workspace, error) {
+	w.Name = strings.TrimSpace(w.Name)
+	if w.Name == "" || utf8.RuneCountInString(w.Name) > MaxWorkspaceNameLength {
+		return Workspace{}, fmt.Errorf("%w: name must contain 1 to %d characters", ErrInvalidWorkspace, MaxWorkspaceNameLength)
+	}
+	return w, nil
+}
+
+// sortMembers orders members by time they were added and user ID.
+func sortMembers(members []Member) {
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].AddedAt.Equal(members[j].AddedAt) {
+			return members[i].AddedAt.Before(members[j].AddedAt)
+		}
+		return members[i].UserID.String() < members[j].UserID.String()
+	})
+}
+
+// sortMemberships orders memberships by creation time and ID of workspace.
+func sortMemberships(memberships []Membership) {
+	sort.Slice(memberships, func(i, j int) bool {
+		a, b := memberships[i].Workspace, memberships[j].Workspace
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID.String() < b.ID.String()
+	})
+}
+
+// workspaceRecord is a workspace of file storages along with its members.
+type workspaceRecord struct {
+	Workspace
+	Members map[uuid.UUID]Member `json:"members"`
+}
+
+// workspaceStore keeps workspaces of file storages in memory
+// and, if path is provided, in a JSON file.
+type workspaceStore struct {
+	workspaces map[uuid.UUID]workspaceRecord
+	path       string
+	mu         sync.RWMutex
+}
+
+func newWorkspaceStore(path string) (*workspaceStore, error) {
+	s := &workspaceStore{
+		workspaces: make(map[uuid.UUID]workspaceRecord),
+		path:       path,
+	}
+	if err := readSidecar(path, &s.workspaces); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DeleteMember removes user from workspace.
+func (s *workspaceStore) DeleteMember(ctx context.Context, workspaceID, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.workspaces[workspaceID]
+	if !ok {
+		return ErrNoMemberWasFound
+	}
+	m, ok := w.Members[userID]
+	if !ok {
+		return ErrNoMemberWasFound
+	}
+	delete(w.Members, userID)
+	if err := writeSidecar(s.path, s.workspaces); err != nil {
+		w.Members[userID] = m
+		return err
+	}
+	return nil
+}
+
+// FindMember returns membership of user in workspace.
+func (s *workspaceStore) FindMember(ctx context.Context, workspaceID, userID uuid.UUID) (Member, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.workspaces[workspaceID].Members[userID]
+	if !ok {
+		return Member{}, ErrNoMemberWasFound
+	}
+	return m, nil
+}
+
+// FindMembers returns members of workspace ordered by time they were added.
+func (s *workspaceStore) FindMembers(ctx context.Context, workspaceID uuid.UUID) ([]Member, error) {
+	s.mu.RLock()
+	w, ok := s.workspaces[workspaceID]
+	if !ok {
+		s.mu.RUnlock()
+		return nil, ErrNoWorkspaceWasFound
+	}
+	members := make([]Member, 0, len(w.Members))
+	for _, m := range w.Members {
+		members = append(members, m)
+	}
+	s.mu.RUnlock()
+
+	sortMembers(members)
+	return members, nil
+}
+
+// FindWorkspacesByUser returns workspaces user is a member of
+// along with role of user, ordered by creation time.
+func (s *workspaceStore) FindWorkspacesByUser(ctx context.Context, userID uuid.UUID) ([]Membership, error) {
+	s.mu.RLock()
+	memberships := make([]Membership, 0)
+	for _, w := range s.workspaces {
+		if m, ok := w.Members[userID]; ok {
+			memberships = append(memberships, Membership{Workspace: w.Workspace, Role: m.Role})
+		}
+	}
+	s.mu.RUnlock()
+
+	sortMemberships(memberships)
+	return memberships, nil
+}
+
+// InsertWorkspace saves new workspace with provided user as its owner.
+func (s *workspaceStore) InsertWorkspace(ctx context.Context, w Workspace, owner uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.workspaces[w.ID]; ok {
+		return ErrWorkspaceExists
+	}
+	if w.CreatedAt.IsZero() {
+		w.CreatedAt = Now()
+	}
+	s.workspaces[w.ID] = workspaceRecord{
+		Workspace: w,
+		Members: map[uuid.UUID]Member{
+			owner: {AddedAt: w.CreatedAt, WorkspaceID: w.ID, UserID: owner, Role: RoleOwner},
+		},
+	}
+	if err := writeSidecar(s.path, s.workspaces); err != nil {
+		delete(s.workspaces, w.ID)
+		return err
+	}
+	return nil
+}
+
+// IterateWorkspaces calls fn for every workspace ordered by ID,
+// stopping at the first error returned by fn.
+func (s *workspaceStore) IterateWorkspaces(ctx context.Context, fn func(Workspace) error) error {
+	s.mu.RLock()
+	workspaces := make([]Workspace, 0, len(s.workspaces))
+	for _, w := range s.workspaces {
+		workspaces = append(workspaces, w.Workspace)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(workspaces, func(i, j int) bool {
+		return workspaces[i].ID.String() < workspaces[j].ID.String()
+	})
+	for _, w := range workspaces {
+		if err := fn(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetMember adds user to workspace or changes role of existing member.
+// Time existing member was added is kept.
+func (s *workspaceStore) SetMember(ctx context.Context, m Member) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.workspaces[m.WorkspaceID]
+	if !ok {
+		return ErrNoWorkspaceWasFound
+	}
+	prev, existed := w.Members[m.UserID]
+	if existed {
+		m.AddedAt = prev.AddedAt
+	} else if m.AddedAt.IsZero() {
+		m.AddedAt = Now()
+	}
+	w.Members[m.UserID] = m
+	if err := writeSidecar(s.path, s.workspaces); err != nil {
+		if existed {
+			w.Members[m.UserID] = prev
+		} else {
+			delete(w.Members, m.UserID)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRole(t *testing.T) {
+	for _, r := range []Role{RoleViewer, RoleEditor, RoleOwner} {
+		b, err := r.MarshalText()
+		require.NoError(t, err)
+		var decoded Role
+		require.NoError(t, decoded.UnmarshalText(b))
+		assert.Equal(t, r, decoded)
+	}
+	var r Role
+	assert.NoError(t, r.UnmarshalText(nil))
+	assert.Equal(t, RoleViewer, r)
+	assert.ErrorIs(t, r.UnmarshalText([]byte("admin")), ErrInvalidRole)
+	_, err := Role(3).MarshalText()
+	assert.ErrorIs(t, err, ErrInvalidRole)
+
+	assert.True(t, RoleOwner.Allows(RoleEditor))
+	assert.True(t, RoleEditor.Allows(RoleEditor))
+	assert.False(t, RoleViewer.Allows(RoleEditor))
+}
+
+func Test_fileStore_Workspaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shorten.json")
+	s, err := NewFileStore(path)
+	require.NoError(t, err)
+	testWorkspaces(t, s)
+	assert.FileExists(t, filepath.Join(filepath.Dir(path), "shorten_workspaces.json"))
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+	members, err := reopened.FindMembers(context.Background(), testWorkspace)
+	require.NoError(t, err)
+	assert.Len(t, members, 2)
+	var links []Link
+	require.NoError(t, reopened.IterateWorkspaceLinks(context.Background(), testWorkspace, ListOptions{}, func(l Link) error {
+		links = append(links, l)
+		return nil
+	}))
+	assert.Len(t, links, 2)
+}
+
+func Test_fileArrayStore_Workspaces(t *testing.T) {
+	s, err := NewFileArrayStore("")
+	require.NoError(t, err)
+	testWorkspaces(t, s)
+}
+
+var testWorkspace = uuid.MustParse("9b1f0c2e-3d4a-4b5c-8d6e-7f8091a2b3c4")
+
+// testWorkspaces checks workspaces management and listing
+// of workspace links common to all storages.
+func testWorkspaces(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	owner, editor, stranger := uuid.New(), uuid.New(), uuid.New()
+
+	_, err := s.FindMembers(ctx, testWorkspace)
+	assert.ErrorIs(t, err, ErrNoWorkspaceWasFound)
+	assert.ErrorIs(t, s.SetMember(ctx, Member{WorkspaceID: testWorkspace, UserID: editor}), ErrNoWorkspaceWasFound)
+
+	require.NoError(t, s.InsertWorkspace(ctx, Workspace{ID: testWorkspace, Name: "team"}, owner))
+	assert.ErrorIs(t, s.InsertWorkspace(ctx, Workspace{ID: testWorkspace, Name: "again"}, stranger), ErrWorkspaceExists)
+	m, err := s.FindMember(ctx, testWorkspace, owner)
+	require.NoError(t, err)
+	assert.Equal(t, RoleOwner, m.Role)
+	assert.False(t, m.AddedAt.IsZero())
+
+	require.NoError(t, s.SetMember(ctx, Member{WorkspaceID: testWorkspace, UserID: editor, Role: RoleViewer}))
+	added, err := s.FindMember(ctx, testWorkspace, editor)
+	require.NoError(t, err)
+	require.NoError(t, s.SetMember(ctx, Member{WorkspaceID: testWorkspace, UserID: editor, Role: RoleEditor}))
+	m, err = s.FindMember(ctx, testWorkspace, editor)
+	require.NoError(t, err)
+	assert.Equal(t, RoleEditor, m.Role)
+	assert.True(t, added.AddedAt.Equal(m.AddedAt), "time member was added must be kept")
+	_, err = s.FindMember(ctx, testWorkspace, stranger)
+	assert.ErrorIs(t, err, ErrNoMemberWasFound)
+
+	members, err := s.FindMembers(ctx, testWorkspace)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, owner, members[0].UserID)
+	assert.Equal(t, editor, members[1].UserID)
+
+	memberships, err := s.FindWorkspacesByUser(ctx, editor)
+	require.NoError(t, err)
+	require.Len(t, memberships, 1)
+	assert.Equal(t, "team", memberships[0].Workspace.Name)
+	assert.Equal(t, RoleEditor, memberships[0].Role)
+	memberships, err = s.FindWorkspacesByUser(ctx, stranger)
+	require.NoError(t, err)
+	assert.Empty(t, memberships)
+	var workspaces []Workspace
+	require.NoError(t, s.IterateWorkspaces(ctx, func(w Workspace) error {
+		workspaces = append(workspaces, w)
+		return nil
+	}))
+	require.Len(t, workspaces, 1)
+	assert.Equal(t, "team", workspaces[0].Name)
+
+	require.NoError(t, s.InsertLinks(ctx, []Link{
+		{ShortPath: "ws1", OriginalURL: "https://one.test", UserID: owner, WorkspaceID: testWorkspace},
+		{ShortPath: "ws2", OriginalURL: "https://two.test", UserID: editor, WorkspaceID: testWorkspace},
+		{ShortPath: "own", OriginalURL: "https://own.test", UserID: owner},
+	}))
+	var shorts []string
+	require.NoError(t, s.IterateWorkspaceLinks(ctx, testWorkspace, ListOptions{}, func(l Link) error {
+		assert.Equal(t, testWorkspace, l.WorkspaceID)
+		shorts = append(shorts, l.ShortPath)
+		return nil
+	}))
+	assert.ElementsMatch(t, []string{"ws1", "ws2"}, shorts)
+	l, err := s.FindLink(ctx, "own")
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Nil, l.WorkspaceID)
+	require.NoError(t, s.IterateWorkspaceLinks(ctx, uuid.Nil, ListOptions{}, func(l Link) error {
+		t.Errorf("personal link %s must not belong to workspace", l.ShortPath)
+		return nil
+	}))
+
+	require.NoError(t, s.DeleteMember(ctx, testWorkspace, owner))
+	assert.ErrorIs(t, s.DeleteMember(ctx, testWorkspace, owner), ErrNoMemberWasFound)
+	require.NoError(t, s.SetMember(ctx, Member{WorkspaceID: testWorkspace, UserID: owner, Role: RoleOwner}))
+}
@@ -0,0 +1,476 @@
+// Package client provides Go client for URL shortener HTTP API.
+//
+// Client keeps user's identity between calls: the userID cookie issued
+// by the server is remembered and sent with subsequent requests.
+// Session may be exported with Session and restored with Options.Session,
+// so the same identity can be used by several processes.
+// Alternatively, API token created with /api/user/tokens
+// may be provided with Options.Token, then no cookies are used.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sessionCookieName = "userID"
+
+// Errors returned by client methods.
+var (
+	ErrConflict   = errors.New("original URL already shortened")
+	ErrGone       = errors.New("short URL is deleted")
+	ErrNoBaseURL  = errors.New("base URL must be provided")
+	ErrNoRedirect = errors.New("server did not redirect")
+)
+
+// ConflictError is returned when shortened URL already exists.
+// It contains previously generated short URL.
+type ConflictError struct {
+	ShortURL string
+}
+
+// Error implements error interface.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrConflict, e.ShortURL)
+}
+
+// Unwrap allows to check ConflictError with errors.Is(err, ErrConflict).
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// StatusError is returned when server responds with unexpected status code.
+type StatusError struct {
+	Body       string
+	StatusCode int
+}
+
+// Error implements error interface.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// Options contains client configuration.
+type Options struct {
+	// HTTPClient is used to perform requests.
+	// If nil, http.DefaultClient settings are used.
+	HTTPClient *http.Client
+	// Session is a previously obtained session token.
+	Session string
+	// Token is an API token sent in Authorization header.
+	// If provided, session is neither sent nor remembered.
+	Token string
+	// MaxRetries is a number of additional attempts
+	// for idempotent requests failed with network error or 5xx status.
+	MaxRetries int
+	// RetryDelay is a delay before the first retry,
+	// doubled for each next attempt. Defaults to 100ms.
+	RetryDelay time.Duration
+	// Gzip enables compression of request bodies.
+	Gzip bool
+}
+
+// BatchItem contains a single URL to be shortened in batch.
+type BatchItem struct {
+	CorrelationID string `json:"correlation_id"`
+	OriginalURL   string `json:"original_url"`
+}
+
+// BatchResult contains short URL generated for corresponding batch item.
+type BatchResult struct {
+	CorrelationID string `json:"correlation_id"`
+	ShortURL      string `json:"short_url"`
+}
+
+// UserURL contains a single URL added by current user.
+type UserURL struct {
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+}
+
+// UserURLsPage contains a single page of URLs added by current user.
+// NextCursor is empty on the last page.
+type UserURLsPage struct {
+	URLs       []UserURL `json:"urls"`
+	NextCursor string    `json:"next_cursor"`
+}
+
+// Client performs requests to URL shortener API.
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+	session    string
+	token      string
+	retryDelay time.Duration
+	maxRetries int
+	mu         sync.RWMutex
+	gzip       bool
+}
+
+// New initializes client for URL shortener located at baseURL.
+func New(baseURL string, opts Options) (*Client, error) {
+	if baseURL == "" {
+		return nil, ErrNoBaseURL
+	}
+	if _, err := url.ParseRequestURI(baseURL); err != nil {
+		return nil, fmt.Errorf("unable to parse base URL:\n%w", err)
+	}
+
+	hc := &http.Client{}
+	if opts.HTTPClient != nil {
+		*hc = *opts.HTTPClient
+	}
+	hc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	retryDelay := opts.RetryDelay
+	if retryDelay == 0 {
+		retryDelay = 100 * time.Millisecond
+	}
+
+	return &Client{
+		httpClient: hc,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		session:    opts.Session,
+		token:      opts.Token,
+		retryDelay: retryDelay,
+		maxRetries: opts.MaxRetries,
+		gzip:       opts.Gzip,
+	}, nil
+}
+
+// Session returns current session token.
+// It is empty until the first response from server.
+func (c *Client) Session() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.session
+}
+
+// Shorten creates short URL for provided original URL using plain text API.
+// If URL was already shortened, previously generated short URL
+// is returned alongside with *ConflictError.
+// Request is never retried, since server may have created the link
+// before response was lost.
+func (c *Client) Shorten(ctx context.Context, originalURL string) (string, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/", "text/plain", []byte(originalURL), false)
+	if err != nil {
+		return "", err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return "", err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return string(body), nil
+	case http.StatusConflict:
+		return string(body), &ConflictError{ShortURL: string(body)}
+	default:
+		return "", &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+}
+
+// ShortenJSON creates short URL for provided original URL using JSON API.
+// If URL was already shortened, previously generated short URL
+// is returned alongside with *ConflictError.
+// Like Shorten, request is never retried.
+func (c *Client) ShortenJSON(ctx context.Context, originalURL string) (string, error) {
+	reqBody, err := json.Marshal(struct {
+		URL string `json:"url"`
+	}{URL: originalURL})
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal request:\n%w", err)
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/api/shorten", "application/json", reqBody, false)
+	if err != nil {
+		return "", err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
+		return "", &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var res struct {
+		Result string `json:"result"`
+	}
+	if err = json.Unmarshal(body, &res); err != nil {
+		return "", fmt.Errorf("unable to unmarshal response:\n%w", err)
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return res.Result, &ConflictError{ShortURL: res.Result}
+	}
+	return res.Result, nil
+}
+
+// ShortenBatch creates short URLs for all provided items.
+// Batch requests are never retried, since server
+// generates new short URLs on every call.
+func (c *Client) ShortenBatch(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	reqBody, err := json.Marshal(items)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal request:\n%w", err)
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/api/shorten/batch", "application/json", reqBody, false)
+	if err != nil {
+		return nil, err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var res []BatchResult
+	if err = json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal response:\n%w", err)
+	}
+	return res, nil
+}
+
+// Expand returns original URL corresponding to provided short URL
+// or short path. ErrGone is returned if short URL was deleted.
+func (c *Client) Expand(ctx context.Context, shortURL string) (string, error) {
+	shortPath := shortURL
+	if u, err := url.Parse(shortURL); err == nil && u.IsAbs() {
+		shortPath = path.Base(u.Path)
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(shortPath), "", nil, true)
+	if err != nil {
+		return "", err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return "", err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		location := resp.Header.Get("Location")
+		if location == "" {
+			return "", ErrNoRedirect
+		}
+		return location, nil
+	case http.StatusGone:
+		return "", ErrGone
+	default:
+		return "", &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+}
+
+// UserURLs returns all URLs added by current user.
+func (c *Client) UserURLs(ctx context.Context) ([]UserURL, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/user/urls", "", nil, true)
+	if err != nil {
+		return nil, err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var res []UserURL
+		if err = json.Unmarshal(body, &res); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal response:\n%w", err)
+		}
+		return res, nil
+	case http.StatusNoContent:
+		return []UserURL{}, nil
+	default:
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+}
+
+// UserURLsPage returns up to limit URLs added by current user
+// starting after provided cursor. Empty cursor requests the first page,
+// zero limit requests the largest page server allows.
+func (c *Client) UserURLsPage(ctx context.Context, limit int, cursor string) (UserURLsPage, error) {
+	q := url.Values{}
+	q.Set("cursor", cursor)
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/api/user/urls?"+q.Encode(), "", nil, true)
+	if err != nil {
+		return UserURLsPage{}, err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return UserURLsPage{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return UserURLsPage{}, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var page UserURLsPage
+	if err = json.Unmarshal(body, &page); err != nil {
+		return UserURLsPage{}, fmt.Errorf("unable to unmarshal response:\n%w", err)
+	}
+	return page, nil
+}
+
+// DeleteURLs requests removal of provided short paths added by current user.
+// Deletion is performed by server asynchronously.
+func (c *Client) DeleteURLs(ctx context.Context, shortPaths []string) error {
+	reqBody, err := json.Marshal(shortPaths)
+	if err != nil {
+		return fmt.Errorf("unable to marshal request:\n%w", err)
+	}
+
+	resp, err := c.do(ctx, http.MethodDelete, "/api/user/urls", "application/json", reqBody, true)
+	if err != nil {
+		return err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return nil
+}
+
+// Ping checks health status of URL shortener.
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/ping", "", nil, true)
+	if err != nil {
+		return err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return nil
+}
+
+// do performs request, retrying idempotent ones
+// on network errors and 5xx responses.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body []byte, idempotent bool) (*http.Response, error) {
+	attempts := 1
+	if idempotent {
+		attempts += c.maxRetries
+	}
+
+	delay := c.retryDelay
+	var resp *http.Response
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+
+		resp, err = c.doOnce(ctx, method, path, contentType, body)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode >= http.StatusInternalServerError && i < attempts-1 {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			continue
+		}
+		return resp, nil
+	}
+	return nil, err
+}
+
+func (c *Client) doOnce(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	var reqBody io.Reader
+	encoded := false
+	if body != nil {
+		if c.gzip {
+			var buf bytes.Buffer
+			gw := gzip.NewWriter(&buf)
+			if _, err := gw.Write(body); err != nil {
+				return nil, fmt.Errorf("unable to compress request body:\n%w", err)
+			}
+			if err := gw.Close(); err != nil {
+				return nil, fmt.Errorf("unable to compress request body:\n%w", err)
+			}
+			reqBody = &buf
+			encoded = true
+		} else {
+			reqBody = bytes.NewReader(body)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request:\n%w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if encoded {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if session := c.Session(); session != "" {
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session})
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to perform request:\n%w", err)
+	}
+	if c.token != "" {
+		return resp, nil
+	}
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == sessionCookieName && cookie.Value != "" {
+			c.mu.Lock()
+			c.session = cookie.Value
+			c.mu.Unlock()
+		}
+	}
+	return resp, nil
+}
+
+func readBody(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response body:\n%w", err)
+	}
+	return body, nil
+}
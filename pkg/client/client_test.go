@@ -0,0 +1,262 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/serjyuriev/shortener/pkg/shortener"
+)
+
+// conflictStore reports conflicts on already shortened URLs
+// like PostgreSQL storage does.
+type conflictStore struct {
+	shortener.Store
+}
+
+func (s *conflictStore) InsertLinks(ctx context.Context, links []shortener.Link) error {
+	for _, l := range links {
+		if _, err := s.FindByOriginalURL(ctx, l.OriginalURL); err == nil {
+			return shortener.ErrNotUniqueOriginalURL
+		}
+	}
+	return s.Store.InsertLinks(ctx, links)
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, shortener.Store) {
+	t.Helper()
+	memory, err := shortener.NewMemoryStore()
+	require.NoError(t, err)
+	store := &conflictStore{Store: memory}
+	h, err := shortener.NewHandler(shortener.Options{
+		BaseURL: "http://short.test",
+		Store:   store,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv, store
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("", Options{})
+	assert.ErrorIs(t, err, ErrNoBaseURL)
+
+	_, err = New("not a url", Options{})
+	assert.Error(t, err)
+
+	c, err := New("http://localhost:8080/", Options{Session: "abc"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080", c.baseURL)
+	assert.Equal(t, "abc", c.Session())
+}
+
+func TestClient_Shorten(t *testing.T) {
+	tests := []struct {
+		name string
+		gzip bool
+		json bool
+	}{
+		{name: "plain text"},
+		{name: "plain text with gzip", gzip: true},
+		{name: "json", json: true},
+		{name: "json with gzip", json: true, gzip: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newTestServer(t)
+			c, err := New(srv.URL, Options{Gzip: tt.gzip})
+			require.NoError(t, err)
+
+			shorten := c.Shorten
+			if tt.json {
+				shorten = c.ShortenJSON
+			}
+
+			shortURL, err := shorten(context.Background(), "https://github.com/serjyuriev")
+			require.NoError(t, err)
+			assert.Regexp(t, "^http://short.test/[a-z]{6}$", shortURL)
+
+			again, err := shorten(context.Background(), "https://github.com/serjyuriev")
+			require.ErrorIs(t, err, ErrConflict)
+			var conflict *ConflictError
+			require.True(t, errors.As(err, &conflict))
+			assert.Equal(t, shortURL, conflict.ShortURL)
+			assert.Equal(t, shortURL, again)
+
+			original, err := c.Expand(context.Background(), shortURL)
+			require.NoError(t, err)
+			assert.Equal(t, "https://github.com/serjyuriev", original)
+		})
+	}
+}
+
+func TestClient_ShortenBatch(t *testing.T) {
+	srv, _ := newTestServer(t)
+	c, err := New(srv.URL, Options{})
+	require.NoError(t, err)
+
+	res, err := c.ShortenBatch(context.Background(), []BatchItem{
+		{CorrelationID: "1", OriginalURL: "https://github.com"},
+		{CorrelationID: "2", OriginalURL: "https://gitlab.com"},
+	})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, "1", res[0].CorrelationID)
+	assert.Equal(t, "2", res[1].CorrelationID)
+
+	original, err := c.Expand(context.Background(), res[1].ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, "https://gitlab.com", original)
+}
+
+func TestClient_Session(t *testing.T) {
+	srv, store := newTestServer(t)
+	c, err := New(srv.URL, Options{})
+	require.NoError(t, err)
+	assert.Empty(t, c.Session())
+
+	urls, err := c.UserURLs(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+	session := c.Session()
+	require.NotEmpty(t, session)
+
+	shortURL, err := c.Shorten(context.Background(), "https://yandex.ru")
+	require.NoError(t, err)
+	assert.Equal(t, session, c.Session())
+
+	restored, err := New(srv.URL, Options{Session: session})
+	require.NoError(t, err)
+	urls, err = restored.UserURLs(context.Background())
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, shortURL, urls[0].ShortURL)
+	assert.Equal(t, "https://yandex.ru", urls[0].OriginalURL)
+	assert.False(t, urls[0].CreatedAt.IsZero())
+
+	page, err := restored.UserURLsPage(context.Background(), 10, "")
+	require.NoError(t, err)
+	assert.Equal(t, UserURLsPage{URLs: urls}, page)
+
+	stranger, err := New(srv.URL, Options{})
+	require.NoError(t, err)
+	urls, err = stranger.UserURLs(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	require.NoError(t, restored.DeleteURLs(context.Background(), []string{shortURL[len("http://short.test/"):]}))
+	require.Eventually(t, func() bool {
+		_, err := store.FindOriginalURL(context.Background(), shortURL[len("http://short.test/"):])
+		return errors.Is(err, shortener.ErrShortenedDeleted)
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = c.Expand(context.Background(), shortURL)
+	assert.ErrorIs(t, err, ErrGone)
+}
+
+func TestClient_Token(t *testing.T) {
+	srv, _ := newTestServer(t)
+	c, err := New(srv.URL, Options{})
+	require.NoError(t, err)
+	shortURL, err := c.Shorten(context.Background(), "https://yandex.ru")
+	require.NoError(t, err)
+
+	newToken := func(scope string) string {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/user/tokens", strings.NewReader(`{"name":"job","scope":"`+scope+`"}`))
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: c.Session()})
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var created struct {
+			Token string `json:"token"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		return created.Token
+	}
+
+	job, err := New(srv.URL, Options{Token: newToken("read-write")})
+	require.NoError(t, err)
+	urls, err := job.UserURLs(context.Background())
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, shortURL, urls[0].ShortURL)
+	_, err = job.Shorten(context.Background(), "https://github.com")
+	require.NoError(t, err)
+	assert.Empty(t, job.Session())
+
+	reader, err := New(srv.URL, Options{Token: newToken("read")})
+	require.NoError(t, err)
+	urls, err = reader.UserURLs(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+	_, err = reader.Shorten(context.Background(), "https://gitlab.com")
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+
+	forged, err := New(srv.URL, Options{Token: "shrt_forged"})
+	require.NoError(t, err)
+	_, err = forged.UserURLs(context.Background())
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusUnauthorized, statusErr.StatusCode)
+}
+
+func TestClient_Retries(t *testing.T) {
+	srv, _ := newTestServer(t)
+	var calls int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		resp, err := http.Get(srv.URL + r.URL.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+	}))
+	defer flaky.Close()
+
+	c, err := New(flaky.URL, Options{RetryDelay: time.Millisecond})
+	require.NoError(t, err)
+	err = c.Ping(context.Background())
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+
+	atomic.StoreInt32(&calls, 0)
+	c, err = New(flaky.URL, Options{MaxRetries: 2, RetryDelay: time.Millisecond})
+	require.NoError(t, err)
+	require.NoError(t, c.Ping(context.Background()))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	_, err = c.ShortenBatch(context.Background(), []BatchItem{{CorrelationID: "1", OriginalURL: "https://vk.com"}})
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	_, err = c.Shorten(context.Background(), "https://vk.com")
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	_, err = c.ShortenJSON(context.Background(), "https://vk.com")
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
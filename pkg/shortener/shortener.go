@@ -0,0 +1,336 @@
+// Package shortener allows to embed URL shortener into an existing
+// Go service instead of running it as a separate binary.
+//
+// Handler returned by NewHandler serves the same API as the standalone
+// server and should be closed once it is no longer used. It may be mounted under a prefix either with chi's Mount
+// or with http.StripPrefix; in the latter case BaseURL should contain
+// the prefix so generated short URLs stay reachable.
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/serjyuriev/shortener/internal/pkg/handlers"
+	"github.com/serjyuriev/shortener/internal/pkg/middleware"
+	"github.com/serjyuriev/shortener/internal/pkg/router"
+	"github.com/serjyuriev/shortener/internal/pkg/service"
+	"github.com/serjyuriev/shortener/internal/pkg/storage"
+)
+
+// Store describes storage backend of URL shortener.
+// Custom implementations must return errors declared
+// in this package for the corresponding situations.
+type Store = storage.Store
+
+// Types used by methods of Store.
+type (
+	// Link contains full information about shortened URL.
+	Link = storage.Link
+	// LinkRevision is a previous original URL of link.
+	LinkRevision = storage.LinkRevision
+	// Passthrough selects parts of request forwarded to original URL.
+	Passthrough = storage.Passthrough
+	// ListOptions restricts links returned by iterating methods of Store.
+	// Its Match method applies all filters except cursor and limit.
+	ListOptions = storage.ListOptions
+	// Cursor points to a link in a list ordered by creation time and short URL.
+	Cursor = storage.Cursor
+	// LinkStatus selects links by their deletion mark.
+	LinkStatus = storage.LinkStatus
+	// UserLinkCount is a number of links of user.
+	UserLinkCount = storage.UserLinkCount
+	// Account is a registered user.
+	Account = storage.Account
+	// Token is an API token of user.
+	Token = storage.Token
+	// TokenScope restricts requests allowed to API token.
+	TokenScope = storage.TokenScope
+	// Template is a set of UTM parameters added to original URLs.
+	Template = storage.Template
+	// Workspace is a group of users sharing links.
+	Workspace = storage.Workspace
+	// Member is a user of workspace along with its role.
+	Member = storage.Member
+	// Membership is a workspace of user along with its role there.
+	Membership = storage.Membership
+	// Role is a permission level of workspace member.
+	Role = storage.Role
+)
+
+// Values of enumerations used by Store.
+const (
+	PassthroughNone  = storage.PassthroughNone
+	PassthroughQuery = storage.PassthroughQuery
+	PassthroughPath  = storage.PassthroughPath
+
+	StatusActive  = storage.StatusActive
+	StatusDeleted = storage.StatusDeleted
+	StatusAll     = storage.StatusAll
+
+	ScopeRead      = storage.ScopeRead
+	ScopeReadWrite = storage.ScopeReadWrite
+
+	RoleViewer = storage.RoleViewer
+	RoleEditor = storage.RoleEditor
+	RoleOwner  = storage.RoleOwner
+)
+
+// Errors that Store implementations are expected to return.
+var (
+	ErrNoURLWasFound        = storage.ErrNoURLWasFound
+	ErrNotUniqueOriginalURL = storage.ErrNotUniqueOriginalURL
+	ErrShortenedDeleted     = storage.ErrShortenedDeleted
+	ErrLinkExhausted        = storage.ErrLinkExhausted
+	ErrLinkDisabled         = storage.ErrLinkDisabled
+	ErrNoAccountWasFound    = storage.ErrNoAccountWasFound
+	ErrAccountExists        = storage.ErrAccountExists
+	ErrNoTokenWasFound      = storage.ErrNoTokenWasFound
+	ErrNoTemplateWasFound   = storage.ErrNoTemplateWasFound
+	ErrNoWorkspaceWasFound  = storage.ErrNoWorkspaceWasFound
+	ErrNoMemberWasFound     = storage.ErrNoMemberWasFound
+	ErrWorkspaceExists      = storage.ErrWorkspaceExists
+)
+
+// Errors of invalid input and denied operations.
+var (
+	ErrInvalidCursor      = storage.ErrInvalidCursor
+	ErrInvalidPassthrough = storage.ErrInvalidPassthrough
+	ErrInvalidTag         = storage.ErrInvalidTag
+	ErrInvalidLogin       = storage.ErrInvalidLogin
+	ErrInvalidToken       = storage.ErrInvalidToken
+	ErrInvalidTokenScope  = storage.ErrInvalidTokenScope
+	ErrInvalidTemplate    = storage.ErrInvalidTemplate
+	ErrInvalidWorkspace   = storage.ErrInvalidWorkspace
+	ErrInvalidRole        = storage.ErrInvalidRole
+	ErrQuotaExceeded      = service.ErrQuotaExceeded
+	ErrInvalidPassword    = service.ErrInvalidPassword
+	ErrWrongPassword      = service.ErrWrongPassword
+	ErrTooManyAttempts    = service.ErrTooManyAttempts
+	ErrPasswordRequired   = service.ErrPasswordRequired
+	ErrSubPathNotAllowed  = service.ErrSubPathNotAllowed
+	ErrInvalidSubPath     = service.ErrInvalidSubPath
+	ErrInvalidCredentials = service.ErrInvalidCredentials
+	ErrWeakPassword       = service.ErrWeakPassword
+	ErrLastOwner          = service.ErrLastOwner
+	ErrNoKeys             = middleware.ErrNoKeys
+	ErrInvalidKey         = middleware.ErrInvalidKey
+	ErrInvalidJWTOptions  = middleware.ErrInvalidJWTOptions
+)
+
+// ErrNoBaseURL is returned when Options contain no base URL.
+var ErrNoBaseURL = errors.New("base URL must be provided")
+
+// Options contains configuration of embedded URL shortener.
+type Options struct {
+	// Store is a custom storage backend.
+	// If nil, DatabaseDSN or FileStoragePath is used to create one.
+	Store Store
+	// BaseURL is prepended to short paths in responses,
+	// e.g. "https://example.com/s".
+	BaseURL string
+	// DatabaseDSN is a PostgreSQL data source name.
+	DatabaseDSN string
+	// FileStoragePath is a path of JSON file storage.
+	// If both DatabaseDSN and FileStoragePath are empty,
+	// links are kept in memory only.
+	FileStoragePath string
+	// MirrorStore, if set, receives copies of all writes.
+	// It allows to fill new storage before switching to it.
+	MirrorStore Store
+	// RedirectCode is HTTP status code of redirects by links
+	// that don't specify their own one, 307 by default.
+	RedirectCode int
+	// AuthKeys sign cookies identifying users. The last key signs
+	// new cookies, all of them verify existing ones. If empty, random key
+	// is used and users lose access to their links once handler is recreated.
+	AuthKeys []AuthKey
+	// CookieLifetime is a lifetime of cookies identifying users,
+	// 30 days by default. Cookies are renewed after half of it passes.
+	// Cookies are restricted to HTTPS if BaseURL uses it.
+	CookieLifetime time.Duration
+	// LegacyCookiesUntil is a moment until which cookies issued
+	// without issue time by older versions are accepted and renewed.
+	// If zero, such cookies are rejected.
+	LegacyCookiesUntil time.Time
+	// LegacyCookieSecret verifies cookies without key ID issued
+	// by versions signing them with hardcoded "sh0rt7" secret.
+	// It is never used to sign anything and can't be one of AuthKeys.
+	// As the secret is public, such cookies don't identify
+	// administrators and registered accounts.
+	LegacyCookieSecret []byte
+	// JWT, if set, makes cookies identifying users JSON Web Tokens,
+	// which are also accepted in Authorization header. HS256 tokens
+	// are signed with its HMACKeys, which must differ from AuthKeys.
+	JWT *JWTOptions
+	// TransferLifetime is a period during which token transferring
+	// links to another user may be redeemed, 15 minutes by default.
+	TransferLifetime time.Duration
+	// Admin, if set, enables admin API under /api/admin
+	// available to holders of admin token and to listed users.
+	Admin *AdminOptions
+	// CreateLimit and RedirectLimit, if their rates are positive, limit
+	// requests creating and following links by every user and client IP.
+	CreateLimit   RateLimit
+	RedirectLimit RateLimit
+	// LimiterStore keeps token buckets of rate limits,
+	// they are kept in memory if it is nil.
+	LimiterStore LimiterStore
+	// TrustedProxies are networks of proxies whose X-Forwarded-For header
+	// is used to find out client IP for rate limits and link creators.
+	TrustedProxies []*net.IPNet
+	// Quotas cap numbers of links users may keep and create at once.
+	Quotas Quotas
+}
+
+// AuthKey is a secret signing cookies identifying users.
+// Its ID is embedded into cookies, so keys may be rotated.
+type AuthKey = middleware.Key
+
+// JWTOptions configure JSON Web Tokens identifying users.
+type JWTOptions = middleware.JWTOptions
+
+// AdminOptions identify administrators of URL shortener.
+type AdminOptions = middleware.AdminOptions
+
+// RateLimit is a token bucket refilled with Rate tokens per second
+// up to Burst tokens, every request takes one token.
+type RateLimit = middleware.Limit
+
+// LimiterStore keeps token buckets of rate limits.
+type LimiterStore = middleware.LimiterStore
+
+// Quota caps links user may create, zero fields mean no limit.
+type Quota = service.Quota
+
+// Quotas are quota of every user along with quotas of some users replacing it.
+type Quotas = service.Quotas
+
+// EdDSAKey is an Ed25519 key signing JSON Web Tokens.
+type EdDSAKey = middleware.EdDSAKey
+
+// Algorithms signing JSON Web Tokens.
+const (
+	AlgHS256 = middleware.AlgHS256
+	AlgEdDSA = middleware.AlgEdDSA
+)
+
+// Handler serves URL shortener API. Close stops its background workers.
+type Handler struct {
+	http.Handler
+	svc service.Service
+}
+
+// Close stops background workers of handler, waiting for running jobs to finish.
+func (h *Handler) Close() error {
+	return h.svc.Close()
+}
+
+// NewMemoryStore creates Store keeping links in memory only.
+// It is mostly useful for tests.
+func NewMemoryStore() (Store, error) {
+	return storage.NewFileStore("")
+}
+
+// NewHandler creates http.Handler serving URL shortener API
+// configured by provided options.
+func NewHandler(opts Options) (_ *Handler, err error) {
+	if opts.BaseURL == "" {
+		return nil, ErrNoBaseURL
+	}
+
+	s := opts.Store
+	if s == nil {
+		s, err = storage.NewStore(opts.DatabaseDSN, opts.FileStoragePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create storage:\n%w", err)
+		}
+	}
+
+	var svc service.Service
+	if opts.MirrorStore != nil {
+		svc = service.NewDualWriteService(s, opts.MirrorStore)
+	} else {
+		svc = service.NewServiceWithStore(s)
+	}
+	defer func() {
+		if err != nil {
+			svc.Close()
+		}
+	}()
+	svc.SetQuotas(opts.Quotas)
+
+	h := handlers.NewHandlers(svc, opts.BaseURL)
+	if opts.RedirectCode != 0 {
+		if err = h.SetRedirectCode(opts.RedirectCode); err != nil {
+			return nil, err
+		}
+	}
+
+	var keys *middleware.Keyring
+	if len(opts.AuthKeys) > 0 {
+		keys, err = middleware.NewKeyring(opts.AuthKeys...)
+	} else {
+		keys, err = middleware.NewRandomKeyring()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create auth keys:\n%w", err)
+	}
+	if err = middleware.CheckLegacySecret(keys, opts.LegacyCookieSecret); err != nil {
+		return nil, err
+	}
+	var jwt *middleware.JWT
+	if opts.JWT != nil {
+		if jwt, err = middleware.NewJWT(*opts.JWT); err != nil {
+			return nil, fmt.Errorf("unable to configure JWT:\n%w", err)
+		}
+	}
+	h.SetTransfers(middleware.NewTransfers(keys, opts.TransferLifetime))
+	h.SetTrustedProxies(opts.TrustedProxies)
+	var admins []uuid.UUID
+	if opts.Admin != nil {
+		admins = opts.Admin.UserIDs
+	}
+	auth := middleware.NewAuth(keys, middleware.CookieOptions{
+		Lifetime:         opts.CookieLifetime,
+		Secure:           strings.HasPrefix(opts.BaseURL, "https://"),
+		JWT:              jwt,
+		LegacyUntil:      opts.LegacyCookiesUntil,
+		LegacySecret:     opts.LegacyCookieSecret,
+		LegacyRestricted: middleware.RestrictLegacy(admins, svc),
+	}, svc)
+	var admin func(http.Handler) http.Handler
+	if opts.Admin != nil {
+		admin = middleware.NewAdmin(*opts.Admin, auth)
+	}
+	store := opts.LimiterStore
+	if store == nil {
+		store = middleware.NewMemoryLimiterStore()
+	}
+	limiter := func(name string, limit RateLimit) func(http.Handler) http.Handler {
+		if limit.Rate <= 0 {
+			return nil
+		}
+		return middleware.NewRateLimiter(middleware.RateLimiterOptions{
+			Name:           name,
+			Limit:          limit,
+			Store:          store,
+			TrustedProxies: opts.TrustedProxies,
+		})
+	}
+	return &Handler{
+		Handler: router.NewRouter(h, router.Middlewares{
+			Auth:          auth,
+			Admin:         admin,
+			CreateLimit:   limiter("create", opts.CreateLimit),
+			RedirectLimit: limiter("redirect", opts.RedirectLimit),
+		}),
+		svc: svc,
+	}, nil
+}
@@ -0,0 +1,229 @@
+package shortener
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/serjyuriev/shortener/internal/pkg/handlers"
+	"github.com/serjyuriev/shortener/internal/pkg/middleware"
+)
+
+func ExampleNewHandler() {
+	h, err := NewHandler(Options{
+		BaseURL: "http://localhost:8080/s",
+	})
+	if err != nil {
+		fmt.Printf("unable to create handler: %v\n", err)
+		return
+	}
+	defer h.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/s/", http.StripPrefix("/s", h))
+
+	request := httptest.NewRequest(
+		http.MethodPost,
+		"http://localhost:8080/s/",
+		strings.NewReader("https://github.com/serjyuriev"),
+	)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, request)
+	result := w.Result()
+	defer result.Body.Close()
+
+	body, err := io.ReadAll(result.Body)
+	if err != nil {
+		fmt.Printf("unable to read response body: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Code: %d\n", result.StatusCode)
+	fmt.Printf("Has prefix: %t\n", strings.HasPrefix(string(body), "http://localhost:8080/s/"))
+
+	// Output:
+	// Code: 201
+	// Has prefix: true
+}
+
+func TestNewHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		mount    func(h http.Handler) http.Handler
+		wantErr  error
+		basePath string
+	}{
+		{
+			name: "no base URL",
+			opts: Options{},
+			mount: func(h http.Handler) http.Handler {
+				return h
+			},
+			wantErr: ErrNoBaseURL,
+		},
+		{
+			name: "invalid auth key",
+			opts: Options{
+				BaseURL:  "http://localhost:8080",
+				AuthKeys: []AuthKey{{ID: "k1"}},
+			},
+			mount: func(h http.Handler) http.Handler {
+				return h
+			},
+			wantErr: middleware.ErrInvalidKey,
+		},
+		{
+			name: "rotatable auth keys",
+			opts: Options{
+				BaseURL: "http://localhost:8080",
+				AuthKeys: []AuthKey{
+					{ID: "k1", Secret: []byte("first secret")},
+					{ID: "k2", Secret: []byte("second secret")},
+				},
+			},
+			mount: func(h http.Handler) http.Handler {
+				return h
+			},
+		},
+		{
+			name: "invalid redirect code",
+			opts: Options{
+				BaseURL:      "http://localhost:8080",
+				RedirectCode: http.StatusOK,
+			},
+			mount: func(h http.Handler) http.Handler {
+				return h
+			},
+			wantErr: handlers.ErrInvalidRedirectCode,
+		},
+		{
+			name: "mounted with chi",
+			opts: Options{
+				BaseURL: "http://localhost:8080/links",
+			},
+			mount: func(h http.Handler) http.Handler {
+				r := chi.NewRouter()
+				r.Mount("/links", h)
+				return r
+			},
+			basePath: "/links",
+		},
+		{
+			name: "mounted with strip prefix",
+			opts: Options{
+				BaseURL: "http://localhost:8080/links/",
+			},
+			mount: func(h http.Handler) http.Handler {
+				mux := http.NewServeMux()
+				mux.Handle("/links/", http.StripPrefix("/links", h))
+				return mux
+			},
+			basePath: "/links",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := NewHandler(tt.opts)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			defer h.Close()
+			srv := httptest.NewServer(tt.mount(h))
+			defer srv.Close()
+
+			client := srv.Client()
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+
+			resp, err := client.Post(
+				srv.URL+tt.basePath+"/",
+				"text/plain",
+				strings.NewReader("https://github.com/serjyuriev"),
+			)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+			shortURL := string(body)
+			require.True(t, strings.HasPrefix(shortURL, "http://localhost:8080"+tt.basePath+"/"))
+			shortPath := strings.TrimPrefix(shortURL, "http://localhost:8080")
+
+			resp, err = client.Get(srv.URL + shortPath)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+			assert.Equal(t, "https://github.com/serjyuriev", resp.Header.Get("Location"))
+		})
+	}
+}
+
+func TestNewHandler_admin(t *testing.T) {
+	h, err := NewHandler(Options{
+		BaseURL: "http://localhost:8080",
+		Admin:   &AdminOptions{Token: "s3cret"},
+	})
+	require.NoError(t, err)
+	defer h.Close()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/", "text/plain", strings.NewReader("https://github.com/serjyuriev"))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	for authorization, wantStatus := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer s3cret": http.StatusOK,
+	} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/admin/users", nil)
+		require.NoError(t, err)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, wantStatus, resp.StatusCode, authorization)
+	}
+}
+
+func TestNewHandler_rateLimit(t *testing.T) {
+	h, err := NewHandler(Options{
+		BaseURL:     "http://localhost:8080",
+		CreateLimit: RateLimit{Rate: 0.01, Burst: 2},
+	})
+	require.NoError(t, err)
+	defer h.Close()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for i, wantStatus := range []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests} {
+		resp, err := http.Post(srv.URL+"/", "text/plain", strings.NewReader(fmt.Sprintf("https://github.com/serjyuriev/%d", i)))
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, wantStatus, resp.StatusCode)
+		if wantStatus == http.StatusTooManyRequests {
+			assert.Equal(t, "100", resp.Header.Get("Retry-After"))
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/ping")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "other routes are not limited")
+}
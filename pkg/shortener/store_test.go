@@ -0,0 +1,319 @@
+package shortener_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/serjyuriev/shortener/pkg/shortener"
+)
+
+// recordingStore is a Store implemented outside of the module internals.
+// It names every type used by Store methods and records calls
+// before passing them to another store.
+type recordingStore struct {
+	next  shortener.Store
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+var _ shortener.Store = (*recordingStore)(nil)
+
+func (s *recordingStore) record(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[method]++
+}
+
+func (s *recordingStore) called(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+func (s *recordingStore) ConsumeUse(ctx context.Context, shortPath string) (string, error) {
+	s.record("ConsumeUse")
+	return s.next.ConsumeUse(ctx, shortPath)
+}
+
+func (s *recordingStore) CountActiveLinks(ctx context.Context, userID uuid.UUID) (int, error) {
+	s.record("CountActiveLinks")
+	return s.next.CountActiveLinks(ctx, userID)
+}
+
+func (s *recordingStore) CountLinksByUser(ctx context.Context) ([]shortener.UserLinkCount, error) {
+	s.record("CountLinksByUser")
+	return s.next.CountLinksByUser(ctx)
+}
+
+func (s *recordingStore) DeleteManyURLs(ctx context.Context, userID uuid.UUID, urls []string) error {
+	s.record("DeleteManyURLs")
+	return s.next.DeleteManyURLs(ctx, userID, urls)
+}
+
+func (s *recordingStore) DeleteMember(ctx context.Context, workspaceID, userID uuid.UUID) error {
+	s.record("DeleteMember")
+	return s.next.DeleteMember(ctx, workspaceID, userID)
+}
+
+func (s *recordingStore) DeleteTemplate(ctx context.Context, userID uuid.UUID, id string) error {
+	s.record("DeleteTemplate")
+	return s.next.DeleteTemplate(ctx, userID, id)
+}
+
+func (s *recordingStore) DeleteToken(ctx context.Context, userID uuid.UUID, id string) error {
+	s.record("DeleteToken")
+	return s.next.DeleteToken(ctx, userID, id)
+}
+
+func (s *recordingStore) FindAccount(ctx context.Context, id uuid.UUID) (shortener.Account, error) {
+	s.record("FindAccount")
+	return s.next.FindAccount(ctx, id)
+}
+
+func (s *recordingStore) FindAccountByLogin(ctx context.Context, login string) (shortener.Account, error) {
+	s.record("FindAccountByLogin")
+	return s.next.FindAccountByLogin(ctx, login)
+}
+
+func (s *recordingStore) FindByOriginalURL(ctx context.Context, originalURL string) (string, error) {
+	s.record("FindByOriginalURL")
+	return s.next.FindByOriginalURL(ctx, originalURL)
+}
+
+func (s *recordingStore) FindLink(ctx context.Context, shortPath string) (shortener.Link, error) {
+	s.record("FindLink")
+	return s.next.FindLink(ctx, shortPath)
+}
+
+func (s *recordingStore) FindLinkHistory(ctx context.Context, shortPath string) ([]shortener.LinkRevision, error) {
+	s.record("FindLinkHistory")
+	return s.next.FindLinkHistory(ctx, shortPath)
+}
+
+func (s *recordingStore) FindMember(ctx context.Context, workspaceID, userID uuid.UUID) (shortener.Member, error) {
+	s.record("FindMember")
+	return s.next.FindMember(ctx, workspaceID, userID)
+}
+
+func (s *recordingStore) FindMembers(ctx context.Context, workspaceID uuid.UUID) ([]shortener.Member, error) {
+	s.record("FindMembers")
+	return s.next.FindMembers(ctx, workspaceID)
+}
+
+func (s *recordingStore) FindOriginalURL(ctx context.Context, shortPath string) (string, error) {
+	s.record("FindOriginalURL")
+	return s.next.FindOriginalURL(ctx, shortPath)
+}
+
+func (s *recordingStore) FindTagsByUser(ctx context.Context, userID uuid.UUID) (map[string]int, error) {
+	s.record("FindTagsByUser")
+	return s.next.FindTagsByUser(ctx, userID)
+}
+
+func (s *recordingStore) FindTemplatesByUser(ctx context.Context, userID uuid.UUID) ([]shortener.Template, error) {
+	s.record("FindTemplatesByUser")
+	return s.next.FindTemplatesByUser(ctx, userID)
+}
+
+func (s *recordingStore) FindTokenByHash(ctx context.Context, hash string) (shortener.Token, error) {
+	s.record("FindTokenByHash")
+	return s.next.FindTokenByHash(ctx, hash)
+}
+
+func (s *recordingStore) FindTokensByUser(ctx context.Context, userID uuid.UUID) ([]shortener.Token, error) {
+	s.record("FindTokensByUser")
+	return s.next.FindTokensByUser(ctx, userID)
+}
+
+func (s *recordingStore) FindURLsByUser(ctx context.Context, userID uuid.UUID) (map[string]string, error) {
+	s.record("FindURLsByUser")
+	return s.next.FindURLsByUser(ctx, userID)
+}
+
+func (s *recordingStore) FindWorkspacesByUser(ctx context.Context, userID uuid.UUID) ([]shortener.Membership, error) {
+	s.record("FindWorkspacesByUser")
+	return s.next.FindWorkspacesByUser(ctx, userID)
+}
+
+func (s *recordingStore) InsertAccount(ctx context.Context, a shortener.Account) error {
+	s.record("InsertAccount")
+	return s.next.InsertAccount(ctx, a)
+}
+
+func (s *recordingStore) InsertManyURLs(ctx context.Context, userID uuid.UUID, urls map[string]string) error {
+	s.record("InsertManyURLs")
+	return s.next.InsertManyURLs(ctx, userID, urls)
+}
+
+func (s *recordingStore) InsertLinks(ctx context.Context, links []shortener.Link) error {
+	s.record("InsertLinks")
+	return s.next.InsertLinks(ctx, links)
+}
+
+func (s *recordingStore) InsertNewURLPair(ctx context.Context, userID uuid.UUID, shortPath, originalURL string) error {
+	s.record("InsertNewURLPair")
+	return s.next.InsertNewURLPair(ctx, userID, shortPath, originalURL)
+}
+
+func (s *recordingStore) InsertTemplate(ctx context.Context, t shortener.Template) error {
+	s.record("InsertTemplate")
+	return s.next.InsertTemplate(ctx, t)
+}
+
+func (s *recordingStore) InsertToken(ctx context.Context, t shortener.Token) error {
+	s.record("InsertToken")
+	return s.next.InsertToken(ctx, t)
+}
+
+func (s *recordingStore) InsertWorkspace(ctx context.Context, w shortener.Workspace, owner uuid.UUID) error {
+	s.record("InsertWorkspace")
+	return s.next.InsertWorkspace(ctx, w, owner)
+}
+
+func (s *recordingStore) IterateAccounts(ctx context.Context, fn func(shortener.Account) error) error {
+	s.record("IterateAccounts")
+	return s.next.IterateAccounts(ctx, fn)
+}
+
+func (s *recordingStore) IterateAllLinks(ctx context.Context, opts shortener.ListOptions, fn func(shortener.Link) error) error {
+	s.record("IterateAllLinks")
+	return s.next.IterateAllLinks(ctx, opts, fn)
+}
+
+func (s *recordingStore) IterateLinks(ctx context.Context, fn func(shortener.Link) error) error {
+	s.record("IterateLinks")
+	return s.next.IterateLinks(ctx, fn)
+}
+
+func (s *recordingStore) IterateTemplates(ctx context.Context, fn func(shortener.Template) error) error {
+	s.record("IterateTemplates")
+	return s.next.IterateTemplates(ctx, fn)
+}
+
+func (s *recordingStore) IterateTokens(ctx context.Context, fn func(shortener.Token) error) error {
+	s.record("IterateTokens")
+	return s.next.IterateTokens(ctx, fn)
+}
+
+func (s *recordingStore) IterateUserLinks(ctx context.Context, userID uuid.UUID, opts shortener.ListOptions, fn func(shortener.Link) error) error {
+	s.record("IterateUserLinks")
+	return s.next.IterateUserLinks(ctx, userID, opts, fn)
+}
+
+func (s *recordingStore) IterateWorkspaceLinks(ctx context.Context, workspaceID uuid.UUID, opts shortener.ListOptions, fn func(shortener.Link) error) error {
+	s.record("IterateWorkspaceLinks")
+	return s.next.IterateWorkspaceLinks(ctx, workspaceID, opts, fn)
+}
+
+func (s *recordingStore) IterateWorkspaces(ctx context.Context, fn func(shortener.Workspace) error) error {
+	s.record("IterateWorkspaces")
+	return s.next.IterateWorkspaces(ctx, fn)
+}
+
+func (s *recordingStore) Ping(ctx context.Context) error {
+	s.record("Ping")
+	return s.next.Ping(ctx)
+}
+
+func (s *recordingStore) PurgeDeletedURLs(ctx context.Context, deletedBefore time.Time) (int, error) {
+	s.record("PurgeDeletedURLs")
+	return s.next.PurgeDeletedURLs(ctx, deletedBefore)
+}
+
+func (s *recordingStore) ReassignURLs(ctx context.Context, from, to uuid.UUID) (int, error) {
+	s.record("ReassignURLs")
+	return s.next.ReassignURLs(ctx, from, to)
+}
+
+func (s *recordingStore) ReplaceLink(ctx context.Context, l shortener.Link) error {
+	s.record("ReplaceLink")
+	return s.next.ReplaceLink(ctx, l)
+}
+
+func (s *recordingStore) RestoreManyURLs(ctx context.Context, userID uuid.UUID, urls []string, deletedAfter time.Time) ([]string, error) {
+	s.record("RestoreManyURLs")
+	return s.next.RestoreManyURLs(ctx, userID, urls, deletedAfter)
+}
+
+func (s *recordingStore) SetDisabled(ctx context.Context, shortPath string, disabled bool) error {
+	s.record("SetDisabled")
+	return s.next.SetDisabled(ctx, shortPath, disabled)
+}
+
+func (s *recordingStore) SetLinkHistory(ctx context.Context, shortPath string, history []shortener.LinkRevision) error {
+	s.record("SetLinkHistory")
+	return s.next.SetLinkHistory(ctx, shortPath, history)
+}
+
+func (s *recordingStore) SetMember(ctx context.Context, m shortener.Member) error {
+	s.record("SetMember")
+	return s.next.SetMember(ctx, m)
+}
+
+func (s *recordingStore) SetTags(ctx context.Context, userID uuid.UUID, shortPath string, tags []string) error {
+	s.record("SetTags")
+	return s.next.SetTags(ctx, userID, shortPath, tags)
+}
+
+func (s *recordingStore) UpdateOriginalURL(ctx context.Context, userID uuid.UUID, shortPath, originalURL string) error {
+	s.record("UpdateOriginalURL")
+	return s.next.UpdateOriginalURL(ctx, userID, shortPath, originalURL)
+}
+
+func (s *recordingStore) UpdateTemplate(ctx context.Context, t shortener.Template) error {
+	s.record("UpdateTemplate")
+	return s.next.UpdateTemplate(ctx, t)
+}
+
+func TestNewHandler_customStore(t *testing.T) {
+	memory, err := shortener.NewMemoryStore()
+	require.NoError(t, err)
+	store := &recordingStore{next: memory, calls: make(map[string]int)}
+	h, err := shortener.NewHandler(shortener.Options{BaseURL: "http://short.test", Store: store})
+	require.NoError(t, err)
+	defer h.Close()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	res, err := client.Post(srv.URL+"/", "text/plain", strings.NewReader("https://github.com/serjyuriev"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	short := strings.TrimPrefix(string(body), "http://short.test/")
+
+	res, err = client.Get(srv.URL + "/" + short)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+	assert.Equal(t, "https://github.com/serjyuriev", res.Header.Get("Location"))
+	assert.Positive(t, store.called("InsertLinks"))
+	assert.Positive(t, store.called("FindLink"))
+
+	_, err = store.FindLink(context.Background(), "missing")
+	assert.ErrorIs(t, err, shortener.ErrNoURLWasFound)
+	opts := shortener.ListOptions{Status: shortener.StatusAll, CreatedTo: time.Now().Add(time.Hour)}
+	var links []shortener.Link
+	err = store.IterateAllLinks(context.Background(), opts, func(l shortener.Link) error {
+		assert.True(t, opts.Match(l))
+		links = append(links, l)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.NotEqual(t, uuid.Nil, links[0].UserID)
+}